@@ -0,0 +1,97 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/bhojpur/drive/pkg/provider/audit"
+	"github.com/spf13/cobra"
+)
+
+var auditVerifyOpts struct {
+	File     string
+	Postgres string
+	Table    string
+	Prefix   string
+	Storage  storageFlags
+}
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Manages the audit log of storage operations",
+}
+
+// auditVerifyCmd represents the audit verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies the chain of audit records, and detects gaps or edits",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var sink audit.Sink
+		switch {
+		case auditVerifyOpts.File != "":
+			sink = audit.NewFileSink(auditVerifyOpts.File)
+		case auditVerifyOpts.Postgres != "":
+			db, err := sql.Open("postgres", auditVerifyOpts.Postgres)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			if sink, err = audit.NewPostgresSink(db, auditVerifyOpts.Table); err != nil {
+				return err
+			}
+		case auditVerifyOpts.Storage.Provider != "":
+			storage, err := auditVerifyOpts.Storage.storage()
+			if err != nil {
+				return err
+			}
+			sink = audit.NewStorageSink(storage, auditVerifyOpts.Prefix)
+		default:
+			return fmt.Errorf("one of --file, --postgres or --provider is required")
+		}
+
+		last, err := audit.Verify(sink)
+		if err != nil {
+			return err
+		}
+
+		if last == nil {
+			fmt.Println("audit log is empty")
+		} else {
+			fmt.Printf("audit log is intact, %d records, last hash %s\n", last.Sequence, last.Hash)
+		}
+		return nil
+	},
+}
+
+func init() {
+	auditVerifyCmd.Flags().StringVar(&auditVerifyOpts.File, "file", "", "JSON-lines audit log file")
+	auditVerifyCmd.Flags().StringVar(&auditVerifyOpts.Postgres, "postgres", "", "PostgreSQL connection string of the audit log")
+	auditVerifyCmd.Flags().StringVar(&auditVerifyOpts.Table, "table", "drive_audit_records", "PostgreSQL table of the audit log")
+	auditVerifyCmd.Flags().StringVar(&auditVerifyOpts.Prefix, "prefix", "audit", "path of the audit records in the storage provider")
+	auditVerifyOpts.Storage.register(auditVerifyCmd, "")
+
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,173 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/catalog"
+	"github.com/spf13/cobra"
+)
+
+var catalogOpts struct {
+	Postgres string
+	Table    string
+	Name     string
+	Prefix   string
+	Digest   bool
+	Storage  storageFlags
+
+	Tags           []string
+	Metadata       map[string]string
+	ContentType    string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  string
+	ModifiedBefore string
+	Limit          int
+}
+
+// catalogCmd represents the catalog command
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Manages the PostgreSQL catalog of object metadata",
+}
+
+func openCatalog() (*catalog.Catalog, error) {
+	if catalogOpts.Postgres == "" {
+		return nil, fmt.Errorf("--postgres is required")
+	}
+
+	db, err := sql.Open("postgres", catalogOpts.Postgres)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.NewCatalog(db, catalogOpts.Table)
+}
+
+// catalogReindexCmd represents the catalog reindex command
+var catalogReindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Scans a storage provider, and synchronizes the catalog with its objects",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCatalog()
+		if err != nil {
+			return err
+		}
+		defer c.DB.Close()
+
+		storage, err := catalogOpts.Storage.storage()
+		if err != nil {
+			return err
+		}
+
+		result, err := c.Reindex(catalogOpts.Name, storage, catalogOpts.Prefix, catalogOpts.Digest)
+		if err != nil {
+			return err
+		}
+
+		for path, err := range result.Failed {
+			fmt.Printf("%s: %v\n", path, err)
+		}
+		fmt.Printf("%d objects indexed, %d entries removed, %d failed\n", result.Indexed, result.Removed, len(result.Failed))
+		return nil
+	},
+}
+
+// catalogSearchCmd represents the catalog search command
+var catalogSearchCmd = &cobra.Command{
+	Use:   "search",
+	Short: "Searches the catalog, and prints matching entries as JSON lines",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCatalog()
+		if err != nil {
+			return err
+		}
+		defer c.DB.Close()
+
+		query := catalog.Query{
+			Storage:     catalogOpts.Name,
+			Prefix:      catalogOpts.Prefix,
+			Tags:        catalogOpts.Tags,
+			Metadata:    catalogOpts.Metadata,
+			ContentType: catalogOpts.ContentType,
+			Limit:       catalogOpts.Limit,
+		}
+		if cmd.Flags().Changed("min-size") {
+			query.MinSize = &catalogOpts.MinSize
+		}
+		if cmd.Flags().Changed("max-size") {
+			query.MaxSize = &catalogOpts.MaxSize
+		}
+		for _, bound := range []struct {
+			value string
+			field **time.Time
+		}{{catalogOpts.ModifiedAfter, &query.ModifiedAfter}, {catalogOpts.ModifiedBefore, &query.ModifiedBefore}} {
+			if bound.value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, bound.value)
+			if err != nil {
+				return err
+			}
+			*bound.field = &t
+		}
+
+		entries, err := c.Search(query)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+		return nil
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{catalogReindexCmd, catalogSearchCmd} {
+		cmd.Flags().StringVar(&catalogOpts.Postgres, "postgres", "", "PostgreSQL connection string of the catalog")
+		cmd.Flags().StringVar(&catalogOpts.Table, "table", "drive_catalog", "PostgreSQL table of the catalog")
+		cmd.Flags().StringVar(&catalogOpts.Name, "name", "default", "name of the storage in the catalog")
+		cmd.Flags().StringVar(&catalogOpts.Prefix, "prefix", "/", "path prefix of objects")
+		catalogCmd.AddCommand(cmd)
+	}
+
+	catalogReindexCmd.Flags().BoolVar(&catalogOpts.Digest, "digest", false, "download objects to compute their SHA-256 digests")
+	catalogOpts.Storage.register(catalogReindexCmd, "")
+
+	catalogSearchCmd.Flags().StringSliceVar(&catalogOpts.Tags, "tag", nil, "tags entries must have")
+	catalogSearchCmd.Flags().StringToStringVar(&catalogOpts.Metadata, "meta", nil, "user metadata entries must have, e.g. camera=x100")
+	catalogSearchCmd.Flags().StringVar(&catalogOpts.ContentType, "content-type", "", "content type of entries")
+	catalogSearchCmd.Flags().Int64Var(&catalogOpts.MinSize, "min-size", 0, "min size of entries in bytes")
+	catalogSearchCmd.Flags().Int64Var(&catalogOpts.MaxSize, "max-size", 0, "max size of entries in bytes")
+	catalogSearchCmd.Flags().StringVar(&catalogOpts.ModifiedAfter, "modified-after", "", "entries modified at or after the RFC 3339 time")
+	catalogSearchCmd.Flags().StringVar(&catalogOpts.ModifiedBefore, "modified-before", "", "entries modified before the RFC 3339 time")
+	catalogSearchCmd.Flags().IntVar(&catalogOpts.Limit, "limit", 1000, "max returned entries")
+
+	rootCmd.AddCommand(catalogCmd)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/erasure"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/spf13/cobra"
+)
+
+var erasureOpts struct {
+	Dirs   []string
+	Data   int
+	Parity int
+	Prefix string
+	Shared bool
+}
+
+// erasureCmd represents the erasure command
+var erasureCmd = &cobra.Command{
+	Use:   "erasure",
+	Short: "Manages erasure-coded storage across multiple backends",
+}
+
+// erasureHealCmd represents the erasure heal command
+var erasureHealCmd = &cobra.Command{
+	Use:   "heal [path]",
+	Short: "Rebuilds missing or corrupted shards, and re-replicates placement metadata",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(erasureOpts.Dirs) == 0 {
+			return fmt.Errorf("at least one --dir is required")
+		}
+		if total := erasureOpts.Data + erasureOpts.Parity; len(erasureOpts.Dirs) < total && !erasureOpts.Shared {
+			return fmt.Errorf("at least %d --dir are required for %d data and %d parity shards, or pass --shared-backends", total, erasureOpts.Data, erasureOpts.Parity)
+		}
+
+		var backends []model.StorageInterface
+		for _, dir := range erasureOpts.Dirs {
+			backends = append(backends, filesystem.New(dir))
+		}
+
+		client := erasure.New(backends, &erasure.Config{DataShards: erasureOpts.Data, ParityShards: erasureOpts.Parity, Prefix: erasureOpts.Prefix, AllowSharedBackends: erasureOpts.Shared})
+
+		path := "/"
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		results, errs, err := client.HealAll(path)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if len(result.Rebuilt) > 0 || result.MetaRepaired > 0 {
+				fmt.Printf("%s: rebuilt shards %v, repaired metadata on %d backends\n", result.Path, result.Rebuilt, result.MetaRepaired)
+			}
+		}
+		for path, err := range errs {
+			fmt.Printf("%s: %v\n", path, err)
+		}
+
+		fmt.Printf("%d objects healthy, %d objects failed\n", len(results), len(errs))
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to heal %d objects", len(errs))
+		}
+		return nil
+	},
+}
+
+func init() {
+	erasureHealCmd.Flags().StringArrayVar(&erasureOpts.Dirs, "dir", nil, "root directory of a backend, in the same order as when objects were written")
+	erasureHealCmd.Flags().IntVar(&erasureOpts.Data, "data", 4, "number of data shards")
+	erasureHealCmd.Flags().IntVar(&erasureOpts.Parity, "parity", 2, "number of parity shards")
+	erasureHealCmd.Flags().StringVar(&erasureOpts.Prefix, "prefix", "", "path of the erasure-coded storage in the backends")
+	erasureHealCmd.Flags().BoolVar(&erasureOpts.Shared, "shared-backends", false, "allow fewer backends than data and parity shards, placing several shards on one backend")
+
+	erasureCmd.AddCommand(erasureHealCmd)
+	rootCmd.AddCommand(erasureCmd)
+}
@@ -0,0 +1,133 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bhojpur/drive/pkg/lifecycle"
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/spf13/cobra"
+)
+
+var lifecycleOpts struct {
+	Policy    string
+	DryRun    bool
+	BatchSize int
+	Targets   map[string]string
+	// VersionDir and VersionSeparator select how max_versions rules group versions
+	VersionDir       bool
+	VersionSeparator string
+	Storage          storageFlags
+}
+
+// lifecycleCmd represents the lifecycle command
+var lifecycleCmd = &cobra.Command{
+	Use:   "lifecycle",
+	Short: "Applies lifecycle rules of expiration, transition and versions to a storage",
+}
+
+func lifecycleEngine() (*lifecycle.Engine, error) {
+	file, err := os.Open(lifecycleOpts.Policy)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	policy, err := lifecycle.LoadPolicy(file)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := lifecycleOpts.Storage.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := map[string]model.StorageInterface{}
+	for name, dir := range lifecycleOpts.Targets {
+		targets[name] = filesystem.New(dir)
+	}
+
+	config := &lifecycle.Config{Targets: targets, BatchSize: lifecycleOpts.BatchSize}
+	switch {
+	case lifecycleOpts.VersionDir && lifecycleOpts.VersionSeparator != "":
+		return nil, fmt.Errorf("--version-dir and --version-separator can't be used together")
+	case lifecycleOpts.VersionDir:
+		config.VersionKey = lifecycle.DirVersionKey
+	case lifecycleOpts.VersionSeparator != "":
+		config.VersionKey = lifecycle.SuffixVersionKey(lifecycleOpts.VersionSeparator)
+	}
+
+	return lifecycle.New(storage, policy, config), nil
+}
+
+// lifecycleRunCmd represents the lifecycle run command
+var lifecycleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Evaluates lifecycle rules against the storage, and prints a JSON report of applied actions",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		engine, err := lifecycleEngine()
+		if err != nil {
+			return err
+		}
+
+		report, err := engine.Run(lifecycleOpts.DryRun)
+		if report != nil {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(report)
+		}
+		return err
+	},
+}
+
+// lifecyclePushCmd represents the lifecycle push command
+var lifecyclePushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Replaces the native lifecycle configuration of the bucket with the rules",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		engine, err := lifecycleEngine()
+		if err != nil {
+			return err
+		}
+		return engine.PushNative()
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{lifecycleRunCmd, lifecyclePushCmd} {
+		cmd.Flags().StringVar(&lifecycleOpts.Policy, "policy", "lifecycle.json", "JSON file of lifecycle rules")
+		lifecycleOpts.Storage.register(cmd, "")
+		lifecycleCmd.AddCommand(cmd)
+	}
+
+	lifecycleRunCmd.Flags().BoolVar(&lifecycleOpts.DryRun, "dry-run", false, "report actions without applying them")
+	lifecycleRunCmd.Flags().IntVar(&lifecycleOpts.BatchSize, "batch-size", 0, "max objects processed by a run, the next run resumes from the checkpoint")
+	lifecycleRunCmd.Flags().StringToStringVar(&lifecycleOpts.Targets, "target", nil, "transition target directory, e.g. cold=/mnt/cold")
+	lifecycleRunCmd.Flags().BoolVar(&lifecycleOpts.VersionDir, "version-dir", false, "max_versions rules treat objects in the same directory as versions of one object")
+	lifecycleRunCmd.Flags().StringVar(&lifecycleOpts.VersionSeparator, "version-separator", "", "max_versions rules treat objects with the same name before the separator as versions of one object, e.g. @ for db@2022-01-01.sql")
+
+	rootCmd.AddCommand(lifecycleCmd)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/shard"
+	"github.com/spf13/cobra"
+)
+
+var shardOpts struct {
+	Backends     []string
+	Rules        []string
+	Ring         []string
+	VirtualNodes int
+	DryRun       bool
+}
+
+// shardCmd represents the shard command
+var shardCmd = &cobra.Command{
+	Use:   "shard",
+	Short: "Manages storage sharded across multiple backends",
+}
+
+// splitPair split "key=value" flags
+func splitPair(flag string, value string) (string, string, error) {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[1] == "" {
+		return "", "", fmt.Errorf("--%s should be in the format of key=value, but got %q", flag, value)
+	}
+	return pair[0], pair[1], nil
+}
+
+// shardRebalanceCmd represents the shard rebalance command
+var shardRebalanceCmd = &cobra.Command{
+	Use:   "rebalance [path]",
+	Short: "Moves objects to the backend they belong to after sharding rules or the ring changed",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(shardOpts.Backends) == 0 {
+			return fmt.Errorf("at least one --backend is required")
+		}
+
+		backends := map[string]model.StorageInterface{}
+		for _, backend := range shardOpts.Backends {
+			name, dir, err := splitPair("backend", backend)
+			if err != nil {
+				return err
+			}
+			backends[name] = filesystem.New(dir)
+		}
+
+		config := &shard.Config{Ring: shardOpts.Ring, VirtualNodes: shardOpts.VirtualNodes}
+		for _, rule := range shardOpts.Rules {
+			prefix, name, err := splitPair("rule", rule)
+			if err != nil {
+				return err
+			}
+			config.Rules = append(config.Rules, shard.Rule{Prefix: prefix, Backend: name})
+		}
+
+		if err := config.Validate(backends); err != nil {
+			return err
+		}
+		client := shard.New(backends, config)
+
+		path := "/"
+		if len(args) > 0 {
+			path = args[0]
+		}
+
+		if shardOpts.DryRun {
+			moves, err := client.Plan(path)
+			if err != nil {
+				return err
+			}
+			for _, move := range moves {
+				fmt.Printf("%s: %s -> %s\n", move.Path, move.From, move.To)
+			}
+			fmt.Printf("%d objects to move\n", len(moves))
+			return nil
+		}
+
+		moves, errs, err := client.Rebalance(path)
+		if err != nil {
+			return err
+		}
+
+		for _, move := range moves {
+			fmt.Printf("%s: moved from %s to %s\n", move.Path, move.From, move.To)
+		}
+		for path, err := range errs {
+			fmt.Printf("%s: %v\n", path, err)
+		}
+
+		fmt.Printf("%d objects moved, %d objects failed\n", len(moves), len(errs))
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to move %d objects", len(errs))
+		}
+		return nil
+	},
+}
+
+func init() {
+	shardRebalanceCmd.Flags().StringArrayVar(&shardOpts.Backends, "backend", nil, "named backend in the format of name=directory, e.g. a=/mnt/disk1")
+	shardRebalanceCmd.Flags().StringArrayVar(&shardOpts.Rules, "rule", nil, "prefix rule in the format of prefix=backend, e.g. images/=a")
+	shardRebalanceCmd.Flags().StringArrayVar(&shardOpts.Ring, "ring", nil, "backend on the consistent hashing ring for keys which don't match any rule")
+	shardRebalanceCmd.Flags().IntVar(&shardOpts.VirtualNodes, "vnodes", 128, "number of virtual nodes of each backend on the ring")
+	shardRebalanceCmd.Flags().BoolVar(&shardOpts.DryRun, "dry-run", false, "print objects to move without moving them")
+
+	shardCmd.AddCommand(shardRebalanceCmd)
+	rootCmd.AddCommand(shardCmd)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/storage"
+	"github.com/spf13/cobra"
+)
+
+// storageFlags command line flags to select a storage provider
+type storageFlags struct {
+	Provider     string
+	ClientID     string
+	ClientSecret string
+	Region       string
+	Bucket       string
+	Endpoint     string
+}
+
+func (flags *storageFlags) register(cmd *cobra.Command, prefix string) {
+	cmd.Flags().StringVar(&flags.Provider, prefix+"provider", "", "storage provider type, e.g. \"Local File System\", \"AWS S3\", \"Aliyun OSS\", \"Tencent Cloud COS\", \"Azure Blob Storage\", \"Google Cloud Storage\"")
+	cmd.Flags().StringVar(&flags.ClientID, prefix+"client-id", "", "storage provider client id")
+	cmd.Flags().StringVar(&flags.ClientSecret, prefix+"client-secret", "", "storage provider client secret")
+	cmd.Flags().StringVar(&flags.Region, prefix+"region", "", "storage provider region")
+	cmd.Flags().StringVar(&flags.Bucket, prefix+"bucket", "", "storage provider bucket")
+	cmd.Flags().StringVar(&flags.Endpoint, prefix+"endpoint", "", "storage provider endpoint")
+}
+
+func (flags *storageFlags) storage() (model.StorageInterface, error) {
+	return storage.OpenStorageProvider(flags.Provider, flags.ClientID, flags.ClientSecret, flags.Region, flags.Bucket, flags.Endpoint)
+}
@@ -0,0 +1,301 @@
+package catalog
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Entry metadata of an object mirrored into the catalog
+type Entry struct {
+	Storage     string            `json:"storage"`
+	Path        string            `json:"path"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type,omitempty"`
+	Digest      string            `json:"digest,omitempty"` // hex SHA-256 of the content
+	ETag        string            `json:"etag,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ModifiedAt  time.Time         `json:"modified_at"`
+	IndexedAt   time.Time         `json:"indexed_at"`
+}
+
+// Query search conditions, empty fields match everything
+type Query struct {
+	Storage string
+	Prefix  string
+	// Tags entries having all the tags
+	Tags []string
+	// Metadata entries having all the key/value pairs
+	Metadata       map[string]string
+	ContentType    string
+	MinSize        *int64
+	MaxSize        *int64
+	ModifiedAfter  *time.Time
+	ModifiedBefore *time.Time
+
+	// AfterStorage and After return entries after the (storage, path) tuple in result order, used to page through results.
+	// Set both from the last returned entry, AfterStorage defaults to Storage
+	AfterStorage string
+	After        string
+	// Limit max returned entries, default to 1000
+	Limit int
+}
+
+// Catalog object metadata catalog in a PostgreSQL table
+type Catalog struct {
+	DB    *sql.DB
+	Table string
+}
+
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewCatalog initialize catalog, the table and its indexes are created if they don't exist. db should be opened with the "postgres" driver of github.com/lib/pq
+func NewCatalog(db *sql.DB, table string) (*Catalog, error) {
+	if table == "" {
+		table = "drive_catalog"
+	}
+	if !tableNameRegexp.MatchString(table) {
+		return nil, fmt.Errorf("invalid catalog table name %q", table)
+	}
+
+	index := strings.Replace(table, ".", "_", -1)
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			storage TEXT NOT NULL,
+			path TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			content_type TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			etag TEXT NOT NULL,
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			metadata JSONB NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL,
+			modified_at TIMESTAMPTZ NOT NULL,
+			indexed_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (storage, path)
+		)`, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_path_idx ON %s (storage, path text_pattern_ops)", index, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_tags_idx ON %s USING GIN (tags)", index, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_metadata_idx ON %s USING GIN (metadata jsonb_path_ops)", index, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_size_idx ON %s (storage, size)", index, table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_modified_at_idx ON %s (storage, modified_at)", index, table),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return &Catalog{DB: db, Table: table}, nil
+}
+
+func cleanPath(path string) string {
+	return "/" + strings.TrimPrefix(path, "/")
+}
+
+// Upsert insert or update the entry. Tags and metadata of an existing entry are kept if the entry has nil Tags or Metadata
+func (catalog *Catalog) Upsert(entry *Entry) error {
+	now := time.Now().UTC()
+	if entry.ModifiedAt.IsZero() {
+		entry.ModifiedAt = now
+	}
+
+	metadata := []byte("{}")
+	if entry.Metadata != nil {
+		var err error
+		if metadata, err = json.Marshal(entry.Metadata); err != nil {
+			return err
+		}
+	}
+
+	tags := entry.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	_, err := catalog.DB.Exec(fmt.Sprintf(`INSERT INTO %[1]s AS t (storage, path, size, content_type, digest, etag, tags, metadata, created_at, modified_at, indexed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9, $9, $10)
+		ON CONFLICT (storage, path) DO UPDATE SET
+			size = EXCLUDED.size,
+			content_type = EXCLUDED.content_type,
+			digest = CASE WHEN EXCLUDED.digest = '' THEN t.digest ELSE EXCLUDED.digest END,
+			etag = EXCLUDED.etag,
+			tags = CASE WHEN $11 THEN EXCLUDED.tags ELSE t.tags END,
+			metadata = CASE WHEN $12 THEN EXCLUDED.metadata ELSE t.metadata END,
+			modified_at = EXCLUDED.modified_at,
+			indexed_at = EXCLUDED.indexed_at`, catalog.Table),
+		entry.Storage, cleanPath(entry.Path), entry.Size, entry.ContentType, entry.Digest, entry.ETag,
+		pq.Array(tags), string(metadata), entry.ModifiedAt, now, entry.Tags != nil, entry.Metadata != nil,
+	)
+	return err
+}
+
+// SetTags replace tags of the entry
+func (catalog *Catalog) SetTags(storage string, path string, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	return catalog.update(storage, path, "tags = $3", pq.Array(tags))
+}
+
+// SetMetadata replace user metadata of the entry
+func (catalog *Catalog) SetMetadata(storage string, path string, metadata map[string]string) error {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return catalog.update(storage, path, "metadata = $3::jsonb", string(data))
+}
+
+func (catalog *Catalog) update(storage string, path string, set string, value interface{}) error {
+	result, err := catalog.DB.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE storage = $1 AND path = $2", catalog.Table, set), storage, cleanPath(path), value)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Remove remove the entry
+func (catalog *Catalog) Remove(storage string, path string) error {
+	_, err := catalog.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE storage = $1 AND path = $2", catalog.Table), storage, cleanPath(path))
+	return err
+}
+
+const columns = "storage, path, size, content_type, digest, etag, tags, metadata, created_at, modified_at, indexed_at"
+
+func scanEntry(scanner interface{ Scan(...interface{}) error }) (*Entry, error) {
+	var (
+		entry    Entry
+		metadata []byte
+	)
+	err := scanner.Scan(&entry.Storage, &entry.Path, &entry.Size, &entry.ContentType, &entry.Digest, &entry.ETag,
+		pq.Array(&entry.Tags), &metadata, &entry.CreatedAt, &entry.ModifiedAt, &entry.IndexedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(metadata, &entry.Metadata); err != nil {
+		return nil, err
+	}
+	entry.CreatedAt, entry.ModifiedAt, entry.IndexedAt = entry.CreatedAt.UTC(), entry.ModifiedAt.UTC(), entry.IndexedAt.UTC()
+	return &entry, nil
+}
+
+// Get get the entry, return sql.ErrNoRows if it doesn't exist
+func (catalog *Catalog) Get(storage string, path string) (*Entry, error) {
+	row := catalog.DB.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE storage = $1 AND path = $2", columns, catalog.Table), storage, cleanPath(path))
+	return scanEntry(row)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search find entries matching the query, ordered by storage and path
+func (catalog *Catalog) Search(query Query) ([]*Entry, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", fmt.Sprintf("$%d", len(args)), -1))
+	}
+
+	if query.Storage != "" {
+		where("storage = ?", query.Storage)
+	}
+	if query.Prefix != "" {
+		where(`path LIKE ? ESCAPE '\'`, likeEscaper.Replace(cleanPath(query.Prefix))+"%")
+	}
+	if len(query.Tags) > 0 {
+		where("tags @> ?", pq.Array(query.Tags))
+	}
+	if len(query.Metadata) > 0 {
+		data, err := json.Marshal(query.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		where("metadata @> ?::jsonb", string(data))
+	}
+	if query.ContentType != "" {
+		where("content_type = ?", query.ContentType)
+	}
+	if query.MinSize != nil {
+		where("size >= ?", *query.MinSize)
+	}
+	if query.MaxSize != nil {
+		where("size <= ?", *query.MaxSize)
+	}
+	if query.ModifiedAfter != nil {
+		where("modified_at >= ?", *query.ModifiedAfter)
+	}
+	if query.ModifiedBefore != nil {
+		where("modified_at < ?", *query.ModifiedBefore)
+	}
+	if query.After != "" {
+		afterStorage := query.AfterStorage
+		if afterStorage == "" {
+			afterStorage = query.Storage
+		}
+		// results are ordered by storage, then path, so pages are split on the same tuple
+		args = append(args, afterStorage, cleanPath(query.After))
+		conditions = append(conditions, fmt.Sprintf("(storage, path) > ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	statement := fmt.Sprintf("SELECT %s FROM %s", columns, catalog.Table)
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+	statement += fmt.Sprintf(" ORDER BY storage, path LIMIT %d", limit)
+
+	rows, err := catalog.DB.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,220 @@
+package catalog_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/catalog"
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/tests"
+	_ "github.com/lib/pq"
+)
+
+type Config struct {
+	DSN string `env:"BHOJPUR_POSTGRES_DSN"`
+}
+
+func newCatalog(t *testing.T) *catalog.Catalog {
+	config := Config{}
+	cfgsvr.Load(&config)
+	if config.DSN == "" {
+		t.Skip(`skip because of no config: BHOJPUR_POSTGRES_DSN`)
+	}
+
+	db, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.Exec("DROP TABLE IF EXISTS drive_catalog_test")
+
+	c, err := catalog.NewCatalog(db, "drive_catalog_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func paths(entries []*catalog.Entry) string {
+	var results []string
+	for _, entry := range entries {
+		results = append(results, entry.Path)
+	}
+	return strings.Join(results, ",")
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(catalog.New(filesystem.New(t.TempDir()), newCatalog(t), "test"), t)
+}
+
+func TestSearch(t *testing.T) {
+	client := catalog.New(filesystem.New(t.TempDir()), newCatalog(t), "test")
+
+	client.PutWithMetadata("/photos/cat.jpg", strings.NewReader("cat"), []string{"animal", "public"}, map[string]string{"camera": "x100"})
+	client.PutWithMetadata("/photos/dog.jpg", strings.NewReader("doggy"), []string{"animal"}, map[string]string{"camera": "m6"})
+	client.PutWithMetadata("/photos_old/car.jpg", strings.NewReader("a car"), []string{"public"}, nil)
+	client.Put("/docs/readme.txt", strings.NewReader("readme file"))
+
+	// a plain Put keeps tags and metadata
+	client.Put("/photos/cat.jpg", strings.NewReader("kitty"))
+
+	minSize, maxSize := int64(5), int64(5)
+	yesterday, tomorrow := time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)
+	cases := []struct {
+		query    catalog.Query
+		expected string
+	}{
+		{catalog.Query{Prefix: "/photos/"}, "/photos/cat.jpg,/photos/dog.jpg"},
+		{catalog.Query{Prefix: "/photos_"}, "/photos_old/car.jpg"},
+		{catalog.Query{Tags: []string{"animal", "public"}}, "/photos/cat.jpg"},
+		{catalog.Query{Metadata: map[string]string{"camera": "m6"}}, "/photos/dog.jpg"},
+		{catalog.Query{MinSize: &minSize, MaxSize: &maxSize}, "/photos/cat.jpg,/photos/dog.jpg,/photos_old/car.jpg"},
+		{catalog.Query{ContentType: "text/plain; charset=utf-8"}, "/docs/readme.txt"},
+		{catalog.Query{ModifiedAfter: &yesterday, ModifiedBefore: &tomorrow, Limit: 2}, "/docs/readme.txt,/photos/cat.jpg"},
+		{catalog.Query{ModifiedAfter: &tomorrow}, ""},
+		{catalog.Query{AfterStorage: "test", After: "/photos/dog.jpg"}, "/photos_old/car.jpg"},
+		{catalog.Query{Storage: "test", After: "/photos/dog.jpg"}, "/photos_old/car.jpg"},
+	}
+	for _, c := range cases {
+		entries, err := client.Search(c.query)
+		if err != nil {
+			t.Errorf("No error should happen when search %+v, but got %v", c.query, err)
+		} else if result := paths(entries); result != c.expected {
+			t.Errorf("Search %+v should return %v, but got %v", c.query, c.expected, result)
+		}
+	}
+
+	entry, err := client.Catalog.Get("test", "/photos/cat.jpg")
+	if err != nil {
+		t.Fatalf("No error should happen when get entry, but got %v", err)
+	}
+	if entry.Size != 5 || entry.Digest == "" || len(entry.Tags) != 2 || entry.Metadata["camera"] != "x100" {
+		t.Errorf("Entry should be updated with tags and metadata kept, but got %+v", entry)
+	}
+
+	client.Delete("/photos/dog.jpg")
+	if _, err := client.Catalog.Get("test", "/photos/dog.jpg"); err != sql.ErrNoRows {
+		t.Errorf("Deleted object should be removed from the catalog, but got %v", err)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	c := newCatalog(t)
+	for _, name := range []string{"b", "a"} {
+		client := catalog.New(filesystem.New(t.TempDir()), c, name)
+		client.Put("/z.txt", strings.NewReader(name))
+		client.Put("/a.txt", strings.NewReader(name))
+	}
+
+	// pages across storages are split on (storage, path), as results are ordered by both
+	var (
+		results []string
+		query   = catalog.Query{Limit: 1}
+	)
+	for i := 0; i < 10; i++ {
+		entries, err := c.Search(query)
+		if err != nil {
+			t.Fatalf("No error should happen when search, but got %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		last := entries[len(entries)-1]
+		results = append(results, last.Storage+":"+last.Path)
+		query.AfterStorage, query.After = last.Storage, last.Path
+	}
+
+	if result := strings.Join(results, ","); result != "a:/a.txt,a:/z.txt,b:/a.txt,b:/z.txt" {
+		t.Errorf("Paging should return all entries in order, but got %v", result)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	c := newCatalog(t)
+	storage := filesystem.New(t.TempDir())
+	client := catalog.New(storage, c, "test")
+
+	client.PutWithMetadata("/a.txt", strings.NewReader("a"), []string{"keep"}, nil)
+	client.Put("/b.txt", strings.NewReader("b"))
+
+	// changes made behind the catalog
+	storage.Delete("/b.txt")
+	storage.Put("/c.txt", strings.NewReader("c"))
+
+	result, err := c.Reindex("test", storage, "/", true)
+	if err != nil {
+		t.Fatalf("No error should happen when reindex, but got %v", err)
+	}
+	if result.Indexed != 2 || result.Removed != 1 || len(result.Failed) != 0 {
+		t.Errorf("Reindex should index 2 objects and remove 1 entry, but got %+v", result)
+	}
+
+	entries, _ := c.Search(catalog.Query{Storage: "test"})
+	if paths(entries) != "/a.txt,/c.txt" {
+		t.Errorf("Catalog should match the storage, but got %v", paths(entries))
+	}
+	if entries[0].Tags[0] != "keep" || entries[1].Digest == "" {
+		t.Errorf("Reindex should keep tags and compute digests, but got %+v, %+v", entries[0], entries[1])
+	}
+}
+
+// truncated storage returning only the first page of listings, like a single ListObjects request
+type truncated struct {
+	model.StorageInterface
+	pageSize int
+}
+
+func (storage truncated) List(path string) ([]*model.Object, error) {
+	objects, err := storage.StorageInterface.List(path)
+	if len(objects) > storage.pageSize {
+		objects = objects[:storage.pageSize]
+	}
+	return objects, err
+}
+
+func TestReindexTruncated(t *testing.T) {
+	c := newCatalog(t)
+	storage := filesystem.New(t.TempDir())
+	client := catalog.New(storage, c, "test")
+
+	client.Put("/a.txt", strings.NewReader("a"))
+	client.Put("/b.txt", strings.NewReader("b"))
+	client.Put("/c.txt", strings.NewReader("c"))
+	storage.Delete("/c.txt")
+
+	result, err := c.Reindex("test", truncated{StorageInterface: storage, pageSize: 1}, "/", false)
+	if err != nil {
+		t.Fatalf("No error should happen when reindex, but got %v", err)
+	}
+	if result.Indexed != 1 || result.Removed != 1 || len(result.Failed) != 0 {
+		t.Errorf("Reindex should only remove entries of missing objects, but got %+v", result)
+	}
+
+	entries, _ := c.Search(catalog.Query{Storage: "test"})
+	if paths(entries) != "/a.txt,/b.txt" {
+		t.Errorf("Entries of objects beyond the listed page should be kept, but got %v", paths(entries))
+	}
+}
@@ -0,0 +1,244 @@
+package catalog
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var _ model.StorageInterface = (*Client)(nil)
+
+// Client catalog storage, mirror metadata of objects written through the underlying storage into the catalog
+type Client struct {
+	Storage model.StorageInterface
+	Catalog *Catalog
+	// Name of the storage in the catalog, so several storages could share a catalog
+	Name string
+}
+
+// New initialize catalog storage
+func New(storage model.StorageInterface, catalog *Catalog, name string) *Client {
+	return &Client{Storage: storage, Catalog: catalog, Name: name}
+}
+
+// Get receive file with given path
+func (client Client) Get(path string) (*os.File, error) {
+	return client.Storage.Get(path)
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	return client.Storage.GetStream(path)
+}
+
+// Put store a reader into given path, tags and user metadata of an existing entry are kept
+func (client Client) Put(path string, reader io.Reader) (*model.Object, error) {
+	return client.PutWithMetadata(path, reader, nil, nil)
+}
+
+// PutWithMetadata store a reader into given path, and catalog it with tags and user metadata
+func (client Client) PutWithMetadata(urlPath string, reader io.Reader, tags []string, metadata map[string]string) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	var (
+		hasher  = sha256.New()
+		counter = &countingWriter{}
+	)
+	object, err := client.Storage.Put(urlPath, io.TeeReader(reader, io.MultiWriter(hasher, counter)))
+	if err != nil {
+		return object, err
+	}
+
+	entry := &Entry{
+		Storage:     client.Name,
+		Path:        urlPath,
+		Size:        counter.size,
+		ContentType: mime.TypeByExtension(path.Ext(urlPath)),
+		Digest:      hex.EncodeToString(hasher.Sum(nil)),
+		ETag:        object.ETag,
+		Tags:        tags,
+		Metadata:    metadata,
+	}
+	if object.ContentType != "" {
+		entry.ContentType = object.ContentType
+	}
+	if object.LastModified != nil {
+		entry.ModifiedAt = *object.LastModified
+	}
+
+	if err := client.Catalog.Upsert(entry); err != nil {
+		return object, err
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+type countingWriter struct {
+	size int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	writer.size += int64(len(p))
+	return len(p), nil
+}
+
+// Delete delete file, and remove it from the catalog
+func (client Client) Delete(path string) error {
+	if err := client.Storage.Delete(path); err != nil {
+		return err
+	}
+	return client.Catalog.Remove(client.Name, path)
+}
+
+// List list all objects under current path
+func (client Client) List(path string) ([]*model.Object, error) {
+	objects, err := client.Storage.List(path)
+	for _, object := range objects {
+		object.StorageInterface = client
+	}
+	return objects, err
+}
+
+// Search find objects of the storage in the catalog
+func (client Client) Search(query Query) ([]*Entry, error) {
+	query.Storage = client.Name
+	return client.Catalog.Search(query)
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL get public accessible URL
+func (client Client) GetURL(path string) (string, error) {
+	return client.Storage.GetURL(path)
+}
+
+// ReindexResult result of reindexing a storage
+type ReindexResult struct {
+	Indexed int
+	Removed int
+	Failed  map[string]error
+}
+
+// Reindex scan objects of the storage under prefix, catalog them, and remove entries of objects which don't exist anymore.
+// Tags and user metadata of existing entries are kept. With digest, objects are downloaded to compute their digests
+func (catalog *Catalog) Reindex(name string, storage model.StorageInterface, prefix string, digest bool) (*ReindexResult, error) {
+	// the catalog keeps microseconds, so entries indexed by this scan are never before it
+	started := time.Now().Truncate(time.Microsecond)
+	objects, err := storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReindexResult{Failed: map[string]error{}}
+	for _, object := range objects {
+		entry := &Entry{
+			Storage:     name,
+			Path:        object.Path,
+			Size:        object.Size,
+			ContentType: object.ContentType,
+			ETag:        object.ETag,
+		}
+		if entry.ContentType == "" {
+			entry.ContentType = mime.TypeByExtension(path.Ext(object.Path))
+		}
+		if object.LastModified != nil {
+			entry.ModifiedAt = *object.LastModified
+		}
+
+		if digest {
+			// keep the entry even if its digest is unknown, the object still exists
+			if err := digestObject(storage, entry); err != nil {
+				result.Failed[object.Path] = err
+			}
+		}
+
+		if err := catalog.Upsert(entry); err != nil {
+			result.Failed[object.Path] = err
+			continue
+		}
+		result.Indexed++
+	}
+
+	// entries not touched by this scan might belong to objects deleted without going through the catalog. Listings of some
+	// storages are truncated, so an entry is only removed once its object is confirmed to be missing
+	rows, err := catalog.DB.Query(
+		"SELECT path FROM "+catalog.Table+` WHERE storage = $1 AND path LIKE $2 ESCAPE '\' AND indexed_at < $3`,
+		name, likeEscaper.Replace(cleanPath(prefix))+"%", started.UTC(),
+	)
+	if err != nil {
+		return result, err
+	}
+	var stale []string
+	for rows.Next() {
+		var stalePath string
+		if err := rows.Scan(&stalePath); err != nil {
+			rows.Close()
+			return result, err
+		}
+		stale = append(stale, stalePath)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, stalePath := range stale {
+		if ok, err := model.Exists(storage, stalePath); err != nil {
+			result.Failed[stalePath] = err
+		} else if !ok {
+			if err := catalog.Remove(name, stalePath); err != nil {
+				result.Failed[stalePath] = err
+				continue
+			}
+			result.Removed++
+		}
+	}
+	return result, nil
+}
+
+func digestObject(storage model.StorageInterface, entry *Entry) error {
+	stream, err := storage.GetStream(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, stream)
+	if err != nil {
+		return err
+	}
+	entry.Size = size
+	entry.Digest = hex.EncodeToString(hasher.Sum(nil))
+	return nil
+}
@@ -0,0 +1,456 @@
+package lifecycle
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	// ErrNativeUnsupported returned when pushing rules to a storage without native lifecycle configuration
+	ErrNativeUnsupported = errors.New("storage doesn't support native lifecycle configuration")
+	// ErrVersionKeyRequired returned when running max_versions rules without Config.VersionKey
+	ErrVersionKeyRequired = errors.New("max_versions rules require Config.VersionKey")
+)
+
+// Action lifecycle action applied to an object
+type Action string
+
+const (
+	// ActionExpire delete the object as it is older than ExpirationDays
+	ActionExpire Action = "expire"
+	// ActionExpireVersion delete the object as it is not one of the newest MaxVersions versions
+	ActionExpireVersion Action = "expire_version"
+	// ActionTransition move the object to TransitionTarget as it is older than TransitionDays
+	ActionTransition Action = "transition"
+)
+
+// Policy lifecycle rules, rules are evaluated in order, deleting wins over transition when several rules match an object
+type Policy struct {
+	Rules []model.LifecycleRule `json:"rules"`
+}
+
+// LoadPolicy load a JSON policy, and validate it
+func LoadPolicy(reader io.Reader) (*Policy, error) {
+	policy := &Policy{}
+	if err := json.NewDecoder(reader).Decode(policy); err != nil {
+		return nil, err
+	}
+	return policy, policy.Validate()
+}
+
+// Validate check rules have unique IDs and at least one action
+func (policy Policy) Validate() error {
+	ids := map[string]bool{}
+	for _, rule := range policy.Rules {
+		if rule.ID == "" {
+			return errors.New("lifecycle rule requires an ID")
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("duplicated lifecycle rule %v", rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if rule.ExpirationDays < 0 || rule.TransitionDays < 0 || rule.MaxVersions < 0 {
+			return fmt.Errorf("lifecycle rule %v has negative value", rule.ID)
+		}
+		if rule.ExpirationDays == 0 && rule.TransitionDays == 0 && rule.MaxVersions == 0 {
+			return fmt.Errorf("lifecycle rule %v has no action", rule.ID)
+		}
+		if rule.TransitionDays > 0 && rule.TransitionTarget == "" && rule.TransitionStorageClass == "" {
+			return fmt.Errorf("lifecycle rule %v requires a transition target or storage class", rule.ID)
+		}
+	}
+	return nil
+}
+
+// DirVersionKey group objects in the same directory as versions of one object, e.g. /backups/db/2022-01-01.sql
+func DirVersionKey(urlPath string) string {
+	return path.Dir(urlPath)
+}
+
+// SuffixVersionKey group objects by their path without the version suffix, which starts from the last separator of the name,
+// e.g. /backups/db@2022-01-01.sql is a version of /backups/db with separator "@". Objects without the separator are their own versions
+func SuffixVersionKey(separator string) func(string) string {
+	return func(urlPath string) string {
+		dir, name := path.Split(urlPath)
+		if i := strings.LastIndex(name, separator); i > 0 {
+			return dir + name[:i]
+		}
+		return urlPath
+	}
+}
+
+func matches(rule model.LifecycleRule, urlPath string) bool {
+	prefix := strings.TrimPrefix(rule.Prefix, "/")
+	urlPath = strings.TrimPrefix(urlPath, "/")
+	return !rule.Disabled && strings.HasPrefix(urlPath, prefix) && strings.HasSuffix(urlPath, rule.Suffix)
+}
+
+// Config lifecycle engine config
+type Config struct {
+	// Targets storages objects could be transitioned to, referred by TransitionTarget of rules
+	Targets map[string]model.StorageInterface
+
+	// BatchSize max objects processed by a run, the scan resumes from the checkpoint in the next run. Zero processes all objects
+	BatchSize int
+
+	// CheckpointPath where the scan checkpoint is saved in the storage, default to ".lifecycle/checkpoint.json"
+	CheckpointPath string
+
+	// VersionKey return the key objects are grouped by as versions, e.g. DirVersionKey or SuffixVersionKey.
+	// It is required by max_versions rules, as there is no safe default
+	VersionKey func(path string) string
+
+	// Now current time, default to time.Now
+	Now func() time.Time
+}
+
+// Engine evaluate lifecycle rules against List output of the storage, and apply actions through the storage interface.
+// Transition to storage classes is only applied natively, see PushNative
+type Engine struct {
+	Storage model.StorageInterface
+	Policy  *Policy
+	Config  *Config
+}
+
+// New initialize lifecycle engine
+func New(storage model.StorageInterface, policy *Policy, config *Config) *Engine {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.CheckpointPath == "" {
+		config.CheckpointPath = ".lifecycle/checkpoint.json"
+	}
+	config.CheckpointPath = "/" + strings.TrimPrefix(config.CheckpointPath, "/")
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Engine{Storage: storage, Policy: policy, Config: config}
+}
+
+// Decision action decided for an object
+type Decision struct {
+	Path         string    `json:"path"`
+	RuleID       string    `json:"rule_id"`
+	Action       Action    `json:"action"`
+	Target       string    `json:"target,omitempty"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Report result of a run
+type Report struct {
+	DryRun    bool        `json:"dry_run"`
+	Scanned   int         `json:"scanned"`
+	Decisions []*Decision `json:"decisions"`
+	// Checkpoint path the next run resumes after, empty if the scan is completed
+	Checkpoint string `json:"checkpoint,omitempty"`
+	Completed  bool   `json:"completed"`
+}
+
+type checkpoint struct {
+	After string `json:"after"`
+}
+
+func (engine Engine) loadCheckpoint() string {
+	stream, err := engine.Storage.GetStream(engine.Config.CheckpointPath)
+	if err != nil {
+		return ""
+	}
+	defer stream.Close()
+
+	var cp checkpoint
+	json.NewDecoder(stream).Decode(&cp)
+	return cp.After
+}
+
+func (engine Engine) saveCheckpoint(after string) error {
+	if after == "" {
+		engine.Storage.Delete(engine.Config.CheckpointPath)
+		return nil
+	}
+
+	data, err := json.Marshal(checkpoint{After: after})
+	if err != nil {
+		return err
+	}
+	_, err = engine.Storage.Put(engine.Config.CheckpointPath, bytes.NewReader(data))
+	return err
+}
+
+func age(object *model.Object, now time.Time) (days int, ok bool) {
+	if object.LastModified == nil {
+		return 0, false
+	}
+	return int(now.Sub(*object.LastModified) / (24 * time.Hour)), true
+}
+
+// expiredVersions find objects which are not one of the newest MaxVersions versions for each rule.
+// It is evaluated against all objects, so versions are counted correctly even if the scan is split in batches
+func (engine Engine) expiredVersions(objects []*model.Object) map[string]string {
+	expired := map[string]string{}
+
+	for _, rule := range engine.Policy.Rules {
+		if rule.MaxVersions == 0 {
+			continue
+		}
+
+		groups := map[string][]*model.Object{}
+		for _, object := range objects {
+			if matches(rule, object.Path) {
+				key := engine.Config.VersionKey(object.Path)
+				groups[key] = append(groups[key], object)
+			}
+		}
+
+		for _, versions := range groups {
+			if len(versions) <= rule.MaxVersions {
+				continue
+			}
+
+			sort.SliceStable(versions, func(i, j int) bool {
+				a, b := versions[i].LastModified, versions[j].LastModified
+				if a == nil || b == nil {
+					return b == nil && a != nil
+				}
+				return a.After(*b)
+			})
+
+			for _, object := range versions[rule.MaxVersions:] {
+				if _, ok := expired[object.Path]; !ok {
+					expired[object.Path] = rule.ID
+				}
+			}
+		}
+	}
+	return expired
+}
+
+// decide return the action for the object, deleting wins over transition
+func (engine Engine) decide(object *model.Object, expiredVersions map[string]string, now time.Time) *Decision {
+	decision := &Decision{Path: object.Path, Size: object.Size}
+	if object.LastModified != nil {
+		decision.LastModified = *object.LastModified
+	}
+
+	days, hasAge := age(object, now)
+	var transition *model.LifecycleRule
+	for i, rule := range engine.Policy.Rules {
+		if !matches(rule, object.Path) || !hasAge {
+			continue
+		}
+		if rule.ExpirationDays > 0 && days >= rule.ExpirationDays {
+			decision.RuleID, decision.Action = rule.ID, ActionExpire
+			return decision
+		}
+		if transition == nil && rule.TransitionDays > 0 && rule.TransitionTarget != "" && days >= rule.TransitionDays {
+			transition = &engine.Policy.Rules[i]
+		}
+	}
+
+	if ruleID, ok := expiredVersions[object.Path]; ok {
+		decision.RuleID, decision.Action = ruleID, ActionExpireVersion
+		return decision
+	}
+
+	if transition != nil {
+		decision.RuleID, decision.Action, decision.Target = transition.ID, ActionTransition, transition.TransitionTarget
+		return decision
+	}
+	return nil
+}
+
+func (engine Engine) apply(decision *Decision) error {
+	switch decision.Action {
+	case ActionExpire, ActionExpireVersion:
+		return engine.Storage.Delete(decision.Path)
+	case ActionTransition:
+		target, ok := engine.Config.Targets[decision.Target]
+		if !ok {
+			return fmt.Errorf("unknown transition target %v", decision.Target)
+		}
+
+		stream, err := engine.Storage.GetStream(decision.Path)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		if _, err := target.Put(decision.Path, stream); err != nil {
+			return err
+		}
+		return engine.Storage.Delete(decision.Path)
+	}
+	return nil
+}
+
+// scanRoots return the directories rules could match objects in, in path order. Nested directories are covered by their parent
+func (engine Engine) scanRoots() []string {
+	var roots []string
+	for _, rule := range engine.Policy.Rules {
+		if rule.Disabled {
+			continue
+		}
+		prefix := "/" + strings.TrimPrefix(rule.Prefix, "/")
+		roots = append(roots, prefix[:strings.LastIndex(prefix, "/")+1])
+	}
+	sort.Strings(roots)
+
+	var results []string
+	for _, root := range roots {
+		if len(results) == 0 || !strings.HasPrefix(root, results[len(results)-1]) {
+			results = append(results, root)
+		}
+	}
+	return results
+}
+
+// list return objects under the root in path order, excluding the checkpoint
+func (engine Engine) list(root string) ([]*model.Object, error) {
+	results, err := engine.Storage.List(root)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointDir := path.Dir(engine.Config.CheckpointPath) + "/"
+	var objects []*model.Object
+	for _, object := range results {
+		object.Path = "/" + strings.TrimPrefix(object.Path, "/")
+		if object.Path != engine.Config.CheckpointPath && !strings.HasPrefix(object.Path, checkpointDir) {
+			objects = append(objects, object)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+// Run evaluate rules against objects of the storage, and apply actions unless dryRun. Only directories of rule prefixes are listed.
+// With Config.BatchSize, a run processes a batch of objects after the saved checkpoint, directories before the checkpoint are not
+// listed again, and directories after the batch are not listed until the next run; dry runs read the checkpoint but don't save it
+func (engine Engine) Run(dryRun bool) (*Report, error) {
+	if err := engine.Policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	if engine.Config.VersionKey == nil {
+		for _, rule := range engine.Policy.Rules {
+			if rule.MaxVersions > 0 && !rule.Disabled {
+				return nil, ErrVersionKeyRequired
+			}
+		}
+	}
+
+	var (
+		now    = engine.Config.Now()
+		report = &Report{DryRun: dryRun, Completed: true}
+		after  string
+	)
+
+	if engine.Config.BatchSize > 0 {
+		after = engine.loadCheckpoint()
+	}
+
+	for _, root := range engine.scanRoots() {
+		// the root sorts before the checkpoint, it has been scanned by previous runs
+		if after > root && !strings.HasPrefix(after, root) {
+			continue
+		}
+
+		if engine.Config.BatchSize > 0 && report.Scanned == engine.Config.BatchSize {
+			report.Completed = false
+			break
+		}
+
+		objects, err := engine.list(root)
+		if err != nil {
+			return report, err
+		}
+
+		// versions are counted against all objects of the root, which include all objects of its rules,
+		// so they are counted correctly even if the scan is split in batches
+		expiredVersions := engine.expiredVersions(objects)
+		start := sort.Search(len(objects), func(i int) bool { return objects[i].Path > after })
+
+		for _, object := range objects[start:] {
+			if engine.Config.BatchSize > 0 && report.Scanned == engine.Config.BatchSize {
+				report.Completed = false
+				break
+			}
+			report.Scanned++
+			report.Checkpoint = object.Path
+
+			decision := engine.decide(object, expiredVersions, now)
+			if decision != nil {
+				if !dryRun {
+					if err := engine.apply(decision); err != nil {
+						decision.Error = err.Error()
+					}
+				}
+				report.Decisions = append(report.Decisions, decision)
+			}
+		}
+
+		if !report.Completed {
+			break
+		}
+	}
+
+	// the checkpoint is only kept for incompleted scans
+	if report.Completed {
+		report.Checkpoint = ""
+	}
+
+	if !dryRun && engine.Config.BatchSize > 0 {
+		if err := engine.saveCheckpoint(report.Checkpoint); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// PushNative replace the native lifecycle configuration of the storage with the rules, instead of applying them with Run.
+// Only prefix filters, expiration and transition to storage classes are supported natively
+func (engine Engine) PushNative() error {
+	if err := engine.Policy.Validate(); err != nil {
+		return err
+	}
+
+	native, ok := engine.Storage.(model.LifecycleInterface)
+	if !ok {
+		return ErrNativeUnsupported
+	}
+	return native.SetLifecycleRules(engine.Policy.Rules)
+}
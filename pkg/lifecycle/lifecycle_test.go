@@ -0,0 +1,234 @@
+package lifecycle_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/lifecycle"
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+)
+
+const day = 24 * time.Hour
+
+var policy = `{"rules": [
+	{"id": "tmp", "prefix": "/tmp/", "expiration_days": 7},
+	{"id": "logs", "prefix": "/logs/", "suffix": ".log", "transition_days": 30, "transition_target": "cold"},
+	{"id": "backups", "prefix": "/backups/", "max_versions": 2}
+]}`
+
+func put(t *testing.T, dir string, storage model.StorageInterface, path string, modified time.Time) {
+	storage.Put(path, strings.NewReader(path))
+	if err := os.Chtimes(filepath.Join(dir, path), modified, modified); err != nil {
+		t.Fatalf("No error should happen when change times, but got %v", err)
+	}
+}
+
+func setup(t *testing.T, now time.Time) (string, model.StorageInterface, model.StorageInterface) {
+	dir := t.TempDir()
+	storage := filesystem.New(dir)
+	put(t, dir, storage, "/tmp/old.txt", now.Add(-8*day))
+	put(t, dir, storage, "/tmp/new.txt", now.Add(-1*day))
+	put(t, dir, storage, "/logs/old.log", now.Add(-40*day))
+	put(t, dir, storage, "/logs/old.txt", now.Add(-40*day))
+	put(t, dir, storage, "/backups/db/1.sql", now.Add(-3*day))
+	put(t, dir, storage, "/backups/db/2.sql", now.Add(-2*day))
+	put(t, dir, storage, "/backups/db/3.sql", now.Add(-1*day))
+	put(t, dir, storage, "/backups/web/1.tar", now.Add(-3*day))
+	return dir, storage, filesystem.New(t.TempDir())
+}
+
+func load(t *testing.T) *lifecycle.Policy {
+	p, err := lifecycle.LoadPolicy(strings.NewReader(policy))
+	if err != nil {
+		t.Fatalf("No error should happen when load policy, but got %v", err)
+	}
+	return p
+}
+
+func decisions(report *lifecycle.Report) map[string]lifecycle.Action {
+	results := map[string]lifecycle.Action{}
+	for _, decision := range report.Decisions {
+		results[decision.Path] = decision.Action
+	}
+	return results
+}
+
+func TestRun(t *testing.T) {
+	now := time.Now()
+	_, storage, cold := setup(t, now)
+	engine := lifecycle.New(storage, load(t), &lifecycle.Config{
+		Targets:    map[string]model.StorageInterface{"cold": cold},
+		VersionKey: lifecycle.DirVersionKey,
+		Now:        func() time.Time { return now },
+	})
+
+	report, err := engine.Run(true)
+	if err != nil {
+		t.Fatalf("No error should happen when dry run, but got %v", err)
+	}
+
+	expected := map[string]lifecycle.Action{
+		"/tmp/old.txt":      lifecycle.ActionExpire,
+		"/logs/old.log":     lifecycle.ActionTransition,
+		"/backups/db/1.sql": lifecycle.ActionExpireVersion,
+	}
+	if results := decisions(report); len(results) != len(expected) {
+		t.Errorf("Dry run should decide %v, but got %v", expected, results)
+	} else {
+		for path, action := range expected {
+			if results[path] != action {
+				t.Errorf("%v should be %v, but got %v", path, action, results[path])
+			}
+		}
+	}
+	if _, err := storage.GetStream("/tmp/old.txt"); err != nil {
+		t.Errorf("Dry run should not delete objects")
+	}
+
+	if _, err := engine.Run(false); err != nil {
+		t.Fatalf("No error should happen when run, but got %v", err)
+	}
+	if _, err := storage.GetStream("/tmp/old.txt"); err == nil {
+		t.Errorf("Expired object should be deleted")
+	}
+	if _, err := storage.GetStream("/backups/db/1.sql"); err == nil {
+		t.Errorf("Old version should be deleted")
+	}
+	if _, err := storage.GetStream("/logs/old.log"); err == nil {
+		t.Errorf("Transitioned object should be removed from the storage")
+	}
+	if _, err := cold.GetStream("/logs/old.log"); err != nil {
+		t.Errorf("Transitioned object should be moved to the target, but got %v", err)
+	}
+
+	if report, _ := engine.Run(true); len(report.Decisions) != 0 {
+		t.Errorf("Nothing should be left to do, but got %v", decisions(report))
+	}
+}
+
+type listRecorder struct {
+	model.StorageInterface
+	listed []string
+}
+
+func (recorder *listRecorder) List(path string) ([]*model.Object, error) {
+	recorder.listed = append(recorder.listed, path)
+	return recorder.StorageInterface.List(path)
+}
+
+func TestCheckpoint(t *testing.T) {
+	now := time.Now()
+	dir, fs, cold := setup(t, now)
+	put(t, dir, fs, "/other/a.txt", now.Add(-40*day))
+	storage := &listRecorder{StorageInterface: fs}
+	engine := lifecycle.New(storage, load(t), &lifecycle.Config{
+		Targets:    map[string]model.StorageInterface{"cold": cold},
+		BatchSize:  3,
+		VersionKey: lifecycle.DirVersionKey,
+		Now:        func() time.Time { return now },
+	})
+
+	var (
+		runs    int
+		scanned int
+		actions = map[string]lifecycle.Action{}
+		listed  []string
+	)
+	for {
+		storage.listed = nil
+		report, err := engine.Run(false)
+		if err != nil {
+			t.Fatalf("No error should happen when run, but got %v", err)
+		}
+		runs++
+		scanned += report.Scanned
+		listed = append(listed, strings.Join(storage.listed, ","))
+		for path, action := range decisions(report) {
+			actions[path] = action
+		}
+		if report.Completed {
+			break
+		}
+		if report.Scanned != 3 || runs > 5 {
+			t.Fatalf("Each run should process a batch, but got %+v", report)
+		}
+	}
+
+	if runs != 3 || scanned != 8 {
+		t.Errorf("Scan should be split in 3 runs over 8 objects, but got %v runs over %v objects", runs, scanned)
+	}
+	if len(actions) != 3 {
+		t.Errorf("Same actions should be applied as a full scan, but got %v", actions)
+	}
+	if expected := []string{"/backups/", "/backups/,/logs/", "/logs/,/tmp/"}; strings.Join(listed, " ") != strings.Join(expected, " ") {
+		t.Errorf("Each run should only list rule directories from the checkpoint, expected %v, but got %v", expected, listed)
+	}
+	if objects, _ := fs.List("/"); len(objects) != 6 {
+		t.Errorf("Checkpoint should be removed after the scan is completed, but got %v objects", len(objects))
+	}
+}
+
+func TestVersionKey(t *testing.T) {
+	now := time.Now()
+	_, storage, _ := setup(t, now)
+	if _, err := lifecycle.New(storage, load(t), nil).Run(true); err != lifecycle.ErrVersionKeyRequired {
+		t.Errorf("Max versions rules should require a version key, but got %v", err)
+	}
+
+	key := lifecycle.SuffixVersionKey("@")
+	for path, expected := range map[string]string{
+		"/backups/db@2022-01-01.sql": "/backups/db",
+		"/backups/db@2022-01-02.sql": "/backups/db",
+		"/backups/web.tar":           "/backups/web.tar",
+		"/backups/@2022.tar":         "/backups/@2022.tar",
+	} {
+		if result := key(path); result != expected {
+			t.Errorf("Version key of %v should be %v, but got %v", path, expected, result)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []string{
+		`{"rules": [{"prefix": "/tmp/", "expiration_days": 7}]}`,
+		`{"rules": [{"id": "a", "expiration_days": 7}, {"id": "a", "expiration_days": 1}]}`,
+		`{"rules": [{"id": "a", "prefix": "/tmp/"}]}`,
+		`{"rules": [{"id": "a", "transition_days": 7}]}`,
+	}
+	for _, p := range invalid {
+		if _, err := lifecycle.LoadPolicy(strings.NewReader(p)); err == nil {
+			t.Errorf("Policy %v should be rejected", p)
+		}
+	}
+}
+
+func TestPushNative(t *testing.T) {
+	engine := lifecycle.New(filesystem.New(t.TempDir()), load(t), nil)
+	if err := engine.PushNative(); err != lifecycle.ErrNativeUnsupported {
+		t.Errorf("File system should not support native lifecycle, but got %v", err)
+	}
+}
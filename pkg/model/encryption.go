@@ -0,0 +1,84 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EncryptionMode who manages keys of server-side encryption
+type EncryptionMode string
+
+const (
+	// EncryptionStorageManaged keys are managed by the storage, e.g. SSE-S3 and OSS AES256 or SM4
+	EncryptionStorageManaged EncryptionMode = "storage"
+	// EncryptionKMS keys are managed by the key management service of the provider, e.g. SSE-KMS
+	EncryptionKMS EncryptionMode = "kms"
+	// EncryptionCustomerKey keys are provided by the customer with every request, e.g. SSE-C
+	EncryptionCustomerKey EncryptionMode = "customer"
+)
+
+// Encryption server-side encryption of an object. CustomerKey is only used to write and read objects,
+// objects returned by Stat report its MD5 as CustomerKeyMD5
+type Encryption struct {
+	Mode EncryptionMode `json:",omitempty"`
+	// Algorithm of storage managed encryption, default to AES256, OSS supports SM4 too
+	Algorithm string `json:",omitempty"`
+	// KMSKeyID key of KMS encryption, the default key of the account is used if blank
+	KMSKeyID string `json:",omitempty"`
+	// CustomerKey 256 bits key of customer key encryption
+	CustomerKey    []byte `json:"-"`
+	CustomerKeyMD5 string `json:",omitempty"`
+}
+
+// Validate check settings of the encryption match its mode
+func (encryption *Encryption) Validate() error {
+	if encryption == nil {
+		return nil
+	}
+
+	switch encryption.Mode {
+	case EncryptionStorageManaged:
+		if algorithm := strings.ToUpper(encryption.Algorithm); algorithm != "" && algorithm != "AES256" && algorithm != "SM4" {
+			return fmt.Errorf("encryption: unknown algorithm %q", encryption.Algorithm)
+		}
+	case EncryptionKMS:
+	case EncryptionCustomerKey:
+		if len(encryption.CustomerKey) != 32 {
+			return fmt.Errorf("encryption: customer key should be 256 bits, but got %d bits", len(encryption.CustomerKey)*8)
+		}
+	default:
+		return fmt.Errorf("encryption: unknown mode %q", encryption.Mode)
+	}
+
+	if encryption.KMSKeyID != "" && encryption.Mode != EncryptionKMS {
+		return errors.New("encryption: KMS key ID requires KMS mode")
+	}
+	if len(encryption.CustomerKey) > 0 && encryption.Mode != EncryptionCustomerKey {
+		return errors.New("encryption: customer key requires customer key mode")
+	}
+	if encryption.Algorithm != "" && encryption.Mode != EncryptionStorageManaged {
+		return errors.New("encryption: algorithm requires storage managed mode")
+	}
+	return nil
+}
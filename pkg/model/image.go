@@ -0,0 +1,163 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrImageTransformUnsupported returned by GetImageURL when the storage can't transform images
+var ErrImageTransformUnsupported = errors.New("image transform is not supported by the storage")
+
+// ImageResizeMode how an image is resized into Width x Height
+type ImageResizeMode string
+
+const (
+	// ImageFit scale the image to fit into the box, keeping its aspect ratio
+	ImageFit ImageResizeMode = "fit"
+	// ImageFill scale the image to cover the box keeping its aspect ratio, then crop the center of it
+	ImageFill ImageResizeMode = "fill"
+	// ImageStretch scale the image to the box exactly, ignoring its aspect ratio
+	ImageStretch ImageResizeMode = "stretch"
+)
+
+// ImageGravity position of a watermark
+type ImageGravity string
+
+const (
+	GravityNorthWest ImageGravity = "nw"
+	GravityNorth     ImageGravity = "north"
+	GravityNorthEast ImageGravity = "ne"
+	GravityWest      ImageGravity = "west"
+	GravityCenter    ImageGravity = "center"
+	GravityEast      ImageGravity = "east"
+	GravitySouthWest ImageGravity = "sw"
+	GravitySouth     ImageGravity = "south"
+	GravitySouthEast ImageGravity = "se"
+)
+
+// ImageCrop region of the original image to keep, it is cropped before resizing
+type ImageCrop struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// ImageWatermark text or image watermark, Image is the path of an image in the same storage
+type ImageWatermark struct {
+	Text  string
+	Image string
+	// Gravity default to south east
+	Gravity ImageGravity
+	// X, Y offsets from the gravity edges in pixels
+	X int
+	Y int
+	// Opacity 1 to 100, default to 100
+	Opacity int
+}
+
+// ImageTransform provider-neutral image processing applied when the image is downloaded, zero values are not changed
+type ImageTransform struct {
+	Width  int
+	Height int
+	// Mode default to ImageFit
+	Mode ImageResizeMode
+	Crop *ImageCrop
+	// Format target format, like jpg, png, webp
+	Format string
+	// Quality 1 to 100
+	Quality   int
+	Watermark *ImageWatermark
+}
+
+var imageFormats = map[string]bool{"jpg": true, "jpeg": true, "png": true, "webp": true, "gif": true, "bmp": true, "tiff": true}
+
+var imageGravities = map[ImageGravity]bool{
+	GravityNorthWest: true, GravityNorth: true, GravityNorthEast: true,
+	GravityWest: true, GravityCenter: true, GravityEast: true,
+	GravitySouthWest: true, GravitySouth: true, GravitySouthEast: true,
+}
+
+// IsZero check the transform doesn't change the image
+func (transform ImageTransform) IsZero() bool {
+	return transform.Width == 0 && transform.Height == 0 && transform.Crop == nil && transform.Format == "" && transform.Quality == 0 && transform.Watermark == nil
+}
+
+// Validate check the transform is valid before rendering it to a provider's syntax
+func (transform ImageTransform) Validate() error {
+	if transform.Width < 0 || transform.Height < 0 {
+		return fmt.Errorf("image transform: invalid size %vx%v", transform.Width, transform.Height)
+	}
+
+	switch transform.Mode {
+	case "", ImageFit:
+	case ImageFill, ImageStretch:
+		if transform.Width == 0 || transform.Height == 0 {
+			return fmt.Errorf("image transform: mode %v requires both width and height", transform.Mode)
+		}
+	default:
+		return fmt.Errorf("image transform: unknown resize mode %q", transform.Mode)
+	}
+
+	if crop := transform.Crop; crop != nil && (crop.X < 0 || crop.Y < 0 || crop.Width <= 0 || crop.Height <= 0) {
+		return fmt.Errorf("image transform: invalid crop %+v", *crop)
+	}
+
+	if transform.Format != "" && !imageFormats[strings.ToLower(transform.Format)] {
+		return fmt.Errorf("image transform: unknown format %q", transform.Format)
+	}
+
+	if transform.Quality < 0 || transform.Quality > 100 {
+		return fmt.Errorf("image transform: quality %v is out of 1 to 100", transform.Quality)
+	}
+
+	if watermark := transform.Watermark; watermark != nil {
+		if (watermark.Text == "") == (watermark.Image == "") {
+			return errors.New("image transform: watermark requires either text or image")
+		}
+		if watermark.Gravity != "" && !imageGravities[watermark.Gravity] {
+			return fmt.Errorf("image transform: unknown watermark gravity %q", watermark.Gravity)
+		}
+		if watermark.X < 0 || watermark.Y < 0 {
+			return fmt.Errorf("image transform: invalid watermark offset %v,%v", watermark.X, watermark.Y)
+		}
+		if watermark.Opacity < 0 || watermark.Opacity > 100 {
+			return fmt.Errorf("image transform: watermark opacity %v is out of 1 to 100", watermark.Opacity)
+		}
+	}
+	return nil
+}
+
+// ImageURLInterface optional interface of storages transforming images when they are downloaded, e.g. Aliyun OSS and Qiniu
+type ImageURLInterface interface {
+	GetImageURL(path string, transform ImageTransform) (string, error)
+}
+
+// GetImageURL get URL of the transformed image, return ErrImageTransformUnsupported if the storage can't transform images
+func GetImageURL(storage StorageInterface, path string, transform ImageTransform) (string, error) {
+	if imager, ok := storage.(ImageURLInterface); ok {
+		return imager.GetImageURL(path, transform)
+	}
+	return "", ErrImageTransformUnsupported
+}
@@ -0,0 +1,47 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// LifecycleRule declarative lifecycle rule of objects matching Prefix and Suffix, ages are counted in days since the object was last modified
+type LifecycleRule struct {
+	ID       string `json:"id"`
+	Prefix   string `json:"prefix,omitempty"`
+	Suffix   string `json:"suffix,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	// ExpirationDays delete objects older than given days
+	ExpirationDays int `json:"expiration_days,omitempty"`
+
+	// TransitionDays move objects older than given days to TransitionStorageClass of the same storage,
+	// or to the storage named TransitionTarget
+	TransitionDays         int    `json:"transition_days,omitempty"`
+	TransitionStorageClass string `json:"transition_storage_class,omitempty"`
+	TransitionTarget       string `json:"transition_target,omitempty"`
+
+	// MaxVersions keep the newest given versions of an object, and delete older ones
+	MaxVersions int `json:"max_versions,omitempty"`
+}
+
+// LifecycleInterface optional interface of storages supporting bucket lifecycle configuration natively
+type LifecycleInterface interface {
+	GetLifecycleRules() ([]LifecycleRule, error)
+	SetLifecycleRules(rules []LifecycleRule) error
+}
@@ -21,8 +21,11 @@ package model
 // THE SOFTWARE.
 
 import (
+	"errors"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -37,11 +40,21 @@ type StorageInterface interface {
 	GetEndpoint() string
 }
 
+// StatInterface optional interface of storages which could get an object's information without downloading it
+type StatInterface interface {
+	Stat(path string) (*Object, error)
+}
+
 // Object content object
 type Object struct {
 	Path             string
 	Name             string
 	LastModified     *time.Time
+	Size             int64
+	ETag             string
+	ContentType      string
+	Retention        *Retention
+	Encryption       *Encryption
 	StorageInterface StorageInterface
 }
 
@@ -49,3 +62,77 @@ type Object struct {
 func (object Object) Get() (*os.File, error) {
 	return object.StorageInterface.Get(object.Path)
 }
+
+// NotExistInterface optional interface of storages whose errors of missing objects don't wrap os.ErrNotExist
+type NotExistInterface interface {
+	IsNotExist(err error) bool
+}
+
+// IsNotExist report whether the error returned by the storage means the object doesn't exist
+func IsNotExist(storage StorageInterface, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	if checker, ok := storage.(NotExistInterface); ok {
+		return checker.IsNotExist(err)
+	}
+	return false
+}
+
+// Exists report whether the object exists, errors other than the object doesn't exist are returned
+func Exists(storage StorageInterface, path string) (bool, error) {
+	var err error
+	if stater, ok := storage.(StatInterface); ok {
+		_, err = stater.Stat(path)
+	} else {
+		var stream io.ReadCloser
+		if stream, err = storage.GetStream(path); err == nil {
+			stream.Close()
+		}
+	}
+
+	if IsNotExist(storage, err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Copy copy the object from one storage to the same path of another storage
+func Copy(from StorageInterface, to StorageInterface, path string) error {
+	stream, err := from.GetStream(path)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	_, err = to.Put(path, stream)
+	return err
+}
+
+// Stat get object's information, use storage's Stat if it is supported, otherwise download the object to get its size
+func Stat(storage StorageInterface, path string) (*Object, error) {
+	if stater, ok := storage.(StatInterface); ok {
+		return stater.Stat(path)
+	}
+
+	stream, err := storage.GetStream(path)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	size, err := io.Copy(ioutil.Discard, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Object{
+		Path:             path,
+		Name:             filepath.Base(path),
+		Size:             size,
+		StorageInterface: storage,
+	}, nil
+}
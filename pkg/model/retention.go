@@ -0,0 +1,66 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io"
+	"time"
+)
+
+// RetentionMode WORM retention mode
+type RetentionMode string
+
+const (
+	// RetentionGovernance retention could be shortened or removed by privileged users
+	RetentionGovernance RetentionMode = "GOVERNANCE"
+	// RetentionCompliance retention can't be shortened or removed by anyone until it expires
+	RetentionCompliance RetentionMode = "COMPLIANCE"
+)
+
+// Retention WORM retention status of an object
+type Retention struct {
+	Mode        RetentionMode `json:",omitempty"`
+	RetainUntil *time.Time    `json:",omitempty"`
+	LegalHold   bool          `json:",omitempty"`
+}
+
+// Locked whether the object can't be deleted or overwritten at given time
+func (retention *Retention) Locked(now time.Time) bool {
+	return retention != nil && (retention.LegalHold || retention.Retained(now))
+}
+
+// Retained whether the retention period is still in effect at given time
+func (retention *Retention) Retained(now time.Time) bool {
+	return retention != nil && retention.RetainUntil != nil && now.Before(*retention.RetainUntil)
+}
+
+// RetentionInterface optional interface of storages supporting per-object WORM retention natively
+type RetentionInterface interface {
+	GetRetention(path string) (*Retention, error)
+	SetRetention(path string, mode RetentionMode, retainUntil time.Time, bypassGovernance bool) error
+	SetLegalHold(path string, hold bool) error
+}
+
+// RetentionPutInterface optional interface of native retention storages which save an object and its retention in one request,
+// so a storage which can't retain it (e.g. an S3 bucket without Object Lock) rejects the object instead of keeping it unretained
+type RetentionPutInterface interface {
+	PutWithRetention(path string, reader io.Reader, mode RetentionMode, retainUntil time.Time) (*Object, error)
+}
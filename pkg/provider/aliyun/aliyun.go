@@ -21,12 +21,17 @@ package aliyun
 // THE SOFTWARE.
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -50,6 +55,22 @@ type Config struct {
 	ACL           aliyun.ACLType
 	ClientOptions []aliyun.ClientOption
 	UseCname      bool
+
+	// Encryption server-side encryption of uploaded objects, OSS supports storage managed (AES256 or SM4) and KMS encryption
+	Encryption *model.Encryption
+	// ObjectEncryption encryption of the object at given path, overwrites Encryption if it returns non-nil
+	ObjectEncryption func(path string) *model.Encryption
+}
+
+// IsNotExist report whether the error means the object doesn't exist
+func IsNotExist(err error) bool {
+	serviceErr, ok := err.(aliyun.ServiceError)
+	return ok && serviceErr.StatusCode == http.StatusNotFound
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
 }
 
 // New initialize Aliyun storage
@@ -67,6 +88,10 @@ func New(config *Config) *Client {
 		config.ACL = aliyun.ACLPublicRead
 	}
 
+	if err := validateEncryption(config.Encryption); err != nil {
+		panic(err)
+	}
+
 	if config.UseCname {
 		config.ClientOptions = append(config.ClientOptions, aliyun.UseCname(config.UseCname))
 	}
@@ -84,6 +109,57 @@ func New(config *Config) *Client {
 	return client
 }
 
+// validateEncryption check OSS supports the encryption
+func validateEncryption(encryption *model.Encryption) error {
+	if err := encryption.Validate(); err != nil {
+		return err
+	}
+	if encryption != nil && encryption.Mode == model.EncryptionCustomerKey {
+		return errors.New("aliyun: customer key encryption is not supported by OSS")
+	}
+	return nil
+}
+
+// encryptionOptions get options to encrypt the object at given path
+func (client Client) encryptionOptions(path string) ([]aliyun.Option, error) {
+	encryption := client.Config.Encryption
+	if client.Config.ObjectEncryption != nil {
+		if objectEncryption := client.Config.ObjectEncryption("/" + client.ToRelativePath(path)); objectEncryption != nil {
+			encryption = objectEncryption
+		}
+	}
+
+	if err := validateEncryption(encryption); err != nil || encryption == nil {
+		return nil, err
+	}
+
+	if encryption.Mode == model.EncryptionKMS {
+		options := []aliyun.Option{aliyun.ServerSideEncryption("KMS")}
+		if encryption.KMSKeyID != "" {
+			options = append(options, aliyun.ServerSideEncryptionKeyID(encryption.KMSKeyID))
+		}
+		return options, nil
+	}
+
+	algorithm := strings.ToUpper(encryption.Algorithm)
+	if algorithm == "" {
+		algorithm = "AES256"
+	}
+	return []aliyun.Option{aliyun.ServerSideEncryption(algorithm)}, nil
+}
+
+// headerEncryption get server-side encryption of the object from its headers
+func headerEncryption(header http.Header) *model.Encryption {
+	switch algorithm := header.Get(aliyun.HTTPHeaderOssServerSideEncryption); algorithm {
+	case "":
+		return nil
+	case "KMS":
+		return &model.Encryption{Mode: model.EncryptionKMS, KMSKeyID: header.Get(aliyun.HTTPHeaderOssServerSideEncryptionKeyID)}
+	default:
+		return &model.Encryption{Mode: model.EncryptionStorageManaged, Algorithm: algorithm}
+	}
+}
+
 // Get receive file with given path
 func (client Client) Get(path string) (file *os.File, err error) {
 	readCloser, err := client.GetStream(path)
@@ -104,13 +180,145 @@ func (client Client) GetStream(path string) (io.ReadCloser, error) {
 	return client.Bucket.GetObject(client.ToRelativePath(path))
 }
 
+// Stat get object's information, retention is reported if the bucket has a WORM retention policy, and so is server-side encryption
+func (client Client) Stat(path string) (*model.Object, error) {
+	header, err := client.Bucket.GetObjectDetailedMeta(client.ToRelativePath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	object := &model.Object{
+		Path:             "/" + client.ToRelativePath(path),
+		Name:             filepath.Base(path),
+		ETag:             strings.Trim(header.Get("ETag"), `"`),
+		ContentType:      header.Get("Content-Type"),
+		Encryption:       headerEncryption(header),
+		StorageInterface: client,
+	}
+	object.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		object.LastModified = &lastModified
+	}
+
+	if object.LastModified != nil {
+		if object.Retention, err = client.bucketRetention(*object.LastModified); err != nil {
+			return nil, err
+		}
+	}
+	return object, nil
+}
+
+// bucketRetention get retention of an object modified at given time from the bucket's WORM policy
+func (client Client) bucketRetention(lastModified time.Time) (*model.Retention, error) {
+	worm, err := client.Bucket.Client.GetBucketWorm(client.Config.Bucket)
+	if err != nil {
+		if serviceErr, ok := err.(aliyun.ServiceError); ok && (serviceErr.StatusCode == http.StatusNotFound || serviceErr.StatusCode == http.StatusForbidden) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	retention := &model.Retention{Mode: model.RetentionGovernance}
+	switch worm.State {
+	case "Locked":
+		retention.Mode = model.RetentionCompliance
+	case "InProgress":
+	default:
+		return nil, nil
+	}
+
+	retainUntil := lastModified.AddDate(0, 0, worm.RetentionPeriodInDays)
+	retention.RetainUntil = &retainUntil
+	return retention, nil
+}
+
+// InitiateRetention create a WORM retention policy for the bucket, which could be aborted until it is completed with CompleteRetention
+func (client Client) InitiateRetention(days int) (wormID string, err error) {
+	return client.Bucket.Client.InitiateBucketWorm(client.Config.Bucket, days)
+}
+
+// CompleteRetention lock the bucket's WORM retention policy, objects can't be deleted or overwritten until they expire
+func (client Client) CompleteRetention(wormID string) error {
+	return client.Bucket.Client.CompleteBucketWorm(client.Config.Bucket, wormID)
+}
+
+// ExtendRetention extend the retention period of the bucket's locked WORM policy
+func (client Client) ExtendRetention(days int, wormID string) error {
+	return client.Bucket.Client.ExtendBucketWorm(client.Config.Bucket, days, wormID)
+}
+
+// GetLifecycleRules get lifecycle rules of the bucket
+func (client Client) GetLifecycleRules() ([]model.LifecycleRule, error) {
+	result, err := client.Bucket.Client.GetBucketLifecycle(client.Config.Bucket)
+	if err != nil {
+		if serviceErr, ok := err.(aliyun.ServiceError); ok && serviceErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rules []model.LifecycleRule
+	for _, ossRule := range result.Rules {
+		rule := model.LifecycleRule{
+			ID:       ossRule.ID,
+			Prefix:   "/" + ossRule.Prefix,
+			Disabled: ossRule.Status != "Enabled",
+		}
+		if ossRule.Expiration != nil {
+			rule.ExpirationDays = ossRule.Expiration.Days
+		}
+		if len(ossRule.Transitions) > 0 {
+			rule.TransitionDays = ossRule.Transitions[0].Days
+			rule.TransitionStorageClass = string(ossRule.Transitions[0].StorageClass)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetLifecycleRules replace lifecycle rules of the bucket, OSS only supports prefix filters, expiration and transition to storage classes
+func (client Client) SetLifecycleRules(rules []model.LifecycleRule) error {
+	if len(rules) == 0 {
+		return client.Bucket.Client.DeleteBucketLifecycle(client.Config.Bucket)
+	}
+
+	var ossRules []aliyun.LifecycleRule
+	for _, rule := range rules {
+		if rule.Suffix != "" || rule.MaxVersions > 0 || rule.TransitionTarget != "" {
+			return fmt.Errorf("lifecycle rule %v is not supported by OSS", rule.ID)
+		}
+
+		ossRule := aliyun.LifecycleRule{
+			ID:     rule.ID,
+			Prefix: strings.TrimPrefix(rule.Prefix, "/"),
+			Status: "Enabled",
+		}
+		if rule.Disabled {
+			ossRule.Status = "Disabled"
+		}
+		if rule.ExpirationDays > 0 {
+			ossRule.Expiration = &aliyun.LifecycleExpiration{Days: rule.ExpirationDays}
+		}
+		if rule.TransitionDays > 0 {
+			ossRule.Transitions = []aliyun.LifecycleTransition{{Days: rule.TransitionDays, StorageClass: aliyun.StorageClassType(rule.TransitionStorageClass)}}
+		}
+		ossRules = append(ossRules, ossRule)
+	}
+	return client.Bucket.Client.SetBucketLifecycle(client.Config.Bucket, ossRules)
+}
+
 // Put store a reader into given path
 func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
 	if seeker, ok := reader.(io.ReadSeeker); ok {
 		seeker.Seek(0, 0)
 	}
 
-	err := client.Bucket.PutObject(client.ToRelativePath(urlPath), reader, aliyun.ACL(client.Config.ACL))
+	options, err := client.encryptionOptions(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Bucket.PutObject(client.ToRelativePath(urlPath), reader, append(options, aliyun.ACL(client.Config.ACL))...)
 	now := time.Now()
 
 	return &model.Object{
@@ -130,20 +338,31 @@ func (client Client) Delete(path string) error {
 func (client Client) List(path string) ([]*model.Object, error) {
 	var objects []*model.Object
 
-	results, err := client.Bucket.ListObjects(aliyun.Prefix(path))
+	// each response returns at most MaxKeys objects, follow markers until all objects are listed
+	marker := ""
+	for {
+		results, err := client.Bucket.ListObjects(aliyun.Prefix(path), aliyun.Marker(marker), aliyun.MaxKeys(1000))
+		if err != nil {
+			return objects, err
+		}
 
-	if err == nil {
 		for _, obj := range results.Objects {
+			lastModified := obj.LastModified
 			objects = append(objects, &model.Object{
 				Path:             "/" + client.ToRelativePath(obj.Key),
 				Name:             filepath.Base(obj.Key),
-				LastModified:     &obj.LastModified,
+				LastModified:     &lastModified,
+				Size:             obj.Size,
+				ETag:             strings.Trim(obj.ETag, `"`),
 				StorageInterface: client,
 			})
 		}
-	}
 
-	return objects, err
+		if !results.IsTruncated {
+			return objects, nil
+		}
+		marker = results.NextMarker
+	}
 }
 
 // GetEndpoint get endpoint, FileSystem's endpoint is /
@@ -183,3 +402,91 @@ func (client Client) GetURL(path string) (url string, err error) {
 	}
 	return path, nil
 }
+
+// imageProcess render the image transform in x-oss-process syntax
+func (client Client) imageProcess(transform model.ImageTransform) string {
+	actions := []string{"image"}
+
+	if crop := transform.Crop; crop != nil {
+		actions = append(actions, fmt.Sprintf("crop,x_%d,y_%d,w_%d,h_%d", crop.X, crop.Y, crop.Width, crop.Height))
+	}
+
+	if transform.Width > 0 || transform.Height > 0 {
+		resize := "resize,m_lfit"
+		switch transform.Mode {
+		case model.ImageFill:
+			resize = "resize,m_fill"
+		case model.ImageStretch:
+			resize = "resize,m_fixed"
+		}
+		if transform.Width > 0 {
+			resize += fmt.Sprintf(",w_%d", transform.Width)
+		}
+		if transform.Height > 0 {
+			resize += fmt.Sprintf(",h_%d", transform.Height)
+		}
+		actions = append(actions, resize)
+	}
+
+	if watermark := transform.Watermark; watermark != nil {
+		// OSS gravities share the names of model.ImageGravity
+		gravity := watermark.Gravity
+		if gravity == "" {
+			gravity = model.GravitySouthEast
+		}
+
+		mark := "watermark,text_" + base64.RawURLEncoding.EncodeToString([]byte(watermark.Text))
+		if watermark.Image != "" {
+			mark = "watermark,image_" + base64.RawURLEncoding.EncodeToString([]byte(client.ToRelativePath(watermark.Image)))
+		}
+		mark += fmt.Sprintf(",g_%s,x_%d,y_%d", gravity, watermark.X, watermark.Y)
+		if watermark.Opacity > 0 {
+			mark += fmt.Sprintf(",t_%d", watermark.Opacity)
+		}
+		actions = append(actions, mark)
+	}
+
+	if transform.Quality > 0 {
+		actions = append(actions, fmt.Sprintf("quality,Q_%d", transform.Quality))
+	}
+
+	if transform.Format != "" {
+		format := strings.ToLower(transform.Format)
+		if format == "jpeg" {
+			format = "jpg"
+		}
+		actions = append(actions, "format,"+format)
+	}
+
+	return strings.Join(actions, "/")
+}
+
+// GetImageURL get URL of the image processed by OSS, it is signed if the bucket is private
+func (client Client) GetImageURL(path string, transform model.ImageTransform) (string, error) {
+	if err := transform.Validate(); err != nil {
+		return "", err
+	}
+
+	if transform.IsZero() {
+		return client.GetURL(path)
+	}
+
+	key := client.ToRelativePath(path)
+	process := client.imageProcess(transform)
+	if client.Config.ACL == aliyun.ACLPrivate {
+		return client.Bucket.SignURL(key, aliyun.HTTPGet, 60*60, aliyun.Process(process)) // 1 hour
+	}
+
+	endpoint := client.GetEndpoint()
+	if !strings.Contains(endpoint, "://") {
+		// same scheme as the SDK, which uses http unless the endpoint is https
+		if strings.HasPrefix(client.Bucket.Client.Config.Endpoint, "https://") {
+			endpoint = "https://" + endpoint
+		} else {
+			endpoint = "http://" + endpoint
+		}
+	}
+
+	objectURL := &url.URL{Path: "/" + key, RawQuery: url.Values{"x-oss-process": {process}}.Encode()}
+	return strings.TrimSuffix(endpoint, "/") + objectURL.String(), nil
+}
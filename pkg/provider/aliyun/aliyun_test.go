@@ -21,12 +21,23 @@ package aliyun_test
 // THE SOFTWARE.
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 
 	aliyunoss "github.com/aliyun/aliyun-oss-go-sdk/oss"
 	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/model"
 	"github.com/bhojpur/drive/pkg/provider/aliyun"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
 	"github.com/bhojpur/drive/tests"
 )
 
@@ -77,3 +88,131 @@ func TestAll(t *testing.T) {
 		tests.TestAll(cli, t)
 	}
 }
+
+func TestImageURL(t *testing.T) {
+	public := aliyun.New(&aliyun.Config{AccessID: "id", AccessKey: "key", Bucket: "drive"})
+
+	cases := []struct {
+		transform model.ImageTransform
+		process   string
+	}{
+		{model.ImageTransform{Width: 200, Height: 100, Format: "webp", Quality: 80}, "image/resize,m_lfit,w_200,h_100/quality,Q_80/format,webp"},
+		{model.ImageTransform{Width: 200, Height: 100, Mode: model.ImageFill, Crop: &model.ImageCrop{X: 10, Y: 20, Width: 300, Height: 400}}, "image/crop,x_10,y_20,w_300,h_400/resize,m_fill,w_200,h_100"},
+		{model.ImageTransform{Height: 100, Mode: model.ImageFit, Watermark: &model.ImageWatermark{Text: "drive", X: 5, Y: 6, Opacity: 50}}, "image/resize,m_lfit,h_100/watermark,text_ZHJpdmU,g_se,x_5,y_6,t_50"},
+		{model.ImageTransform{Format: "JPEG", Watermark: &model.ImageWatermark{Image: "/logo.png", Gravity: model.GravityNorthWest}}, "image/watermark,image_bG9nby5wbmc,g_nw,x_0,y_0/format,jpg"},
+	}
+	for _, c := range cases {
+		imageURL, err := model.GetImageURL(public, "/photos/cat.png", c.transform)
+		if err != nil {
+			t.Errorf("No error should happen when get image URL, but got %v", err)
+		} else if expected := "http://drive.oss-cn-hangzhou.aliyuncs.com/photos/cat.png?x-oss-process=" + url.QueryEscape(c.process); imageURL != expected {
+			t.Errorf("Image URL should be %v, but got %v", expected, imageURL)
+		}
+	}
+
+	if _, err := public.GetImageURL("/photos/cat.png", model.ImageTransform{Quality: 101}); err == nil {
+		t.Errorf("Invalid transform should fail")
+	}
+
+	private := aliyun.New(&aliyun.Config{AccessID: "id", AccessKey: "key", Bucket: "drive", ACL: aliyunoss.ACLPrivate})
+	imageURL, err := private.GetImageURL("/photos/cat.png", model.ImageTransform{Width: 100})
+	if err != nil {
+		t.Fatalf("No error should happen when get private image URL, but got %v", err)
+	}
+
+	signed, _ := url.Parse(imageURL)
+	query := signed.Query()
+	mac := hmac.New(sha1.New, []byte("key"))
+	fmt.Fprintf(mac, "GET\n\n\n%s\n/drive/photos/cat.png?x-oss-process=%s", query.Get("Expires"), "image/resize,m_lfit,w_100")
+	if query.Get("x-oss-process") != "image/resize,m_lfit,w_100" || query.Get("Signature") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Private image URL should be signed with the process, but got %v", imageURL)
+	}
+
+	if _, err := model.GetImageURL(filesystem.New(t.TempDir()), "/photos/cat.png", model.ImageTransform{Width: 100}); err != model.ErrImageTransformUnsupported {
+		t.Errorf("Storages without image processing should return unsupported error, but got %v", err)
+	}
+}
+
+// encryptedOSS in-process fake of OSS, which stores encryption headers of objects
+type encryptedOSS struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	objects map[string]http.Header
+}
+
+func startEncryptedOSS(t *testing.T) *encryptedOSS {
+	fake := &encryptedOSS{objects: map[string]http.Header{}}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+
+		switch {
+		case r.Method == http.MethodPut:
+			ioutil.ReadAll(r.Body)
+			header := http.Header{}
+			for _, name := range []string{aliyunoss.HTTPHeaderOssServerSideEncryption, aliyunoss.HTTPHeaderOssServerSideEncryptionKeyID} {
+				if value := r.Header.Get(name); value != "" {
+					header.Set(name, value)
+				}
+			}
+			fake.objects[r.URL.Path] = header
+		case r.Method == http.MethodHead && fake.objects[r.URL.Path] != nil:
+			for name, values := range fake.objects[r.URL.Path] {
+				w.Header()[name] = values
+			}
+		default:
+			// including the bucket's WORM configuration
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+		}
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func TestEncryption(t *testing.T) {
+	fake := startEncryptedOSS(t)
+	client := aliyun.New(&aliyun.Config{
+		AccessID:   "id",
+		AccessKey:  "key",
+		Bucket:     "drive",
+		Endpoint:   fake.URL,
+		Encryption: &model.Encryption{Mode: model.EncryptionStorageManaged, Algorithm: "sm4"},
+		ObjectEncryption: func(path string) *model.Encryption {
+			if strings.HasPrefix(path, "/kms/") {
+				return &model.Encryption{Mode: model.EncryptionKMS, KMSKeyID: "drive-key"}
+			}
+			return nil
+		},
+	})
+
+	client.Put("/report.txt", strings.NewReader("report"))
+	if object, err := client.Stat("/report.txt"); err != nil {
+		t.Errorf("No error should happen when stat, but got %v", err)
+	} else if object.Encryption == nil || object.Encryption.Mode != model.EncryptionStorageManaged || object.Encryption.Algorithm != "SM4" {
+		t.Errorf("Stat should report SM4 encryption, but got %+v", object.Encryption)
+	}
+
+	client.Put("/kms/report.txt", strings.NewReader("report"))
+	if object, err := client.Stat("/kms/report.txt"); err != nil {
+		t.Errorf("No error should happen when stat, but got %v", err)
+	} else if object.Encryption == nil || object.Encryption.Mode != model.EncryptionKMS || object.Encryption.KMSKeyID != "drive-key" {
+		t.Errorf("Stat should report KMS encryption, but got %+v", object.Encryption)
+	}
+
+	for _, encryption := range []*model.Encryption{
+		{Mode: model.EncryptionCustomerKey, CustomerKey: make([]byte, 32)},
+		{Mode: model.EncryptionStorageManaged, KMSKeyID: "drive-key"},
+		{Mode: model.EncryptionStorageManaged, Algorithm: "DES"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Invalid encryption %+v should panic", encryption)
+				}
+			}()
+			aliyun.New(&aliyun.Config{AccessID: "id", AccessKey: "key", Bucket: "drive", Encryption: encryption})
+		}()
+	}
+}
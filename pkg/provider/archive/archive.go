@@ -0,0 +1,451 @@
+package archive
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// Formats of supported archives
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// ReadOnlyError returned by operations which would modify the archive
+type ReadOnlyError struct {
+	Op   string
+	Path string
+}
+
+func (err *ReadOnlyError) Error() string {
+	return fmt.Sprintf("archive: %s %s: storage is read-only", err.Op, err.Path)
+}
+
+// IsReadOnly check if the error is returned because the storage is read-only
+func IsReadOnly(err error) bool {
+	var readOnlyErr *ReadOnlyError
+	return errors.As(err, &readOnlyErr)
+}
+
+// Config archive storage config
+type Config struct {
+	// Format of the archive, detected from its content if empty
+	Format string
+	// StripComponents remove leading components from member names, like tar's --strip-components,
+	// e.g. 1 serves "release-1.0/bin/app" as "/bin/app"
+	StripComponents int
+	// Endpoint public URL the archive's content is served at, GetURL returns the path if it is empty
+	Endpoint string
+}
+
+type member struct {
+	name     string
+	size     int64
+	modified time.Time
+	// offset of the member's data in the archive, -1 if the data has to be read through a decompressor
+	offset int64
+	// file of zip archives, or index of the entry in tar archives
+	file  *zip.File
+	index int
+}
+
+// Client archive storage, serves members of a zip or tar archive without extracting it. Members are indexed when
+// the archive is opened, and read directly from the archive. It is safe for concurrent use
+type Client struct {
+	Config *Config
+	Format string
+
+	reader  io.ReaderAt
+	size    int64
+	members map[string]*member
+	names   []string
+	closer  func() error
+}
+
+// New initialize archive storage from a reader of the archive with given size
+func New(reader io.ReaderAt, size int64, config *Config) (*Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	client := &Client{Config: config, Format: config.Format, reader: reader, size: size, members: map[string]*member{}}
+	if client.Format == "" {
+		client.Format = detectFormat(reader)
+	}
+
+	var err error
+	switch client.Format {
+	case FormatZip:
+		err = client.indexZip()
+	case FormatTar, FormatTarGz:
+		err = client.indexTar()
+	default:
+		err = fmt.Errorf("archive: unsupported format %q", client.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range client.members {
+		client.names = append(client.names, name)
+	}
+	sort.Strings(client.names)
+	return client, nil
+}
+
+// OpenFile initialize archive storage from a local archive, which is kept open until Close
+func OpenFile(name string, config *Config) (*Client, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		var client *Client
+		if client, err = New(file, info.Size(), config); err == nil {
+			client.closer = file.Close
+			return client, nil
+		}
+	}
+	file.Close()
+	return nil, err
+}
+
+// Open initialize archive storage from an archive saved in another storage, the archive is downloaded into a
+// temporary file which is removed on Close
+func Open(storage model.StorageInterface, path string, config *Config) (*Client, error) {
+	stream, err := storage.GetStream(path)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	file, err := ioutil.TempFile("", "archive")
+	if err != nil {
+		return nil, err
+	}
+	closer := func() error {
+		err := file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	size, err := io.Copy(file, stream)
+	if err == nil {
+		var client *Client
+		if client, err = New(file, size, config); err == nil {
+			client.closer = closer
+			return client, nil
+		}
+	}
+	closer()
+	return nil, err
+}
+
+// Close release the archive opened by OpenFile or Open
+func (client *Client) Close() error {
+	if client.closer != nil {
+		return client.closer()
+	}
+	return nil
+}
+
+func detectFormat(reader io.ReaderAt) string {
+	header := make([]byte, 512)
+	n, _ := reader.ReadAt(header, 0)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGz
+	}
+	return FormatTar
+}
+
+// memberName convert name of an archive member to the storage key, returns empty string if it should be skipped
+func (client *Client) memberName(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	segments := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if len(segments) <= client.Config.StripComponents {
+		return ""
+	}
+	return strings.Join(segments[client.Config.StripComponents:], "/")
+}
+
+func (client *Client) indexZip() error {
+	reader, err := zip.NewReader(client.reader, client.size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		name := client.memberName(file.Name)
+		if name == "" || file.FileInfo().IsDir() {
+			continue
+		}
+
+		m := &member{name: name, size: int64(file.UncompressedSize64), modified: file.Modified, offset: -1, file: file}
+		if file.Method == zip.Store {
+			if offset, err := file.DataOffset(); err == nil {
+				m.offset = offset
+			}
+		}
+		client.members[name] = m
+	}
+	return nil
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.n += int64(n)
+	return n, err
+}
+
+// tarReader open a tar reader from the beginning of the archive
+func (client *Client) tarReader() (*tar.Reader, *countingReader, error) {
+	var reader io.Reader = io.NewSectionReader(client.reader, 0, client.size)
+	if client.Format == FormatTarGz {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = gzipReader
+	}
+
+	counter := &countingReader{Reader: reader}
+	return tar.NewReader(counter), counter, nil
+}
+
+func (client *Client) indexTar() error {
+	reader, counter, err := client.tarReader()
+	if err != nil {
+		return err
+	}
+
+	for index := 0; ; index++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := client.memberName(header.Name)
+		if name == "" || !header.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		// tar reader consumes headers block by block, so data of the entry starts at the current position
+		m := &member{name: name, size: header.Size, modified: header.ModTime, offset: counter.n, index: index}
+		if client.Format == FormatTarGz || isSparse(header) {
+			m.offset = -1
+		}
+		client.members[name] = m
+	}
+}
+
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) lookup(op string, urlPath string) (*member, error) {
+	if m, ok := client.members[strings.TrimPrefix(path.Clean("/"+urlPath), "/")]; ok {
+		return m, nil
+	}
+	return nil, &os.PathError{Op: op, Path: urlPath, Err: os.ErrNotExist}
+}
+
+func (client *Client) open(m *member) (io.ReadCloser, error) {
+	switch {
+	case m.offset >= 0:
+		return ioutil.NopCloser(io.NewSectionReader(client.reader, m.offset, m.size)), nil
+	case m.file != nil:
+		return m.file.Open()
+	}
+
+	reader, _, err := client.tarReader()
+	if err != nil {
+		return nil, err
+	}
+	for index := 0; index <= m.index; index++ {
+		if _, err := reader.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.NopCloser(reader), nil
+}
+
+// Get receive file with given path
+func (client *Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+	if err != nil {
+		return nil, err
+	}
+	defer readCloser.Close()
+
+	if file, err = ioutil.TempFile("/tmp", "archive"); err == nil {
+		if _, err = io.Copy(file, readCloser); err == nil {
+			_, err = file.Seek(0, 0)
+		}
+	}
+	return file, err
+}
+
+// GetStream get file as stream
+func (client *Client) GetStream(path string) (io.ReadCloser, error) {
+	m, err := client.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	return client.open(m)
+}
+
+// GetRange get length bytes of the file starting at offset as stream, a negative length reads until the end of the file.
+// Uncompressed members are read directly from the archive, compressed members are decompressed and skipped to offset
+func (client *Client) GetRange(path string, offset, length int64) (io.ReadCloser, error) {
+	m, err := client.lookup("open", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || offset > m.size {
+		return nil, fmt.Errorf("range offset %d is out of file size %d", offset, m.size)
+	}
+	if length < 0 || offset+length > m.size {
+		length = m.size - offset
+	}
+
+	if m.offset >= 0 {
+		return ioutil.NopCloser(io.NewSectionReader(client.reader, m.offset+offset, length)), nil
+	}
+
+	stream, err := client.open(m)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, stream, offset); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(stream, length), stream}, nil
+}
+
+func (client *Client) object(m *member) *model.Object {
+	modified := m.modified
+	return &model.Object{
+		Path:             "/" + m.name,
+		Name:             path.Base(m.name),
+		LastModified:     &modified,
+		Size:             m.size,
+		ContentType:      mime.TypeByExtension(path.Ext(m.name)),
+		StorageInterface: client,
+	}
+}
+
+// Stat get object's information
+func (client *Client) Stat(path string) (*model.Object, error) {
+	m, err := client.lookup("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	return client.object(m), nil
+}
+
+// Put archive is read-only, always returns ReadOnlyError
+func (client *Client) Put(path string, reader io.Reader) (*model.Object, error) {
+	return nil, &ReadOnlyError{Op: "put", Path: path}
+}
+
+// Delete archive is read-only, always returns ReadOnlyError
+func (client *Client) Delete(path string) error {
+	return &ReadOnlyError{Op: "delete", Path: path}
+}
+
+// List list all objects under current path
+func (client *Client) List(path string) ([]*model.Object, error) {
+	prefix := strings.Trim(path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var objects []*model.Object
+	for i := sort.SearchStrings(client.names, prefix); i < len(client.names) && strings.HasPrefix(client.names[i], prefix); i++ {
+		objects = append(objects, client.object(client.members[client.names[i]]))
+	}
+	return objects, nil
+}
+
+// GetEndpoint get endpoint, archive's endpoint is Endpoint or /
+func (client *Client) GetEndpoint() string {
+	if client.Config.Endpoint != "" {
+		return client.Config.Endpoint
+	}
+	return "/"
+}
+
+// GetURL get public accessible URL if Endpoint is set, otherwise return the path like FileSystem does
+func (client *Client) GetURL(path string) (string, error) {
+	if client.Config.Endpoint != "" {
+		return strings.TrimSuffix(client.Config.Endpoint, "/") + "/" + strings.TrimPrefix(path, "/"), nil
+	}
+	return path, nil
+}
@@ -0,0 +1,250 @@
+package archive_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/archive"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+)
+
+var (
+	modified = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	files    = map[string][]byte{
+		"release/README.md":      []byte("# Release"),
+		"release/bin/app":        randomData(100000, 1),
+		"release/web/index.html": []byte("<html></html>"),
+		"release/web/app.js":     randomData(5000, 2),
+	}
+)
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func names() []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	return names
+}
+
+func buildZip() []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	writer.CreateHeader(&zip.FileHeader{Name: "release/", Modified: modified})
+	for _, name := range names() {
+		// keep the binary uncompressed to cover direct ranged reads
+		method := zip.Deflate
+		if strings.HasPrefix(name, "release/bin/") {
+			method = zip.Store
+		}
+		w, _ := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: modified})
+		w.Write(files[name])
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func buildTar(compress bool) []byte {
+	var buf bytes.Buffer
+	var gzipWriter *gzip.Writer
+	writer := tar.NewWriter(&buf)
+	if compress {
+		gzipWriter = gzip.NewWriter(&buf)
+		writer = tar.NewWriter(gzipWriter)
+	}
+
+	writer.WriteHeader(&tar.Header{Name: "./release/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modified})
+	for _, name := range names() {
+		writer.WriteHeader(&tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name])), ModTime: modified})
+		writer.Write(files[name])
+	}
+	// long names are stored in PAX headers
+	long := "release/" + strings.Repeat("d", 120) + "/file.txt"
+	writer.WriteHeader(&tar.Header{Name: long, Typeflag: tar.TypeReg, Mode: 0644, Size: 4, ModTime: modified})
+	writer.Write([]byte("long"))
+	writer.WriteHeader(&tar.Header{Name: "release/link", Typeflag: tar.TypeSymlink, Linkname: "README.md", ModTime: modified})
+	writer.Close()
+
+	if gzipWriter != nil {
+		gzipWriter.Close()
+	}
+	return buf.Bytes()
+}
+
+func TestFormats(t *testing.T) {
+	archives := map[string][]byte{archive.FormatZip: buildZip(), archive.FormatTar: buildTar(false), archive.FormatTarGz: buildTar(true)}
+
+	for format, data := range archives {
+		client, err := archive.New(bytes.NewReader(data), int64(len(data)), &archive.Config{StripComponents: 1})
+		if err != nil {
+			t.Fatalf("No error should happen when open %v archive, but got %v", format, err)
+		}
+		if client.Format != format {
+			t.Errorf("Format should be detected as %v, but got %v", format, client.Format)
+		}
+
+		for name, content := range files {
+			key := strings.TrimPrefix(name, "release")
+			stream, err := client.GetStream(key)
+			if err != nil {
+				t.Errorf("No error should happen when get %v from %v archive, but got %v", key, format, err)
+				continue
+			}
+			if result, err := ioutil.ReadAll(stream); err != nil || !bytes.Equal(result, content) {
+				t.Errorf("%v from %v archive should match content, but got %v", key, format, err)
+			}
+			stream.Close()
+
+			object, err := client.Stat(key)
+			if err != nil || object.Path != key || object.Size != int64(len(content)) || !object.LastModified.Equal(modified) {
+				t.Errorf("Stat of %v from %v archive should return object's information, but got %+v, %v", key, format, object, err)
+			}
+		}
+
+		if file, err := client.Get("/web/index.html"); err != nil {
+			t.Errorf("No error should happen when get file, but got %v", err)
+		} else if result, _ := ioutil.ReadAll(file); string(result) != "<html></html>" {
+			t.Errorf("File should match content, but got %v", string(result))
+		}
+
+		if objects, err := client.List("/web"); err != nil || len(objects) != 2 || objects[0].Path != "/web/app.js" || objects[1].ContentType != "text/html; charset=utf-8" {
+			t.Errorf("List should return members under the path, but got %v, %v", objects, err)
+		}
+		if objects, _ := client.List("/"); (format == archive.FormatZip && len(objects) != 4) || (format != archive.FormatZip && len(objects) != 5) {
+			t.Errorf("List should return all files of %v archive, but got %v", format, len(objects))
+		}
+
+		if _, err := client.Stat("/link"); !os.IsNotExist(err) {
+			t.Errorf("Only regular files should be served, but got %v", err)
+		}
+		if _, err := client.GetStream("/missing.txt"); !os.IsNotExist(err) {
+			t.Errorf("Missing file should return not exist error, but got %v", err)
+		}
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	content := files["release/bin/app"]
+	for _, data := range [][]byte{buildZip(), buildTar(false), buildTar(true)} {
+		client, _ := archive.New(bytes.NewReader(data), int64(len(data)), nil)
+
+		ranges := [][2]int64{{0, 10}, {5000, 30000}, {99990, 100}, {123, -1}, {100000, 10}}
+		for _, r := range ranges {
+			stream, err := client.GetRange("/release/bin/app", r[0], r[1])
+			if err != nil {
+				t.Errorf("No error should happen when get range %v from %v archive, but got %v", r, client.Format, err)
+				continue
+			}
+
+			end := r[0] + r[1]
+			if r[1] < 0 || end > int64(len(content)) {
+				end = int64(len(content))
+			}
+			if result, err := ioutil.ReadAll(stream); err != nil || !bytes.Equal(result, content[r[0]:end]) {
+				t.Errorf("range %v from %v archive should match content, but got %v bytes, %v", r, client.Format, len(result), err)
+			}
+			stream.Close()
+		}
+
+		if _, err := client.GetRange("/release/bin/app", 100001, 1); err == nil {
+			t.Errorf("range out of file should fail")
+		}
+		if _, err := client.GetRange("/release/web/app.js", 10, 10); err != nil {
+			t.Errorf("Compressed members should support ranges too, but got %v", err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	storage := filesystem.New(t.TempDir())
+	storage.Put("/bundles/release.tar.gz", bytes.NewReader(buildTar(true)))
+
+	client, err := archive.Open(storage, "/bundles/release.tar.gz", &archive.Config{StripComponents: 1, Endpoint: "https://cdn.bhojpur.net/release"})
+	if err != nil {
+		t.Fatalf("No error should happen when open archive from storage, but got %v", err)
+	}
+	defer client.Close()
+
+	if stream, err := client.GetStream("/README.md"); err != nil {
+		t.Errorf("No error should happen when get stream, but got %v", err)
+	} else if result, _ := ioutil.ReadAll(stream); string(result) != "# Release" {
+		t.Errorf("File should match content, but got %v", string(result))
+	}
+	if url, _ := client.GetURL("/web/app.js"); url != "https://cdn.bhojpur.net/release/web/app.js" {
+		t.Errorf("URL should be based on endpoint, but got %v", url)
+	}
+
+	if _, err := archive.Open(storage, "/bundles/missing.zip", nil); !os.IsNotExist(err) {
+		t.Errorf("Missing archive should return not exist error, but got %v", err)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "release.zip")
+	ioutil.WriteFile(name, buildZip(), 0644)
+
+	client, err := archive.OpenFile(name, nil)
+	if err != nil {
+		t.Fatalf("No error should happen when open archive file, but got %v", err)
+	}
+	defer client.Close()
+
+	if object, err := client.Stat("release/README.md"); err != nil || object.Name != "README.md" {
+		t.Errorf("Stat should return object's information, but got %+v, %v", object, err)
+	}
+
+	if _, err := archive.OpenFile(filepath.Join(t.TempDir(), "broken.zip"), nil); err == nil {
+		t.Errorf("Missing archive file should fail")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	data := buildZip()
+	client, _ := archive.New(bytes.NewReader(data), int64(len(data)), nil)
+
+	if _, err := client.Put("/release/README.md", strings.NewReader("changed")); !archive.IsReadOnly(err) {
+		t.Errorf("Put should return read-only error, but got %v", err)
+	}
+	if err := client.Delete("/release/README.md"); !archive.IsReadOnly(err) {
+		t.Errorf("Delete should return read-only error, but got %v", err)
+	} else if err.Error() != "archive: delete /release/README.md: storage is read-only" {
+		t.Errorf("Error should include operation and path, but got %v", err)
+	}
+	if _, err := client.Stat("/release/README.md"); err != nil {
+		t.Errorf("Read-only operations should not change the archive, but got %v", err)
+	}
+}
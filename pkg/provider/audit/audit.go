@@ -0,0 +1,343 @@
+package audit
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var _ model.StorageInterface = (*Client)(nil)
+
+// Operations recorded by the audit log
+const (
+	OperationGet       = "get"
+	OperationGetStream = "get_stream"
+	OperationPut       = "put"
+	OperationDelete    = "delete"
+	OperationList      = "list"
+	OperationGetURL    = "get_url"
+)
+
+// Results recorded by the audit log
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record audit record of a storage operation, records are chained by including the hash of the previous one
+type Record struct {
+	Sequence  uint64        `json:"seq"`
+	Time      time.Time     `json:"time"`
+	Actor     string        `json:"actor"`
+	Operation string        `json:"operation"`
+	Key       string        `json:"key"`
+	Size      int64         `json:"size"` // bytes transferred, or number of objects for list
+	Digest    string        `json:"digest,omitempty"`
+	Result    string        `json:"result"`
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency"`
+	PrevHash  string        `json:"prev_hash"`
+	Hash      string        `json:"hash"`
+}
+
+// ComputeHash compute record's hash, which covers all fields except the hash itself
+func (record Record) ComputeHash() string {
+	record.Hash = ""
+	record.Time = record.Time.UTC()
+	data, _ := json.Marshal(record)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sink destination of audit records
+type Sink interface {
+	// Append persist a record after the last one
+	Append(record *Record) error
+	// Last return the last persisted record, or nil if the sink is empty
+	Last() (*Record, error)
+	// Walk call fn for each persisted record in sequence order
+	Walk(fn func(record *Record) error) error
+}
+
+// Logger chain records and write them into a sink, it is safe for concurrent use
+type Logger struct {
+	Sink  Sink
+	mutex sync.Mutex
+	last  *Record
+}
+
+// NewLogger initialize logger, continue the chain of records already in the sink
+func NewLogger(sink Sink) (*Logger, error) {
+	last, err := sink.Last()
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{Sink: sink, last: last}, nil
+}
+
+// Log assign sequence and hashes to the record, then append it to the sink
+func (logger *Logger) Log(record *Record) error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	record.Sequence = 1
+	record.PrevHash = ""
+	if logger.last != nil {
+		record.Sequence = logger.last.Sequence + 1
+		record.PrevHash = logger.last.Hash
+	}
+	// keep precision that every sink is able to persist
+	record.Time = record.Time.UTC().Truncate(time.Microsecond)
+	record.Hash = record.ComputeHash()
+
+	if err := logger.Sink.Append(record); err != nil {
+		return err
+	}
+	logger.last = record
+	return nil
+}
+
+// VerifyError returned when the chain of records is broken
+type VerifyError struct {
+	Sequence uint64
+	Reason   string
+}
+
+func (err *VerifyError) Error() string {
+	return fmt.Sprintf("audit record %d: %s", err.Sequence, err.Reason)
+}
+
+// Verify walk all records of the sink, and detect gaps or edits in the chain. It returns the last record, so its hash could be anchored somewhere else to detect truncation
+func Verify(sink Sink) (last *Record, err error) {
+	err = sink.Walk(func(record *Record) error {
+		var expectedSequence uint64 = 1
+		var expectedPrevHash string
+		if last != nil {
+			expectedSequence = last.Sequence + 1
+			expectedPrevHash = last.Hash
+		}
+
+		switch {
+		case record.Sequence != expectedSequence:
+			return &VerifyError{Sequence: record.Sequence, Reason: fmt.Sprintf("expected sequence %d, records are missing or reordered", expectedSequence)}
+		case record.PrevHash != expectedPrevHash:
+			return &VerifyError{Sequence: record.Sequence, Reason: "previous hash doesn't match"}
+		case record.Hash != record.ComputeHash():
+			return &VerifyError{Sequence: record.Sequence, Reason: "hash doesn't match, record has been modified"}
+		}
+
+		last = record
+		return nil
+	})
+	return last, err
+}
+
+// Client audit storage, records every operation of the underlying storage
+type Client struct {
+	Storage model.StorageInterface
+	Logger  *Logger
+	Actor   string
+}
+
+// New initialize audit storage
+func New(storage model.StorageInterface, logger *Logger, actor string) *Client {
+	return &Client{Storage: storage, Logger: logger, Actor: actor}
+}
+
+// WithActor return a copy of the storage which records operations for another actor, sharing the same chain
+func (client Client) WithActor(actor string) *Client {
+	client.Actor = actor
+	return &client
+}
+
+func (client Client) log(operation string, key string, size int64, digest string, start time.Time, err error) error {
+	record := &Record{
+		Time:      start,
+		Actor:     client.Actor,
+		Operation: operation,
+		Key:       key,
+		Size:      size,
+		Digest:    digest,
+		Result:    ResultSuccess,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		record.Result = ResultFailure
+		record.Error = err.Error()
+	}
+	return client.Logger.Log(record)
+}
+
+// Get receive file with given path
+func (client Client) Get(path string) (*os.File, error) {
+	start := time.Now()
+	file, err := client.Storage.Get(path)
+
+	var size int64
+	var digest string
+	if err == nil {
+		hasher := sha256.New()
+		if size, err = io.Copy(hasher, file); err == nil {
+			digest = hex.EncodeToString(hasher.Sum(nil))
+			_, err = file.Seek(0, 0)
+		}
+	}
+
+	if logErr := client.log(OperationGet, path, size, digest, start, err); logErr != nil && err == nil {
+		err = logErr
+	}
+	return file, err
+}
+
+// GetStream get file as stream, the read is recorded when the stream is closed
+func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	start := time.Now()
+	stream, err := client.Storage.GetStream(path)
+	if err != nil {
+		client.log(OperationGetStream, path, 0, "", start, err)
+		return nil, err
+	}
+
+	return &auditReader{ReadCloser: stream, client: client, path: path, start: start, hasher: sha256.New()}, nil
+}
+
+type auditReader struct {
+	io.ReadCloser
+	client  Client
+	path    string
+	start   time.Time
+	hasher  hash.Hash
+	size    int64
+	readErr error
+	eof     bool
+	closed  bool
+}
+
+func (reader *auditReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.hasher.Write(p[:n])
+	reader.size += int64(n)
+	if err == io.EOF {
+		reader.eof = true
+	} else if err != nil {
+		reader.readErr = err
+	}
+	return n, err
+}
+
+// Close close the underlying stream and record the read, digest is only recorded if the whole stream has been read
+func (reader *auditReader) Close() error {
+	err := reader.ReadCloser.Close()
+	if reader.closed {
+		return err
+	}
+	reader.closed = true
+
+	var digest string
+	if reader.eof {
+		digest = hex.EncodeToString(reader.hasher.Sum(nil))
+	}
+
+	logErr := reader.client.log(OperationGetStream, reader.path, reader.size, digest, reader.start, reader.readErr)
+	if err == nil {
+		err = logErr
+	}
+	return err
+}
+
+// Put store a reader into given path
+func (client Client) Put(path string, reader io.Reader) (*model.Object, error) {
+	start := time.Now()
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	var (
+		hasher  = sha256.New()
+		counter = &countingWriter{}
+	)
+	object, err := client.Storage.Put(path, io.TeeReader(reader, io.MultiWriter(hasher, counter)))
+
+	if logErr := client.log(OperationPut, path, counter.size, hex.EncodeToString(hasher.Sum(nil)), start, err); logErr != nil && err == nil {
+		err = logErr
+	}
+	if object != nil {
+		object.StorageInterface = client
+	}
+	return object, err
+}
+
+type countingWriter struct {
+	size int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	writer.size += int64(len(p))
+	return len(p), nil
+}
+
+// Delete delete file
+func (client Client) Delete(path string) error {
+	start := time.Now()
+	err := client.Storage.Delete(path)
+	if logErr := client.log(OperationDelete, path, 0, "", start, err); logErr != nil && err == nil {
+		err = logErr
+	}
+	return err
+}
+
+// List list all objects under current path
+func (client Client) List(path string) ([]*model.Object, error) {
+	start := time.Now()
+	objects, err := client.Storage.List(path)
+	if logErr := client.log(OperationList, path, int64(len(objects)), "", start, err); logErr != nil && err == nil {
+		err = logErr
+	}
+	for _, object := range objects {
+		object.StorageInterface = client
+	}
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL get public accessible URL, which is recorded as it grants access to the file
+func (client Client) GetURL(path string) (string, error) {
+	start := time.Now()
+	url, err := client.Storage.GetURL(path)
+	if logErr := client.log(OperationGetURL, path, 0, "", start, err); logErr != nil && err == nil {
+		err = logErr
+	}
+	return url, err
+}
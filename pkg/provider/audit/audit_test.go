@@ -0,0 +1,163 @@
+package audit_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/provider/audit"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/tests"
+	_ "github.com/lib/pq"
+)
+
+type Config struct {
+	DSN string `env:"BHOJPUR_POSTGRES_DSN"`
+}
+
+func newClient(t *testing.T, sink audit.Sink) *audit.Client {
+	logger, err := audit.NewLogger(sink)
+	if err != nil {
+		t.Fatalf("No error should happen when initialize logger, but got %v", err)
+	}
+	return audit.New(filesystem.New(t.TempDir()), logger, "tester")
+}
+
+func testSink(t *testing.T, sink audit.Sink) {
+	tests.TestAll(newClient(t, sink), t)
+
+	last, err := audit.Verify(sink)
+	if err != nil {
+		t.Fatalf("No error should happen when verify audit records, but got %v", err)
+	}
+	if last == nil || last.Sequence < 8 {
+		t.Fatalf("All operations should be recorded, but got %v", last)
+	}
+
+	// chain continues after restart
+	client := newClient(t, sink)
+	client.Put("/sample.txt", strings.NewReader("sample"))
+	if next, err := audit.Verify(sink); err != nil || next.Sequence != last.Sequence+1 || next.PrevHash != last.Hash {
+		t.Errorf("Chain should be continued after restart, but got %v, %v", next, err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	testSink(t, audit.NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl")))
+}
+
+func TestStorageSink(t *testing.T) {
+	testSink(t, audit.NewStorageSink(filesystem.New(t.TempDir()), "audit"))
+}
+
+func TestPostgresSink(t *testing.T) {
+	config := Config{}
+	cfgsvr.Load(&config)
+	if config.DSN == "" {
+		t.Skip(`skip because of no config: BHOJPUR_POSTGRES_DSN`)
+	}
+
+	db, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Exec("DROP TABLE IF EXISTS drive_audit_test")
+
+	sink, err := audit.NewPostgresSink(db, "drive_audit_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSink(t, sink)
+}
+
+func TestRecord(t *testing.T) {
+	sink := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	client := newClient(t, sink).WithActor("alice")
+
+	client.Put("/a.txt", strings.NewReader("sample"))
+	if stream, err := client.GetStream("/a.txt"); err == nil {
+		ioutil.ReadAll(stream)
+		stream.Close()
+	}
+	client.Delete("/missing.txt")
+
+	var records []*audit.Record
+	sink.Walk(func(record *audit.Record) error {
+		records = append(records, record)
+		return nil
+	})
+
+	if len(records) != 3 {
+		t.Fatalf("Should found 3 records, but got %v", len(records))
+	}
+
+	sampleDigest := "af2bdbe1aa9b6ec1e2ade1d694f41fc71a831d0268e9891562113d8a62add1bf"
+	if record := records[0]; record.Actor != "alice" || record.Operation != audit.OperationPut || record.Key != "/a.txt" || record.Size != 6 || record.Digest != sampleDigest || record.Result != audit.ResultSuccess {
+		t.Errorf("put record is incorrect, got %+v", record)
+	}
+	if record := records[1]; record.Operation != audit.OperationGetStream || record.Size != 6 || record.Digest != sampleDigest {
+		t.Errorf("get stream record is incorrect, got %+v", record)
+	}
+	if record := records[2]; record.Operation != audit.OperationDelete || record.Result != audit.ResultFailure || record.Error == "" {
+		t.Errorf("failed delete record is incorrect, got %+v", record)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tamper := map[string]func(lines []string) []string{
+		"edit": func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"actor":"tester"`, `"actor":"mallory"`, 1)
+			return lines
+		},
+		"gap": func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		},
+		"reorder": func(lines []string) []string {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		},
+	}
+
+	for name, fn := range tamper {
+		logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+		sink := audit.NewFileSink(logPath)
+		client := newClient(t, sink)
+		for _, p := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+			client.Put(p, strings.NewReader("sample"))
+		}
+
+		data, _ := ioutil.ReadFile(logPath)
+		lines := fn(strings.Split(strings.TrimSpace(string(data)), "\n"))
+		ioutil.WriteFile(logPath, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+
+		if _, err := audit.Verify(sink); err == nil {
+			t.Errorf("%v: tampered audit log should fail verification", name)
+		} else if _, ok := err.(*audit.VerifyError); !ok {
+			t.Errorf("%v: should return VerifyError, but got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package audit
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// PostgresSink write records into a PostgreSQL table
+type PostgresSink struct {
+	DB    *sql.DB
+	Table string
+}
+
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewPostgresSink initialize PostgreSQL sink, the table is created if it doesn't exist. db should be opened with the "postgres" driver of github.com/lib/pq
+func NewPostgresSink(db *sql.DB, table string) (*PostgresSink, error) {
+	if table == "" {
+		table = "drive_audit_records"
+	}
+	if !tableNameRegexp.MatchString(table) {
+		return nil, fmt.Errorf("invalid audit table name %q", table)
+	}
+
+	sink := &PostgresSink{DB: db, Table: table}
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		seq BIGINT PRIMARY KEY,
+		time TIMESTAMPTZ NOT NULL,
+		actor TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		key TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		digest TEXT NOT NULL,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL,
+		latency BIGINT NOT NULL,
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	)`, table))
+	if err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+const postgresColumns = "seq, time, actor, operation, key, size, digest, result, error, latency, prev_hash, hash"
+
+// Append insert a record into the table
+func (sink *PostgresSink) Append(record *Record) error {
+	_, err := sink.DB.Exec(
+		fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)", sink.Table, postgresColumns),
+		int64(record.Sequence), record.Time, record.Actor, record.Operation, record.Key, record.Size,
+		record.Digest, record.Result, record.Error, int64(record.Latency), record.PrevHash, record.Hash,
+	)
+	return err
+}
+
+func scanRecord(scanner interface{ Scan(...interface{}) error }) (*Record, error) {
+	var (
+		record   Record
+		sequence int64
+		latency  int64
+	)
+	err := scanner.Scan(&sequence, &record.Time, &record.Actor, &record.Operation, &record.Key, &record.Size,
+		&record.Digest, &record.Result, &record.Error, &latency, &record.PrevHash, &record.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	record.Sequence = uint64(sequence)
+	record.Latency = time.Duration(latency)
+	record.Time = record.Time.UTC()
+	return &record, nil
+}
+
+// Last return the record with the highest sequence
+func (sink *PostgresSink) Last() (*Record, error) {
+	row := sink.DB.QueryRow(fmt.Sprintf("SELECT %s FROM %s ORDER BY seq DESC LIMIT 1", postgresColumns, sink.Table))
+	record, err := scanRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return record, err
+}
+
+// Walk call fn for each record in sequence order
+func (sink *PostgresSink) Walk(fn func(record *Record) error) error {
+	rows, err := sink.DB.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY seq", postgresColumns, sink.Table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,198 @@
+package audit
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+// FileSink write records into a JSON-lines file
+type FileSink struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// NewFileSink initialize JSON-lines file sink
+func NewFileSink(path string) *FileSink {
+	return &FileSink{Path: path}
+}
+
+// Append persist a record as a new line of the file
+func (sink *FileSink) Append(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	file, err := os.OpenFile(sink.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Last return the last record of the file
+func (sink *FileSink) Last() (last *Record, err error) {
+	err = sink.Walk(func(record *Record) error {
+		last = record
+		return nil
+	})
+	return
+}
+
+// Walk call fn for each record of the file
+func (sink *FileSink) Walk(fn func(record *Record) error) error {
+	file, err := os.Open(sink.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return fmt.Errorf("%s:%d: %w", sink.Path, line, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// StorageSink write each record as an object into another storage
+type StorageSink struct {
+	Storage model.StorageInterface
+	Prefix  string
+}
+
+// NewStorageSink initialize storage sink, records are saved under prefix
+func NewStorageSink(storage model.StorageInterface, prefix string) *StorageSink {
+	return &StorageSink{Storage: storage, Prefix: prefix}
+}
+
+func (sink *StorageSink) recordPath(sequence uint64) string {
+	return "/" + path.Join(sink.Prefix, fmt.Sprintf("%020d.json", sequence))
+}
+
+// Append persist a record as a new object
+func (sink *StorageSink) Append(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = sink.Storage.Put(sink.recordPath(record.Sequence), bytes.NewReader(data))
+	return err
+}
+
+func (sink *StorageSink) names() ([]string, error) {
+	objects, err := sink.Storage.List(sink.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, object := range objects {
+		if path.Ext(object.Name) == ".json" {
+			names = append(names, object.Name)
+		}
+	}
+	// names are zero-padded sequences, so they are sorted in sequence order
+	sort.Strings(names)
+	return names, nil
+}
+
+func (sink *StorageSink) read(name string) (*Record, error) {
+	stream, err := sink.Storage.GetStream("/" + path.Join(sink.Prefix, name))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return record, nil
+}
+
+// Last return the record with the highest sequence
+func (sink *StorageSink) Last() (*Record, error) {
+	names, err := sink.names()
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	return sink.read(names[len(names)-1])
+}
+
+// Walk call fn for each record in sequence order
+func (sink *StorageSink) Walk(fn func(record *Record) error) error {
+	names, err := sink.names()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		record, err := sink.read(name)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
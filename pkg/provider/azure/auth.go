@@ -0,0 +1,145 @@
+package azure
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the Blob service REST API used by requests and SAS
+const Version = "2019-12-12"
+
+// sign add Shared Key authorization to the request
+// https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (client *Client) sign(req *http.Request) {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalizedHeaders(req.Header) + client.canonicalizedResource(req.URL)
+
+	req.Header.Set("Authorization", "SharedKey "+client.Config.AccountName+":"+client.hmac(stringToSign))
+}
+
+func (client *Client) hmac(stringToSign string) string {
+	mac := hmac.New(sha256.New, client.key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func canonicalizedHeaders(header http.Header) string {
+	var names []string
+	for name := range header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result strings.Builder
+	for _, name := range names {
+		result.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+	return result.String()
+}
+
+func (client *Client) canonicalizedResource(u *url.URL) string {
+	resource := "/" + client.Config.AccountName + u.EscapedPath()
+	if u.Path == "" {
+		resource += "/"
+	}
+
+	query := u.Query()
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return resource
+}
+
+// SignURL generate a service SAS URL which grants permissions (e.g. "r" to read, "rw" to read and write) to the blob
+// until expiry, it requires the account key
+// https://docs.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+func (client *Client) SignURL(path string, permissions string, expiry time.Duration) (string, error) {
+	if len(client.key) == 0 {
+		return "", ErrAccountKeyRequired
+	}
+
+	var (
+		name     = client.blobName(path)
+		expireAt = time.Now().UTC().Add(expiry).Format("2006-01-02T15:04:05Z")
+	)
+
+	stringToSign := strings.Join([]string{
+		permissions,
+		"", // signed start
+		expireAt,
+		"/blob/" + client.Config.AccountName + "/" + client.Config.Container + "/" + name,
+		"", // signed identifier
+		"", // signed IP
+		"", // signed protocol
+		Version,
+		"b",
+		"", // signed snapshot time
+		"", // rscc
+		"", // rscd
+		"", // rsce
+		"", // rscl
+		"", // rsct
+	}, "\n")
+
+	query := url.Values{}
+	query.Set("sv", Version)
+	query.Set("sr", "b")
+	query.Set("sp", permissions)
+	query.Set("se", expireAt)
+	query.Set("sig", client.hmac(stringToSign))
+	return client.blobURL(name) + "?" + query.Encode(), nil
+}
@@ -0,0 +1,484 @@
+package azure
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface  = (*Client)(nil)
+	_ model.StatInterface     = (*Client)(nil)
+	_ model.NotExistInterface = (*Client)(nil)
+)
+
+// ErrAccountKeyRequired returned when signing URLs without the account key
+var ErrAccountKeyRequired = errors.New("azure: account key is required to sign URLs")
+
+// Access tiers of block blobs
+const (
+	TierHot     = "Hot"
+	TierCool    = "Cool"
+	TierArchive = "Archive"
+)
+
+// Config Azure Blob Storage client config
+type Config struct {
+	AccountName string
+	// AccountKey base64 encoded key, requests are authorized with Shared Key
+	AccountKey string
+	// SASToken authorize requests with a shared access signature instead of the account key, e.g. "sv=...&sig=..."
+	SASToken  string
+	Container string
+
+	// Endpoint of the blob service, default to "https://<AccountName>.blob.core.windows.net". For Azurite, it
+	// includes the account, e.g. "http://127.0.0.1:10000/devstoreaccount1"
+	Endpoint string
+
+	// AccessTier of uploaded blobs, default to the account's tier
+	AccessTier string
+	// BlockSize of staged uploads, content larger than it is uploaded block by block, default to 4MiB
+	BlockSize int
+	// URLExpiry expiry of SAS URLs returned by GetURL, default to 1 hour
+	URLExpiry time.Duration
+	// PublicAccess container allows anonymous reads, GetURL returns unsigned URLs
+	PublicAccess bool
+
+	// HTTPClient used to send requests, default to a client with 5 minutes timeout
+	HTTPClient *http.Client
+}
+
+// Client Azure Blob Storage
+type Client struct {
+	Config *Config
+	key    []byte
+	sas    url.Values
+}
+
+// Error returned by the blob service
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (err *Error) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("azure: %v %v: %v", err.StatusCode, err.Code, err.Message)
+	}
+	return fmt.Sprintf("azure: %v %v", err.StatusCode, err.Code)
+}
+
+// IsNotExist report whether the error shows that the blob or container doesn't exist
+func IsNotExist(err error) bool {
+	var azureErr *Error
+	return errors.As(err, &azureErr) && azureErr.StatusCode == http.StatusNotFound
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client *Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
+}
+
+// New initialize Azure Blob Storage
+func New(config *Config) (*Client, error) {
+	if config.AccountName == "" || config.Container == "" {
+		return nil, errors.New("azure: AccountName and Container are required")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://" + config.AccountName + ".blob.core.windows.net"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.BlockSize <= 0 {
+		config.BlockSize = 4 << 20
+	}
+	if config.URLExpiry == 0 {
+		config.URLExpiry = time.Hour
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+
+	client := &Client{Config: config}
+	if config.AccountKey != "" {
+		key, err := base64.StdEncoding.DecodeString(config.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("azure: invalid account key: %w", err)
+		}
+		client.key = key
+	}
+	if config.SASToken != "" {
+		sas, err := url.ParseQuery(strings.TrimPrefix(config.SASToken, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("azure: invalid SAS token: %w", err)
+		}
+		client.sas = sas
+	}
+	return client, nil
+}
+
+func (client *Client) blobName(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func (client *Client) containerURL() string {
+	return client.Config.Endpoint + "/" + url.PathEscape(client.Config.Container)
+}
+
+func (client *Client) blobURL(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return client.containerURL() + "/" + strings.Join(segments, "/")
+}
+
+// do send a request to the resource URL, query and header are added to it. Responses with unexpected status are
+// returned as *Error
+func (client *Client) do(method, resourceURL string, query url.Values, header http.Header, body io.Reader, expected ...int) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if client.key == nil {
+		for name, values := range client.sas {
+			query[name] = values
+		}
+	}
+
+	req, err := http.NewRequest(method, resourceURL, body)
+	if err != nil {
+		return nil, err
+	}
+	// keep the encoding in sync with the canonicalized resource
+	req.URL.RawQuery = query.Encode()
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", Version)
+	if client.key != nil {
+		client.sign(req)
+	}
+
+	resp, err := client.Config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+
+	azureErr := &Error{StatusCode: resp.StatusCode, Code: resp.Header.Get("x-ms-error-code")}
+	var errorBody struct {
+		Code    string
+		Message string
+	}
+	if data, _ := ioutil.ReadAll(resp.Body); xml.Unmarshal(data, &errorBody) == nil {
+		if azureErr.Code == "" {
+			azureErr.Code = errorBody.Code
+		}
+		azureErr.Message = strings.SplitN(errorBody.Message, "\n", 2)[0]
+	}
+	if azureErr.Code == "" {
+		azureErr.Code = resp.Status
+	}
+	return nil, azureErr
+}
+
+// CreateContainer create the container if it doesn't exist
+func (client *Client) CreateContainer() error {
+	resp, err := client.do(http.MethodPut, client.containerURL(), url.Values{"restype": {"container"}}, nil, nil, http.StatusCreated)
+	var azureErr *Error
+	if errors.As(err, &azureErr) && azureErr.StatusCode == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get receive file with given path
+func (client *Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "azure"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream
+func (client *Client) GetStream(path string) (io.ReadCloser, error) {
+	resp, err := client.do(http.MethodGet, client.blobURL(client.blobName(path)), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (client *Client) toObject(name string, header http.Header) *model.Object {
+	object := &model.Object{
+		Path:             "/" + name,
+		Name:             path.Base(name),
+		ETag:             header.Get("ETag"),
+		ContentType:      header.Get("Content-Type"),
+		StorageInterface: client,
+	}
+	object.Size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if modTime, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		object.LastModified = &modTime
+	}
+	return object
+}
+
+// Stat get object's information from blob properties
+func (client *Client) Stat(path string) (*model.Object, error) {
+	name := client.blobName(path)
+	resp, err := client.do(http.MethodHead, client.blobURL(name), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return client.toObject(name, resp.Header), nil
+}
+
+// GetAccessTier get the access tier of the blob
+func (client *Client) GetAccessTier(path string) (string, error) {
+	resp, err := client.do(http.MethodHead, client.blobURL(client.blobName(path)), nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("x-ms-access-tier"), nil
+}
+
+// SetAccessTier move the blob to another access tier. Archived blobs need to be rehydrated to Hot or Cool before they
+// could be read, which takes hours
+func (client *Client) SetAccessTier(path string, tier string) error {
+	header := http.Header{}
+	header.Set("x-ms-access-tier", tier)
+	resp, err := client.do(http.MethodPut, client.blobURL(client.blobName(path)), url.Values{"comp": {"tier"}}, header, nil, http.StatusOK, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Put store a reader into given path. Content smaller than BlockSize is uploaded with a single request, otherwise it
+// is streamed as staged blocks which are committed when all of them are uploaded, so readers don't see partial blobs
+func (client *Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	var (
+		name   = client.blobName(urlPath)
+		buffer = make([]byte, client.Config.BlockSize)
+		header = http.Header{}
+	)
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		header.Set("x-ms-blob-content-type", contentType)
+	}
+	if client.Config.AccessTier != "" {
+		header.Set("x-ms-access-tier", client.Config.AccessTier)
+	}
+
+	n, err := io.ReadFull(reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		header.Set("x-ms-blob-type", "BlockBlob")
+		resp, err := client.do(http.MethodPut, client.blobURL(name), nil, header, bytes.NewReader(buffer[:n]), http.StatusCreated)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return client.putObject(name, int64(n), header, resp.Header), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// block ids of a blob must have the same length, the nonce avoids mixing blocks of concurrent uploads
+	nonce := make([]byte, 6)
+	rand.Read(nonce)
+
+	var (
+		blockIDs []string
+		size     int64
+	)
+	for n > 0 {
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%x-%06d", nonce, len(blockIDs))))
+		resp, err := client.do(http.MethodPut, client.blobURL(name), url.Values{"comp": {"block"}, "blockid": {blockID}}, nil, bytes.NewReader(buffer[:n]), http.StatusCreated)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		blockIDs = append(blockIDs, blockID)
+		size += int64(n)
+
+		if n, err = io.ReadFull(reader, buffer); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+	}
+
+	var blockList bytes.Buffer
+	blockList.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, blockID := range blockIDs {
+		blockList.WriteString("<Latest>" + blockID + "</Latest>")
+	}
+	blockList.WriteString("</BlockList>")
+
+	resp, err := client.do(http.MethodPut, client.blobURL(name), url.Values{"comp": {"blocklist"}}, header, &blockList, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return client.putObject(name, size, header, resp.Header), nil
+}
+
+func (client *Client) putObject(name string, size int64, header http.Header, respHeader http.Header) *model.Object {
+	object := client.toObject(name, respHeader)
+	object.Size = size
+	object.ContentType = header.Get("x-ms-blob-content-type")
+	return object
+}
+
+// Delete delete file
+func (client *Client) Delete(path string) error {
+	resp, err := client.do(http.MethodDelete, client.blobURL(client.blobName(path)), nil, nil, nil, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listResult struct {
+	Blobs []struct {
+		Name       string
+		Properties struct {
+			LastModified  string `xml:"Last-Modified"`
+			ETag          string `xml:"Etag"`
+			ContentLength int64  `xml:"Content-Length"`
+			ContentType   string `xml:"Content-Type"`
+		}
+	} `xml:"Blobs>Blob"`
+	NextMarker string
+}
+
+// List list all objects under current path, pages are followed with the returned markers
+func (client *Client) List(urlPath string) ([]*model.Object, error) {
+	var (
+		objects []*model.Object
+		marker  string
+		prefix  string
+	)
+	if urlPath != "" {
+		prefix = strings.Trim(urlPath, "/") + "/"
+	}
+
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}}
+		if prefix != "" && prefix != "/" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := client.do(http.MethodGet, client.containerURL(), query, nil, nil, http.StatusOK)
+		if err != nil {
+			return objects, err
+		}
+
+		result := listResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return objects, err
+		}
+
+		for _, blob := range result.Blobs {
+			object := &model.Object{
+				Path:             "/" + blob.Name,
+				Name:             path.Base(blob.Name),
+				Size:             blob.Properties.ContentLength,
+				ETag:             blob.Properties.ETag,
+				ContentType:      blob.Properties.ContentType,
+				StorageInterface: client,
+			}
+			if modTime, err := http.ParseTime(blob.Properties.LastModified); err == nil {
+				object.LastModified = &modTime
+			}
+			objects = append(objects, object)
+		}
+
+		if marker = result.NextMarker; marker == "" {
+			return objects, nil
+		}
+	}
+}
+
+// GetEndpoint get endpoint of the container
+func (client *Client) GetEndpoint() string {
+	return client.containerURL()
+}
+
+// GetURL get public accessible URL. It is signed with a read-only SAS when the account key is set, or the client's SAS
+// token is appended, unless the container allows public access
+func (client *Client) GetURL(path string) (string, error) {
+	name := client.blobName(path)
+	switch {
+	case client.Config.PublicAccess:
+		return client.blobURL(name), nil
+	case client.key != nil:
+		return client.SignURL(path, "r", client.Config.URLExpiry)
+	case client.sas != nil:
+		return client.blobURL(name) + "?" + client.sas.Encode(), nil
+	}
+	return client.blobURL(name), nil
+}
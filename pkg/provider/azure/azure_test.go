@@ -0,0 +1,380 @@
+package azure_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/provider/azure"
+	"github.com/bhojpur/drive/tests"
+)
+
+type Config struct {
+	AccountName string `env:"BHOJPUR_AZURE_ACCOUNT_NAME"`
+	AccountKey  string `env:"BHOJPUR_AZURE_ACCOUNT_KEY"`
+	Container   string `env:"BHOJPUR_AZURE_CONTAINER"`
+	Endpoint    string `env:"BHOJPUR_AZURE_ENDPOINT"`
+}
+
+// TestAzurite run against Azurite or a real account, for Azurite set BHOJPUR_AZURE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1
+// and its well-known devstoreaccount1 credentials
+func TestAzurite(t *testing.T) {
+	config := Config{}
+	cfgsvr.Load(&config)
+	if config.AccountName == "" || config.AccountKey == "" {
+		t.Skip(`skip because of no config: BHOJPUR_AZURE_ACCOUNT_NAME, BHOJPUR_AZURE_ACCOUNT_KEY`)
+	}
+	if config.Container == "" {
+		config.Container = "drive-test"
+	}
+
+	client, err := azure.New(&azure.Config{AccountName: config.AccountName, AccountKey: config.AccountKey, Container: config.Container, Endpoint: config.Endpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CreateContainer(); err != nil {
+		t.Fatalf("No error should happen when create container, but got %v", err)
+	}
+	tests.TestAll(client, t)
+
+	data := make([]byte, 10<<20+123)
+	rand.Read(data)
+	if _, err := client.Put("/staged.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("No error should happen when upload blocks, but got %v", err)
+	}
+	if file, err := client.Get("/staged.bin"); err != nil {
+		t.Errorf("No error should happen when get file, but got %v", err)
+	} else if result, _ := ioutil.ReadAll(file); !bytes.Equal(result, data) {
+		t.Errorf("Committed blocks should match content")
+	}
+	client.Delete("/staged.bin")
+}
+
+// fakeService in-process blob service which implements the requests sent by the client, it checks requests carry
+// Shared Key or SAS authorization but doesn't verify signatures
+type fakeService struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	blobs    map[string]*fakeBlob
+	blocks   map[string]map[string][]byte
+	requests map[string]int
+	pageSize int
+}
+
+type fakeBlob struct {
+	data        []byte
+	contentType string
+	tier        string
+	modified    time.Time
+	etag        string
+}
+
+func startFake(t *testing.T) *fakeService {
+	fake := &fakeService{blobs: map[string]*fakeBlob{}, blocks: map[string]map[string][]byte{}, requests: map[string]int{}, pageSize: 2}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (fake *fakeService) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%v</Code><Message>%v</Message></Error>`, code, code)
+}
+
+func (fake *fakeService) save(name string, data []byte, header http.Header) {
+	tier := header.Get("x-ms-access-tier")
+	if tier == "" {
+		tier = azure.TierHot
+	}
+	fake.blobs[name] = &fakeBlob{
+		data:        data,
+		contentType: header.Get("x-ms-blob-content-type"),
+		tier:        tier,
+		modified:    time.Now().UTC(),
+		etag:        fmt.Sprintf(`"0x%X"`, rand.Int63()),
+	}
+}
+
+func (fake *fakeService) serve(w http.ResponseWriter, req *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	query := req.URL.Query()
+	if strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey devstoreaccount1:") {
+		if req.Header.Get("x-ms-version") == "" || req.Header.Get("x-ms-date") == "" {
+			fake.fail(w, http.StatusBadRequest, "MissingRequiredHeader")
+			return
+		}
+	} else if query.Get("sig") == "" {
+		fake.fail(w, http.StatusForbidden, "AuthenticationFailed")
+		return
+	}
+
+	// path style URL: /devstoreaccount1/container/blob
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != "devstoreaccount1" || parts[1] != "drive" {
+		fake.fail(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	fake.requests[req.Method+" "+query.Get("comp")]++
+
+	if len(parts) == 2 {
+		if query.Get("comp") == "list" {
+			fake.list(w, query)
+		} else {
+			fake.fail(w, http.StatusConflict, "ContainerAlreadyExists")
+		}
+		return
+	}
+
+	name := parts[2]
+	blob := fake.blobs[name]
+	switch req.Method + " " + query.Get("comp") {
+	case "PUT ":
+		data, _ := ioutil.ReadAll(req.Body)
+		fake.save(name, data, req.Header)
+		w.Header().Set("ETag", fake.blobs[name].etag)
+		w.WriteHeader(http.StatusCreated)
+	case "PUT block":
+		if fake.blocks[name] == nil {
+			fake.blocks[name] = map[string][]byte{}
+		}
+		fake.blocks[name][query.Get("blockid")], _ = ioutil.ReadAll(req.Body)
+		w.WriteHeader(http.StatusCreated)
+	case "PUT blocklist":
+		var blockList struct {
+			Latest []string
+		}
+		xml.NewDecoder(req.Body).Decode(&blockList)
+
+		var data []byte
+		for _, id := range blockList.Latest {
+			block, ok := fake.blocks[name][id]
+			if !ok {
+				fake.fail(w, http.StatusBadRequest, "InvalidBlockList")
+				return
+			}
+			data = append(data, block...)
+		}
+		delete(fake.blocks, name)
+		fake.save(name, data, req.Header)
+		w.Header().Set("ETag", fake.blobs[name].etag)
+		w.WriteHeader(http.StatusCreated)
+	case "PUT tier":
+		if blob == nil {
+			fake.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		blob.tier = req.Header.Get("x-ms-access-tier")
+		w.WriteHeader(http.StatusOK)
+	case "GET ", "HEAD ":
+		if blob == nil {
+			fake.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob.data)))
+		w.Header().Set("Content-Type", blob.contentType)
+		w.Header().Set("Last-Modified", blob.modified.Format(http.TimeFormat))
+		w.Header().Set("ETag", blob.etag)
+		w.Header().Set("x-ms-access-tier", blob.tier)
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			w.Write(blob.data)
+		}
+	case "DELETE ":
+		if blob == nil {
+			fake.fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(fake.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		fake.fail(w, http.StatusBadRequest, "UnsupportedRequest")
+	}
+}
+
+func (fake *fakeService) list(w http.ResponseWriter, query map[string][]string) {
+	prefix, marker := "", ""
+	if values := query["prefix"]; len(values) > 0 {
+		prefix = values[0]
+	}
+	if values := query["marker"]; len(values) > 0 {
+		marker = values[0]
+	}
+
+	var names []string
+	for name := range fake.blobs {
+		if strings.HasPrefix(name, prefix) && name >= marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var nextMarker string
+	if len(names) > fake.pageSize {
+		nextMarker = names[fake.pageSize]
+		names = names[:fake.pageSize]
+	}
+
+	var result strings.Builder
+	result.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>`)
+	for _, name := range names {
+		blob := fake.blobs[name]
+		fmt.Fprintf(&result, "<Blob><Name>%v</Name><Properties><Last-Modified>%v</Last-Modified><Etag>%v</Etag><Content-Length>%v</Content-Length><Content-Type>%v</Content-Type><AccessTier>%v</AccessTier></Properties></Blob>",
+			name, blob.modified.Format(http.TimeFormat), blob.etag, len(blob.data), blob.contentType, blob.tier)
+	}
+	fmt.Fprintf(&result, "</Blobs><NextMarker>%v</NextMarker></EnumerationResults>", nextMarker)
+	w.Write([]byte(result.String()))
+}
+
+var accountKey = base64.StdEncoding.EncodeToString([]byte("drive test account key"))
+
+func newClient(t *testing.T, fake *fakeService, config *azure.Config) *azure.Client {
+	config.AccountName = "devstoreaccount1"
+	config.Container = "drive"
+	config.Endpoint = fake.URL + "/devstoreaccount1"
+	if config.SASToken == "" {
+		config.AccountKey = accountKey
+	}
+	client, err := azure.New(config)
+	if err != nil {
+		t.Fatalf("No error should happen when initialize client, but got %v", err)
+	}
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newClient(t, startFake(t), &azure.Config{}), t)
+}
+
+func TestStagedUpload(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(t, fake, &azure.Config{BlockSize: 1 << 10})
+
+	data := make([]byte, 5000)
+	rand.Read(data)
+	object, err := client.Put("/backups/data.bin", ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("No error should happen when upload blocks, but got %v", err)
+	}
+	if object.Size != int64(len(data)) || object.ETag == "" {
+		t.Errorf("Returned object should have size and etag, but got %+v", object)
+	}
+	if fake.requests["PUT block"] != 5 || fake.requests["PUT blocklist"] != 1 || fake.requests["PUT "] != 0 {
+		t.Errorf("Content should be uploaded as 5 blocks, but got requests %v", fake.requests)
+	}
+
+	if stream, err := client.GetStream("/backups/data.bin"); err != nil {
+		t.Errorf("No error should happen when get stream, but got %v", err)
+	} else if result, _ := ioutil.ReadAll(stream); !bytes.Equal(result, data) {
+		t.Errorf("Committed blocks should match content")
+	}
+
+	client.Put("/backups/small.txt", strings.NewReader("small"))
+	if fake.requests["PUT "] != 1 {
+		t.Errorf("Small content should be uploaded with a single request, but got requests %v", fake.requests)
+	}
+	if object, err := client.Stat("/backups/small.txt"); err != nil || object.Size != 5 || object.ContentType != "text/plain; charset=utf-8" || object.LastModified == nil {
+		t.Errorf("Stat should read blob properties, but got %+v, %v", object, err)
+	}
+}
+
+func TestListMarkers(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(t, fake, &azure.Config{})
+
+	for i := 0; i < 5; i++ {
+		client.Put(fmt.Sprintf("/logs/%d.txt", i), strings.NewReader("log"))
+	}
+	client.Put("/logs.txt", strings.NewReader("not under logs/"))
+
+	objects, err := client.List("/logs")
+	if err != nil || len(objects) != 5 {
+		t.Fatalf("List should follow markers, but got %v objects, %v", len(objects), err)
+	}
+	if fake.requests["GET list"] != 3 {
+		t.Errorf("List should request 3 pages, but got %v", fake.requests["GET list"])
+	}
+	if objects[0].Path != "/logs/0.txt" || objects[0].Size != 3 || objects[0].LastModified == nil {
+		t.Errorf("Listed objects should have properties, but got %+v", objects[0])
+	}
+}
+
+func TestAccessTier(t *testing.T) {
+	client := newClient(t, startFake(t), &azure.Config{AccessTier: azure.TierCool})
+	client.Put("/archive/2020.tar", strings.NewReader("archive"))
+
+	if tier, err := client.GetAccessTier("/archive/2020.tar"); err != nil || tier != azure.TierCool {
+		t.Errorf("Uploaded blob should be in Cool tier, but got %v, %v", tier, err)
+	}
+	if err := client.SetAccessTier("/archive/2020.tar", azure.TierArchive); err != nil {
+		t.Errorf("No error should happen when set tier, but got %v", err)
+	}
+	if tier, _ := client.GetAccessTier("/archive/2020.tar"); tier != azure.TierArchive {
+		t.Errorf("Blob should be moved to Archive tier, but got %v", tier)
+	}
+	if err := client.SetAccessTier("/missing", azure.TierHot); !azure.IsNotExist(err) {
+		t.Errorf("Missing blob should return not exist error, but got %v", err)
+	}
+}
+
+func TestSAS(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(t, fake, &azure.Config{SASToken: "?sv=2019-12-12&ss=b&srt=co&sp=rwdl&se=2030-01-01T00:00:00Z&sig=c2lnbmF0dXJl"})
+
+	if _, err := client.Put("/shared/a.txt", strings.NewReader("shared")); err != nil {
+		t.Fatalf("Requests should be authorized with SAS token, but got %v", err)
+	}
+	if url, err := client.GetURL("/shared/a.txt"); err != nil || !strings.HasPrefix(url, fake.URL+"/devstoreaccount1/drive/shared/a.txt?") || !strings.Contains(url, "sig=c2lnbmF0dXJl") {
+		t.Errorf("URL should include SAS token, but got %v, %v", url, err)
+	}
+	if _, err := client.SignURL("/shared/a.txt", "r", time.Hour); err != azure.ErrAccountKeyRequired {
+		t.Errorf("Signing URLs should require account key, but got %v", err)
+	}
+
+	keyClient := newClient(t, fake, &azure.Config{})
+	url, err := keyClient.GetURL("/shared/a.txt")
+	if err != nil || !strings.Contains(url, "sp=r") || !strings.Contains(url, "sr=b") || !strings.Contains(url, "sig=") {
+		t.Errorf("URL should be signed with a read-only SAS, but got %v, %v", url, err)
+	}
+	if resp, err := http.Get(url); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Signed URL should be readable, but got %v, %v", resp, err)
+	}
+
+	unauthorized, _ := azure.New(&azure.Config{AccountName: "devstoreaccount1", Container: "drive", Endpoint: fake.URL + "/devstoreaccount1"})
+	if _, err := unauthorized.Put("/a.txt", strings.NewReader("a")); err == nil {
+		t.Errorf("Unauthorized request should fail")
+	}
+}
@@ -0,0 +1,397 @@
+package bolt
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// ErrReadOnly returned when writing into a read-only database
+var ErrReadOnly = errors.New("storage is read-only")
+
+var (
+	objectsBucket = []byte("objects")
+	chunksBucket  = []byte("chunks")
+)
+
+// Config bbolt storage config
+type Config struct {
+	// ChunkSize bytes of each chunk value, default to 256KiB
+	ChunkSize int
+	// ReadOnly open the database file in read-only mode, e.g. a snapshot
+	ReadOnly bool
+	// Timeout waiting for the file lock held by another process, default to 1s
+	Timeout time.Duration
+}
+
+// Client bbolt storage, objects are saved in a single embedded B+tree database file.
+// Metadata is keyed by path in the "objects" bucket, so List is a cursor scan in path order.
+// Content is split into chunks keyed by object ID and sequence in the "chunks" bucket
+type Client struct {
+	DB     *bbolt.DB
+	Config *Config
+}
+
+type meta struct {
+	ID          uint64    `json:"id"`
+	Size        int64     `json:"size"`
+	Chunks      uint32    `json:"chunks"`
+	ContentType string    `json:"content_type,omitempty"`
+	Digest      string    `json:"digest"`
+	Modified    time.Time `json:"modified"`
+}
+
+// New open or create the database file
+func New(file string, config *Config) (*Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.ChunkSize == 0 {
+		config.ChunkSize = 256 << 10
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+
+	db, err := bbolt.Open(file, 0600, &bbolt.Options{Timeout: config.Timeout, ReadOnly: config.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	if !config.ReadOnly {
+		err = db.Update(func(tx *bbolt.Tx) error {
+			for _, name := range [][]byte{objectsBucket, chunksBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &Client{DB: db, Config: config}, nil
+}
+
+// Close close the database file
+func (client Client) Close() error {
+	return client.DB.Close()
+}
+
+func cleanPath(urlPath string) string {
+	return "/" + strings.TrimPrefix(urlPath, "/")
+}
+
+func chunkKey(id uint64, seq uint32) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, id)
+	binary.BigEndian.PutUint32(key[8:], seq)
+	return key
+}
+
+func getMeta(tx *bbolt.Tx, urlPath string) (*meta, error) {
+	objects := tx.Bucket(objectsBucket)
+	if objects == nil {
+		return nil, os.ErrNotExist
+	}
+
+	value := objects.Get([]byte(urlPath))
+	if value == nil {
+		return nil, os.ErrNotExist
+	}
+
+	m := &meta{}
+	return m, json.Unmarshal(value, m)
+}
+
+func (client Client) toObject(urlPath string, m *meta) *model.Object {
+	modified := m.Modified
+	return &model.Object{
+		Path:             urlPath,
+		Name:             filepath.Base(urlPath),
+		LastModified:     &modified,
+		Size:             m.Size,
+		ETag:             m.Digest,
+		ContentType:      m.ContentType,
+		StorageInterface: client,
+	}
+}
+
+// Get receive file with given path
+func (client Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "bolt"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream, chunks are read one by one in short read transactions
+func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	var m *meta
+	err := client.DB.View(func(tx *bbolt.Tx) (err error) {
+		m, err = getMeta(tx, cleanPath(path))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reader{client: client, meta: m}, nil
+}
+
+// reader read chunks of an object in sequence. Long read transactions would block the database from growing, so each chunk is read in its own transaction
+type reader struct {
+	client Client
+	meta   *meta
+	seq    uint32
+	buffer []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if r.seq >= r.meta.Chunks {
+			return 0, io.EOF
+		}
+
+		err := r.client.DB.View(func(tx *bbolt.Tx) error {
+			value := tx.Bucket(chunksBucket).Get(chunkKey(r.meta.ID, r.seq))
+			if value == nil {
+				// the object has been overwritten or deleted while reading
+				return io.ErrUnexpectedEOF
+			}
+			r.buffer = append([]byte(nil), value...)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.seq++
+	}
+
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	return nil
+}
+
+// Stat get object's information without reading its content
+func (client Client) Stat(path string) (*model.Object, error) {
+	var m *meta
+	err := client.DB.View(func(tx *bbolt.Tx) (err error) {
+		m, err = getMeta(tx, cleanPath(path))
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(cleanPath(path), m), nil
+}
+
+func deleteObject(tx *bbolt.Tx, urlPath string) error {
+	m, err := getMeta(tx, urlPath)
+	if err != nil {
+		return err
+	}
+
+	chunks := tx.Bucket(chunksBucket)
+	for seq := uint32(0); seq < m.Chunks; seq++ {
+		if err := chunks.Delete(chunkKey(m.ID, seq)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(objectsBucket).Delete([]byte(urlPath))
+}
+
+// Put store a reader into given path atomically. The reader is spooled into a temporary file first, so the write transaction
+// isn't held while waiting for a slow reader
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if client.Config.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	spool, err := ioutil.TempFile("", "bolt")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := spool.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	urlPath = cleanPath(urlPath)
+	m := &meta{
+		Size:        size,
+		ContentType: mime.TypeByExtension(path.Ext(urlPath)),
+		Digest:      hex.EncodeToString(hasher.Sum(nil)),
+		Modified:    time.Now(),
+	}
+
+	err = client.DB.Update(func(tx *bbolt.Tx) error {
+		if err := deleteObject(tx, urlPath); err != nil && err != os.ErrNotExist {
+			return err
+		}
+
+		objects, chunks := tx.Bucket(objectsBucket), tx.Bucket(chunksBucket)
+		id, err := objects.NextSequence()
+		if err != nil {
+			return err
+		}
+		m.ID = id
+
+		for {
+			buffer := make([]byte, client.Config.ChunkSize)
+			n, err := io.ReadFull(spool, buffer)
+			if n > 0 {
+				if err := chunks.Put(chunkKey(m.ID, m.Chunks), buffer[:n]); err != nil {
+					return err
+				}
+				m.Chunks++
+			}
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+
+		value, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return objects.Put([]byte(urlPath), value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.toObject(urlPath, m), nil
+}
+
+// Delete delete file atomically
+func (client Client) Delete(path string) error {
+	if client.Config.ReadOnly {
+		return ErrReadOnly
+	}
+
+	return client.DB.Update(func(tx *bbolt.Tx) error {
+		return deleteObject(tx, cleanPath(path))
+	})
+}
+
+// List list all objects under current path in path order
+func (client Client) List(path string) ([]*model.Object, error) {
+	prefix := cleanPath(path)
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	var objects []*model.Object
+	err := client.DB.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(objectsBucket)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, value := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, value = cursor.Next() {
+			m := &meta{}
+			if err := json.Unmarshal(value, m); err != nil {
+				return err
+			}
+			objects = append(objects, client.toObject(string(key), m))
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// Snapshot write a consistent copy of the database into writer, while reads and writes continue
+func (client Client) Snapshot(writer io.Writer) (int64, error) {
+	var size int64
+	err := client.DB.View(func(tx *bbolt.Tx) (err error) {
+		size, err = tx.WriteTo(writer)
+		return
+	})
+	return size, err
+}
+
+// SnapshotFile write a consistent copy of the database into file, which could be opened with Config.ReadOnly
+func (client Client) SnapshotFile(file string) error {
+	return client.DB.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(file, 0600)
+	})
+}
+
+// GetEndpoint get endpoint, bbolt's endpoint is /
+func (client Client) GetEndpoint() string {
+	return "/"
+}
+
+// GetURL objects are not accessible by URL, return the path like FileSystem does
+func (client Client) GetURL(path string) (string, error) {
+	return path, nil
+}
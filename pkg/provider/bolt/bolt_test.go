@@ -0,0 +1,117 @@
+package bolt_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/provider/bolt"
+	"github.com/bhojpur/drive/tests"
+)
+
+func newClient(t *testing.T, config *bolt.Config) *bolt.Client {
+	client, err := bolt.New(filepath.Join(t.TempDir(), "drive.db"), config)
+	if err != nil {
+		t.Fatalf("No error should happen when open database, but got %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newClient(t, nil), t)
+}
+
+func TestChunks(t *testing.T) {
+	client := newClient(t, &bolt.Config{ChunkSize: 1 << 10})
+	data := make([]byte, 10000)
+	rand.Read(data)
+
+	if _, err := client.Put("/data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+	client.Put("/data.bin", bytes.NewReader(data[:5000]))
+
+	stream, err := client.GetStream("/data.bin")
+	if err != nil {
+		t.Fatalf("No error should happen when get stream, but got %v", err)
+	}
+	if result, err := ioutil.ReadAll(stream); err != nil || !bytes.Equal(result, data[:5000]) {
+		t.Errorf("Stream should match overwritten content, but got %v bytes, %v", len(result), err)
+	}
+
+	if object, err := client.Stat("/data.bin"); err != nil || object.Size != 5000 || object.ETag == "" {
+		t.Errorf("Stat should report size and digest, but got %+v, %v", object, err)
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	client := newClient(t, nil)
+	for _, path := range []string{"/b/2.txt", "/a_b/3.txt", "/b/1.txt", "/b/a/1.txt", "/c.txt"} {
+		client.Put(path, strings.NewReader(path))
+	}
+
+	objects, err := client.List("/b")
+	if err != nil {
+		t.Fatalf("No error should happen when list, but got %v", err)
+	}
+
+	var paths []string
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	if strings.Join(paths, ",") != "/b/1.txt,/b/2.txt,/b/a/1.txt" {
+		t.Errorf("List should return objects under the path in order, but got %v", paths)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	client := newClient(t, nil)
+	client.Put("/a.txt", strings.NewReader("before"))
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := client.SnapshotFile(snapshot); err != nil {
+		t.Fatalf("No error should happen when snapshot, but got %v", err)
+	}
+	client.Put("/a.txt", strings.NewReader("after"))
+
+	readOnly, err := bolt.New(snapshot, &bolt.Config{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("No error should happen when open snapshot, but got %v", err)
+	}
+	defer readOnly.Close()
+
+	stream, err := readOnly.GetStream("/a.txt")
+	if err != nil {
+		t.Fatalf("No error should happen when get stream from snapshot, but got %v", err)
+	}
+	if data, _ := ioutil.ReadAll(stream); string(data) != "before" {
+		t.Errorf("Snapshot should keep content at the time it was taken, but got %v", string(data))
+	}
+
+	if _, err := readOnly.Put("/b.txt", strings.NewReader("b")); err != bolt.ErrReadOnly {
+		t.Errorf("Write into read-only snapshot should be rejected, but got %v", err)
+	}
+}
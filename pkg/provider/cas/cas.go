@@ -0,0 +1,444 @@
+package cas
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/internal/gc"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// ErrInvalidPath returned for paths escaping the root, which would refer to data outside of refs, e.g. "/../blobs/..."
+var ErrInvalidPath = errors.New("path escapes the root")
+
+// Ref reference record of a logical path, saved in the underlying storage
+type Ref struct {
+	Digest      string    `json:"digest"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Modified    time.Time `json:"modified"`
+}
+
+// Client content-addressable storage, blobs are stored once under their SHA-256 digest, logical paths are references to digests.
+//
+// Layout in the underlying storage:
+//
+//	<prefix>/blobs/<digest[:2]>/<digest>    content
+//	<prefix>/refs/<path>                    JSON reference record
+//	<prefix>/counts/<digest[:2]>/<digest>   number of references of the blob
+//	<prefix>/pending/<nonce>                marker of a recent write listing its digest, which protects the blob from GC of other processes
+type Client struct {
+	Storage model.StorageInterface
+	Prefix  string
+
+	gc       *gc.Guard
+	counting *sync.Mutex
+}
+
+// New initialize content-addressable storage, its data is saved under prefix of the underlying storage
+func New(storage model.StorageInterface, prefix string) *Client {
+	client := &Client{Storage: storage, Prefix: strings.Trim(prefix, "/"), counting: &sync.Mutex{}}
+	client.gc = gc.New(storage, client.join("pending"))
+	return client
+}
+
+func (client Client) join(elem ...string) string {
+	return "/" + path.Join(append([]string{client.Prefix}, elem...)...)
+}
+
+func (client Client) refPath(urlPath string) (string, error) {
+	cleaned := path.Clean(strings.TrimLeft(urlPath, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidPath
+	}
+	return client.join("refs", cleaned), nil
+}
+
+func (client Client) blobPath(digest string) string {
+	return client.join("blobs", digest[:2], digest)
+}
+
+func (client Client) countPath(digest string) string {
+	return client.join("counts", digest[:2], digest)
+}
+
+func (client Client) readJSON(urlPath string, value interface{}) error {
+	stream, err := client.Storage.GetStream(urlPath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return json.NewDecoder(stream).Decode(value)
+}
+
+func (client Client) writeJSON(urlPath string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = client.Storage.Put(urlPath, bytes.NewReader(data))
+	return err
+}
+
+// GetRef get reference record of the logical path
+func (client Client) GetRef(urlPath string) (*Ref, error) {
+	refPath, err := client.refPath(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &Ref{}
+	if err := client.readJSON(refPath, ref); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (*os.File, error) {
+	ref, err := client.GetRef(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.Storage.Get(client.blobPath(ref.Digest))
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(urlPath string) (io.ReadCloser, error) {
+	ref, err := client.GetRef(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.Storage.GetStream(client.blobPath(ref.Digest))
+}
+
+// Stat get object's information from its reference, ETag is the SHA-256 digest of the content
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	ref, err := client.GetRef(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(urlPath, ref), nil
+}
+
+func (client Client) toObject(urlPath string, ref *Ref) *model.Object {
+	modified := ref.Modified
+	return &model.Object{
+		Path:             urlPath,
+		Name:             filepath.Base(urlPath),
+		LastModified:     &modified,
+		Size:             ref.Size,
+		ETag:             ref.Digest,
+		ContentType:      ref.ContentType,
+		StorageInterface: client,
+	}
+}
+
+// Put store a reader into given path, its content is only uploaded if no blob has the same digest
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if _, err := client.refPath(urlPath); err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	// spool content to know its digest before uploading
+	spool, err := ioutil.TempFile("", "cas")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), reader)
+	if err != nil {
+		return nil, err
+	}
+	digest := hex.EncodeToString(hasher.Sum(nil))
+
+	ref := &Ref{
+		Digest:      digest,
+		Size:        size,
+		ContentType: mime.TypeByExtension(path.Ext(urlPath)),
+		Modified:    time.Now(),
+	}
+	if ref.ContentType == "" {
+		head := make([]byte, 512)
+		n, _ := spool.ReadAt(head, 0)
+		ref.ContentType = http.DetectContentType(head[:n])
+	}
+
+	write, err := client.gc.Begin([]string{digest})
+	if err != nil {
+		return nil, err
+	}
+	defer write.End()
+
+	if err := client.commit(urlPath, spool, ref, write); err != nil {
+		write.Abort()
+		return nil, err
+	}
+
+	return client.toObject(urlPath, ref), nil
+}
+
+func (client Client) commit(urlPath string, spool *os.File, ref *Ref, write *gc.Write) error {
+	if err := client.uploadMissing(spool, ref.Digest); err != nil {
+		return err
+	}
+
+	refPath, err := client.refPath(urlPath)
+	if err != nil {
+		return err
+	}
+
+	oldRef, _ := client.GetRef(urlPath)
+	if err := client.writeJSON(refPath, ref); err != nil {
+		return err
+	}
+
+	write.Commit()
+
+	// blob might be collected by another process between the check and the commit of the reference
+	if err := client.uploadMissing(spool, ref.Digest); err != nil {
+		return err
+	}
+
+	if oldRef == nil || oldRef.Digest != ref.Digest {
+		client.addRefCount(ref.Digest, 1)
+		if oldRef != nil {
+			client.addRefCount(oldRef.Digest, -1)
+		}
+	}
+	return nil
+}
+
+// uploadMissing upload the blob unless it exists
+func (client Client) uploadMissing(spool *os.File, digest string) error {
+	if ok, err := model.Exists(client.Storage, client.blobPath(digest)); err != nil || ok {
+		return err
+	}
+	return client.upload(spool, digest)
+}
+
+func (client Client) upload(spool *os.File, digest string) error {
+	if _, err := spool.Seek(0, 0); err != nil {
+		return err
+	}
+	_, err := client.Storage.Put(client.blobPath(digest), spool)
+	return err
+}
+
+// RefCount get number of references of the blob. Reference counts are advisory, GC uses mark-and-sweep to find unreferenced blobs
+func (client Client) RefCount(digest string) (int64, error) {
+	stream, err := client.Storage.GetStream(client.countPath(digest))
+	if err != nil {
+		return 0, nil
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (client Client) addRefCount(digest string, delta int64) error {
+	client.counting.Lock()
+	defer client.counting.Unlock()
+
+	count, err := client.RefCount(digest)
+	if err != nil {
+		return err
+	}
+
+	if count += delta; count <= 0 {
+		if ok, err := model.Exists(client.Storage, client.countPath(digest)); err != nil || !ok {
+			return err
+		}
+		return client.Storage.Delete(client.countPath(digest))
+	}
+	_, err = client.Storage.Put(client.countPath(digest), strings.NewReader(strconv.FormatInt(count, 10)))
+	return err
+}
+
+// Delete delete the reference of given path and decrement the blob's reference count, the blob is removed by GC
+func (client Client) Delete(urlPath string) error {
+	ref, err := client.GetRef(urlPath)
+	if err != nil {
+		return err
+	}
+
+	refPath, err := client.refPath(urlPath)
+	if err != nil {
+		return err
+	}
+	if err := client.Storage.Delete(refPath); err != nil {
+		return err
+	}
+	return client.addRefCount(ref.Digest, -1)
+}
+
+// List list all objects under current path
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	refsPrefix := client.join("refs")
+	refPath, err := client.refPath(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	results, err := client.Storage.List(refPath)
+
+	var objects []*model.Object
+	for _, result := range results {
+		logicalPath := strings.TrimPrefix("/"+strings.TrimPrefix(result.Path, "/"), refsPrefix)
+		if logicalPath == "/"+strings.TrimPrefix(result.Path, "/") {
+			continue
+		}
+
+		ref, err := client.GetRef(logicalPath)
+		if err != nil {
+			continue
+		}
+		objects = append(objects, client.toObject(logicalPath, ref))
+	}
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL get public accessible URL of the referenced blob
+func (client Client) GetURL(urlPath string) (string, error) {
+	ref, err := client.GetRef(urlPath)
+	if err != nil {
+		return "", err
+	}
+	return client.Storage.GetURL(client.blobPath(ref.Digest))
+}
+
+// GCResult result of a garbage collection
+type GCResult struct {
+	Marked   int
+	Deleted  []string
+	Retained []string
+}
+
+// Collect mark-and-sweep garbage collection, delete blobs which are not referenced by any path.
+// Blobs written by this process during GC are retained. Blobs modified or written by other processes within minAge are retained too,
+// so minAge should be longer than the slowest write when other processes write into the same storage
+func (client Client) Collect(minAge time.Duration) (*GCResult, error) {
+	start := time.Now()
+	result := &GCResult{}
+
+	client.gc.Reset()
+
+	// mark
+	marked := map[string]bool{}
+	refsPrefix := client.join("refs")
+	refs, err := client.Storage.List(refsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range refs {
+		logicalPath := strings.TrimPrefix("/"+strings.TrimPrefix(object.Path, "/"), refsPrefix)
+		ref, err := client.GetRef(logicalPath)
+		if err != nil {
+			return nil, fmt.Errorf("read reference %v: %w", logicalPath, err)
+		}
+		marked[ref.Digest] = true
+	}
+	result.Marked = len(marked)
+
+	// pending markers of recent writes
+	protected, err := client.gc.Protected(start, minAge)
+	if err != nil {
+		return nil, err
+	}
+
+	// sweep
+	blobs, err := client.Storage.List(client.join("blobs"))
+	if err != nil {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		digest := blob.Name
+		if marked[digest] {
+			continue
+		}
+
+		if protected[digest] || (blob.LastModified != nil && start.Sub(*blob.LastModified) < minAge) {
+			result.Retained = append(result.Retained, digest)
+			continue
+		}
+
+		deleted, err := client.sweep(digest)
+		if err != nil {
+			return result, err
+		}
+		if deleted {
+			result.Deleted = append(result.Deleted, digest)
+		} else {
+			result.Retained = append(result.Retained, digest)
+		}
+	}
+
+	return result, nil
+}
+
+func (client Client) sweep(digest string) (bool, error) {
+	return client.gc.Sweep(digest, func() error {
+		if err := client.Storage.Delete(client.blobPath(digest)); err != nil {
+			return err
+		}
+		if ok, _ := model.Exists(client.Storage, client.countPath(digest)); ok {
+			client.Storage.Delete(client.countPath(digest))
+		}
+		return nil
+	})
+}
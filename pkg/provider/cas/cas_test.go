@@ -0,0 +1,162 @@
+package cas_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/cas"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/tests"
+)
+
+func TestAll(t *testing.T) {
+	tests.TestAll(cas.New(filesystem.New(t.TempDir()), "cas"), t)
+}
+
+func TestDeduplication(t *testing.T) {
+	storage := filesystem.New(t.TempDir())
+	client := cas.New(storage, "cas")
+
+	a, err := client.Put("/builds/1/cache.bin", strings.NewReader("cached blob"))
+	if err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+	b, _ := client.Put("/builds/2/cache.bin", strings.NewReader("cached blob"))
+	if a.ETag != b.ETag {
+		t.Errorf("Same content should have same digest, but got %v and %v", a.ETag, b.ETag)
+	}
+
+	if blobs, _ := storage.List("/cas/blobs"); len(blobs) != 1 {
+		t.Errorf("Same content should be stored once, but got %v blobs", len(blobs))
+	}
+	if count, _ := client.RefCount(a.ETag); count != 2 {
+		t.Errorf("Blob should have 2 references, but got %v", count)
+	}
+
+	if objects, err := client.List("/builds"); err != nil || len(objects) != 2 {
+		t.Errorf("Should found 2 objects, but got %v, %v", objects, err)
+	}
+
+	if stream, err := client.GetStream("/builds/2/cache.bin"); err != nil {
+		t.Errorf("No error should happen when get file, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "cached blob" {
+		t.Errorf("Downloaded file should contain correct content, but got %v", string(data))
+	}
+
+	client.Delete("/builds/1/cache.bin")
+	if count, _ := client.RefCount(a.ETag); count != 1 {
+		t.Errorf("Blob should have 1 reference after delete, but got %v", count)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	storage := filesystem.New(t.TempDir())
+	client := cas.New(storage, "")
+
+	kept, _ := client.Put("/kept.txt", strings.NewReader("kept"))
+	deleted, _ := client.Put("/deleted.txt", strings.NewReader("deleted"))
+	overwritten, _ := client.Put("/overwritten.txt", strings.NewReader("old"))
+	client.Put("/overwritten.txt", strings.NewReader("new"))
+	client.Delete("/deleted.txt")
+
+	// pending markers of recent writes protect blobs from GC of other processes
+	result, err := cas.New(storage, "").Collect(time.Hour)
+	if err != nil {
+		t.Fatalf("No error should happen when collect garbage, but got %v", err)
+	}
+	if len(result.Deleted) != 0 {
+		t.Errorf("Recently written blobs should be retained, but got %v", result.Deleted)
+	}
+
+	result, err = cas.New(storage, "").Collect(0)
+	if err != nil {
+		t.Fatalf("No error should happen when collect garbage, but got %v", err)
+	}
+	if len(result.Deleted) != 2 || result.Marked != 2 {
+		t.Errorf("Unreferenced blobs %v, %v should be deleted, but got %+v", deleted.ETag, overwritten.ETag, result)
+	}
+
+	if stream, err := client.GetStream("/kept.txt"); err != nil {
+		t.Errorf("Referenced blob %v should be kept, but got %v", kept.ETag, err)
+	} else {
+		stream.Close()
+	}
+}
+
+func TestCollectWhileWriting(t *testing.T) {
+	client := cas.New(filesystem.New(t.TempDir()), "")
+	client.Put("/a.txt", strings.NewReader("shared"))
+	client.Delete("/a.txt")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			client.Put("/b.txt", strings.NewReader("shared"))
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		client.Collect(0)
+	}
+	<-done
+
+	if stream, err := client.GetStream("/b.txt"); err != nil {
+		t.Errorf("Blob referenced by concurrent write should be kept, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "shared" {
+		t.Errorf("Downloaded file should contain correct content, but got %v", string(data))
+	}
+}
+
+func TestInvalidPath(t *testing.T) {
+	storage := filesystem.New(t.TempDir())
+	client := cas.New(storage, "cas")
+
+	object, err := client.Put("/a.txt", strings.NewReader("a"))
+	if err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+	blobPath := "/blobs/" + object.ETag[:2] + "/" + object.ETag
+
+	for _, urlPath := range []string{"/.." + blobPath, "../.." + blobPath, "/refs/../../.." + blobPath} {
+		if _, err := client.Put(urlPath, strings.NewReader("b")); err != cas.ErrInvalidPath {
+			t.Errorf("Put %v should be rejected, but got %v", urlPath, err)
+		}
+		if err := client.Delete(urlPath); err != cas.ErrInvalidPath {
+			t.Errorf("Delete %v should be rejected, but got %v", urlPath, err)
+		}
+	}
+
+	if stream, err := client.GetStream("/a.txt"); err != nil {
+		t.Errorf("No error should happen when get file, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "a" {
+		t.Errorf("Blob should not be overwritten, but got %v", string(data))
+	}
+	if _, err := client.Put("/dir/../b.txt", strings.NewReader("b")); err != nil {
+		t.Errorf("Paths staying under the root should be cleaned, but got %v", err)
+	}
+	if _, err := client.Stat("/b.txt"); err != nil {
+		t.Errorf("No error should happen when stat cleaned path, but got %v", err)
+	}
+}
@@ -0,0 +1,523 @@
+package chunked
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/internal/gc"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// ErrChunkCorrupted returned when a chunk's content doesn't match its digest
+var ErrChunkCorrupted = errors.New("chunk content doesn't match its digest")
+
+// Chunk a chunk of an object
+type Chunk struct {
+	Digest string `json:"digest"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// Manifest manifest of a logical object, saved in the underlying storage
+type Manifest struct {
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Modified    time.Time `json:"modified"`
+	Chunks      []Chunk   `json:"chunks"`
+}
+
+// Digest identifier of the whole object, the SHA-256 digest of its chunk digests
+func (manifest Manifest) Digest() string {
+	hasher := sha256.New()
+	for _, chunk := range manifest.Chunks {
+		hasher.Write([]byte(chunk.Digest))
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// UploadStats statistics of an upload, only new chunks are sent to the underlying storage
+type UploadStats struct {
+	Chunks    int
+	NewChunks int
+	Bytes     int64
+	NewBytes  int64
+}
+
+// Config chunked storage config
+type Config struct {
+	Prefix  string
+	MinSize int // default to 256KiB
+	AvgSize int // default to 1MiB
+	MaxSize int // default to 4MiB
+}
+
+// Client chunked storage, split objects into content-defined chunks, which are stored by digest in the underlying storage,
+// each logical object has a manifest listing its chunks. Uploading a modified object only sends the changed chunks.
+//
+// Layout in the underlying storage:
+//
+//	<prefix>/chunks/<digest[:2]>/<digest>   content of a chunk
+//	<prefix>/manifests/<path>               JSON manifest
+//	<prefix>/pending/<nonce>                marker of a recent upload listing its chunks, which protects them from GC of other processes
+type Client struct {
+	Storage model.StorageInterface
+	Config  *Config
+
+	gc *gc.Guard
+}
+
+// New initialize chunked storage
+func New(storage model.StorageInterface, config *Config) *Client {
+	if config == nil {
+		config = &Config{}
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	if config.AvgSize == 0 {
+		config.AvgSize = 1 << 20
+	}
+	if config.MinSize == 0 {
+		config.MinSize = config.AvgSize / 4
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = config.AvgSize * 4
+	}
+
+	client := &Client{Storage: storage, Config: config}
+	client.gc = gc.New(storage, client.join("pending"))
+	return client
+}
+
+func (client Client) join(elem ...string) string {
+	return "/" + path.Join(append([]string{client.Config.Prefix}, elem...)...)
+}
+
+func (client Client) manifestPath(urlPath string) string {
+	return client.join("manifests", strings.TrimPrefix(urlPath, "/"))
+}
+
+func (client Client) chunkPath(digest string) string {
+	return client.join("chunks", digest[:2], digest)
+}
+
+func (client Client) hasChunk(digest string) (bool, error) {
+	return model.Exists(client.Storage, client.chunkPath(digest))
+}
+
+// GetManifest get manifest of the logical object
+func (client Client) GetManifest(urlPath string) (*Manifest, error) {
+	stream, err := client.Storage.GetStream(client.manifestPath(urlPath))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(stream).Decode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Upload split the reader into chunks, upload new chunks, then save the manifest. A pending marker listing the chunks is
+// saved before checking whether they exist, which protects them from GC of other processes until the manifest is saved,
+// and existing chunks are checked again after that
+func (client Client) Upload(urlPath string, reader io.Reader) (*Manifest, *UploadStats, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	// spool chunks to know all digests before the marker is saved, and to upload deduplicated chunks again if they are
+	// collected before the manifest is committed
+	spool, err := ioutil.TempFile("", "chunked")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	var (
+		manifest  = &Manifest{ContentType: mime.TypeByExtension(path.Ext(urlPath))}
+		stats     = &UploadStats{}
+		chunker   = NewChunker(reader, client.Config.MinSize, client.Config.AvgSize, client.Config.MaxSize)
+		seen      = map[string]bool{}
+		spooled   []Chunk // unique chunks, offsets are in the spool
+		digests   []string
+		spoolSize int64
+	)
+
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, stats, err
+		}
+
+		if manifest.ContentType == "" {
+			manifest.ContentType = http.DetectContentType(data)
+		}
+
+		sum := sha256.Sum256(data)
+		chunk := Chunk{Digest: hex.EncodeToString(sum[:]), Offset: manifest.Size, Size: int64(len(data))}
+
+		if !seen[chunk.Digest] {
+			seen[chunk.Digest] = true
+			if _, err := spool.Write(data); err != nil {
+				return nil, stats, err
+			}
+			spooled = append(spooled, Chunk{Digest: chunk.Digest, Offset: spoolSize, Size: chunk.Size})
+			digests = append(digests, chunk.Digest)
+			spoolSize += chunk.Size
+		}
+
+		stats.Chunks++
+		stats.Bytes += chunk.Size
+		manifest.Size += chunk.Size
+		manifest.Chunks = append(manifest.Chunks, chunk)
+	}
+
+	// the marker is kept after the upload, and removed by GC once it is older than GC's minimum age
+	write, err := client.gc.Begin(digests)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer write.End()
+
+	var deduplicated []Chunk
+	for _, chunk := range spooled {
+		if ok, err := client.hasChunk(chunk.Digest); err != nil {
+			write.Abort()
+			return nil, stats, err
+		} else if ok {
+			deduplicated = append(deduplicated, chunk)
+			continue
+		}
+		if _, err := client.Storage.Put(client.chunkPath(chunk.Digest), io.NewSectionReader(spool, chunk.Offset, chunk.Size)); err != nil {
+			write.Abort()
+			return nil, stats, err
+		}
+		stats.NewChunks++
+		stats.NewBytes += chunk.Size
+	}
+
+	manifest.Modified = time.Now()
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		write.Abort()
+		return nil, stats, err
+	}
+	if _, err := client.Storage.Put(client.manifestPath(urlPath), bytes.NewReader(data)); err != nil {
+		write.Abort()
+		return nil, stats, err
+	}
+	write.Commit()
+
+	// chunks might be collected by another process between the check and the commit of the manifest
+	for _, chunk := range deduplicated {
+		if ok, err := client.hasChunk(chunk.Digest); err != nil {
+			return nil, stats, err
+		} else if !ok {
+			if _, err := client.Storage.Put(client.chunkPath(chunk.Digest), io.NewSectionReader(spool, chunk.Offset, chunk.Size)); err != nil {
+				return nil, stats, err
+			}
+		}
+	}
+	return manifest, stats, nil
+}
+
+// Put store a reader into given path
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	manifest, _, err := client.Upload(urlPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(urlPath, manifest), nil
+}
+
+func (client Client) toObject(urlPath string, manifest *Manifest) *model.Object {
+	modified := manifest.Modified
+	return &model.Object{
+		Path:             urlPath,
+		Name:             filepath.Base(urlPath),
+		LastModified:     &modified,
+		Size:             manifest.Size,
+		ETag:             manifest.Digest(),
+		ContentType:      manifest.ContentType,
+		StorageInterface: client,
+	}
+}
+
+// Stat get object's information from its manifest
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	manifest, err := client.GetManifest(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(urlPath, manifest), nil
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(urlPath)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "chunked"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream, chunks are downloaded one by one while reading
+func (client Client) GetStream(urlPath string) (io.ReadCloser, error) {
+	manifest, err := client.GetManifest(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{client: client, chunks: manifest.Chunks, remaining: manifest.Size}, nil
+}
+
+// GetRange get length bytes of the object starting at offset as stream, only the chunks covering the range are downloaded.
+// A negative length reads until the end of the object
+func (client Client) GetRange(urlPath string, offset, length int64) (io.ReadCloser, error) {
+	manifest, err := client.GetManifest(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || offset > manifest.Size {
+		return nil, fmt.Errorf("range offset %d is out of object size %d", offset, manifest.Size)
+	}
+	if length < 0 || offset+length > manifest.Size {
+		length = manifest.Size - offset
+	}
+
+	// first chunk which ends after offset
+	first := sort.Search(len(manifest.Chunks), func(i int) bool {
+		chunk := manifest.Chunks[i]
+		return chunk.Offset+chunk.Size > offset
+	})
+
+	var skip int64
+	if first < len(manifest.Chunks) {
+		skip = offset - manifest.Chunks[first].Offset
+	}
+	return &reader{client: client, chunks: manifest.Chunks[first:], skip: skip, remaining: length}, nil
+}
+
+// reader reassemble chunks into a stream
+type reader struct {
+	client    Client
+	chunks    []Chunk
+	skip      int64
+	remaining int64
+
+	current io.ReadCloser
+	chunk   Chunk
+	hasher  hash.Hash
+	read    int64
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if r.current == nil {
+		if len(r.chunks) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.current.Read(p)
+	r.hasher.Write(p[:n])
+	r.read += int64(n)
+	r.remaining -= int64(n)
+
+	if err == io.EOF || r.read == r.chunk.Size {
+		if closeErr := r.closeChunk(); closeErr != nil {
+			return n, closeErr
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *reader) open() error {
+	r.chunk, r.chunks = r.chunks[0], r.chunks[1:]
+	stream, err := r.client.Storage.GetStream(r.client.chunkPath(r.chunk.Digest))
+	if err != nil {
+		return err
+	}
+	r.current, r.hasher, r.read = stream, sha256.New(), 0
+
+	// skip the head of the first chunk of a range, it is still hashed to verify the chunk
+	if r.skip > 0 {
+		skipped, err := io.CopyN(r.hasher, r.current, r.skip)
+		r.read, r.skip = skipped, 0
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeChunk close current chunk, and verify its digest if it has been read completely
+func (r *reader) closeChunk() error {
+	r.current.Close()
+	r.current = nil
+	if r.read != r.chunk.Size {
+		if r.remaining > 0 {
+			return ErrChunkCorrupted
+		}
+		return nil
+	}
+	if hex.EncodeToString(r.hasher.Sum(nil)) != r.chunk.Digest {
+		return ErrChunkCorrupted
+	}
+	return nil
+}
+
+func (r *reader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
+
+// Delete delete the manifest of given path, chunks are removed by Collect as they might be shared with other objects
+func (client Client) Delete(urlPath string) error {
+	return client.Storage.Delete(client.manifestPath(urlPath))
+}
+
+// List list all objects under current path
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	manifestsPrefix := client.join("manifests")
+	results, err := client.Storage.List(client.manifestPath(urlPath))
+
+	var objects []*model.Object
+	for _, result := range results {
+		logicalPath := strings.TrimPrefix("/"+strings.TrimPrefix(result.Path, "/"), manifestsPrefix)
+		if manifest, err := client.GetManifest(logicalPath); err == nil {
+			objects = append(objects, client.toObject(logicalPath, manifest))
+		}
+	}
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL chunked objects have no single URL in the underlying storage, return the path like FileSystem does
+func (client Client) GetURL(urlPath string) (string, error) {
+	return urlPath, nil
+}
+
+// Collect delete chunks which are not referenced by any manifest. Chunks written by this process during GC are retained.
+// Chunks modified, or protected by pending markers of other processes, within minAge are retained too,
+// so minAge should be longer than the slowest upload when other processes write into the same storage
+func (client Client) Collect(minAge time.Duration) (deleted []string, err error) {
+	start := time.Now()
+
+	client.gc.Reset()
+
+	marked := map[string]bool{}
+	manifestsPrefix := client.join("manifests")
+	manifests, err := client.Storage.List(manifestsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range manifests {
+		manifest, err := client.GetManifest(strings.TrimPrefix("/"+strings.TrimPrefix(object.Path, "/"), manifestsPrefix))
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range manifest.Chunks {
+			marked[chunk.Digest] = true
+		}
+	}
+
+	// pending markers of recent uploads
+	protected, err := client.gc.Protected(start, minAge)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := client.Storage.List(client.join("chunks"))
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if marked[chunk.Name] || protected[chunk.Name] || chunk.LastModified == nil || start.Sub(*chunk.LastModified) < minAge {
+			continue
+		}
+
+		swept, err := client.sweep(chunk.Name)
+		if err != nil {
+			return deleted, err
+		}
+		if swept {
+			deleted = append(deleted, chunk.Name)
+		}
+	}
+	return deleted, nil
+}
+
+func (client Client) sweep(digest string) (bool, error) {
+	return client.gc.Sweep(digest, func() error {
+		return client.Storage.Delete(client.chunkPath(digest))
+	})
+}
@@ -0,0 +1,209 @@
+package chunked_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/chunked"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/tests"
+)
+
+var config = chunked.Config{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestAll(t *testing.T) {
+	cfg := config
+	tests.TestAll(chunked.New(filesystem.New(t.TempDir()), &cfg), t)
+}
+
+func TestChunker(t *testing.T) {
+	data := randomData(1<<20, 1)
+	chunker := chunked.NewChunker(bytes.NewReader(data), config.MinSize, config.AvgSize, config.MaxSize)
+
+	var total, count int
+	for {
+		chunk, err := chunker.Next()
+		if err != nil {
+			break
+		}
+		if len(chunk) > config.MaxSize || (len(chunk) < config.MinSize && total+len(chunk) != len(data)) {
+			t.Errorf("chunk size %v is out of range", len(chunk))
+		}
+		if !bytes.Equal(chunk, data[total:total+len(chunk)]) {
+			t.Fatalf("chunk at %v doesn't match content", total)
+		}
+		total += len(chunk)
+		count++
+	}
+
+	if total != len(data) {
+		t.Errorf("chunks should cover %v bytes, but got %v", len(data), total)
+	}
+	if avg := total / count; avg < config.MinSize*2 || avg > config.MaxSize/2 {
+		t.Errorf("average chunk size %v is far from %v", avg, config.AvgSize)
+	}
+}
+
+func TestDeltaUpload(t *testing.T) {
+	cfg := config
+	client := chunked.New(filesystem.New(t.TempDir()), &cfg)
+	data := randomData(1<<20, 2)
+
+	_, stats, err := client.Upload("/vm.img", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("No error should happen when upload, but got %v", err)
+	}
+	if stats.NewBytes != int64(len(data)) {
+		t.Errorf("First upload should send all bytes, but got %+v", stats)
+	}
+
+	// insert a few bytes in the middle of the file
+	modified := append(append(append([]byte{}, data[:500000]...), []byte("small change")...), data[500000:]...)
+	_, stats, err = client.Upload("/vm.img", bytes.NewReader(modified))
+	if err != nil {
+		t.Fatalf("No error should happen when upload, but got %v", err)
+	}
+	if stats.NewChunks > 3 || stats.NewBytes > int64(3*config.MaxSize) {
+		t.Errorf("Modified upload should only send changed chunks, but got %+v", stats)
+	}
+
+	if stream, err := client.GetStream("/vm.img"); err != nil {
+		t.Errorf("No error should happen when get stream, but got %v", err)
+	} else if result, _ := ioutil.ReadAll(stream); !bytes.Equal(result, modified) {
+		t.Errorf("Reassembled object should match modified content")
+	}
+
+	if object, err := client.Stat("/vm.img"); err != nil || object.Size != int64(len(modified)) {
+		t.Errorf("Stat should report size %v, but got %v, %v", len(modified), object, err)
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	cfg := config
+	client := chunked.New(filesystem.New(t.TempDir()), &cfg)
+	data := randomData(200000, 3)
+	client.Put("/data.bin", bytes.NewReader(data))
+
+	ranges := [][2]int64{{0, 10}, {5000, 30000}, {199990, 10}, {199990, 100}, {123456, -1}, {200000, 10}}
+	for _, r := range ranges {
+		stream, err := client.GetRange("/data.bin", r[0], r[1])
+		if err != nil {
+			t.Errorf("No error should happen when get range %v, but got %v", r, err)
+			continue
+		}
+
+		end := r[0] + r[1]
+		if r[1] < 0 || end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if result, err := ioutil.ReadAll(stream); err != nil || !bytes.Equal(result, data[r[0]:end]) {
+			t.Errorf("range %v should match content, but got %v bytes, %v", r, len(result), err)
+		}
+		stream.Close()
+	}
+
+	if _, err := client.GetRange("/data.bin", 200001, 1); err == nil {
+		t.Errorf("range out of object should fail")
+	}
+}
+
+func TestCorruptedChunk(t *testing.T) {
+	storage := filesystem.New(t.TempDir())
+	client := chunked.New(storage, &chunked.Config{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10})
+	client.Put("/data.bin", bytes.NewReader(randomData(50000, 4)))
+
+	manifest, _ := client.GetManifest("/data.bin")
+	digest := manifest.Chunks[1].Digest
+	storage.Put("/chunks/"+digest[:2]+"/"+digest, strings.NewReader(strings.Repeat("x", int(manifest.Chunks[1].Size))))
+
+	stream, _ := client.GetStream("/data.bin")
+	if _, err := ioutil.ReadAll(stream); err != chunked.ErrChunkCorrupted {
+		t.Errorf("Corrupted chunk should be detected, but got %v", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	cfg := config
+	client := chunked.New(filesystem.New(t.TempDir()), &cfg)
+	client.Put("/a.bin", bytes.NewReader(randomData(50000, 5)))
+	client.Put("/b.bin", bytes.NewReader(randomData(50000, 6)))
+	client.Delete("/a.bin")
+
+	if deleted, err := client.Collect(time.Hour); err != nil || len(deleted) != 0 {
+		t.Errorf("Recent chunks should be retained, but got %v, %v", deleted, err)
+	}
+	if deleted, err := client.Collect(0); err != nil || len(deleted) == 0 {
+		t.Errorf("Unreferenced chunks should be deleted, but got %v, %v", deleted, err)
+	}
+	if stream, err := client.GetStream("/b.bin"); err != nil {
+		t.Errorf("No error should happen when get stream, but got %v", err)
+	} else if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Errorf("Referenced chunks should be kept, but got %v", err)
+	}
+}
+
+func TestCollectPending(t *testing.T) {
+	// chunks look older than GC's minimum age, only pending markers protect them
+	storage := memory.New(&memory.Config{Now: func() time.Time { return time.Now().Add(-2 * time.Hour) }})
+	cfg := config
+	writer := chunked.New(storage, &cfg)
+	data := randomData(50000, 7)
+	writer.Put("/a.bin", bytes.NewReader(data))
+
+	// the manifest of a deduplicated upload of another process is not committed yet
+	manifest, _ := writer.GetManifest("/a.bin")
+	if markers, _ := storage.List("/pending"); len(markers) != 1 || len(manifest.Chunks) < 2 {
+		t.Errorf("Upload should save one pending marker listing all of its chunks, but got %v", len(markers))
+	}
+	writer.Delete("/a.bin")
+
+	collector := chunked.New(storage, &cfg)
+	if deleted, err := collector.Collect(time.Hour); err != nil || len(deleted) != 0 {
+		t.Errorf("Chunks protected by pending markers should be retained, but got %v, %v", deleted, err)
+	}
+
+	if deleted, err := collector.Collect(0); err != nil || len(deleted) != len(manifest.Chunks) {
+		t.Errorf("Unreferenced chunks should be deleted once markers expire, but got %v, %v", deleted, err)
+	}
+
+	// deleted chunks are uploaded again, instead of being trusted from an earlier upload
+	if _, stats, err := writer.Upload("/a.bin", bytes.NewReader(data)); err != nil || stats.NewChunks != len(manifest.Chunks) {
+		t.Errorf("Collected chunks should be uploaded again, but got %+v, %v", stats, err)
+	}
+	if stream, err := writer.GetStream("/a.bin"); err != nil {
+		t.Errorf("No error should happen when get stream, but got %v", err)
+	} else if result, err := ioutil.ReadAll(stream); err != nil || !bytes.Equal(result, data) {
+		t.Errorf("Object should be read back, but got %v", err)
+	}
+}
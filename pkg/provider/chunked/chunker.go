@@ -0,0 +1,148 @@
+package chunked
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gear random values of the rolling hash, generated with splitmix64 from a fixed seed so chunk boundaries are stable across processes
+var gear [256]uint64
+
+func init() {
+	seed := uint64(0x6a09e667f3bcc908)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker split a stream into content-defined chunks with a FastCDC-style gear hash. Boundaries depend on content only,
+// so an insertion or deletion only changes the chunks around it
+type Chunker struct {
+	reader  io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64
+	maskL   uint64
+	buffer  []byte
+	start   int
+	end     int
+	eof     bool
+}
+
+// NewChunker initialize chunker, chunks are between minSize and maxSize bytes, avgSize bytes in average
+func NewChunker(reader io.Reader, minSize, avgSize, maxSize int) *Chunker {
+	avgBits := bits.Len(uint(avgSize)) - 1
+
+	return &Chunker{
+		reader:  reader,
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		// normalized chunking, harder to cut before the average size, easier after it
+		maskS:  highBits(avgBits + 1),
+		maskL:  highBits(avgBits - 1),
+		buffer: make([]byte, maxSize*2),
+	}
+}
+
+func highBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// Next return the next chunk, the returned slice is only valid until the next call. It returns io.EOF when the stream is consumed
+func (chunker *Chunker) Next() ([]byte, error) {
+	if err := chunker.fill(); err != nil {
+		return nil, err
+	}
+
+	if chunker.start == chunker.end {
+		return nil, io.EOF
+	}
+
+	data := chunker.buffer[chunker.start:chunker.end]
+	n := chunker.cut(data)
+	chunker.start += n
+	return data[:n], nil
+}
+
+// fill read from the stream until maxSize bytes are buffered or the stream is consumed
+func (chunker *Chunker) fill() error {
+	if chunker.end-chunker.start >= chunker.maxSize || chunker.eof {
+		return nil
+	}
+
+	copy(chunker.buffer, chunker.buffer[chunker.start:chunker.end])
+	chunker.end -= chunker.start
+	chunker.start = 0
+
+	for chunker.end < chunker.maxSize {
+		n, err := chunker.reader.Read(chunker.buffer[chunker.end:])
+		chunker.end += n
+		if err == io.EOF {
+			chunker.eof = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (chunker *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= chunker.minSize {
+		return n
+	}
+	if n > chunker.maxSize {
+		n = chunker.maxSize
+	}
+
+	normal := chunker.avgSize
+	if normal > n {
+		normal = n
+	}
+
+	var fingerprint uint64
+	i := chunker.minSize
+	for ; i < normal; i++ {
+		fingerprint = (fingerprint << 1) + gear[data[i]]
+		if fingerprint&chunker.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fingerprint = (fingerprint << 1) + gear[data[i]]
+		if fingerprint&chunker.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
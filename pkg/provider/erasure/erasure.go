@@ -0,0 +1,824 @@
+package erasure
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/klauspost/reedsolomon"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// ErrNotEnoughShards returned when less than DataShards shards of an object are available
+var ErrNotEnoughShards = errors.New("not enough shards to reconstruct the object")
+
+// Config erasure-coded storage config
+type Config struct {
+	DataShards   int
+	ParityShards int
+	BlockSize    int // bytes of each shard per stripe, default to 1MiB
+	Prefix       string
+	// AllowSharedBackends place several shards of an object on the same backend when there are fewer
+	// backends than DataShards+ParityShards. Losing such a backend loses all of its shards, so the
+	// object no longer survives the loss of ParityShards backends, only of ParityShards shards
+	AllowSharedBackends bool
+}
+
+// Meta placement metadata of an object, replicated to every backend
+type Meta struct {
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Modified     time.Time `json:"modified"`
+	DataShards   int       `json:"data_shards"`
+	ParityShards int       `json:"parity_shards"`
+	BlockSize    int       `json:"block_size"`
+	// Placement backend index of each shard
+	Placement []int `json:"placement"`
+	// Digests SHA-256 digest of each shard
+	Digests []string `json:"digests"`
+	// BlockDigests SHA-256 digest of each stripe block of each shard, verified while reading
+	BlockDigests [][]string `json:"block_digests,omitempty"`
+	// Version shards of each write are saved under their own version, so stale shards never match new metadata
+	Version string `json:"version,omitempty"`
+}
+
+// stripes return the shard block size of each stripe of the object
+func (meta Meta) stripes() []int {
+	var (
+		stripes    []int
+		stripeSize = int64(meta.DataShards * meta.BlockSize)
+	)
+	for remaining := meta.Size; remaining > 0; remaining -= stripeSize {
+		if remaining >= stripeSize {
+			stripes = append(stripes, meta.BlockSize)
+		} else {
+			stripes = append(stripes, int((remaining+int64(meta.DataShards)-1)/int64(meta.DataShards)))
+		}
+	}
+	return stripes
+}
+
+// Client erasure-coded storage, Reed-Solomon encode each object into DataShards data shards and ParityShards parity shards,
+// which are placed on different backends. Any DataShards shards reconstruct the object.
+//
+// Layout in each backend:
+//
+//	<prefix>/meta/<path>                      JSON placement metadata
+//	<prefix>/shards/<path>/<version>/<index>  shard content
+type Client struct {
+	Backends []model.StorageInterface
+	Config   *Config
+	encoder  reedsolomon.Encoder
+}
+
+// New initialize erasure-coded storage, panics if the config is invalid
+func New(backends []model.StorageInterface, config *Config) *Client {
+	if config.BlockSize == 0 {
+		config.BlockSize = 1 << 20
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	if len(backends) == 0 {
+		panic("erasure-coded storage requires at least one backend")
+	}
+
+	if total := config.DataShards + config.ParityShards; len(backends) < total && !config.AllowSharedBackends {
+		panic(fmt.Sprintf("erasure-coded storage requires at least %d backends to place %d data and %d parity shards on different backends, got %d", total, config.DataShards, config.ParityShards, len(backends)))
+	}
+
+	encoder, err := reedsolomon.New(config.DataShards, config.ParityShards)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Client{Backends: backends, Config: config, encoder: encoder}
+}
+
+func (client Client) join(elem ...string) string {
+	return "/" + path.Join(append([]string{client.Config.Prefix}, elem...)...)
+}
+
+func (client Client) metaPath(urlPath string) string {
+	return client.join("meta", strings.TrimPrefix(urlPath, "/"))
+}
+
+func (client Client) shardPath(urlPath string, meta *Meta, index int) string {
+	return client.join("shards", strings.TrimPrefix(urlPath, "/"), meta.Version, strconv.Itoa(index))
+}
+
+func newVersion(now time.Time) string {
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	return fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(nonce))
+}
+
+// placement choose backends of shards with rendezvous hashing, so shards are spread on different backends when there are enough of them
+func (client Client) placement(urlPath string) []int {
+	type score struct {
+		backend int
+		value   uint64
+	}
+
+	scores := make([]score, len(client.Backends))
+	for i := range client.Backends {
+		hasher := fnv.New64a()
+		fmt.Fprintf(hasher, "%s:%d", urlPath, i)
+		scores[i] = score{backend: i, value: hasher.Sum64()}
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].value > scores[j].value })
+
+	total := client.Config.DataShards + client.Config.ParityShards
+	placement := make([]int, total)
+	// with AllowSharedBackends, shards wrap around to backends already used
+	for i := range placement {
+		placement[i] = scores[i%len(scores)].backend
+	}
+	return placement
+}
+
+// readMetas read placement metadata of the object from each backend, metas[i] is nil when backend i failed with errs[i]
+func (client Client) readMetas(urlPath string) (metas []*Meta, errs []error) {
+	metas, errs = make([]*Meta, len(client.Backends)), make([]error, len(client.Backends))
+	for i, backend := range client.Backends {
+		stream, err := backend.GetStream(client.metaPath(urlPath))
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		meta := &Meta{}
+		err = json.NewDecoder(stream).Decode(meta)
+		stream.Close()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		metas[i] = meta
+	}
+	return metas, errs
+}
+
+// latest return the metadata of the latest version, versions start with the zero-padded write time so they sort by time
+func latest(metas []*Meta) (latest *Meta) {
+	for _, meta := range metas {
+		if meta != nil && (latest == nil || meta.Version > latest.Version) {
+			latest = meta
+		}
+	}
+	return latest
+}
+
+// GetMeta get placement metadata of the object. Backends which missed a write keep stale metadata until it is healed,
+// so metadata of all backends is read and the latest version wins
+func (client Client) GetMeta(urlPath string) (*Meta, error) {
+	metas, errs := client.readMetas(urlPath)
+	if meta := latest(metas); meta != nil {
+		return meta, nil
+	}
+	return nil, lastError(errs)
+}
+
+func lastError(errs []error) (lastErr error) {
+	for _, err := range errs {
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// metaQuorum number of backends metadata must be saved to for a write to succeed, DataShards+1 but at most all backends
+func (client Client) metaQuorum() int {
+	if quorum := client.Config.DataShards + 1; quorum < len(client.Backends) {
+		return quorum
+	}
+	return len(client.Backends)
+}
+
+// putMeta save metadata into all backends, it fails when less than metaQuorum backends saved it. Indexes of backends
+// which saved it are returned in both cases
+func (client Client) putMeta(urlPath string, meta *Meta) (saved []int, err error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for i, backend := range client.Backends {
+		if _, err := backend.Put(client.metaPath(urlPath), bytes.NewReader(data)); err != nil {
+			lastErr = err
+		} else {
+			saved = append(saved, i)
+		}
+	}
+
+	if quorum := client.metaQuorum(); len(saved) < quorum {
+		return saved, fmt.Errorf("failed to save metadata of %v into %d of %d backends, last error: %v", urlPath, quorum, len(client.Backends), lastErr)
+	}
+	return saved, nil
+}
+
+// restoreMeta put the previous metadata back into backends, or delete the metadata if the object didn't exist
+func (client Client) restoreMeta(urlPath string, previous *Meta, backends []int) (lastErr error) {
+	var data []byte
+	if previous != nil {
+		var err error
+		if data, err = json.Marshal(previous); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range backends {
+		var err error
+		if previous != nil {
+			_, err = client.Backends[i].Put(client.metaPath(urlPath), bytes.NewReader(data))
+		} else {
+			err = client.Backends[i].Delete(client.metaPath(urlPath))
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Put encode a reader into shards, and save them into backends
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	var (
+		total      = client.Config.DataShards + client.Config.ParityShards
+		stripeSize = client.Config.DataShards * client.Config.BlockSize
+		buffer     = make([]byte, stripeSize)
+		spools     = make([]*os.File, total)
+		hashers    = make([]io.Writer, total)
+		digests    = make([]interface{ Sum([]byte) []byte }, total)
+		meta       = &Meta{
+			ContentType:  mime.TypeByExtension(path.Ext(urlPath)),
+			DataShards:   client.Config.DataShards,
+			ParityShards: client.Config.ParityShards,
+			BlockSize:    client.Config.BlockSize,
+			Placement:    client.placement(urlPath),
+			BlockDigests: make([][]string, total),
+			Version:      newVersion(time.Now()),
+		}
+	)
+
+	for i := range spools {
+		spool, err := ioutil.TempFile("", "erasure")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		hasher := sha256.New()
+		spools[i], hashers[i], digests[i] = spool, io.MultiWriter(spool, hasher), hasher
+	}
+
+	// encode stripe by stripe into spool files, so memory usage doesn't depend on object size
+	for {
+		n, err := io.ReadFull(reader, buffer)
+		if n > 0 {
+			shards, splitErr := client.encoder.Split(buffer[:n])
+			if splitErr != nil {
+				return nil, splitErr
+			}
+			if encodeErr := client.encoder.Encode(shards); encodeErr != nil {
+				return nil, encodeErr
+			}
+			for i, shard := range shards {
+				if _, writeErr := hashers[i].Write(shard); writeErr != nil {
+					return nil, writeErr
+				}
+				blockDigest := sha256.Sum256(shard)
+				meta.BlockDigests[i] = append(meta.BlockDigests[i], hex.EncodeToString(blockDigest[:]))
+			}
+			meta.Size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		failed  int
+		lastErr error
+	)
+	for i, spool := range spools {
+		meta.Digests = append(meta.Digests, hex.EncodeToString(digests[i].Sum(nil)))
+		if _, err := spool.Seek(0, 0); err != nil {
+			return nil, err
+		}
+
+		// shards failed to be written are rebuilt by Heal, as long as enough shards are saved
+		if _, err := client.Backends[meta.Placement[i]].Put(client.shardPath(urlPath, meta, i), spool); err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+
+	if failed > client.Config.ParityShards {
+		client.deleteShards(urlPath, meta)
+		return nil, fmt.Errorf("%w: failed to save %d shards, last error: %v", ErrNotEnoughShards, failed, lastErr)
+	}
+
+	previous, _ := client.GetMeta(urlPath)
+
+	meta.Modified = time.Now()
+	if saved, err := client.putMeta(urlPath, meta); err != nil {
+		// roll the metadata back, so the previous version stays readable. New shards are kept if the rollback fails,
+		// as the new metadata left in some backends still refers to them
+		if client.restoreMeta(urlPath, previous, saved) == nil {
+			client.deleteShards(urlPath, meta)
+		}
+		return nil, err
+	}
+
+	// shards of the previous version are not referenced any more
+	if previous != nil && previous.Version != meta.Version {
+		client.deleteShards(urlPath, previous)
+	}
+	return client.toObject(urlPath, meta), nil
+}
+
+// deleteShards delete shards of the object version from their backends, return the last error
+func (client Client) deleteShards(urlPath string, meta *Meta) (lastErr error) {
+	for i, backend := range meta.Placement {
+		if err := client.Backends[backend].Delete(client.shardPath(urlPath, meta, i)); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (client Client) toObject(urlPath string, meta *Meta) *model.Object {
+	modified := meta.Modified
+	return &model.Object{
+		Path:             urlPath,
+		Name:             filepath.Base(urlPath),
+		LastModified:     &modified,
+		Size:             meta.Size,
+		ContentType:      meta.ContentType,
+		StorageInterface: client,
+	}
+}
+
+// Stat get object's information from its metadata
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	meta, err := client.GetMeta(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(urlPath, meta), nil
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(urlPath)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "erasure"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream, missing, failing or corrupted shards are reconstructed from the others
+func (client Client) GetStream(urlPath string) (io.ReadCloser, error) {
+	meta, err := client.GetMeta(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &reader{client: client, urlPath: urlPath, meta: meta, stripes: meta.stripes(), streams: make([]io.ReadCloser, len(meta.Placement)), failed: map[int]bool{}}
+	if err := reader.ensureStreams(); err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// reader decode stripes from shard streams
+type reader struct {
+	client  Client
+	urlPath string
+	meta    *Meta
+	stripes []int
+	stripe  int
+	offset  int64 // offset of the current stripe in each shard
+	streams []io.ReadCloser
+	failed  map[int]bool
+	buffer  []byte
+}
+
+// ensureStreams open shard streams until DataShards streams are available, data shards are preferred as they need no decoding
+func (r *reader) ensureStreams() error {
+	var available int
+	for _, stream := range r.streams {
+		if stream != nil {
+			available++
+		}
+	}
+
+	for i := range r.streams {
+		if available >= r.meta.DataShards {
+			return nil
+		}
+		if r.streams[i] != nil || r.failed[i] {
+			continue
+		}
+
+		stream, err := r.client.Backends[r.meta.Placement[i]].GetStream(r.client.shardPath(r.urlPath, r.meta, i))
+		if err == nil && r.offset > 0 {
+			if _, err = io.CopyN(ioutil.Discard, stream, r.offset); err != nil {
+				stream.Close()
+			}
+		}
+		if err != nil {
+			r.failed[i] = true
+			continue
+		}
+		r.streams[i] = stream
+		available++
+	}
+
+	if available < r.meta.DataShards {
+		return ErrNotEnoughShards
+	}
+	return nil
+}
+
+// verify check the block of the current stripe matches its digest, corrupted blocks are treated like missing shards
+func (r *reader) verify(i int, block []byte) bool {
+	if i >= len(r.meta.BlockDigests) || r.stripe >= len(r.meta.BlockDigests[i]) {
+		return false
+	}
+	digest := sha256.Sum256(block)
+	return hex.EncodeToString(digest[:]) == r.meta.BlockDigests[i][r.stripe]
+}
+
+func (r *reader) fail(i int) {
+	r.streams[i].Close()
+	r.streams[i] = nil
+	r.failed[i] = true
+}
+
+// decodeStripe read the current stripe from shard streams and decode its data
+func (r *reader) decodeStripe() ([]byte, error) {
+	blockSize := r.stripes[r.stripe]
+
+	for {
+		if err := r.ensureStreams(); err != nil {
+			return nil, err
+		}
+
+		shards := make([][]byte, len(r.streams))
+		var available int
+		for i, stream := range r.streams {
+			if stream == nil || available >= r.meta.DataShards {
+				continue
+			}
+			block := make([]byte, blockSize)
+			if _, err := io.ReadFull(stream, block); err != nil || !r.verify(i, block) {
+				r.fail(i)
+				continue
+			}
+			shards[i] = block
+			available++
+		}
+
+		if available < r.meta.DataShards {
+			// shards failed while reading, retry the stripe with other shards
+			for i, shard := range shards {
+				if shard != nil {
+					r.streams[i].Close()
+					r.streams[i] = nil
+				}
+			}
+			continue
+		}
+
+		if err := r.client.encoder.ReconstructData(shards); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, 0, blockSize*r.meta.DataShards)
+		for _, shard := range shards[:r.meta.DataShards] {
+			data = append(data, shard...)
+		}
+
+		stripeStart := int64(r.stripe) * int64(r.meta.BlockSize*r.meta.DataShards)
+		if remaining := r.meta.Size - stripeStart; int64(len(data)) > remaining {
+			data = data[:remaining]
+		}
+
+		r.stripe++
+		r.offset += int64(blockSize)
+		return data, nil
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if r.stripe >= len(r.stripes) {
+			return 0, io.EOF
+		}
+
+		data, err := r.decodeStripe()
+		if err != nil {
+			return 0, err
+		}
+		r.buffer = data
+	}
+
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	for i, stream := range r.streams {
+		if stream != nil {
+			stream.Close()
+			r.streams[i] = nil
+		}
+	}
+	return nil
+}
+
+// Delete delete shards and metadata of the object from all backends
+func (client Client) Delete(urlPath string) error {
+	meta, err := client.GetMeta(urlPath)
+	if err != nil {
+		return err
+	}
+
+	lastErr := client.deleteShards(urlPath, meta)
+
+	var deleted int
+	for _, backend := range client.Backends {
+		if err := backend.Delete(client.metaPath(urlPath)); err == nil {
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return fmt.Errorf("failed to delete metadata of %v", urlPath)
+	}
+	return lastErr
+}
+
+// List list all objects under current path, metadata of all backends are merged so it works with unavailable backends
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	var (
+		metaPrefix = client.join("meta")
+		found      = map[string]bool{}
+		objects    []*model.Object
+		lastErr    error
+		succeeded  int
+	)
+
+	for _, backend := range client.Backends {
+		results, err := backend.List(client.metaPath(urlPath))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		succeeded++
+
+		for _, result := range results {
+			logicalPath := strings.TrimPrefix("/"+strings.TrimPrefix(result.Path, "/"), metaPrefix)
+			if found[logicalPath] {
+				continue
+			}
+			found[logicalPath] = true
+
+			if meta, err := client.GetMeta(logicalPath); err == nil {
+				objects = append(objects, client.toObject(logicalPath, meta))
+			}
+		}
+	}
+
+	if succeeded == 0 {
+		return nil, lastErr
+	}
+	return objects, nil
+}
+
+// HealResult result of healing an object
+type HealResult struct {
+	Path string
+	// Rebuilt index of shards which were missing or corrupted, and have been rebuilt
+	Rebuilt []int
+	// MetaRepaired number of backends missing or stale metadata has been replaced on
+	MetaRepaired int
+}
+
+// verifyShard check the shard exists and matches its digest
+func (client Client) verifyShard(urlPath string, meta *Meta, index int) bool {
+	stream, err := client.Backends[meta.Placement[index]].GetStream(client.shardPath(urlPath, meta, index))
+	if err != nil {
+		return false
+	}
+	defer stream.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, stream); err != nil {
+		return false
+	}
+	return index < len(meta.Digests) && hex.EncodeToString(hasher.Sum(nil)) == meta.Digests[index]
+}
+
+// Heal verify shards of the object against their digests, rebuild missing or corrupted shards from the healthy ones,
+// and copy the latest metadata to backends missing it or keeping a stale version
+func (client Client) Heal(urlPath string) (*HealResult, error) {
+	metas, errs := client.readMetas(urlPath)
+	meta := latest(metas)
+	if meta == nil {
+		return nil, lastError(errs)
+	}
+
+	result := &HealResult{Path: urlPath}
+	for i := range meta.Placement {
+		if !client.verifyShard(urlPath, meta, i) {
+			result.Rebuilt = append(result.Rebuilt, i)
+		}
+	}
+
+	if len(result.Rebuilt) > meta.ParityShards {
+		return result, ErrNotEnoughShards
+	}
+
+	if len(result.Rebuilt) > 0 {
+		if err := client.rebuild(urlPath, meta, result.Rebuilt); err != nil {
+			return result, err
+		}
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return result, err
+	}
+	for i, backend := range client.Backends {
+		if metas[i] != nil && metas[i].Version == meta.Version {
+			continue
+		}
+		if _, err := backend.Put(client.metaPath(urlPath), bytes.NewReader(data)); err != nil {
+			return result, err
+		}
+		result.MetaRepaired++
+	}
+	return result, nil
+}
+
+// rebuild reconstruct broken shards stripe by stripe from healthy shards, and save them into their backends
+func (client Client) rebuild(urlPath string, meta *Meta, broken []int) error {
+	encoder, err := reedsolomon.New(meta.DataShards, meta.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	var (
+		isBroken = map[int]bool{}
+		streams  = map[int]io.ReadCloser{}
+		spools   = map[int]*os.File{}
+	)
+	for _, i := range broken {
+		isBroken[i] = true
+	}
+
+	defer func() {
+		for _, stream := range streams {
+			stream.Close()
+		}
+	}()
+
+	for i := range meta.Placement {
+		if isBroken[i] {
+			spool, err := ioutil.TempFile("", "erasure")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+			spools[i] = spool
+		} else if len(streams) < meta.DataShards {
+			stream, err := client.Backends[meta.Placement[i]].GetStream(client.shardPath(urlPath, meta, i))
+			if err != nil {
+				return err
+			}
+			streams[i] = stream
+		}
+	}
+
+	for _, blockSize := range meta.stripes() {
+		shards := make([][]byte, len(meta.Placement))
+		for i, stream := range streams {
+			shards[i] = make([]byte, blockSize)
+			if _, err := io.ReadFull(stream, shards[i]); err != nil {
+				return err
+			}
+		}
+
+		if err := encoder.Reconstruct(shards); err != nil {
+			return err
+		}
+
+		for i, spool := range spools {
+			if _, err := spool.Write(shards[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, spool := range spools {
+		if _, err := spool.Seek(0, 0); err != nil {
+			return err
+		}
+		if _, err := client.Backends[meta.Placement[i]].Put(client.shardPath(urlPath, meta, i), spool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HealAll heal all objects under the path, objects failed to be healed are reported with their errors
+func (client Client) HealAll(urlPath string) ([]*HealResult, map[string]error, error) {
+	objects, err := client.List(urlPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		results []*HealResult
+		errs    = map[string]error{}
+	)
+	for _, object := range objects {
+		result, err := client.Heal(object.Path)
+		if err != nil {
+			errs[object.Path] = err
+		} else {
+			results = append(results, result)
+		}
+	}
+	return results, errs, nil
+}
+
+// GetEndpoint get endpoint of the first backend
+func (client Client) GetEndpoint() string {
+	return client.Backends[0].GetEndpoint()
+}
+
+// GetURL erasure-coded objects have no single URL in backends, return the path like FileSystem does
+func (client Client) GetURL(urlPath string) (string, error) {
+	return urlPath, nil
+}
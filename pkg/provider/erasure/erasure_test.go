@@ -0,0 +1,272 @@
+package erasure_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/erasure"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/tests"
+)
+
+func newClient(t *testing.T, count int) (*erasure.Client, []string) {
+	var (
+		dirs     []string
+		backends []model.StorageInterface
+	)
+	for i := 0; i < count; i++ {
+		dir := t.TempDir()
+		dirs = append(dirs, dir)
+		backends = append(backends, filesystem.New(dir))
+	}
+	return erasure.New(backends, &erasure.Config{DataShards: 3, ParityShards: 2, BlockSize: 4 << 10}), dirs
+}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func readAll(t *testing.T, client *erasure.Client, path string) []byte {
+	stream, err := client.GetStream(path)
+	if err != nil {
+		t.Fatalf("No error should happen when get stream, but got %v", err)
+	}
+	defer stream.Close()
+
+	data, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("No error should happen when read stream, but got %v", err)
+	}
+	return data
+}
+
+func TestAll(t *testing.T) {
+	client, _ := newClient(t, 5)
+	tests.TestAll(client, t)
+}
+
+func TestSizes(t *testing.T) {
+	client, _ := newClient(t, 5)
+	for _, size := range []int{0, 1, 3, 4 << 10, 12 << 10, 12<<10 + 1, 100000} {
+		data := randomData(size, int64(size))
+		if _, err := client.Put("/data.bin", bytes.NewReader(data)); err != nil {
+			t.Fatalf("No error should happen when put %v bytes, but got %v", size, err)
+		}
+		if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+			t.Errorf("Object of %v bytes should be read back, but got %v bytes", size, len(result))
+		}
+	}
+}
+
+func TestDegradedRead(t *testing.T) {
+	client, dirs := newClient(t, 5)
+	data := randomData(100000, 1)
+	client.Put("/data.bin", bytes.NewReader(data))
+
+	// lose two backends, any three shards still reconstruct the object
+	os.RemoveAll(dirs[0])
+	os.RemoveAll(dirs[3])
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+		t.Errorf("Object should be reconstructed from remaining shards")
+	}
+
+	if object, err := client.Stat("/data.bin"); err != nil || object.Size != int64(len(data)) {
+		t.Errorf("Stat should report size %v, but got %v, %v", len(data), object, err)
+	}
+
+	os.RemoveAll(dirs[4])
+	if _, err := client.GetStream("/data.bin"); err == nil {
+		t.Errorf("Reading with less than three shards should fail")
+	}
+}
+
+func TestHeal(t *testing.T) {
+	client, _ := newClient(t, 5)
+	data := randomData(50000, 2)
+	client.Put("/data.bin", bytes.NewReader(data))
+
+	meta, _ := client.GetMeta("/data.bin")
+	client.Backends[meta.Placement[1]].Delete("/shards/data.bin/" + meta.Version + "/1")
+	client.Backends[meta.Placement[4]].Put("/shards/data.bin/"+meta.Version+"/4", strings.NewReader("corrupted"))
+	client.Backends[2].Delete("/meta/data.bin")
+
+	result, err := client.Heal("/data.bin")
+	if err != nil {
+		t.Fatalf("No error should happen when heal, but got %v", err)
+	}
+	if len(result.Rebuilt) != 2 || result.Rebuilt[0] != 1 || result.Rebuilt[1] != 4 {
+		t.Errorf("Shard 1 and 4 should be rebuilt, but got %v", result.Rebuilt)
+	}
+	if result.MetaRepaired != 1 {
+		t.Errorf("Metadata should be copied to one backend, but got %v", result.MetaRepaired)
+	}
+
+	if result, err := client.Heal("/data.bin"); err != nil || len(result.Rebuilt) != 0 {
+		t.Errorf("Healed object should be healthy, but got %+v, %v", result, err)
+	}
+
+	// the object can be read from the rebuilt shards only
+	client.Backends[meta.Placement[0]].Delete("/shards/data.bin/" + meta.Version + "/0")
+	client.Backends[meta.Placement[2]].Delete("/shards/data.bin/" + meta.Version + "/2")
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+		t.Errorf("Object should be reconstructed from rebuilt shards")
+	}
+}
+
+func TestCorruptedRead(t *testing.T) {
+	client, _ := newClient(t, 5)
+	data := randomData(50000, 3)
+	client.Put("/data.bin", bytes.NewReader(data))
+
+	// bit-rot in a data shard keeps its size, so it is only detected by its digest
+	meta, _ := client.GetMeta("/data.bin")
+	shard := "/shards/data.bin/" + meta.Version + "/1"
+	stream, _ := client.Backends[meta.Placement[1]].GetStream(shard)
+	content, _ := ioutil.ReadAll(stream)
+	stream.Close()
+	content[len(content)/2] ^= 0xff
+	client.Backends[meta.Placement[1]].Put(shard, bytes.NewReader(content))
+
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+		t.Errorf("Corrupted shard should be replaced by parity when read")
+	}
+}
+
+func newMemoryClient(count int) (*erasure.Client, []*memory.Client) {
+	var (
+		memories []*memory.Client
+		backends []model.StorageInterface
+	)
+	for i := 0; i < count; i++ {
+		backend := memory.New(&memory.Config{})
+		memories = append(memories, backend)
+		backends = append(backends, backend)
+	}
+	return erasure.New(backends, &erasure.Config{DataShards: 3, ParityShards: 2, BlockSize: 4 << 10}), memories
+}
+
+func TestPutFailures(t *testing.T) {
+	client, memories := newMemoryClient(5)
+	previous := randomData(30000, 4)
+	if _, err := client.Put("/data.bin", bytes.NewReader(previous)); err != nil {
+		t.Fatalf("No error should happen when put, but got %v", err)
+	}
+
+	// more failed shards than parity shards fail the put, and keep the previous version
+	for _, backend := range memories[:3] {
+		backend.SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationPut: 1}})
+	}
+	if _, err := client.Put("/data.bin", bytes.NewReader(randomData(30000, 5))); err == nil {
+		t.Errorf("Put should fail when more shards than parity shards failed to be saved")
+	}
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, previous) {
+		t.Errorf("Previous version should be kept when put failed")
+	}
+
+	// a failed shard of an overwrite is never matched to the stale shard of the previous version
+	memories[0].SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationPut: 1}})
+	for _, backend := range memories[1:3] {
+		backend.SetFaults(nil)
+	}
+	data := randomData(30000, 6)
+	if _, err := client.Put("/data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("No error should happen when put with one failed shard, but got %v", err)
+	}
+	memories[0].SetFaults(nil)
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+		t.Errorf("Object should be read back without mixing versions")
+	}
+
+	meta, _ := client.GetMeta("/data.bin")
+	if result, err := client.Heal("/data.bin"); err != nil || len(result.Rebuilt) != 1 || meta.Placement[result.Rebuilt[0]] != 0 {
+		t.Errorf("Failed shard should be rebuilt by heal, but got %+v, %v", result, err)
+	}
+
+	// a backend which missed the metadata of an overwrite keeps the stale version, which must not win
+	memories[4].SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationPut: 2}})
+	data = randomData(30000, 7)
+	if _, err := client.Put("/data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("No error should happen when put with metadata saved into a quorum, but got %v", err)
+	}
+	memories[4].SetFaults(nil)
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+		t.Errorf("Latest version of metadata should be read")
+	}
+	if result, err := client.Heal("/data.bin"); err != nil || result.MetaRepaired != 1 {
+		t.Errorf("Stale metadata should be repaired by heal, but got %+v, %v", result, err)
+	}
+}
+
+func TestMetaFailures(t *testing.T) {
+	var (
+		memories = []*memory.Client{memory.New(nil), memory.New(nil), memory.New(nil)}
+		client   = erasure.New([]model.StorageInterface{memories[0], memories[1], memories[2]}, &erasure.Config{DataShards: 2, ParityShards: 1, BlockSize: 4 << 10})
+		previous = randomData(20000, 8)
+	)
+	if _, err := client.Put("/data.bin", bytes.NewReader(previous)); err != nil {
+		t.Fatalf("No error should happen when put, but got %v", err)
+	}
+
+	// the first put saves the shard, the second one the metadata
+	memories[0].SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationPut: 2}})
+	if _, err := client.Put("/data.bin", bytes.NewReader(randomData(20000, 9))); err == nil {
+		t.Errorf("Put should fail when metadata is saved into less than DataShards+1 backends")
+	}
+	memories[0].SetFaults(nil)
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, previous) {
+		t.Errorf("Previous version should be kept when metadata failed to be saved")
+	}
+	if result, err := client.Heal("/data.bin"); err != nil || len(result.Rebuilt) != 0 || result.MetaRepaired != 0 {
+		t.Errorf("Previous version should be intact after a failed put, but got %+v, %v", result, err)
+	}
+}
+
+func TestBackendCount(t *testing.T) {
+	backends := []model.StorageInterface{memory.New(nil), memory.New(nil), memory.New(nil)}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("New should panic with fewer backends than data and parity shards")
+			}
+		}()
+		erasure.New(backends, &erasure.Config{DataShards: 3, ParityShards: 2})
+	}()
+
+	client := erasure.New(backends, &erasure.Config{DataShards: 3, ParityShards: 2, BlockSize: 4 << 10, AllowSharedBackends: true})
+	data := randomData(20000, 7)
+	if _, err := client.Put("/data.bin", bytes.NewReader(data)); err != nil {
+		t.Fatalf("No error should happen when put with shared backends, but got %v", err)
+	}
+	if result := readAll(t, client, "/data.bin"); !bytes.Equal(result, data) {
+		t.Errorf("Object should be read back from shared backends")
+	}
+}
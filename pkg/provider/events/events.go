@@ -0,0 +1,464 @@
+package events
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var _ model.StorageInterface = (*Client)(nil)
+
+// EventType type of object change events
+type EventType string
+
+// Event types published by the storage
+const (
+	ObjectCreated EventType = "ObjectCreated"
+	ObjectDeleted EventType = "ObjectDeleted"
+	ObjectCopied  EventType = "ObjectCopied"
+)
+
+// Event object change event
+type Event struct {
+	ID     string    `json:"id"`
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`
+	Source string    `json:"source,omitempty"` // source key of copied objects
+	Size   int64     `json:"size"`
+	ETag   string    `json:"etag,omitempty"`
+	Actor  string    `json:"actor,omitempty"`
+}
+
+// Filter select events by type and key, like S3 notification configurations. Empty fields match everything
+type Filter struct {
+	Types  []EventType `json:"types,omitempty"`
+	Prefix string      `json:"prefix,omitempty"`
+	Suffix string      `json:"suffix,omitempty"`
+}
+
+// Match whether the event is selected by the filter
+func (filter Filter) Match(event *Event) bool {
+	key := strings.TrimPrefix(event.Key, "/")
+	if !strings.HasPrefix(key, strings.TrimPrefix(filter.Prefix, "/")) || !strings.HasSuffix(key, filter.Suffix) {
+		return false
+	}
+
+	if len(filter.Types) == 0 {
+		return true
+	}
+	for _, t := range filter.Types {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func newID(now time.Time) string {
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	return fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(nonce))
+}
+
+type subscription struct {
+	filter  Filter
+	handler func(*Event)
+}
+
+// Publisher dispatch events to in-process subscribers, and queue them in the outbox for webhooks. It is safe for concurrent use
+type Publisher struct {
+	Config        *Config
+	mutex         sync.RWMutex
+	subscriptions map[int]*subscription
+	nextID        int
+	wakeup        chan struct{}
+	flushing      sync.Mutex
+	// updating serializes updates of queued deliveries by writers and Flush
+	updating sync.Mutex
+}
+
+// NewPublisher initialize publisher, panics if a webhook has no valid name
+func NewPublisher(config *Config) *Publisher {
+	if config == nil {
+		config = &Config{}
+	}
+
+	for _, webhook := range config.Webhooks {
+		if !validName(webhook.Name) {
+			panic(fmt.Sprintf("invalid webhook name %q", webhook.Name))
+		}
+	}
+
+	if config.Outbox == nil {
+		config.Outbox = NewMemoryOutbox()
+	}
+
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 5
+	}
+
+	if config.Backoff == 0 {
+		config.Backoff = time.Second
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	if config.CommitTimeout == 0 {
+		config.CommitTimeout = time.Minute
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Publisher{Config: config, subscriptions: map[int]*subscription{}, wakeup: make(chan struct{}, 1)}
+}
+
+// Subscribe call handler synchronously for each published event matching the filter, call the returned function to unsubscribe
+func (publisher *Publisher) Subscribe(filter Filter, handler func(*Event)) (unsubscribe func()) {
+	publisher.mutex.Lock()
+	defer publisher.mutex.Unlock()
+
+	id := publisher.nextID
+	publisher.nextID++
+	publisher.subscriptions[id] = &subscription{filter: filter, handler: handler}
+
+	return func() {
+		publisher.mutex.Lock()
+		defer publisher.mutex.Unlock()
+		delete(publisher.subscriptions, id)
+	}
+}
+
+// Publish assign an ID to the event, notify subscribers, and queue a delivery in the outbox for each matching webhook
+func (publisher *Publisher) Publish(event *Event) error {
+	publisher.prepareEvent(event)
+	queued, err := publisher.queue(event, false, event.Time)
+	if err != nil {
+		return err
+	}
+	publisher.notify(event, queued)
+	return nil
+}
+
+// Prepare queue deliveries of an event before the change is written, so the event is not lost if the process crashes
+// during the write. Prepared deliveries wait for Commit as long as the writer renews them within Config.CommitTimeout,
+// and are delivered once they are not renewed in time. Later events of the webhooks don't wait for them
+func (publisher *Publisher) Prepare(event *Event) error {
+	publisher.prepareEvent(event)
+	_, err := publisher.queue(event, true, event.Time.Add(publisher.Config.CommitTimeout))
+	return err
+}
+
+// Renew extend prepared deliveries of an event by Config.CommitTimeout, call it periodically while the change is written
+func (publisher *Publisher) Renew(event *Event) error {
+	_, err := publisher.update(event, func(delivery *Delivery) bool {
+		delivery.NextAttempt = publisher.Config.Now().Add(publisher.Config.CommitTimeout)
+		return true
+	})
+	return err
+}
+
+// Commit make prepared deliveries due with the final event after the change is written, and notify subscribers.
+// Deliveries already sent because they were not renewed in time are not sent again
+func (publisher *Publisher) Commit(event *Event) error {
+	queued, err := publisher.update(event, func(delivery *Delivery) bool {
+		delivery.Event, delivery.NextAttempt, delivery.Prepared = event, event.Time, false
+		return true
+	})
+	publisher.notify(event, queued)
+	return err
+}
+
+// Abort remove prepared deliveries of an event whose change failed to be written
+func (publisher *Publisher) Abort(event *Event) error {
+	publisher.updating.Lock()
+	defer publisher.updating.Unlock()
+
+	var lastErr error
+	for _, webhook := range publisher.Config.Webhooks {
+		if webhook.Filter.Match(event) {
+			if err := publisher.Config.Outbox.Remove(deliveryID(event, webhook)); err != nil {
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+func (publisher *Publisher) prepareEvent(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = publisher.Config.Now()
+	}
+	event.Time = event.Time.UTC()
+	if event.ID == "" {
+		event.ID = newID(event.Time)
+	}
+}
+
+func deliveryID(event *Event, webhook *Webhook) string {
+	return event.ID + "-" + webhook.Name
+}
+
+// queue save a delivery of the event due at nextAttempt for each matching webhook
+func (publisher *Publisher) queue(event *Event, prepared bool, nextAttempt time.Time) (queued bool, err error) {
+	for _, webhook := range publisher.Config.Webhooks {
+		if !webhook.Filter.Match(event) {
+			continue
+		}
+
+		delivery := &Delivery{ID: deliveryID(event, webhook), Webhook: webhook.Name, Event: event, NextAttempt: nextAttempt, Prepared: prepared}
+		if err := publisher.Config.Outbox.Save(delivery); err != nil {
+			return queued, err
+		}
+		queued = true
+	}
+	return queued, nil
+}
+
+// update modify prepared deliveries of the event for each matching webhook with fn, deliveries which have been sent,
+// removed or committed already are skipped
+func (publisher *Publisher) update(event *Event, fn func(delivery *Delivery) bool) (updated bool, err error) {
+	publisher.updating.Lock()
+	defer publisher.updating.Unlock()
+
+	for _, webhook := range publisher.Config.Webhooks {
+		if !webhook.Filter.Match(event) {
+			continue
+		}
+
+		delivery, err := publisher.Config.Outbox.Get(deliveryID(event, webhook))
+		if err != nil {
+			return updated, err
+		}
+		if delivery == nil || !delivery.Prepared || !fn(delivery) {
+			continue
+		}
+		if err := publisher.Config.Outbox.Save(delivery); err != nil {
+			return updated, err
+		}
+		updated = true
+	}
+	return updated, nil
+}
+
+// notify call subscribers of the event, and wake up the background delivery if deliveries are queued
+func (publisher *Publisher) notify(event *Event, queued bool) {
+	publisher.mutex.RLock()
+	var handlers []func(*Event)
+	for _, sub := range publisher.subscriptions {
+		if sub.filter.Match(event) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	publisher.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+
+	if queued {
+		select {
+		case publisher.wakeup <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Client eventing storage, publish events for changes made through the underlying storage
+type Client struct {
+	Storage   model.StorageInterface
+	Publisher *Publisher
+	Actor     string
+}
+
+// New initialize eventing storage
+func New(storage model.StorageInterface, publisher *Publisher, actor string) *Client {
+	return &Client{Storage: storage, Publisher: publisher, Actor: actor}
+}
+
+// WithActor return a copy of the storage which publishes events for another actor
+func (client Client) WithActor(actor string) *Client {
+	client.Actor = actor
+	return &client
+}
+
+// stat get size and ETag from storages which could stat objects cheaply
+func (client Client) stat(path string, object *model.Object) *model.Object {
+	if stater, ok := client.Storage.(model.StatInterface); ok {
+		if stat, err := stater.Stat(path); err == nil {
+			return stat
+		}
+	}
+	if object == nil {
+		object = &model.Object{}
+	}
+	return object
+}
+
+// prepare record the event in the outbox before the change is written, and renew it until the returned function is called
+func (client Client) prepare(eventType EventType, path string, source string, object *model.Object) (*Event, func(), error) {
+	event := &Event{
+		Type:   eventType,
+		Key:    "/" + strings.TrimPrefix(path, "/"),
+		Source: source,
+		Actor:  client.Actor,
+	}
+	if object != nil {
+		event.Size, event.ETag = object.Size, object.ETag
+	}
+	if err := client.Publisher.Prepare(event); err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(client.Publisher.Config.CommitTimeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				client.report(client.Publisher.Renew(event))
+			}
+		}
+	}()
+	return event, func() { close(done) }, nil
+}
+
+// commit publish the event after the change is written. The change is not failed if the outbox can't be updated,
+// as the prepared deliveries are still delivered
+func (client Client) commit(event *Event, object *model.Object) {
+	if object != nil {
+		event.Size, event.ETag = object.Size, object.ETag
+	}
+	client.report(client.Publisher.Commit(event))
+}
+
+func (client Client) report(err error) {
+	if err != nil && client.Publisher.Config.OnError != nil {
+		client.Publisher.Config.OnError(err)
+	}
+}
+
+// Get receive file with given path
+func (client Client) Get(path string) (*os.File, error) {
+	return client.Storage.Get(path)
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	return client.Storage.GetStream(path)
+}
+
+// Put store a reader into given path, and publish ObjectCreated
+func (client Client) Put(path string, reader io.Reader) (*model.Object, error) {
+	event, release, err := client.prepare(ObjectCreated, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	object, err := client.Storage.Put(path, reader)
+	if err != nil {
+		client.Publisher.Abort(event)
+		return object, err
+	}
+
+	client.commit(event, client.stat(path, object))
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Copy copy the object to another path, and publish ObjectCopied
+func (client Client) Copy(from string, to string) (*model.Object, error) {
+	stream, err := client.Storage.GetStream(from)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	event, release, err := client.prepare(ObjectCopied, to, "/"+strings.TrimPrefix(from, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	object, err := client.Storage.Put(to, stream)
+	if err != nil {
+		client.Publisher.Abort(event)
+		return object, err
+	}
+
+	client.commit(event, client.stat(to, object))
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Delete delete file, and publish ObjectDeleted
+func (client Client) Delete(path string) error {
+	object := client.stat(path, nil)
+	event, release, err := client.prepare(ObjectDeleted, path, "", object)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := client.Storage.Delete(path); err != nil {
+		client.Publisher.Abort(event)
+		return err
+	}
+
+	client.commit(event, object)
+	return nil
+}
+
+// List list all objects under current path
+func (client Client) List(path string) ([]*model.Object, error) {
+	objects, err := client.Storage.List(path)
+	for _, object := range objects {
+		object.StorageInterface = client
+	}
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL get public accessible URL
+func (client Client) GetURL(path string) (string, error) {
+	return client.Storage.GetURL(path)
+}
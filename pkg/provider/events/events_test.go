@@ -0,0 +1,307 @@
+package events_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/events"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/tests"
+)
+
+func TestAll(t *testing.T) {
+	tests.TestAll(events.New(filesystem.New(t.TempDir()), events.NewPublisher(nil), "tester"), t)
+}
+
+func TestSubscribe(t *testing.T) {
+	publisher := events.NewPublisher(nil)
+	client := events.New(filesystem.New(t.TempDir()), publisher, "alice")
+
+	var received []*events.Event
+	unsubscribe := publisher.Subscribe(events.Filter{Prefix: "/uploads/", Suffix: ".jpg"}, func(event *events.Event) {
+		received = append(received, event)
+	})
+
+	client.Put("/uploads/a.jpg", strings.NewReader("image"))
+	client.Put("/uploads/a.txt", strings.NewReader("text"))
+	client.Put("/other/b.jpg", strings.NewReader("image"))
+	client.Copy("/uploads/a.jpg", "/uploads/b.jpg")
+	client.WithActor("bob").Delete("/uploads/a.jpg")
+
+	if len(received) != 3 {
+		t.Fatalf("Matching events should be received, but got %v", len(received))
+	}
+
+	created, copied, deleted := received[0], received[1], received[2]
+	if created.Type != events.ObjectCreated || created.Key != "/uploads/a.jpg" || created.Size != 5 || created.Actor != "alice" || created.ID == "" {
+		t.Errorf("ObjectCreated event is not correct, got %+v", created)
+	}
+	if copied.Type != events.ObjectCopied || copied.Key != "/uploads/b.jpg" || copied.Source != "/uploads/a.jpg" {
+		t.Errorf("ObjectCopied event is not correct, got %+v", copied)
+	}
+	if deleted.Type != events.ObjectDeleted || deleted.Actor != "bob" || deleted.Size != 5 {
+		t.Errorf("ObjectDeleted event is not correct, got %+v", deleted)
+	}
+
+	unsubscribe()
+	client.Put("/uploads/c.jpg", strings.NewReader("image"))
+	if len(received) != 3 {
+		t.Errorf("Unsubscribed handler should not be called")
+	}
+}
+
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	r.bodies = append(r.bodies, string(body))
+	r.headers = append(r.headers, req.Header)
+}
+
+func TestWebhook(t *testing.T) {
+	r := &receiver{failures: 1}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	now := time.Now()
+	outboxDir := t.TempDir()
+	outbox, _ := events.NewFileOutbox(outboxDir)
+	config := &events.Config{
+		Webhooks: []*events.Webhook{{Name: "images", URL: server.URL, Secret: "secret", Filter: events.Filter{Types: []events.EventType{events.ObjectCreated}}}},
+		Outbox:   outbox,
+		Now:      func() time.Time { return now },
+	}
+	client := events.New(filesystem.New(t.TempDir()), events.NewPublisher(config), "alice")
+
+	client.Put("/a.jpg", strings.NewReader("image"))
+	client.Delete("/a.jpg")
+
+	if delivered, err := client.Publisher.Flush(); delivered != 0 || err != nil {
+		t.Errorf("First attempt should fail, but got %v, %v", delivered, err)
+	}
+
+	// restart with the same outbox
+	outbox, _ = events.NewFileOutbox(outboxDir)
+	config = &events.Config{Webhooks: config.Webhooks, Outbox: outbox, Now: func() time.Time { return now }}
+	publisher := events.NewPublisher(config)
+
+	if delivered, _ := publisher.Flush(); delivered != 0 {
+		t.Errorf("Delivery should wait for backoff, but got %v", delivered)
+	}
+
+	now = now.Add(time.Minute)
+	if delivered, err := publisher.Flush(); delivered != 1 || err != nil {
+		t.Fatalf("Delivery should be retried, but got %v, %v", delivered, err)
+	}
+
+	if len(r.bodies) != 1 {
+		t.Fatalf("Webhook should receive one event, but got %v", len(r.bodies))
+	}
+	if !events.VerifySignature("secret", []byte(r.bodies[0]), r.headers[0].Get(events.HeaderSignature)) {
+		t.Errorf("Webhook request should be signed")
+	}
+	if r.headers[0].Get(events.HeaderEvent) != string(events.ObjectCreated) {
+		t.Errorf("Webhook request should have event type, but got %v", r.headers[0].Get(events.HeaderEvent))
+	}
+	if pending, _ := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("Delivered events should be removed from the outbox, but got %v", pending)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	server := httptest.NewServer(&receiver{failures: 10})
+	defer server.Close()
+
+	now := time.Now()
+	var dropped []*events.Delivery
+	publisher := events.NewPublisher(&events.Config{
+		Webhooks:    []*events.Webhook{{Name: "hook", URL: server.URL}},
+		MaxAttempts: 2,
+		OnDrop:      func(delivery *events.Delivery, err error) { dropped = append(dropped, delivery) },
+		Now:         func() time.Time { return now },
+	})
+
+	publisher.Publish(&events.Event{Type: events.ObjectCreated, Key: "/a.txt"})
+	for i := 0; i < 3; i++ {
+		publisher.Flush()
+		now = now.Add(time.Hour)
+	}
+
+	if len(dropped) != 1 || dropped[0].Attempts != 2 {
+		t.Errorf("Delivery should be dropped after max attempts, but got %v", dropped)
+	}
+}
+
+// failingOutbox fail saving deliveries after given saves
+type failingOutbox struct {
+	*events.MemoryOutbox
+	saves int
+}
+
+func (outbox *failingOutbox) Save(delivery *events.Delivery) error {
+	if outbox.saves == 0 {
+		return errors.New("outbox is unavailable")
+	}
+	outbox.saves--
+	return outbox.MemoryOutbox.Save(delivery)
+}
+
+func TestOutbox(t *testing.T) {
+	server := httptest.NewServer(&receiver{})
+	defer server.Close()
+
+	now := time.Now()
+	outbox := &failingOutbox{MemoryOutbox: events.NewMemoryOutbox(), saves: 1}
+	var errs []error
+	storage := memory.New(nil)
+	publisher := events.NewPublisher(&events.Config{
+		Webhooks: []*events.Webhook{{Name: "hook", URL: server.URL}},
+		Outbox:   outbox,
+		OnError:  func(err error) { errs = append(errs, err) },
+		Now:      func() time.Time { return now },
+	})
+	client := events.New(storage, publisher, "alice")
+
+	// the write is committed, failing to update the outbox doesn't fail it, the prepared delivery is sent after the commit timeout
+	if _, err := client.Put("/a.txt", strings.NewReader("a")); err != nil {
+		t.Errorf("Committed write should not fail, but got %v", err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("Outbox error should be reported, but got %v", errs)
+	}
+	if delivered, _ := publisher.Flush(); delivered != 0 {
+		t.Errorf("Prepared delivery should wait for the commit timeout, but got %v", delivered)
+	}
+	now = now.Add(2 * time.Minute)
+	if delivered, err := publisher.Flush(); delivered != 1 || err != nil {
+		t.Errorf("Prepared delivery should be sent after the commit timeout, but got %v, %v", delivered, err)
+	}
+
+	// the event can't be recorded, so the write is not made
+	if _, err := client.Put("/b.txt", strings.NewReader("b")); err == nil {
+		t.Errorf("Write should fail when the event can't be recorded")
+	}
+	if _, err := storage.Stat("/b.txt"); err == nil {
+		t.Errorf("Object should not be written when the event can't be recorded")
+	}
+
+	// failed writes remove their prepared deliveries
+	outbox.saves = 10
+	storage.SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationPut: 1}})
+	if _, err := client.Put("/c.txt", strings.NewReader("c")); err == nil {
+		t.Errorf("Injected fault should fail the write")
+	}
+	if pending, _ := outbox.Pending(); len(pending) != 0 {
+		t.Errorf("Prepared deliveries of failed writes should be removed, but got %v", pending)
+	}
+}
+
+// slowReader block reads until released, like a long upload
+type slowReader struct {
+	release chan struct{}
+	reader  io.Reader
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	<-r.release
+	return r.reader.Read(p)
+}
+
+func TestPrepare(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	publisher := events.NewPublisher(&events.Config{
+		Webhooks:      []*events.Webhook{{Name: "hook", URL: server.URL}},
+		CommitTimeout: 200 * time.Millisecond,
+	})
+	client := events.New(memory.New(nil), publisher, "alice")
+
+	// a write running longer than the commit timeout renews its deliveries, which don't delay later events
+	reader := &slowReader{release: make(chan struct{}), reader: strings.NewReader("slow")}
+	done := make(chan error)
+	go func() {
+		_, err := client.Put("/slow.txt", reader)
+		done <- err
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	publisher.Publish(&events.Event{Type: events.ObjectDeleted, Key: "/other.txt"})
+	if delivered, err := publisher.Flush(); delivered != 1 || err != nil {
+		t.Errorf("Only the later event should be sent while the write runs, but got %v, %v", delivered, err)
+	}
+
+	close(reader.release)
+	if err := <-done; err != nil {
+		t.Fatalf("No error should happen when put, but got %v", err)
+	}
+	if delivered, err := publisher.Flush(); delivered != 1 || err != nil {
+		t.Errorf("Committed event should be sent, but got %v, %v", delivered, err)
+	}
+	if len(r.bodies) != 2 || !strings.Contains(r.bodies[1], `"size":4`) {
+		t.Errorf("Committed event should be sent once with its size, but got %v", r.bodies)
+	}
+
+	// a delivery sent after the commit timeout, e.g. of a stalled writer, isn't sent again by the commit
+	now := time.Now()
+	publisher = events.NewPublisher(&events.Config{
+		Webhooks: []*events.Webhook{{Name: "hook", URL: server.URL}},
+		Now:      func() time.Time { return now },
+	})
+	event := &events.Event{Type: events.ObjectCreated, Key: "/stalled.txt"}
+	if err := publisher.Prepare(event); err != nil {
+		t.Fatalf("No error should happen when prepare, but got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if delivered, _ := publisher.Flush(); delivered != 1 {
+		t.Errorf("Prepared delivery should be sent after the commit timeout, but got %v", delivered)
+	}
+	if err := publisher.Commit(event); err != nil {
+		t.Errorf("No error should happen when commit, but got %v", err)
+	}
+	if delivered, _ := publisher.Flush(); delivered != 0 || len(r.bodies) != 3 {
+		t.Errorf("Sent delivery should not be sent again, but got %v, %v", delivered, len(r.bodies))
+	}
+}
@@ -0,0 +1,223 @@
+package events
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery an event queued for a webhook
+type Delivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Event       *Event    `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Prepared the change of the event is being written, NextAttempt is the end of the writer's lease, which is renewed
+	// while the write runs. Prepared deliveries are sent once the lease expires, e.g. when the writer crashed
+	Prepared bool `json:"prepared,omitempty"`
+}
+
+// Outbox queue of deliveries
+type Outbox interface {
+	// Save create or update a delivery
+	Save(delivery *Delivery) error
+	// Get return the delivery, or nil if it is not queued
+	Get(id string) (*Delivery, error)
+	// Remove remove a delivery which is delivered or dropped
+	Remove(id string) error
+	// Pending return queued deliveries in publishing order
+	Pending() ([]*Delivery, error)
+}
+
+// MemoryOutbox in-memory outbox, deliveries are lost on restart
+type MemoryOutbox struct {
+	mutex      sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// NewMemoryOutbox initialize in-memory outbox
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{deliveries: map[string]*Delivery{}}
+}
+
+// Save create or update a delivery
+func (outbox *MemoryOutbox) Save(delivery *Delivery) error {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	copied := *delivery
+	outbox.deliveries[delivery.ID] = &copied
+	return nil
+}
+
+// Get return the delivery, or nil if it is not queued
+func (outbox *MemoryOutbox) Get(id string) (*Delivery, error) {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	delivery, ok := outbox.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *delivery
+	return &copied, nil
+}
+
+// Remove remove a delivery
+func (outbox *MemoryOutbox) Remove(id string) error {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+	delete(outbox.deliveries, id)
+	return nil
+}
+
+// Pending return queued deliveries in publishing order
+func (outbox *MemoryOutbox) Pending() ([]*Delivery, error) {
+	outbox.mutex.Lock()
+	defer outbox.mutex.Unlock()
+
+	var deliveries []*Delivery
+	for _, delivery := range outbox.deliveries {
+		copied := *delivery
+		deliveries = append(deliveries, &copied)
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+// FileOutbox durable outbox, each delivery is saved as a JSON file in the directory, so deliveries survive restarts
+type FileOutbox struct {
+	Dir string
+}
+
+// NewFileOutbox initialize file outbox, the directory is created if it doesn't exist
+func NewFileOutbox(dir string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileOutbox{Dir: dir}, nil
+}
+
+func (outbox *FileOutbox) path(id string) string {
+	return filepath.Join(outbox.Dir, id+".json")
+}
+
+// Save write the delivery into a temporary file, then rename it so a crash never leaves a partial delivery
+func (outbox *FileOutbox) Save(delivery *Delivery) error {
+	if !validName(delivery.ID) {
+		return os.ErrInvalid
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(outbox.Dir, ".delivery")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), outbox.path(delivery.ID))
+}
+
+// Get read the delivery file, return nil if it is not queued
+func (outbox *FileOutbox) Get(id string) (*Delivery, error) {
+	if !validName(id) {
+		return nil, os.ErrInvalid
+	}
+
+	data, err := ioutil.ReadFile(outbox.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	delivery := &Delivery{}
+	if err := json.Unmarshal(data, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Remove remove the delivery file
+func (outbox *FileOutbox) Remove(id string) error {
+	if !validName(id) {
+		return os.ErrInvalid
+	}
+
+	err := os.Remove(outbox.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Pending return queued deliveries in publishing order
+func (outbox *FileOutbox) Pending() ([]*Delivery, error) {
+	files, err := ioutil.ReadDir(outbox.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*Delivery
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(outbox.Dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		delivery := &Delivery{}
+		if err := json.Unmarshal(data, delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+func sortDeliveries(deliveries []*Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+}
@@ -0,0 +1,265 @@
+package events
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of webhook requests
+const (
+	HeaderSignature = "X-Drive-Signature"
+	HeaderEvent     = "X-Drive-Event"
+	HeaderDelivery  = "X-Drive-Delivery"
+)
+
+// Webhook HTTP endpoint events are posted to as JSON, signed with HMAC-SHA256 of the secret
+type Webhook struct {
+	Name   string
+	URL    string
+	Secret string
+	Filter Filter
+}
+
+// Config publisher config
+type Config struct {
+	Webhooks []*Webhook
+
+	// Outbox where deliveries are queued until webhooks accept them, default to an in-memory outbox which doesn't survive restarts
+	Outbox Outbox
+
+	// MaxAttempts of a delivery before it is dropped, default to 5
+	MaxAttempts int
+
+	// Backoff delay before the first retry, doubled for each following retry, default to 1s
+	Backoff time.Duration
+
+	// Timeout of webhook requests, default to 10s
+	Timeout time.Duration
+
+	// HTTPClient used to post webhooks, default to a client with Timeout
+	HTTPClient *http.Client
+
+	// OnDrop called when a delivery is dropped after MaxAttempts
+	OnDrop func(delivery *Delivery, err error)
+
+	// CommitTimeout lease of deliveries prepared before a write, default to 1m. Writers renew the lease every half of it
+	// until the write is committed. Deliveries of writes interrupted by a crash are delivered once their lease expires,
+	// so events are never lost, but might be sent for failed writes
+	CommitTimeout time.Duration
+
+	// OnError called when a committed event can't be updated in the outbox, it is still delivered from its prepared record
+	OnError func(err error)
+
+	// Now current time, default to time.Now
+	Now func() time.Time
+}
+
+// Sign compute the signature header value of the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature check the signature header value of a webhook request body, for receivers
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func (publisher *Publisher) webhook(name string) *Webhook {
+	for _, webhook := range publisher.Config.Webhooks {
+		if webhook.Name == name {
+			return webhook
+		}
+	}
+	return nil
+}
+
+func (publisher *Publisher) post(webhook *Webhook, delivery *Delivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(delivery.Event.Type))
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	if webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(webhook.Secret, body))
+	}
+
+	client := publisher.Config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: publisher.Config.Timeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v responded %v", webhook.Name, resp.Status)
+	}
+	return nil
+}
+
+// Flush attempt deliveries in the outbox which are due, failed deliveries are retried with exponential backoff.
+// It returns the number of delivered events
+func (publisher *Publisher) Flush() (int, error) {
+	publisher.flushing.Lock()
+	defer publisher.flushing.Unlock()
+
+	deliveries, err := publisher.Config.Outbox.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		now       = publisher.Config.Now()
+		delivered int
+		wg        sync.WaitGroup
+		lastErr   error
+	)
+
+	// deliver to webhooks concurrently, but keep the order of events for each webhook
+	byWebhook := map[string][]*Delivery{}
+	for _, delivery := range deliveries {
+		byWebhook[delivery.Webhook] = append(byWebhook[delivery.Webhook], delivery)
+	}
+
+	for name, queue := range byWebhook {
+		wg.Add(1)
+		go func(webhook *Webhook, name string, queue []*Delivery) {
+			defer wg.Done()
+
+			for _, delivery := range queue {
+				if delivery.NextAttempt.After(now) {
+					if delivery.Prepared {
+						// the write is still running, following events don't wait for it
+						continue
+					}
+					// retry later, following events wait to keep the order
+					return
+				}
+
+				var err error
+				if webhook == nil {
+					err = fmt.Errorf("unknown webhook %v", name)
+				} else {
+					err = publisher.post(webhook, delivery)
+				}
+
+				publisher.updating.Lock()
+				if err == nil {
+					delivered++
+					if removeErr := publisher.Config.Outbox.Remove(delivery.ID); removeErr != nil {
+						lastErr = removeErr
+					}
+					publisher.updating.Unlock()
+					continue
+				}
+
+				if delivery.Prepared {
+					// the write might have been committed or aborted while posting, keep its change
+					if current, getErr := publisher.Config.Outbox.Get(delivery.ID); getErr == nil && current == nil {
+						publisher.updating.Unlock()
+						continue
+					} else if getErr == nil && !current.Prepared {
+						delivery.Event, delivery.Prepared = current.Event, false
+					}
+				}
+
+				delivery.Attempts++
+				delivery.LastError = err.Error()
+				if delivery.Attempts >= publisher.Config.MaxAttempts {
+					if removeErr := publisher.Config.Outbox.Remove(delivery.ID); removeErr != nil {
+						lastErr = removeErr
+					}
+					if publisher.Config.OnDrop != nil {
+						publisher.Config.OnDrop(delivery, err)
+					}
+					publisher.updating.Unlock()
+					continue
+				}
+
+				delivery.NextAttempt = now.Add(publisher.Config.Backoff << uint(delivery.Attempts-1))
+				if saveErr := publisher.Config.Outbox.Save(delivery); saveErr != nil {
+					lastErr = saveErr
+				}
+				publisher.updating.Unlock()
+				return
+			}
+		}(publisher.webhook(name), name, queue)
+	}
+	wg.Wait()
+
+	return delivered, lastErr
+}
+
+// Start deliver events in the background, on publishing and every interval for retries. Deliveries left in a durable outbox
+// by the previous process are delivered as well. Call the returned function to stop it
+func (publisher *Publisher) Start(interval time.Duration, onError func(error)) (stop func()) {
+	var (
+		done = make(chan struct{})
+		once sync.Once
+	)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := publisher.Flush(); err != nil && onError != nil {
+				onError(err)
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			case <-publisher.wakeup:
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/\\")
+}
@@ -23,9 +23,11 @@ package filesystem
 import (
 	"fmt"
 	"io"
+	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	model "github.com/bhojpur/drive/pkg/model"
 )
@@ -63,6 +65,27 @@ func (fileSystem FileSystem) GetStream(path string) (io.ReadCloser, error) {
 	return os.Open(fileSystem.GetFullPath(path))
 }
 
+// Stat get object's information
+func (fileSystem FileSystem) Stat(path string) (*model.Object, error) {
+	info, err := os.Stat(fileSystem.GetFullPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.EISDIR}
+	}
+
+	modTime := info.ModTime()
+	return &model.Object{
+		Path:             path,
+		Name:             info.Name(),
+		LastModified:     &modTime,
+		Size:             info.Size(),
+		ContentType:      mime.TypeByExtension(filepath.Ext(path)),
+		StorageInterface: fileSystem,
+	}, nil
+}
+
 // Put store a reader into given path
 func (fileSystem FileSystem) Put(path string, reader io.Reader) (*model.Object, error) {
 	var (
@@ -109,6 +132,7 @@ func (fileSystem FileSystem) List(path string) ([]*model.Object, error) {
 				Path:             strings.TrimPrefix(path, fileSystem.Base),
 				Name:             info.Name(),
 				LastModified:     &modTime,
+				Size:             info.Size(),
 				StorageInterface: fileSystem,
 			})
 		}
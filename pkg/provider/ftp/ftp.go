@@ -0,0 +1,422 @@
+package ftp
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/jlaffaye/ftp"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// Config FTP client config
+type Config struct {
+	// Host address of the server, e.g. "ftp.example.com:21"
+	Host     string
+	User     string
+	Password string
+
+	// TLSConfig enable FTPS, the control connection is upgraded with AUTH TLS unless ImplicitTLS is set.
+	// ServerName default to the host of Host
+	TLSConfig   *tls.Config
+	ImplicitTLS bool
+	// DisableEPSV use PASV only, for servers or firewalls which don't handle EPSV
+	DisableEPSV bool
+
+	// Root directory on the server where objects are saved
+	Root string
+	// Endpoint public URL of Root, if files are also served over HTTP
+	Endpoint string
+
+	// PoolSize max number of connections opened to the server, default to 4
+	PoolSize int
+	// IdleTimeout connections idle longer than it are checked with NOOP before being reused, default to 1 minute
+	IdleTimeout time.Duration
+	// Timeout of establishing connections, default to 30s
+	Timeout time.Duration
+}
+
+// Client FTP storage, operations are sent over a pool of connections. Transfers always use passive mode
+type Client struct {
+	Config *Config
+	idle   chan *conn
+	slots  chan struct{}
+}
+
+type conn struct {
+	*ftp.ServerConn
+	used time.Time
+}
+
+// New initialize FTP storage, a first connection is opened to verify the config
+func New(config *Config) (*Client, error) {
+	if config.PoolSize <= 0 {
+		config.PoolSize = 4
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = time.Minute
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.TLSConfig != nil && config.TLSConfig.ServerName == "" && !config.TLSConfig.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(config.Host)
+		if err != nil {
+			return nil, err
+		}
+		config.TLSConfig = config.TLSConfig.Clone()
+		config.TLSConfig.ServerName = host
+	}
+
+	client := &Client{
+		Config: config,
+		idle:   make(chan *conn, config.PoolSize),
+		slots:  make(chan struct{}, config.PoolSize),
+	}
+
+	c, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+	client.idle <- c
+	return client, nil
+}
+
+func (client *Client) dial() (*conn, error) {
+	options := []ftp.DialOption{
+		ftp.DialWithTimeout(client.Config.Timeout),
+		ftp.DialWithDisabledEPSV(client.Config.DisableEPSV),
+	}
+	if client.Config.TLSConfig != nil {
+		if client.Config.ImplicitTLS {
+			options = append(options, ftp.DialWithTLS(client.Config.TLSConfig))
+		} else {
+			options = append(options, ftp.DialWithExplicitTLS(client.Config.TLSConfig))
+		}
+	}
+
+	serverConn, err := ftp.Dial(client.Config.Host, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := serverConn.Login(client.Config.User, client.Config.Password); err != nil {
+		serverConn.Quit()
+		return nil, err
+	}
+	return &conn{ServerConn: serverConn, used: time.Now()}, nil
+}
+
+// acquire take an idle connection from the pool or open a new one, it blocks when PoolSize connections are in use
+func (client *Client) acquire() (*conn, error) {
+	client.slots <- struct{}{}
+
+	for {
+		select {
+		case c := <-client.idle:
+			if time.Since(c.used) < client.Config.IdleTimeout || c.NoOp() == nil {
+				return c, nil
+			}
+			// the server has probably closed the idle connection
+			c.Quit()
+		default:
+			c, err := client.dial()
+			if err != nil {
+				<-client.slots
+			}
+			return c, err
+		}
+	}
+}
+
+// release put the connection back into the pool, unless err shows it is broken
+func (client *Client) release(c *conn, err error) {
+	var protocolErr *textproto.Error
+	if err == nil || errors.As(err, &protocolErr) {
+		c.used = time.Now()
+		select {
+		case client.idle <- c:
+		default:
+			c.Quit()
+		}
+	} else {
+		c.Quit()
+	}
+	<-client.slots
+}
+
+// Close close all idle connections
+func (client *Client) Close() error {
+	for {
+		select {
+		case c := <-client.idle:
+			c.Quit()
+		default:
+			return nil
+		}
+	}
+}
+
+// ToRemotePath get the path on the server
+func (client *Client) ToRemotePath(urlPath string) string {
+	return path.Join("/", client.Config.Root, urlPath)
+}
+
+func (client *Client) toPath(remotePath string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(remotePath, path.Join("/", client.Config.Root)), "/")
+}
+
+// Get receive file with given path
+func (client *Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "ftp"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream, the connection is returned to the pool when the stream is closed
+func (client *Client) GetStream(path string) (io.ReadCloser, error) {
+	c, err := client.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.Retr(client.ToRemotePath(path))
+	if err != nil {
+		client.release(c, err)
+		return nil, err
+	}
+	return &stream{Response: response, client: client, conn: c}, nil
+}
+
+type stream struct {
+	*ftp.Response
+	client *Client
+	conn   *conn
+	closed bool
+}
+
+func (stream *stream) Close() error {
+	if stream.closed {
+		return nil
+	}
+	stream.closed = true
+
+	err := stream.Response.Close()
+	stream.client.release(stream.conn, err)
+	return err
+}
+
+// Stat get object's information with SIZE and MDTM
+func (client *Client) Stat(urlPath string) (object *model.Object, err error) {
+	c, err := client.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { client.release(c, err) }()
+
+	remotePath := client.ToRemotePath(urlPath)
+	size, err := c.FileSize(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	object = &model.Object{
+		Path:             client.toPath(remotePath),
+		Name:             path.Base(remotePath),
+		Size:             size,
+		ContentType:      mime.TypeByExtension(path.Ext(remotePath)),
+		StorageInterface: client,
+	}
+	if c.IsGetTimeSupported() {
+		modTime, err := c.GetTime(remotePath)
+		if err != nil {
+			return nil, err
+		}
+		object.LastModified = &modTime
+	}
+	return object, nil
+}
+
+// Put store a reader into given path, parent directories are created if they don't exist
+func (client *Client) Put(urlPath string, reader io.Reader) (object *model.Object, err error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	c, err := client.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { client.release(c, err) }()
+
+	remotePath := client.ToRemotePath(urlPath)
+	if err = client.makeDirs(c, path.Dir(remotePath)); err != nil {
+		return nil, err
+	}
+
+	counter := &countingReader{Reader: reader}
+	if err = c.Stor(remotePath, counter); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &model.Object{
+		Path:             client.toPath(remotePath),
+		Name:             path.Base(remotePath),
+		LastModified:     &now,
+		Size:             counter.size,
+		ContentType:      mime.TypeByExtension(path.Ext(remotePath)),
+		StorageInterface: client,
+	}, nil
+}
+
+// makeDirs create the directory and its parents with MKD, servers reply an error for existing directories, so only
+// connection errors are returned, others surface when storing the file
+func (client *Client) makeDirs(c *conn, dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	var current string
+	for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current += "/" + name
+		if err := c.MakeDir(current); err != nil {
+			var protocolErr *textproto.Error
+			if !errors.As(err, &protocolErr) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type countingReader struct {
+	io.Reader
+	size int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.size += int64(n)
+	return n, err
+}
+
+// Delete delete file
+func (client *Client) Delete(path string) (err error) {
+	c, err := client.acquire()
+	if err != nil {
+		return err
+	}
+	defer func() { client.release(c, err) }()
+
+	return c.Delete(client.ToRemotePath(path))
+}
+
+// List list all objects under current path recursively, directories are read with MLSD when the server supports it,
+// which reports exact modification times
+func (client *Client) List(path string) (objects []*model.Object, err error) {
+	c, err := client.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { client.release(c, err) }()
+
+	objects, err = client.list(c, client.ToRemotePath(path))
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) && protocolErr.Code == ftp.StatusFileUnavailable {
+		// the path doesn't exist
+		return nil, nil
+	}
+	return objects, err
+}
+
+func (client *Client) list(c *conn, dir string) ([]*model.Object, error) {
+	entries, err := c.List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*model.Object
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		remotePath := path.Join(dir, entry.Name)
+		switch entry.Type {
+		case ftp.EntryTypeFolder:
+			children, err := client.list(c, remotePath)
+			if err != nil {
+				return objects, err
+			}
+			objects = append(objects, children...)
+		case ftp.EntryTypeFile:
+			modTime := entry.Time
+			objects = append(objects, &model.Object{
+				Path:             client.toPath(remotePath),
+				Name:             entry.Name,
+				LastModified:     &modTime,
+				Size:             int64(entry.Size),
+				ContentType:      mime.TypeByExtension(path.Ext(entry.Name)),
+				StorageInterface: client,
+			})
+		}
+	}
+	return objects, nil
+}
+
+// GetEndpoint get endpoint, default to the server's host
+func (client *Client) GetEndpoint() string {
+	if client.Config.Endpoint != "" {
+		return client.Config.Endpoint
+	}
+	return client.Config.Host
+}
+
+// GetURL get public accessible URL if Endpoint is set, otherwise return the path like FileSystem does
+func (client *Client) GetURL(path string) (string, error) {
+	if client.Config.Endpoint != "" {
+		return strings.TrimSuffix(client.Config.Endpoint, "/") + "/" + strings.TrimPrefix(path, "/"), nil
+	}
+	return path, nil
+}
@@ -0,0 +1,420 @@
+package ftp_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/ftp"
+	"github.com/bhojpur/drive/tests"
+)
+
+// server minimal in-process FTP server serving a local directory, it accepts user "partner" with password "secret"
+type server struct {
+	addr      string
+	root      string
+	tlsConfig *tls.Config
+	// number of control connections accepted, and max number of them open at the same time
+	accepted int32
+	open     int32
+	maxOpen  int32
+}
+
+func startServer(t *testing.T) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &server{addr: listener.Addr().String(), root: t.TempDir(), tlsConfig: serverTLSConfig(t)}
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+			open := atomic.AddInt32(&s.open, 1)
+			for max := atomic.LoadInt32(&s.maxOpen); open > max && !atomic.CompareAndSwapInt32(&s.maxOpen, max, open); max = atomic.LoadInt32(&s.maxOpen) {
+			}
+			go func() {
+				s.serve(c)
+				atomic.AddInt32(&s.open, -1)
+			}()
+		}
+	}()
+	return s
+}
+
+func serverTLSConfig(t *testing.T) *tls.Config {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+func (s *server) clientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	certificate, _ := x509.ParseCertificate(s.tlsConfig.Certificates[0].Certificate[0])
+	pool.AddCert(certificate)
+	return &tls.Config{RootCAs: pool}
+}
+
+type session struct {
+	server    *server
+	conn      net.Conn
+	text      *textproto.Conn
+	user      string
+	loggedIn  bool
+	protected bool
+	passive   net.Listener
+}
+
+func (s *server) serve(conn net.Conn) {
+	session := &session{server: s, conn: conn, text: textproto.NewConn(conn)}
+	defer func() {
+		if session.passive != nil {
+			session.passive.Close()
+		}
+		session.text.Close()
+	}()
+
+	session.reply(220, "ready")
+	for {
+		line, err := session.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			command, arg = line[:i], line[i+1:]
+		}
+		if !session.handle(strings.ToUpper(command), arg) {
+			return
+		}
+	}
+}
+
+func (session *session) reply(code int, message string) {
+	session.text.PrintfLine("%d %s", code, message)
+}
+
+func (session *session) localPath(arg string) string {
+	return filepath.Join(session.server.root, filepath.FromSlash(path.Clean("/"+arg)))
+}
+
+// handle run a command, return false when the connection should be closed
+func (session *session) handle(command, arg string) bool {
+	switch command {
+	case "AUTH":
+		session.reply(234, "AUTH TLS ok")
+		tlsConn := tls.Server(session.conn, session.server.tlsConfig)
+		if tlsConn.Handshake() != nil {
+			return false
+		}
+		session.conn = tlsConn
+		session.text = textproto.NewConn(tlsConn)
+		return true
+	case "USER":
+		session.user = arg
+		session.reply(331, "password required")
+		return true
+	case "PASS":
+		if session.user == "partner" && arg == "secret" {
+			session.loggedIn = true
+			session.reply(230, "logged in")
+		} else {
+			session.reply(530, "login incorrect")
+		}
+		return true
+	case "QUIT":
+		session.reply(221, "bye")
+		return false
+	}
+
+	if !session.loggedIn {
+		session.reply(530, "not logged in")
+		return true
+	}
+
+	switch command {
+	case "FEAT":
+		session.text.PrintfLine("211-Features:\r\n MDTM\r\n MLST type*;size*;modify*;\r\n SIZE\r\n EPSV\r\n211 End")
+	case "TYPE", "PBSZ", "NOOP", "OPTS":
+		session.reply(200, "ok")
+	case "PROT":
+		session.protected = arg == "P"
+		session.reply(200, "ok")
+	case "EPSV", "PASV":
+		if session.passive != nil {
+			session.passive.Close()
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			session.reply(425, err.Error())
+			return true
+		}
+		session.passive = listener
+		port := listener.Addr().(*net.TCPAddr).Port
+		if command == "EPSV" {
+			session.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		} else {
+			session.reply(227, fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256))
+		}
+	case "MLSD":
+		entries, err := ioutil.ReadDir(session.localPath(arg))
+		if err != nil {
+			session.reply(550, err.Error())
+			return true
+		}
+		session.transfer(func(data net.Conn) error {
+			for _, entry := range entries {
+				kind := "file"
+				if entry.IsDir() {
+					kind = "dir"
+				}
+				fmt.Fprintf(data, "type=%s;size=%d;modify=%s; %s\r\n", kind, entry.Size(), entry.ModTime().UTC().Format("20060102150405"), entry.Name())
+			}
+			return nil
+		})
+	case "RETR":
+		file, err := os.Open(session.localPath(arg))
+		if err != nil {
+			session.reply(550, err.Error())
+			return true
+		}
+		defer file.Close()
+		session.transfer(func(data net.Conn) error {
+			_, err := io.Copy(data, file)
+			return err
+		})
+	case "STOR":
+		file, err := os.Create(session.localPath(arg))
+		if err != nil {
+			session.reply(550, err.Error())
+			return true
+		}
+		defer file.Close()
+		session.transfer(func(data net.Conn) error {
+			_, err := io.Copy(file, data)
+			return err
+		})
+	case "DELE":
+		if info, err := os.Stat(session.localPath(arg)); err != nil || info.IsDir() {
+			session.reply(550, "no such file")
+		} else if err := os.Remove(session.localPath(arg)); err != nil {
+			session.reply(550, err.Error())
+		} else {
+			session.reply(250, "deleted")
+		}
+	case "MKD":
+		if err := os.Mkdir(session.localPath(arg), 0755); err != nil {
+			session.reply(550, err.Error())
+		} else {
+			session.reply(257, fmt.Sprintf("%q created", arg))
+		}
+	case "SIZE", "MDTM":
+		info, err := os.Stat(session.localPath(arg))
+		if err != nil || info.IsDir() {
+			session.reply(550, "no such file")
+		} else if command == "SIZE" {
+			session.reply(213, fmt.Sprint(info.Size()))
+		} else {
+			session.reply(213, info.ModTime().UTC().Format("20060102150405"))
+		}
+	default:
+		session.reply(502, "command not implemented")
+	}
+	return true
+}
+
+// transfer accept the passive data connection and run fn on it
+func (session *session) transfer(fn func(data net.Conn) error) {
+	if session.passive == nil {
+		session.reply(425, "use EPSV or PASV first")
+		return
+	}
+	listener := session.passive
+	session.passive = nil
+	defer listener.Close()
+
+	session.reply(150, "opening data connection")
+	data, err := listener.Accept()
+	if err != nil {
+		session.reply(425, err.Error())
+		return
+	}
+	if session.protected {
+		tlsConn := tls.Server(data, session.server.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			data.Close()
+			session.reply(425, err.Error())
+			return
+		}
+		data = tlsConn
+	}
+
+	err = fn(data)
+	data.Close()
+	if err != nil {
+		session.reply(426, err.Error())
+	} else {
+		session.reply(226, "transfer complete")
+	}
+}
+
+func newClient(t *testing.T, s *server, config *ftp.Config) *ftp.Client {
+	config.Host = s.addr
+	config.User = "partner"
+	config.Password = "secret"
+	client, err := ftp.New(config)
+	if err != nil {
+		t.Fatalf("No error should happen when connect, but got %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newClient(t, startServer(t), &ftp.Config{}), t)
+}
+
+func TestExplicitTLS(t *testing.T) {
+	s := startServer(t)
+	tests.TestAll(newClient(t, s, &ftp.Config{TLSConfig: s.clientTLSConfig()}), t)
+
+	if _, err := ftp.New(&ftp.Config{Host: s.addr, User: "partner", Password: "secret", TLSConfig: &tls.Config{}}); err == nil {
+		t.Errorf("Untrusted server certificate should be rejected")
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := startServer(t)
+	if _, err := ftp.New(&ftp.Config{Host: s.addr, User: "partner", Password: "wrong"}); err == nil {
+		t.Errorf("Wrong password should be rejected")
+	}
+}
+
+func TestPutAndList(t *testing.T) {
+	s := startServer(t)
+	client := newClient(t, s, &ftp.Config{Root: "/upload", DisableEPSV: true})
+
+	for _, path := range []string{"/inbox/2022/a.csv", "/inbox/b.csv", "/outbox/c.csv"} {
+		if _, err := client.Put(path, strings.NewReader(path)); err != nil {
+			t.Fatalf("No error should happen when save file, but got %v", err)
+		}
+	}
+	client.Put("/inbox/b.csv", strings.NewReader("updated"))
+
+	objects, err := client.List("/inbox")
+	if err != nil || len(objects) != 2 {
+		t.Fatalf("List should return files under the path recursively, but got %v, %v", objects, err)
+	}
+	for _, object := range objects {
+		if object.Path != "/inbox/2022/a.csv" && object.Path != "/inbox/b.csv" {
+			t.Errorf("Unexpected object %v", object.Path)
+		}
+		if object.LastModified == nil || time.Since(*object.LastModified) > time.Minute {
+			t.Errorf("List should report modified time, but got %v", object.LastModified)
+		}
+	}
+
+	if objects, err := client.List("/missing"); err != nil || len(objects) != 0 {
+		t.Errorf("List of a missing path should be empty, but got %v, %v", objects, err)
+	}
+
+	if object, err := client.Stat("/inbox/b.csv"); err != nil || object.Size != int64(len("updated")) || object.LastModified == nil {
+		t.Errorf("Stat should report size and modified time, but got %+v, %v", object, err)
+	}
+	if _, err := client.Stat("/inbox"); err == nil {
+		t.Errorf("Stat of a directory should fail")
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(s.root, "upload", "inbox", "b.csv")); err != nil || string(data) != "updated" {
+		t.Errorf("File should be written under root, but got %q, %v", data, err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	s := startServer(t)
+	client := newClient(t, s, &ftp.Config{PoolSize: 2})
+
+	for i := 0; i < 10; i++ {
+		client.Put(fmt.Sprintf("/file%d.txt", i), strings.NewReader("content"))
+	}
+	if accepted := atomic.LoadInt32(&s.accepted); accepted != 1 {
+		t.Errorf("Sequential operations should reuse the connection, but %v connections were opened", accepted)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stream, err := client.GetStream(fmt.Sprintf("/file%d.txt", i))
+			if err != nil {
+				t.Errorf("No error should happen when get stream, but got %v", err)
+				return
+			}
+			defer stream.Close()
+			if data, _ := ioutil.ReadAll(stream); string(data) != "content" {
+				t.Errorf("Downloaded file should contain correct content, but got %v", string(data))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxOpen := atomic.LoadInt32(&s.maxOpen); maxOpen > 2 {
+		t.Errorf("Pool should open at most 2 connections, but %v were open", maxOpen)
+	}
+}
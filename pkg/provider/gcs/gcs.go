@@ -0,0 +1,447 @@
+package gcs
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+var (
+	_ model.StorageInterface  = (*Client)(nil)
+	_ model.StatInterface     = (*Client)(nil)
+	_ model.NotExistInterface = (*Client)(nil)
+)
+
+// DefaultEndpoint of Google Cloud Storage
+const DefaultEndpoint = "https://storage.googleapis.com"
+
+// Storage classes of objects
+const (
+	StorageClassStandard = "STANDARD"
+	StorageClassNearline = "NEARLINE"
+	StorageClassColdline = "COLDLINE"
+	StorageClassArchive  = "ARCHIVE"
+)
+
+// Config Google Cloud Storage client config
+type Config struct {
+	Bucket string
+
+	// CredentialsJSON or CredentialsFile of a service account, or any credentials supported by Google client libraries.
+	// Application default credentials are used when both are empty
+	CredentialsJSON []byte
+	CredentialsFile string
+
+	// Endpoint override, e.g. "http://localhost:4443" for an emulator. Requests to a custom endpoint are sent without
+	// credentials unless they are configured
+	Endpoint string
+
+	// StorageClass, CacheControl and Metadata of uploaded objects, default to the bucket's settings
+	StorageClass string
+	CacheControl string
+	Metadata     map[string]string
+
+	// ChunkSize of resumable uploads, rounded up to a multiple of 256KiB, default to 8MiB
+	ChunkSize int
+	// MaxRetries of a failed chunk, the upload resumes from the last byte persisted by the server, default to 3
+	MaxRetries int
+
+	// URLExpiry expiry of V4 signed URLs returned by GetURL, default to 1 hour, max 7 days
+	URLExpiry time.Duration
+	// PublicRead bucket allows anonymous reads, GetURL returns unsigned URLs
+	PublicRead bool
+
+	// HTTPClient used to send requests, credentials are added to its transport
+	HTTPClient *http.Client
+}
+
+// Client Google Cloud Storage
+type Client struct {
+	Config     *Config
+	HTTPClient *http.Client
+	signer     *signer
+}
+
+// ObjectAttrs writable attributes of an object
+type ObjectAttrs struct {
+	ContentType  string            `json:"contentType,omitempty"`
+	CacheControl string            `json:"cacheControl,omitempty"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// object resource of the JSON API
+type object struct {
+	ObjectAttrs
+	Name       string `json:"name"`
+	Size       string `json:"size"`
+	Updated    string `json:"updated"`
+	ETag       string `json:"etag"`
+	MD5Hash    string `json:"md5Hash"`
+	Generation string `json:"generation"`
+}
+
+// Error returned by the JSON API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("gcs: %v %v", err.StatusCode, err.Message)
+}
+
+// IsNotExist report whether the error shows that the object or bucket doesn't exist
+func IsNotExist(err error) bool {
+	var gcsErr *Error
+	return errors.As(err, &gcsErr) && gcsErr.StatusCode == http.StatusNotFound
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client *Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
+}
+
+// New initialize Google Cloud Storage
+func New(config *Config) (*Client, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("gcs: Bucket is required")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultEndpoint
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = 8 << 20
+	}
+	config.ChunkSize = (config.ChunkSize + minChunkSize - 1) / minChunkSize * minChunkSize
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.URLExpiry == 0 {
+		config.URLExpiry = time.Hour
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+
+	credentialsJSON := config.CredentialsJSON
+	if len(credentialsJSON) == 0 && config.CredentialsFile != "" {
+		data, err := ioutil.ReadFile(config.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		credentialsJSON = data
+	}
+
+	client := &Client{Config: config, HTTPClient: config.HTTPClient}
+	if len(credentialsJSON) == 0 && config.Endpoint != DefaultEndpoint {
+		// emulators don't check credentials
+		return client, nil
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, config.HTTPClient)
+	var (
+		credentials *google.Credentials
+		err         error
+	)
+	if len(credentialsJSON) > 0 {
+		credentials, err = google.CredentialsFromJSON(ctx, credentialsJSON, scope)
+	} else {
+		credentials, err = google.FindDefaultCredentials(ctx, scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.HTTPClient = &http.Client{
+		Transport: &oauth2.Transport{Source: credentials.TokenSource, Base: config.HTTPClient.Transport},
+		Timeout:   config.HTTPClient.Timeout,
+	}
+	// signed URLs require the private key of a service account
+	if credentials.JSON != nil {
+		client.signer, _ = newSigner(credentials.JSON)
+	}
+	return client, nil
+}
+
+const scope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+func (client *Client) objectName(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func (client *Client) objectURL(name string) string {
+	return client.Config.Endpoint + "/storage/v1/b/" + url.PathEscape(client.Config.Bucket) + "/o/" + url.PathEscape(name)
+}
+
+// do send the request, responses with unexpected status are returned as *Error
+func (client *Client) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	return nil, responseError(resp)
+}
+
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	gcsErr := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if data, _ := ioutil.ReadAll(resp.Body); json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+		gcsErr.Message = body.Error.Message
+	}
+	return gcsErr
+}
+
+func (client *Client) doJSON(method, rawURL string, body interface{}, result interface{}, expected ...int) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, rawURL, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	resp, err := client.do(req, expected...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+func (client *Client) toObject(obj *object) *model.Object {
+	result := &model.Object{
+		Path:             "/" + obj.Name,
+		Name:             path.Base(obj.Name),
+		ETag:             obj.ETag,
+		ContentType:      obj.ContentType,
+		StorageInterface: client,
+	}
+	result.Size, _ = strconv.ParseInt(obj.Size, 10, 64)
+	if updated, err := time.Parse(time.RFC3339Nano, obj.Updated); err == nil {
+		result.LastModified = &updated
+	}
+	return result
+}
+
+// Get receive file with given path
+func (client *Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "gcs"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream
+func (client *Client) GetStream(path string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, client.objectURL(client.objectName(path))+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.do(req, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Stat get object's information
+func (client *Client) Stat(path string) (*model.Object, error) {
+	obj := &object{}
+	if err := client.doJSON(http.MethodGet, client.objectURL(client.objectName(path)), nil, obj, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return client.toObject(obj), nil
+}
+
+// GetAttrs get content type, cache control, storage class and custom metadata of the object
+func (client *Client) GetAttrs(path string) (*ObjectAttrs, error) {
+	obj := &object{}
+	if err := client.doJSON(http.MethodGet, client.objectURL(client.objectName(path)), nil, obj, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &obj.ObjectAttrs, nil
+}
+
+// UpdateMetadata merge metadata into the object's custom metadata, keys with empty values are removed
+func (client *Client) UpdateMetadata(path string, metadata map[string]string) error {
+	patch := map[string]map[string]interface{}{"metadata": {}}
+	for key, value := range metadata {
+		if value == "" {
+			patch["metadata"][key] = nil
+		} else {
+			patch["metadata"][key] = value
+		}
+	}
+	return client.doJSON(http.MethodPatch, client.objectURL(client.objectName(path)), patch, nil, http.StatusOK)
+}
+
+// SetStorageClass change the storage class of the object, which rewrites it in place
+func (client *Client) SetStorageClass(path string, storageClass string) error {
+	var (
+		name       = client.objectName(path)
+		rewriteURL = client.objectURL(name) + "/rewriteTo/b/" + url.PathEscape(client.Config.Bucket) + "/o/" + url.PathEscape(name)
+		body       = map[string]string{"storageClass": storageClass}
+	)
+
+	for token := ""; ; {
+		rawURL := rewriteURL
+		if token != "" {
+			rawURL += "?rewriteToken=" + url.QueryEscape(token)
+		}
+
+		var result struct {
+			Done         bool   `json:"done"`
+			RewriteToken string `json:"rewriteToken"`
+		}
+		if err := client.doJSON(http.MethodPost, rawURL, body, &result, http.StatusOK); err != nil {
+			return err
+		}
+		// large objects are rewritten with multiple calls
+		if result.Done || result.RewriteToken == "" {
+			return nil
+		}
+		token = result.RewriteToken
+	}
+}
+
+// Put store a reader into given path with a resumable upload, objects get the storage class, cache control and
+// metadata of the config
+func (client *Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	return client.PutWithAttrs(urlPath, reader, &ObjectAttrs{
+		CacheControl: client.Config.CacheControl,
+		StorageClass: client.Config.StorageClass,
+		Metadata:     client.Config.Metadata,
+	})
+}
+
+// Delete delete file
+func (client *Client) Delete(path string) error {
+	return client.doJSON(http.MethodDelete, client.objectURL(client.objectName(path)), nil, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// List list all objects under current path, pages are followed with the returned tokens
+func (client *Client) List(urlPath string) ([]*model.Object, error) {
+	var (
+		objects []*model.Object
+		token   string
+		prefix  string
+	)
+	if urlPath != "" {
+		prefix = strings.Trim(urlPath, "/") + "/"
+	}
+
+	for {
+		query := url.Values{}
+		if prefix != "" && prefix != "/" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+
+		var result struct {
+			Items         []*object `json:"items"`
+			NextPageToken string    `json:"nextPageToken"`
+		}
+		listURL := client.Config.Endpoint + "/storage/v1/b/" + url.PathEscape(client.Config.Bucket) + "/o?" + query.Encode()
+		if err := client.doJSON(http.MethodGet, listURL, nil, &result, http.StatusOK); err != nil {
+			return objects, err
+		}
+
+		for _, item := range result.Items {
+			objects = append(objects, client.toObject(item))
+		}
+		if token = result.NextPageToken; token == "" {
+			return objects, nil
+		}
+	}
+}
+
+// GetEndpoint get endpoint of the bucket
+func (client *Client) GetEndpoint() string {
+	return client.Config.Endpoint + "/" + client.Config.Bucket
+}
+
+// GetURL get public accessible URL. It is a V4 signed URL unless the bucket allows public reads, or the client is
+// connected to an emulator without credentials
+func (client *Client) GetURL(path string) (string, error) {
+	switch {
+	case client.Config.PublicRead:
+		return client.publicURL(client.objectName(path)), nil
+	case client.signer == nil && client.Config.Endpoint != DefaultEndpoint:
+		return client.publicURL(client.objectName(path)), nil
+	}
+	return client.SignURL(path, http.MethodGet, client.Config.URLExpiry)
+}
+
+func (client *Client) publicURL(name string) string {
+	return client.Config.Endpoint + "/" + escape(client.Config.Bucket, false) + "/" + escape(name, true)
+}
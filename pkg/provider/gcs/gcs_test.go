@@ -0,0 +1,454 @@
+package gcs_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/provider/gcs"
+	"github.com/bhojpur/drive/tests"
+)
+
+type Config struct {
+	Endpoint        string `env:"BHOJPUR_GCS_ENDPOINT"`
+	Bucket          string `env:"BHOJPUR_GCS_BUCKET"`
+	CredentialsFile string `env:"BHOJPUR_GCS_CREDENTIALS_FILE"`
+}
+
+// TestEmulator run against an emulator like fake-gcs-server, e.g. BHOJPUR_GCS_ENDPOINT=http://localhost:4443, or a real
+// bucket with BHOJPUR_GCS_CREDENTIALS_FILE
+func TestEmulator(t *testing.T) {
+	config := Config{}
+	cfgsvr.Load(&config)
+	if config.Bucket == "" {
+		t.Skip(`skip because of no config: BHOJPUR_GCS_BUCKET`)
+	}
+
+	client, err := gcs.New(&gcs.Config{Bucket: config.Bucket, Endpoint: config.Endpoint, CredentialsFile: config.CredentialsFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests.TestAll(client, t)
+}
+
+// fakeService in-process fake of the JSON API, which implements the requests sent by the client
+type fakeService struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	objects  map[string]*fakeObject
+	sessions map[string]*fakeSession
+	requests map[string]int
+	pageSize int
+	// failChunks number of following chunks which are only persisted partially, and answered with 503
+	failChunks int
+	// token required in Authorization header, if set
+	token string
+}
+
+type fakeObject struct {
+	data     []byte
+	attrs    map[string]interface{}
+	updated  time.Time
+	rewrites int
+}
+
+type fakeSession struct {
+	name  string
+	attrs map[string]interface{}
+	data  []byte
+}
+
+func startFake(t *testing.T) *fakeService {
+	fake := &fakeService{objects: map[string]*fakeObject{}, sessions: map[string]*fakeSession{}, requests: map[string]int{}, pageSize: 2}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (fake *fakeService) fail(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": message}})
+}
+
+func (fake *fakeService) resource(name string, object *fakeObject) map[string]interface{} {
+	resource := map[string]interface{}{
+		"name":    name,
+		"size":    strconv.Itoa(len(object.data)),
+		"updated": object.updated.Format(time.RFC3339Nano),
+		"etag":    fmt.Sprintf("etag-%d", object.updated.UnixNano()),
+	}
+	for key, value := range object.attrs {
+		if key != "name" {
+			resource[key] = value
+		}
+	}
+	return resource
+}
+
+func (fake *fakeService) serve(w http.ResponseWriter, req *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if req.URL.Path == "/token" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "secret-token", "token_type": "Bearer", "expires_in": 3600})
+		return
+	}
+	if fake.token != "" && req.Header.Get("Authorization") != "Bearer "+fake.token {
+		fake.fail(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	var (
+		query    = req.URL.Query()
+		segments = strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	)
+	for i := range segments {
+		segments[i], _ = url.PathUnescape(segments[i])
+	}
+
+	switch {
+	case len(segments) == 6 && segments[0] == "upload" && segments[5] == "o":
+		fake.upload(w, req)
+	case len(segments) == 5 && segments[0] == "storage" && segments[4] == "o":
+		fake.requests["list"]++
+		fake.list(w, query)
+	case len(segments) == 6 && segments[0] == "storage":
+		fake.object(w, req, segments[5])
+	case len(segments) == 11 && segments[0] == "storage" && segments[6] == "rewriteTo":
+		object := fake.objects[segments[5]]
+		if object == nil {
+			fake.fail(w, http.StatusNotFound, "No such object")
+			return
+		}
+		var attrs map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&attrs)
+		// rewrite large objects in two calls
+		if object.rewrites++; object.rewrites%2 == 1 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"done": false, "rewriteToken": "next"})
+			return
+		}
+		object.attrs["storageClass"] = attrs["storageClass"]
+		json.NewEncoder(w).Encode(map[string]interface{}{"done": true, "resource": fake.resource(segments[5], object)})
+	case len(segments) >= 2 && req.Method == http.MethodGet:
+		// public URL: /bucket/object
+		object := fake.objects[strings.Join(segments[1:], "/")]
+		if object == nil {
+			fake.fail(w, http.StatusNotFound, "No such object")
+			return
+		}
+		w.Write(object.data)
+	default:
+		fake.fail(w, http.StatusBadRequest, "unsupported request "+req.Method+" "+req.URL.Path)
+	}
+}
+
+func (fake *fakeService) object(w http.ResponseWriter, req *http.Request, name string) {
+	object := fake.objects[name]
+	if object == nil {
+		fake.fail(w, http.StatusNotFound, "No such object: "+name)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		if req.URL.Query().Get("alt") == "media" {
+			w.Write(object.data)
+		} else {
+			json.NewEncoder(w).Encode(fake.resource(name, object))
+		}
+	case http.MethodPatch:
+		var patch struct {
+			Metadata map[string]*string
+		}
+		json.NewDecoder(req.Body).Decode(&patch)
+		metadata, _ := object.attrs["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = map[string]interface{}{}
+		}
+		for key, value := range patch.Metadata {
+			if value == nil {
+				delete(metadata, key)
+			} else {
+				metadata[key] = *value
+			}
+		}
+		object.attrs["metadata"] = metadata
+		json.NewEncoder(w).Encode(fake.resource(name, object))
+	case http.MethodDelete:
+		delete(fake.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fake.fail(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (fake *fakeService) upload(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if req.Method == http.MethodPost {
+		session := &fakeSession{name: query.Get("name")}
+		json.NewDecoder(req.Body).Decode(&session.attrs)
+		id := strconv.Itoa(len(fake.sessions) + 1)
+		fake.sessions[id] = session
+		w.Header().Set("Location", fake.URL+req.URL.Path+"?uploadType=resumable&upload_id="+id)
+		return
+	}
+
+	session := fake.sessions[query.Get("upload_id")]
+	if session == nil {
+		fake.fail(w, http.StatusNotFound, "No such upload")
+		return
+	}
+	if req.Method == http.MethodDelete {
+		delete(fake.sessions, query.Get("upload_id"))
+		w.WriteHeader(499)
+		return
+	}
+
+	fake.requests["chunk"]++
+	body, _ := ioutil.ReadAll(req.Body)
+	var start, end int64 = -1, -1
+	var total string
+	contentRange := strings.TrimPrefix(req.Header.Get("Content-Range"), "bytes ")
+	if i := strings.Index(contentRange, "/"); i >= 0 {
+		total = contentRange[i+1:]
+		if r := contentRange[:i]; r != "*" {
+			fmt.Sscanf(r, "%d-%d", &start, &end)
+		}
+	}
+
+	if start >= 0 {
+		if start > int64(len(session.data)) {
+			fake.fail(w, http.StatusBadRequest, "missing bytes")
+			return
+		}
+		if fake.failChunks > 0 {
+			fake.failChunks--
+			session.data = append(session.data[:start], body[:len(body)/2]...)
+			fake.fail(w, http.StatusServiceUnavailable, "backend error")
+			return
+		}
+		session.data = append(session.data[:start], body...)
+	}
+
+	if total != "*" && total == strconv.Itoa(len(session.data)) {
+		object := &fakeObject{data: session.data, attrs: session.attrs, updated: time.Now().UTC()}
+		fake.objects[session.name] = object
+		json.NewEncoder(w).Encode(fake.resource(session.name, object))
+		return
+	}
+
+	if len(session.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func (fake *fakeService) list(w http.ResponseWriter, query url.Values) {
+	var names []string
+	for name := range fake.objects {
+		if strings.HasPrefix(name, query.Get("prefix")) && name >= query.Get("pageToken") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := map[string]interface{}{}
+	if len(names) > fake.pageSize {
+		result["nextPageToken"] = names[fake.pageSize]
+		names = names[:fake.pageSize]
+	}
+
+	var items []interface{}
+	for _, name := range names {
+		items = append(items, fake.resource(name, fake.objects[name]))
+	}
+	result["items"] = items
+	json.NewEncoder(w).Encode(result)
+}
+
+func newClient(t *testing.T, fake *fakeService, config *gcs.Config) *gcs.Client {
+	config.Bucket = "drive"
+	config.Endpoint = fake.URL
+	client, err := gcs.New(config)
+	if err != nil {
+		t.Fatalf("No error should happen when initialize client, but got %v", err)
+	}
+	return client
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newClient(t, startFake(t), &gcs.Config{}), t)
+}
+
+func TestResumableUpload(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(t, fake, &gcs.Config{ChunkSize: 256 << 10})
+	data := randomData(600 << 10)
+
+	if _, err := client.Put("/backups/data.bin", ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatalf("No error should happen when upload, but got %v", err)
+	}
+	if fake.requests["chunk"] != 3 {
+		t.Errorf("Content should be uploaded in 3 chunks, but got %v requests", fake.requests["chunk"])
+	}
+
+	fake.requests["chunk"] = 0
+	fake.failChunks = 1
+	object, err := client.Put("/backups/data.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed chunk should be resumed, but got %v", err)
+	}
+	if object.Size != int64(len(data)) || object.Path != "/backups/data.bin" {
+		t.Errorf("Returned object should have size and path, but got %+v", object)
+	}
+	// first chunk fails, then the client queries the persisted offset and sends the rest
+	if fake.requests["chunk"] != 5 {
+		t.Errorf("Failed chunk should be resumed from the persisted offset, but got %v requests", fake.requests["chunk"])
+	}
+
+	if stream, err := client.GetStream("/backups/data.bin"); err != nil {
+		t.Errorf("No error should happen when get stream, but got %v", err)
+	} else if result, _ := ioutil.ReadAll(stream); !bytes.Equal(result, data) {
+		t.Errorf("Uploaded object should match content")
+	}
+
+	if _, err := client.Put("/empty.txt", strings.NewReader("")); err != nil {
+		t.Errorf("No error should happen when upload empty object, but got %v", err)
+	}
+	if object, err := client.Stat("/empty.txt"); err != nil || object.Size != 0 || object.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("Empty object should be saved, but got %+v, %v", object, err)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(t, fake, &gcs.Config{})
+
+	for i := 0; i < 5; i++ {
+		client.Put(fmt.Sprintf("/logs/%d.txt", i), strings.NewReader("log"))
+	}
+	client.Put("/logs.txt", strings.NewReader("not under logs/"))
+
+	objects, err := client.List("/logs")
+	if err != nil || len(objects) != 5 {
+		t.Fatalf("List should follow page tokens, but got %v objects, %v", len(objects), err)
+	}
+	if fake.requests["list"] != 3 {
+		t.Errorf("List should request 3 pages, but got %v", fake.requests["list"])
+	}
+	if objects[0].Path != "/logs/0.txt" || objects[0].Size != 3 || objects[0].LastModified == nil {
+		t.Errorf("Listed objects should have properties, but got %+v", objects[0])
+	}
+}
+
+func TestAttrs(t *testing.T) {
+	client := newClient(t, startFake(t), &gcs.Config{StorageClass: gcs.StorageClassNearline, Metadata: map[string]string{"owner": "drive"}})
+	client.Put("/reports/q1.csv", strings.NewReader("a,b"))
+
+	attrs, err := client.GetAttrs("/reports/q1.csv")
+	if err != nil || attrs.StorageClass != gcs.StorageClassNearline || attrs.Metadata["owner"] != "drive" || attrs.ContentType != "text/csv; charset=utf-8" {
+		t.Errorf("Object should be uploaded with attributes of the config, but got %+v, %v", attrs, err)
+	}
+
+	if _, err := client.PutWithAttrs("/reports/q2.csv", strings.NewReader("c,d"), &gcs.ObjectAttrs{StorageClass: gcs.StorageClassColdline, CacheControl: "no-cache"}); err != nil {
+		t.Errorf("No error should happen when upload with attributes, but got %v", err)
+	}
+	if attrs, _ := client.GetAttrs("/reports/q2.csv"); attrs == nil || attrs.StorageClass != gcs.StorageClassColdline || attrs.CacheControl != "no-cache" {
+		t.Errorf("Object should be uploaded with given attributes, but got %+v", attrs)
+	}
+
+	if err := client.UpdateMetadata("/reports/q1.csv", map[string]string{"owner": "", "reviewed": "yes"}); err != nil {
+		t.Errorf("No error should happen when update metadata, but got %v", err)
+	}
+	if attrs, _ := client.GetAttrs("/reports/q1.csv"); attrs == nil || len(attrs.Metadata) != 1 || attrs.Metadata["reviewed"] != "yes" {
+		t.Errorf("Metadata should be merged, but got %+v", attrs)
+	}
+
+	if err := client.SetStorageClass("/reports/q1.csv", gcs.StorageClassArchive); err != nil {
+		t.Errorf("No error should happen when set storage class, but got %v", err)
+	}
+	if attrs, _ := client.GetAttrs("/reports/q1.csv"); attrs == nil || attrs.StorageClass != gcs.StorageClassArchive {
+		t.Errorf("Object should be rewritten to Archive, but got %+v", attrs)
+	}
+	if err := client.SetStorageClass("/missing.csv", gcs.StorageClassArchive); !gcs.IsNotExist(err) {
+		t.Errorf("Missing object should return not exist error, but got %v", err)
+	}
+}
+
+func TestServiceAccount(t *testing.T) {
+	fake := startFake(t)
+	fake.token = "secret-token"
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "drive@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    fake.URL + "/token",
+	})
+
+	client := newClient(t, fake, &gcs.Config{CredentialsJSON: credentials})
+	if _, err := client.Put("/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("Requests should be authorized with the access token, but got %v", err)
+	}
+
+	signed, err := client.GetURL("/dir/a b.txt")
+	if err != nil {
+		t.Fatalf("No error should happen when sign URL, but got %v", err)
+	}
+	u, _ := url.Parse(signed)
+	query := u.Query()
+	if u.EscapedPath() != "/drive/dir/a%20b.txt" || query.Get("X-Goog-Algorithm") != "GOOG4-RSA-SHA256" || query.Get("X-Goog-Expires") != "3600" ||
+		!strings.HasPrefix(query.Get("X-Goog-Credential"), "drive@project.iam.gserviceaccount.com/") || len(query.Get("X-Goog-Signature")) != 512 {
+		t.Errorf("URL should be signed with V4 signature, but got %v", signed)
+	}
+
+	if _, err := client.SignURL("/a.txt", http.MethodGet, 8*24*time.Hour); err == nil {
+		t.Errorf("Expiry longer than 7 days should be rejected")
+	}
+	if _, err := newClient(t, fake, &gcs.Config{}).SignURL("/a.txt", http.MethodGet, time.Hour); err != gcs.ErrSignerRequired {
+		t.Errorf("Signing URLs should require a service account key, but got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package gcs
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSignerRequired returned when signing URLs without a service account key
+var ErrSignerRequired = errors.New("gcs: signed URLs require service account credentials with a private key")
+
+type signer struct {
+	email string
+	key   *rsa.PrivateKey
+}
+
+func newSigner(credentialsJSON []byte) (*signer, error) {
+	var account struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(credentialsJSON, &account); err != nil {
+		return nil, err
+	}
+	if account.Type != "service_account" {
+		return nil, ErrSignerRequired
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("gcs: invalid service account private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcs: service account private key is not a RSA key")
+	}
+	return &signer{email: account.ClientEmail, key: rsaKey}, nil
+}
+
+// escape percent-encode all characters except unreserved ones, and slashes if keepSlash
+func escape(s string, keepSlash bool) string {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' || (keepSlash && c == '/') {
+			result.WriteByte(c)
+		} else {
+			fmt.Fprintf(&result, "%%%02X", c)
+		}
+	}
+	return result.String()
+}
+
+// SignURL generate a V4 signed URL which allows the method (e.g. GET, PUT) on the object until expiry, max 7 days
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func (client *Client) SignURL(path string, method string, expiry time.Duration) (string, error) {
+	if client.signer == nil {
+		return "", ErrSignerRequired
+	}
+	if expiry <= 0 || expiry > 7*24*time.Hour {
+		return "", fmt.Errorf("gcs: expiry of signed URLs should be between 1 second and 7 days, but got %v", expiry)
+	}
+
+	endpoint, err := url.Parse(client.Config.Endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		now         = time.Now().UTC()
+		timestamp   = now.Format("20060102T150405Z")
+		scope       = now.Format("20060102") + "/auto/storage/goog4_request"
+		resource    = "/" + escape(client.Config.Bucket, false) + "/" + escape(client.objectName(path), true)
+		queryParams = map[string]string{
+			"X-Goog-Algorithm":     "GOOG4-RSA-SHA256",
+			"X-Goog-Credential":    client.signer.email + "/" + scope,
+			"X-Goog-Date":          timestamp,
+			"X-Goog-Expires":       strconv.Itoa(int(expiry / time.Second)),
+			"X-Goog-SignedHeaders": "host",
+		}
+	)
+
+	var names []string
+	for name := range queryParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var query []string
+	for _, name := range names {
+		query = append(query, escape(name, false)+"="+escape(queryParams[name], false))
+	}
+	canonicalQuery := strings.Join(query, "&")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		endpoint.Path + resource,
+		canonicalQuery,
+		"host:" + endpoint.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{"GOOG4-RSA-SHA256", timestamp, scope, hex.EncodeToString(requestHash[:])}, "\n")
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, client.signer.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return client.Config.Endpoint + resource + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
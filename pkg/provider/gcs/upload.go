@@ -0,0 +1,234 @@
+package gcs
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+// minChunkSize chunks of resumable uploads, except the last one, must be multiple of it
+const minChunkSize = 256 << 10
+
+// PutWithAttrs store a reader into given path with given attributes. The content is sent in chunks of a resumable
+// upload, a failed chunk is retried from the last byte persisted by the server
+// https://cloud.google.com/storage/docs/performing-resumable-uploads
+func (client *Client) PutWithAttrs(urlPath string, reader io.Reader, attrs *ObjectAttrs) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	name := client.objectName(urlPath)
+	objectAttrs := *attrs
+	if objectAttrs.ContentType == "" {
+		objectAttrs.ContentType = mime.TypeByExtension(path.Ext(name))
+	}
+
+	sessionURL, err := client.startUpload(name, &objectAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		buffered = bufio.NewReader(reader)
+		buffer   = make([]byte, client.Config.ChunkSize)
+		offset   int64
+	)
+	for {
+		n, err := io.ReadFull(buffered, buffer)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			client.cancelUpload(sessionURL)
+			return nil, err
+		}
+		if !last {
+			if _, err := buffered.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				client.cancelUpload(sessionURL)
+				return nil, err
+			}
+		}
+
+		obj, err := client.uploadChunk(sessionURL, buffer[:n], offset, last)
+		if err != nil {
+			client.cancelUpload(sessionURL)
+			return nil, err
+		}
+		offset += int64(n)
+
+		if last {
+			if obj == nil {
+				return nil, errors.New("gcs: upload completed without object")
+			}
+			return client.toObject(obj), nil
+		}
+	}
+}
+
+// startUpload initiate a resumable upload session, return its URL
+func (client *Client) startUpload(name string, attrs *ObjectAttrs) (string, error) {
+	body, err := json.Marshal(struct {
+		*ObjectAttrs
+		Name string `json:"name"`
+	}{ObjectAttrs: attrs, Name: name})
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{"uploadType": {"resumable"}, "name": {name}}
+	req, err := http.NewRequest(http.MethodPost, client.Config.Endpoint+"/upload/storage/v1/b/"+url.PathEscape(client.Config.Bucket)+"/o?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if attrs.ContentType != "" {
+		req.Header.Set("X-Upload-Content-Type", attrs.ContentType)
+	}
+
+	resp, err := client.do(req, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("gcs: resumable upload session URL is missing")
+	}
+	return location, nil
+}
+
+func (client *Client) cancelUpload(sessionURL string) {
+	if req, err := http.NewRequest(http.MethodDelete, sessionURL, nil); err == nil {
+		if resp, err := client.HTTPClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// uploadChunk send the chunk starting at offset, the object is returned when the last chunk completes the upload
+func (client *Client) uploadChunk(sessionURL string, chunk []byte, offset int64, last bool) (*object, error) {
+	total := "*"
+	if last {
+		total = strconv.FormatInt(offset+int64(len(chunk)), 10)
+	}
+	end := offset + int64(len(chunk))
+
+	var lastErr error
+	for attempt := 0; attempt <= client.Config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * 100 * time.Millisecond)
+		}
+
+		contentRange := "bytes */" + total
+		if offset < end {
+			contentRange = fmt.Sprintf("bytes %d-%d/%s", offset, end-1, total)
+		}
+
+		obj, next, err := client.sendChunk(sessionURL, contentRange, chunk)
+		if err != nil {
+			if !retryable(err) {
+				return nil, err
+			}
+			lastErr = err
+
+			// ask the server how many bytes it has persisted
+			if obj, next, err = client.sendChunk(sessionURL, "bytes */*", nil); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case obj != nil:
+			return obj, nil
+		case next < offset || next > end:
+			return nil, fmt.Errorf("gcs: server persisted %v bytes, but bytes %v-%v have been sent", next, offset, end)
+		case next == end && !last:
+			return nil, nil
+		}
+
+		// resend the part of the chunk which isn't persisted, or finalize the upload
+		chunk = chunk[next-offset:]
+		offset = next
+		lastErr = fmt.Errorf("gcs: upload is incomplete at %v bytes", offset)
+	}
+	return nil, lastErr
+}
+
+// sendChunk send a request of the upload session, it returns the object if the upload is completed, otherwise the
+// number of bytes persisted by the server
+func (client *Client) sendChunk(sessionURL string, contentRange string, chunk []byte) (*object, int64, error) {
+	req, err := http.NewRequest(http.MethodPut, sessionURL, bytes.NewReader(chunk))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		defer resp.Body.Close()
+		obj := &object{}
+		if err := json.NewDecoder(resp.Body).Decode(obj); err != nil {
+			return nil, 0, err
+		}
+		return obj, 0, nil
+	case http.StatusPermanentRedirect:
+		// 308 Resume Incomplete, Range is "bytes=0-<last persisted byte>", missing if nothing is persisted
+		resp.Body.Close()
+		var next int64
+		if persisted := resp.Header.Get("Range"); persisted != "" {
+			last, err := strconv.ParseInt(persisted[strings.LastIndex(persisted, "-")+1:], 10, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("gcs: invalid range %q", persisted)
+			}
+			next = last + 1
+		}
+		return nil, next, nil
+	}
+	return nil, 0, responseError(resp)
+}
+
+func retryable(err error) bool {
+	var gcsErr *Error
+	if errors.As(err, &gcsErr) {
+		return gcsErr.StatusCode >= 500 || gcsErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package gc
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+// Guard protects content-addressed data (e.g. blobs or chunks) from mark-and-sweep GC while writers reference it.
+//
+// In the same process, writers hold the read lock from their dedup checks until their references are committed, and
+// record committed digests, GC holds the write lock when deleting. Across processes, each write saves a pending marker
+// listing its digests before the dedup checks, GC retains digests of markers younger than its minimum age.
+type Guard struct {
+	Storage model.StorageInterface
+	// Dir directory of pending markers in the storage
+	Dir string
+
+	lock   sync.RWMutex
+	mutex  sync.Mutex
+	recent map[string]bool
+}
+
+// New initialize guard, pending markers are saved under dir of the storage
+func New(storage model.StorageInterface, dir string) *Guard {
+	return &Guard{Storage: storage, Dir: dir, recent: map[string]bool{}}
+}
+
+// nonce name of a pending marker, starts with its creation time
+func nonce() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+// nonceTime get the time when the pending marker was created from its nonce
+func nonceTime(nonce string) time.Time {
+	nanos, _ := strconv.ParseInt(strings.SplitN(nonce, "-", 2)[0], 10, 64)
+	return time.Unix(0, nanos)
+}
+
+// Write a write referencing digests, protected from GC until it is ended
+type Write struct {
+	guard   *Guard
+	marker  string
+	digests []string
+}
+
+// Begin a write referencing digests, call it before checking whether they exist. The write blocks sweeps of this process
+// until End is called
+func (guard *Guard) Begin(digests []string) (*Write, error) {
+	data, err := json.Marshal(digests)
+	if err != nil {
+		return nil, err
+	}
+
+	guard.lock.RLock()
+	marker := path.Join(guard.Dir, nonce())
+	if _, err := guard.Storage.Put(marker, bytes.NewReader(data)); err != nil {
+		guard.lock.RUnlock()
+		return nil, err
+	}
+	return &Write{guard: guard, marker: marker, digests: digests}, nil
+}
+
+// Commit record digests of the write are referenced, so sweeps of this process running since before the references
+// were saved retain them. The pending marker is kept, and removed by GC once it is older than GC's minimum age
+func (write *Write) Commit() {
+	write.guard.mutex.Lock()
+	for _, digest := range write.digests {
+		write.guard.recent[digest] = true
+	}
+	write.guard.mutex.Unlock()
+}
+
+// Abort delete the pending marker of a failed write
+func (write *Write) Abort() {
+	write.guard.Storage.Delete(write.marker)
+}
+
+// End unblock sweeps of this process
+func (write *Write) End() {
+	write.guard.lock.RUnlock()
+}
+
+// Reset forget digests committed before, call it when GC starts, before referenced digests are marked
+func (guard *Guard) Reset() {
+	guard.mutex.Lock()
+	guard.recent = map[string]bool{}
+	guard.mutex.Unlock()
+}
+
+// Protected return digests listed by pending markers younger than minAge at start, older markers are deleted
+func (guard *Guard) Protected(start time.Time, minAge time.Duration) (map[string]bool, error) {
+	markers, err := guard.Storage.List(guard.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	protected := map[string]bool{}
+	for _, marker := range markers {
+		if start.Sub(nonceTime(marker.Name)) >= minAge {
+			guard.Storage.Delete(marker.Path)
+			continue
+		}
+
+		var digests []string
+		if err := readJSON(guard.Storage, marker.Path, &digests); err != nil {
+			if model.IsNotExist(guard.Storage, err) {
+				// removed by a failed write
+				continue
+			}
+			return nil, fmt.Errorf("read pending marker %v: %w", marker.Path, err)
+		}
+		for _, digest := range digests {
+			protected[digest] = true
+		}
+	}
+	return protected, nil
+}
+
+// Sweep call sweep to delete the digest unless a write of this process committed it since Reset. Writes of this process
+// are blocked meanwhile, so they don't find the digest before it is deleted
+func (guard *Guard) Sweep(digest string, sweep func() error) (bool, error) {
+	guard.lock.Lock()
+	defer guard.lock.Unlock()
+
+	guard.mutex.Lock()
+	recent := guard.recent[digest]
+	guard.mutex.Unlock()
+	if recent {
+		return false, nil
+	}
+
+	if err := sweep(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func readJSON(storage model.StorageInterface, urlPath string, value interface{}) error {
+	stream, err := storage.GetStream(urlPath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return json.NewDecoder(stream).Decode(value)
+}
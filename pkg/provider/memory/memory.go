@@ -0,0 +1,367 @@
+package memory
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+var (
+	// ErrInjected default error returned by injected faults
+	ErrInjected = errors.New("injected fault")
+	// ErrObjectTooLarge returned when an object exceeds Config.MaxObjectSize
+	ErrObjectTooLarge = errors.New("object exceeds the max object size")
+	// ErrStorageFull returned when saving an object would exceed Config.MaxTotalSize
+	ErrStorageFull = errors.New("storage exceeds the max total size")
+)
+
+// Operation storage operations, used to count calls and inject faults
+type Operation string
+
+// Operations of the storage
+const (
+	OperationGet       Operation = "get"
+	OperationGetStream Operation = "get_stream"
+	OperationPut       Operation = "put"
+	OperationDelete    Operation = "delete"
+	OperationList      Operation = "list"
+	OperationGetURL    Operation = "get_url"
+	OperationStat      Operation = "stat"
+)
+
+// Faults faults injected into the storage
+type Faults struct {
+	// Latency added to every call
+	Latency time.Duration
+	// FailOn fail the Nth call (1-based, counted since the faults are set) of the operation
+	FailOn map[Operation]int
+	// Err returned by failed calls and partial reads, default to ErrInjected
+	Err error
+	// PartialRead streams fail after given bytes, zero disables it
+	PartialRead int64
+}
+
+// Config memory storage config
+type Config struct {
+	// MaxObjectSize max bytes of an object, zero means no limit
+	MaxObjectSize int64
+	// MaxTotalSize max bytes of all objects, zero means no limit
+	MaxTotalSize int64
+	// Now current time used as LastModified, default to time.Now
+	Now func() time.Time
+}
+
+type object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+// Client memory storage, objects are kept in a map. It is safe for concurrent use
+type Client struct {
+	Config *Config
+
+	mutex   sync.RWMutex
+	objects map[string]*object
+	size    int64
+
+	faultsMutex sync.Mutex
+	faults      *Faults
+	calls       map[Operation]int
+}
+
+// New initialize memory storage
+func New(config *Config) *Client {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Client{Config: config, objects: map[string]*object{}, calls: map[Operation]int{}}
+}
+
+// SetFaults replace injected faults, and reset call counts. nil removes all faults
+func (client *Client) SetFaults(faults *Faults) {
+	client.faultsMutex.Lock()
+	defer client.faultsMutex.Unlock()
+	client.faults = faults
+	client.calls = map[Operation]int{}
+}
+
+// Calls return number of calls of the operation since the faults are set
+func (client *Client) Calls(operation Operation) int {
+	client.faultsMutex.Lock()
+	defer client.faultsMutex.Unlock()
+	return client.calls[operation]
+}
+
+// Size return total bytes of all objects
+func (client *Client) Size() int64 {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+	return client.size
+}
+
+// call count the call, and apply injected faults
+func (client *Client) call(operation Operation) error {
+	client.faultsMutex.Lock()
+	client.calls[operation]++
+	var (
+		faults = client.faults
+		count  = client.calls[operation]
+	)
+	client.faultsMutex.Unlock()
+
+	if faults == nil {
+		return nil
+	}
+
+	if faults.Latency > 0 {
+		time.Sleep(faults.Latency)
+	}
+	if n, ok := faults.FailOn[operation]; ok && n == count {
+		return faults.err()
+	}
+	return nil
+}
+
+func (faults *Faults) err() error {
+	if faults.Err != nil {
+		return faults.Err
+	}
+	return ErrInjected
+}
+
+func cleanPath(urlPath string) string {
+	return "/" + strings.TrimPrefix(urlPath, "/")
+}
+
+func (client *Client) toObject(urlPath string, o *object) *model.Object {
+	modified := o.modified
+	return &model.Object{
+		Path:             urlPath,
+		Name:             filepath.Base(urlPath),
+		LastModified:     &modified,
+		Size:             int64(len(o.data)),
+		ETag:             o.etag,
+		ContentType:      mime.TypeByExtension(path.Ext(urlPath)),
+		StorageInterface: client,
+	}
+}
+
+func (client *Client) lookup(urlPath string) (*object, error) {
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+
+	o, ok := client.objects[cleanPath(urlPath)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return o, nil
+}
+
+// Get receive file with given path
+func (client *Client) Get(path string) (*os.File, error) {
+	if err := client.call(OperationGet); err != nil {
+		return nil, err
+	}
+
+	o, err := client.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile("", "memory")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(o.data); err != nil {
+		file.Close()
+		return nil, err
+	}
+	_, err = file.Seek(0, 0)
+	return file, err
+}
+
+// GetStream get file as stream
+func (client *Client) GetStream(path string) (io.ReadCloser, error) {
+	if err := client.call(OperationGetStream); err != nil {
+		return nil, err
+	}
+
+	o, err := client.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// saved objects are never modified, so the stream could share the data
+	var reader io.Reader = bytes.NewReader(o.data)
+
+	client.faultsMutex.Lock()
+	faults := client.faults
+	client.faultsMutex.Unlock()
+	if faults != nil && faults.PartialRead > 0 && faults.PartialRead < int64(len(o.data)) {
+		reader = io.MultiReader(io.LimitReader(reader, faults.PartialRead), &errReader{err: faults.err()})
+	}
+	return ioutil.NopCloser(reader), nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (reader *errReader) Read([]byte) (int, error) {
+	return 0, reader.err
+}
+
+// Stat get object's information
+func (client *Client) Stat(path string) (*model.Object, error) {
+	if err := client.call(OperationStat); err != nil {
+		return nil, err
+	}
+
+	o, err := client.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(cleanPath(path), o), nil
+}
+
+// Put store a reader into given path
+func (client *Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if err := client.call(OperationPut); err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	if client.Config.MaxObjectSize > 0 {
+		// read one more byte to detect objects exceeding the limit without reading them fully
+		reader = io.LimitReader(reader, client.Config.MaxObjectSize+1)
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if client.Config.MaxObjectSize > 0 && int64(len(data)) > client.Config.MaxObjectSize {
+		return nil, ErrObjectTooLarge
+	}
+
+	sum := md5.Sum(data)
+	o := &object{data: data, etag: hex.EncodeToString(sum[:]), modified: client.Config.Now()}
+	urlPath = cleanPath(urlPath)
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	size := client.size + int64(len(data))
+	if previous, ok := client.objects[urlPath]; ok {
+		size -= int64(len(previous.data))
+	}
+	if client.Config.MaxTotalSize > 0 && size > client.Config.MaxTotalSize {
+		return nil, ErrStorageFull
+	}
+
+	client.objects[urlPath] = o
+	client.size = size
+	return client.toObject(urlPath, o), nil
+}
+
+// Delete delete file
+func (client *Client) Delete(path string) error {
+	if err := client.call(OperationDelete); err != nil {
+		return err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	path = cleanPath(path)
+	o, ok := client.objects[path]
+	if !ok {
+		return os.ErrNotExist
+	}
+	client.size -= int64(len(o.data))
+	delete(client.objects, path)
+	return nil
+}
+
+// List list all objects under current path in path order
+func (client *Client) List(path string) ([]*model.Object, error) {
+	if err := client.call(OperationList); err != nil {
+		return nil, err
+	}
+
+	prefix := cleanPath(path)
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	client.mutex.RLock()
+	defer client.mutex.RUnlock()
+
+	var objects []*model.Object
+	for key, o := range client.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, client.toObject(key, o))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Path < objects[j].Path })
+	return objects, nil
+}
+
+// GetEndpoint get endpoint, memory storage's endpoint is /
+func (client *Client) GetEndpoint() string {
+	return "/"
+}
+
+// GetURL objects are not accessible by URL, return the path like FileSystem does
+func (client *Client) GetURL(path string) (string, error) {
+	if err := client.call(OperationGetURL); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,128 @@
+package memory_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/tests"
+)
+
+func TestAll(t *testing.T) {
+	tests.TestAll(memory.New(nil), t)
+}
+
+func TestLimits(t *testing.T) {
+	client := memory.New(&memory.Config{MaxObjectSize: 10, MaxTotalSize: 15})
+
+	if _, err := client.Put("/a.txt", strings.NewReader(strings.Repeat("a", 11))); err != memory.ErrObjectTooLarge {
+		t.Errorf("Object exceeding max object size should be rejected, but got %v", err)
+	}
+	if _, err := client.Put("/a.txt", strings.NewReader(strings.Repeat("a", 10))); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+	if _, err := client.Put("/b.txt", strings.NewReader(strings.Repeat("b", 6))); err != memory.ErrStorageFull {
+		t.Errorf("Object exceeding max total size should be rejected, but got %v", err)
+	}
+
+	// overwriting frees the previous object's size
+	if _, err := client.Put("/a.txt", strings.NewReader(strings.Repeat("a", 5))); err != nil {
+		t.Errorf("No error should happen when overwrite file, but got %v", err)
+	}
+	if _, err := client.Put("/b.txt", strings.NewReader(strings.Repeat("b", 10))); err != nil {
+		t.Errorf("No error should happen when save file, but got %v", err)
+	}
+	if client.Size() != 15 {
+		t.Errorf("Total size should be 15, but got %v", client.Size())
+	}
+}
+
+func TestFaults(t *testing.T) {
+	client := memory.New(nil)
+	client.Put("/a.txt", strings.NewReader("0123456789"))
+
+	client.SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationGetStream: 2}})
+	if _, err := client.GetStream("/a.txt"); err != nil {
+		t.Errorf("First call should succeed, but got %v", err)
+	}
+	if _, err := client.GetStream("/a.txt"); err != memory.ErrInjected {
+		t.Errorf("Second call should fail, but got %v", err)
+	}
+	if _, err := client.GetStream("/a.txt"); err != nil {
+		t.Errorf("Third call should succeed, but got %v", err)
+	}
+	if calls := client.Calls(memory.OperationGetStream); calls != 3 {
+		t.Errorf("Calls should be counted, but got %v", calls)
+	}
+
+	client.SetFaults(&memory.Faults{PartialRead: 4})
+	stream, _ := client.GetStream("/a.txt")
+	if data, err := ioutil.ReadAll(stream); err != memory.ErrInjected || string(data) != "0123" {
+		t.Errorf("Stream should fail after 4 bytes, but got %q, %v", data, err)
+	}
+
+	client.SetFaults(&memory.Faults{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	client.Stat("/a.txt")
+	if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Latency should be injected")
+	}
+
+	client.SetFaults(nil)
+	if _, err := client.GetStream("/a.txt"); err != nil {
+		t.Errorf("No fault should be injected after faults are removed, but got %v", err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := memory.New(&memory.Config{Now: func() time.Time { return now }})
+
+	object, _ := client.Put("/a.txt", strings.NewReader("a"))
+	if !object.LastModified.Equal(now) {
+		t.Errorf("LastModified should come from the clock, but got %v", object.LastModified)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	client := memory.New(nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := "/dir/" + string(rune('a'+i)) + ".txt"
+			client.Put(path, strings.NewReader(path))
+			client.List("/dir")
+			client.GetStream(path)
+		}(i)
+	}
+	wg.Wait()
+
+	if objects, _ := client.List("/dir"); len(objects) != 20 {
+		t.Errorf("All objects should be saved, but got %v", len(objects))
+	}
+}
@@ -0,0 +1,333 @@
+package postgres
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// Config PostgreSQL storage config
+type Config struct {
+	// Table of objects, chunks are saved in <Table>_chunks. Default to "drive_objects"
+	Table string
+	// ChunkSize bytes of each chunk row, default to 1MiB
+	ChunkSize int
+}
+
+// Client PostgreSQL storage, objects are saved as rows of chunked bytea, so they are streamed without loading whole files into memory
+type Client struct {
+	DB     *sql.DB
+	Config *Config
+}
+
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// New initialize PostgreSQL storage, tables are created if they don't exist. db should be opened with the "postgres" driver of github.com/lib/pq
+func New(db *sql.DB, config *Config) (*Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.Table == "" {
+		config.Table = "drive_objects"
+	}
+	if !tableNameRegexp.MatchString(config.Table) {
+		return nil, fmt.Errorf("invalid storage table name %q", config.Table)
+	}
+
+	if config.ChunkSize == 0 {
+		config.ChunkSize = 1 << 20
+	}
+
+	client := &Client{DB: db, Config: config}
+	index := strings.Replace(config.Table, ".", "_", -1)
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			path TEXT NOT NULL UNIQUE,
+			size BIGINT NOT NULL,
+			content_type TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			modified_at TIMESTAMPTZ NOT NULL
+		)`, config.Table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_path_prefix_idx ON %s (path text_pattern_ops)", index, config.Table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			object_id BIGINT NOT NULL REFERENCES %s (id) ON DELETE CASCADE,
+			seq INTEGER NOT NULL,
+			data BYTEA NOT NULL,
+			PRIMARY KEY (object_id, seq)
+		)`, client.chunksTable(), config.Table),
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (client Client) chunksTable() string {
+	return client.Config.Table + "_chunks"
+}
+
+func cleanPath(urlPath string) string {
+	return "/" + strings.TrimPrefix(urlPath, "/")
+}
+
+// Get receive file with given path
+func (client Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "postgres"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream, chunks are queried one by one while reading
+func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	var (
+		id   int64
+		size int64
+	)
+	err := client.DB.QueryRow(fmt.Sprintf("SELECT id, size FROM %s WHERE path = $1", client.Config.Table), cleanPath(path)).Scan(&id, &size)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &reader{client: client, id: id, remaining: size}, nil
+}
+
+// reader read chunks of an object in sequence
+type reader struct {
+	client    Client
+	id        int64
+	seq       int
+	remaining int64
+	buffer    []byte
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+
+		err := r.client.DB.QueryRow(
+			fmt.Sprintf("SELECT data FROM %s WHERE object_id = $1 AND seq = $2", r.client.chunksTable()), r.id, r.seq,
+		).Scan(&r.buffer)
+		if err == sql.ErrNoRows {
+			// the object has been overwritten or deleted while reading
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		r.seq++
+	}
+
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *reader) Close() error {
+	return nil
+}
+
+// Stat get object's information without reading its content
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	row := client.DB.QueryRow(fmt.Sprintf("SELECT path, size, content_type, digest, modified_at FROM %s WHERE path = $1", client.Config.Table), cleanPath(urlPath))
+	object, err := client.scanObject(row)
+	if err == sql.ErrNoRows {
+		return nil, os.ErrNotExist
+	}
+	return object, err
+}
+
+func (client Client) scanObject(scanner interface{ Scan(...interface{}) error }) (*model.Object, error) {
+	var (
+		object   = &model.Object{StorageInterface: client}
+		modified time.Time
+	)
+	if err := scanner.Scan(&object.Path, &object.Size, &object.ContentType, &object.ETag, &modified); err != nil {
+		return nil, err
+	}
+
+	object.Name = filepath.Base(object.Path)
+	object.LastModified = &modified
+	return object, nil
+}
+
+// Put store a reader into given path in a transaction, a failed upload leaves no partial object and keeps the previous one
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	urlPath = cleanPath(urlPath)
+	tx, err := client.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// replace the previous object, its chunks are deleted by cascade when the transaction is committed
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE path = $1", client.Config.Table), urlPath); err != nil {
+		return nil, err
+	}
+
+	var (
+		id          int64
+		now         = time.Now()
+		contentType = mime.TypeByExtension(path.Ext(urlPath))
+	)
+	err = tx.QueryRow(
+		fmt.Sprintf("INSERT INTO %s (path, size, content_type, digest, modified_at) VALUES ($1, 0, $2, '', $3) RETURNING id", client.Config.Table),
+		urlPath, contentType, now,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (object_id, seq, data) VALUES ($1, $2, $3)", client.chunksTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer insert.Close()
+
+	var (
+		hasher = sha256.New()
+		buffer = make([]byte, client.Config.ChunkSize)
+		size   int64
+	)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(reader, buffer)
+		if n > 0 {
+			if _, err := insert.Exec(id, seq, buffer[:n]); err != nil {
+				return nil, err
+			}
+			hasher.Write(buffer[:n])
+			size += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET size = $1, digest = $2 WHERE id = $3", client.Config.Table), size, digest, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &model.Object{
+		Path:             urlPath,
+		Name:             filepath.Base(urlPath),
+		LastModified:     &now,
+		Size:             size,
+		ETag:             digest,
+		ContentType:      contentType,
+		StorageInterface: client,
+	}, nil
+}
+
+// Delete delete file
+func (client Client) Delete(path string) error {
+	result, err := client.DB.Exec(fmt.Sprintf("DELETE FROM %s WHERE path = $1", client.Config.Table), cleanPath(path))
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// List list all objects under current path, by path prefix through the index
+func (client Client) List(path string) ([]*model.Object, error) {
+	prefix := cleanPath(path)
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	rows, err := client.DB.Query(
+		fmt.Sprintf(`SELECT path, size, content_type, digest, modified_at FROM %s WHERE path LIKE $1 ESCAPE '\' ORDER BY path`, client.Config.Table),
+		likeEscaper.Replace(prefix)+"%",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*model.Object
+	for rows.Next() {
+		object, err := client.scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// GetEndpoint get endpoint, PostgreSQL's endpoint is /
+func (client Client) GetEndpoint() string {
+	return "/"
+}
+
+// GetURL objects are not accessible by URL, return the path like FileSystem does
+func (client Client) GetURL(path string) (string, error) {
+	return path, nil
+}
@@ -0,0 +1,160 @@
+package postgres_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/provider/postgres"
+	"github.com/bhojpur/drive/tests"
+	_ "github.com/lib/pq"
+)
+
+type Config struct {
+	DSN string `env:"BHOJPUR_POSTGRES_DSN"`
+}
+
+func newClient(t *testing.T) *postgres.Client {
+	config := Config{}
+	cfgsvr.Load(&config)
+	if config.DSN == "" {
+		t.Skip(`skip because of no config: BHOJPUR_POSTGRES_DSN`)
+	}
+
+	db, err := sql.Open("postgres", config.DSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.Exec("DROP TABLE IF EXISTS drive_objects_test_chunks, drive_objects_test")
+
+	client, err := postgres.New(db, &postgres.Config{Table: "drive_objects_test", ChunkSize: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newClient(t), t)
+}
+
+func TestChunks(t *testing.T) {
+	client := newClient(t)
+	data := make([]byte, 10000)
+	rand.Read(data)
+
+	object, err := client.Put("/data.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+	if object.Size != int64(len(data)) || object.ETag == "" {
+		t.Errorf("Put should report size and digest, but got %+v", object)
+	}
+
+	var chunks int
+	client.DB.QueryRow("SELECT COUNT(*) FROM drive_objects_test_chunks").Scan(&chunks)
+	if chunks != 10 {
+		t.Errorf("Object should be saved in 10 chunks, but got %v", chunks)
+	}
+
+	stream, err := client.GetStream("/data.bin")
+	if err != nil {
+		t.Fatalf("No error should happen when get stream, but got %v", err)
+	}
+	if result, err := ioutil.ReadAll(stream); err != nil || !bytes.Equal(result, data) {
+		t.Errorf("Stream should match content, but got %v bytes, %v", len(result), err)
+	}
+}
+
+type failingReader struct {
+	reader io.Reader
+	after  int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.after <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > r.after {
+		p = p[:r.after]
+	}
+	n, err := r.reader.Read(p)
+	r.after -= n
+	return n, err
+}
+
+func TestFailedUpload(t *testing.T) {
+	client := newClient(t)
+	client.Put("/a.txt", strings.NewReader("previous"))
+
+	if _, err := client.Put("/a.txt", &failingReader{reader: strings.NewReader(strings.Repeat("x", 5000)), after: 3000}); err == nil {
+		t.Fatalf("Failed upload should return error")
+	}
+
+	stream, err := client.GetStream("/a.txt")
+	if err != nil {
+		t.Fatalf("Previous object should be kept, but got %v", err)
+	}
+	if data, _ := ioutil.ReadAll(stream); string(data) != "previous" {
+		t.Errorf("Previous object should be kept, but got %v", string(data))
+	}
+
+	if _, err := client.Put("/b.txt", &failingReader{reader: strings.NewReader(strings.Repeat("x", 5000)), after: 3000}); err == nil {
+		t.Fatalf("Failed upload should return error")
+	}
+	if _, err := client.Stat("/b.txt"); err == nil {
+		t.Errorf("Failed upload should leave no partial object")
+	}
+
+	var chunks int
+	client.DB.QueryRow("SELECT COUNT(*) FROM drive_objects_test_chunks").Scan(&chunks)
+	if chunks != 1 {
+		t.Errorf("Failed upload should leave no chunks, but got %v", chunks)
+	}
+}
+
+func TestListPrefix(t *testing.T) {
+	client := newClient(t)
+	for _, path := range []string{"/a/1.txt", "/a/b/2.txt", "/a_b/3.txt", "/c.txt"} {
+		client.Put(path, strings.NewReader(path))
+	}
+
+	objects, err := client.List("/a")
+	if err != nil {
+		t.Fatalf("No error should happen when list, but got %v", err)
+	}
+
+	var paths []string
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	if strings.Join(paths, ",") != "/a/1.txt,/a/b/2.txt" {
+		t.Errorf("List should only return objects under the path, but got %v", paths)
+	}
+}
@@ -23,6 +23,8 @@ package qiniu
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -38,9 +40,20 @@ import (
 
 	"github.com/bhojpur/drive/pkg/model"
 	"github.com/qiniu/go-sdk/v7/auth/qbox"
+	qiniuclient "github.com/qiniu/go-sdk/v7/client"
 	"github.com/qiniu/go-sdk/v7/storage"
 )
 
+var (
+	_ model.StorageInterface  = (*Client)(nil)
+	_ model.StatInterface     = (*Client)(nil)
+	_ model.ImageURLInterface = (*Client)(nil)
+	_ model.NotExistInterface = (*Client)(nil)
+)
+
+// batchLimit max operations Qiniu accepts in one batch request
+const batchLimit = 1000
+
 // Client Qiniu storage
 type Client struct {
 	Config        *Config
@@ -52,34 +65,62 @@ type Client struct {
 
 // Config Qiniu client config
 type Config struct {
-	AccessID      string
-	AccessKey     string
-	Region        string
-	Bucket        string
+	AccessID  string
+	AccessKey string
+	// Region region name like huadong, or region ID like z0, the region is discovered from the bucket if it is blank or "auto"
+	Region string
+	Bucket string
+	// Endpoint download domain of the bucket, http or https is used by UseHTTPS if it has no scheme
 	Endpoint      string
 	UseHTTPS      bool
 	UseCdnDomains bool
 	PrivateURL    bool
+	// Zone hosts of the region, overwrites Region, useful for private deployments
+	Zone *storage.Zone
 }
 
-var zonedata = map[string]*storage.Zone{
-	"huadong": &storage.ZoneHuadong,
-	"huabei":  &storage.ZoneHuabei,
-	"huanan":  &storage.ZoneHuanan,
-	"beimei":  &storage.ZoneBeimei,
+// regions region names, region IDs are accepted too
+var regions = map[string]storage.RegionID{
+	"huadong":          storage.RIDHuadong,
+	"huadong-zhejiang": storage.RIDHuadongZheJiang,
+	"huabei":           storage.RIDHuabei,
+	"huanan":           storage.RIDHuanan,
+	"beimei":           storage.RIDNorthAmerica,
+	"xinjiapo":         storage.RIDSingapore,
+	"fog-cn-east-1":    storage.RIDFogCnEast1,
 }
 
-func New(config *Config) *Client {
+func lookupZone(name string) (*storage.Zone, bool) {
+	id, ok := regions[strings.ToLower(name)]
+	if !ok {
+		id = storage.RegionID(name)
+	}
+
+	if region, ok := storage.GetRegionByID(id); ok {
+		return &region, true
+	}
+	return nil, false
+}
 
+// New initialize Qiniu storage
+func New(config *Config) *Client {
 	client := &Client{Config: config, storageCfg: storage.Config{}}
 
 	client.mac = qbox.NewMac(config.AccessID, config.AccessKey)
 
-	if z, ok := zonedata[strings.ToLower(config.Region)]; ok {
-		client.storageCfg.Zone = z
-	} else {
-		panic(fmt.Sprintf("Zone %s is invalid, only support huadong, huabei, huanan, beimei.", config.Region))
+	switch {
+	case config.Zone != nil:
+		client.storageCfg.Zone = config.Zone
+	case config.Region == "" || strings.EqualFold(config.Region, "auto"):
+		// the SDK queries the zone of the bucket when it is needed
+	default:
+		zone, ok := lookupZone(config.Region)
+		if !ok {
+			panic(fmt.Sprintf("Region %s is invalid, only support huadong, huadong-zhejiang, huabei, huanan, beimei, xinjiapo, fog-cn-east-1, region IDs like z0, or auto.", config.Region))
+		}
+		client.storageCfg.Zone = zone
 	}
+
 	if len(config.Endpoint) == 0 {
 		panic("endpoint must be provided.")
 	}
@@ -90,37 +131,84 @@ func New(config *Config) *Client {
 	return client
 }
 
-func (client Client) SetPutPolicy(putPolicy *storage.PutPolicy) {
+// SetPutPolicy set the policy used to sign uploads, its scope is set to the uploaded key if blank
+func (client *Client) SetPutPolicy(putPolicy *storage.PutPolicy) {
 	client.putPolicy = putPolicy
 }
 
+// IsNotExist check the error means the object doesn't exist
+func IsNotExist(err error) bool {
+	var errInfo *qiniuclient.ErrorInfo
+	if errors.As(err, &errInfo) {
+		return errInfo.Code == 612 || errInfo.Code == http.StatusNotFound
+	}
+	return false
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
+}
+
 // Get receive file with given path
 func (client Client) Get(path string) (file *os.File, err error) {
 	readCloser, err := client.GetStream(path)
 
-	if file, err = ioutil.TempFile("/tmp", "qiniu"); err == nil {
-		defer readCloser.Close()
-		_, err = io.Copy(file, readCloser)
-		file.Seek(0, 0)
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "qiniu"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
 	}
 
 	return file, err
 }
 
-// GetStream get file as stream
+// GetStream get file as stream, downloads are always signed so private buckets could be read regardless of PrivateURL,
+// which only decides whether URLs returned by GetURL are signed
 func (client Client) GetStream(path string) (io.ReadCloser, error) {
-	purl, err := client.GetURL(path)
+	if len(path) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	deadline := time.Now().Add(time.Second * 3600).Unix()
+	purl := storage.MakePrivateURLv2(client.mac, client.downloadDomain(), storageKey(path), deadline)
+
+	res, err := http.Get(purl)
 	if err != nil {
 		return nil, err
 	}
 
-	var res *http.Response
-	res, err = http.Get(purl)
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = fmt.Errorf("file %s not found", path)
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, qiniuclient.ResponseError(res)
+	}
+	return res.Body, nil
+}
+
+// Stat get object's information
+func (client Client) Stat(path string) (*model.Object, error) {
+	key := storageKey(path)
+	info, err := client.bucketManager.Stat(client.Config.Bucket, key)
+	if err != nil {
+		return nil, err
 	}
+	return client.toObject(key, info.Hash, info.Fsize, info.PutTime, info.MimeType), nil
+}
 
-	return res.Body, err
+func (client Client) toObject(key, hash string, size, putTime int64, mimeType string) *model.Object {
+	// put time is in units of 100 nanoseconds
+	lastModified := time.Unix(0, putTime*100)
+	return &model.Object{
+		Path:             "/" + key,
+		Name:             filepath.Base(key),
+		LastModified:     &lastModified,
+		Size:             size,
+		ETag:             hash,
+		ContentType:      mimeType,
+		StorageInterface: client,
+	}
 }
 
 // Put store a reader into given path
@@ -141,13 +229,13 @@ func (client Client) Put(urlPath string, reader io.Reader) (r *model.Object, err
 		fileType = http.DetectContentType(buffer)
 	}
 
-	putPolicy := storage.PutPolicy{
-		Scope: fmt.Sprintf("%s:%s", client.Config.Bucket, urlPath),
-	}
-
+	putPolicy := storage.PutPolicy{}
 	if client.putPolicy != nil {
 		putPolicy = *client.putPolicy
 	}
+	if putPolicy.Scope == "" {
+		putPolicy.Scope = fmt.Sprintf("%s:%s", client.Config.Bucket, urlPath)
+	}
 
 	upToken := putPolicy.UploadToken(client.mac)
 
@@ -156,7 +244,8 @@ func (client Client) Put(urlPath string, reader io.Reader) (r *model.Object, err
 	dataLen := int64(len(buffer))
 
 	putExtra := storage.PutExtra{
-		Params: map[string]string{},
+		Params:   map[string]string{},
+		MimeType: fileType,
 	}
 	err = formUploader.Put(context.Background(), &ret, upToken, urlPath, bytes.NewReader(buffer), dataLen, &putExtra)
 	if err != nil {
@@ -165,9 +254,12 @@ func (client Client) Put(urlPath string, reader io.Reader) (r *model.Object, err
 
 	now := time.Now()
 	return &model.Object{
-		Path:             ret.Key,
+		Path:             "/" + ret.Key,
 		Name:             filepath.Base(urlPath),
 		LastModified:     &now,
+		Size:             dataLen,
+		ETag:             ret.Hash,
+		ContentType:      fileType,
 		StorageInterface: client,
 	}, err
 }
@@ -179,31 +271,152 @@ func (client Client) Delete(path string) error {
 
 // List list all objects under current path
 func (client Client) List(path string) (objects []*model.Object, err error) {
-	var prefix = storageKey(path)
-	var listItems []storage.ListItem
-	listItems, _, _, _, err = client.bucketManager.ListFiles(
-		client.Config.Bucket,
-		prefix,
-		"",
-		"",
-		100,
+	var (
+		prefix    = storageKey(path)
+		marker    string
+		listItems []storage.ListItem
+		hasNext   = true
 	)
 
+	for hasNext {
+		listItems, _, marker, hasNext, err = client.bucketManager.ListFiles(client.Config.Bucket, prefix, "", marker, batchLimit)
+		if err != nil {
+			return
+		}
+
+		for _, content := range listItems {
+			objects = append(objects, client.toObject(content.Key, content.Hash, content.Fsize, content.PutTime, content.MimeType))
+		}
+	}
+
+	return
+}
+
+// Pair source and destination of a copy or move operation
+type Pair struct {
+	From string
+	To   string
+}
+
+// batch run operations with as few batch requests as possible, results are in the same order as operations
+func (client Client) batch(operations []string) ([]storage.BatchOpRet, error) {
+	// batch requests are sent to CentralRsHost, which has to be the rs host of the bucket's region
+	rsHost, err := client.bucketManager.RsHost(client.Config.Bucket)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	for _, content := range listItems {
-		t := time.Unix(content.PutTime, 0)
-		objects = append(objects, &model.Object{
-			Path:             "/" + storageKey(content.Key),
-			Name:             filepath.Base(content.Key),
-			LastModified:     &t,
-			StorageInterface: client,
-		})
+	cfg := client.storageCfg
+	cfg.CentralRsHost = strings.TrimPrefix(strings.TrimPrefix(rsHost, "https://"), "http://")
+	bucketManager := storage.NewBucketManager(client.mac, &cfg)
+
+	var results []storage.BatchOpRet
+	for start := 0; start < len(operations); start += batchLimit {
+		end := start + batchLimit
+		if end > len(operations) {
+			end = len(operations)
+		}
+
+		rets, err := bucketManager.Batch(operations[start:end])
+		if err != nil {
+			return results, err
+		}
+		if len(rets) != end-start {
+			return results, fmt.Errorf("qiniu: batch returned %d results for %d operations", len(rets), end-start)
+		}
+		results = append(results, rets...)
+	}
+	return results, nil
+}
+
+func batchError(ret storage.BatchOpRet) error {
+	if ret.Code == http.StatusOK {
+		return nil
 	}
+	return &qiniuclient.ErrorInfo{Code: ret.Code, Err: ret.Data.Error}
+}
 
-	return
+// BatchStat get information of objects with batch requests, errors of objects are returned with their paths
+func (client Client) BatchStat(paths ...string) (map[string]*model.Object, map[string]error, error) {
+	operations := make([]string, len(paths))
+	for i, path := range paths {
+		operations[i] = storage.URIStat(client.Config.Bucket, storageKey(path))
+	}
+
+	rets, err := client.batch(operations)
+
+	objects, errs := map[string]*model.Object{}, map[string]error{}
+	for i, ret := range rets {
+		if err := batchError(ret); err != nil {
+			errs[paths[i]] = err
+			continue
+		}
+		objects[paths[i]] = client.toObject(storageKey(paths[i]), ret.Data.Hash, ret.Data.Fsize, ret.Data.PutTime, ret.Data.MimeType)
+	}
+	return objects, errs, err
+}
+
+// BatchDelete delete objects with batch requests, errors of objects are returned with their paths
+func (client Client) BatchDelete(paths ...string) (map[string]error, error) {
+	operations := make([]string, len(paths))
+	for i, path := range paths {
+		operations[i] = storage.URIDelete(client.Config.Bucket, storageKey(path))
+	}
+	return client.batchPaths(paths, operations)
+}
+
+// BatchCopy copy objects with batch requests, existing destinations are overwritten if force is true.
+// Errors are returned with source paths
+func (client Client) BatchCopy(pairs []Pair, force bool) (map[string]error, error) {
+	return client.batchPairs(pairs, force, storage.URICopy)
+}
+
+// BatchMove move objects with batch requests, existing destinations are overwritten if force is true.
+// Errors are returned with source paths
+func (client Client) BatchMove(pairs []Pair, force bool) (map[string]error, error) {
+	return client.batchPairs(pairs, force, storage.URIMove)
+}
+
+func (client Client) batchPairs(pairs []Pair, force bool, uri func(srcBucket, srcKey, destBucket, destKey string, force bool) string) (map[string]error, error) {
+	paths := make([]string, len(pairs))
+	operations := make([]string, len(pairs))
+	for i, pair := range pairs {
+		paths[i] = pair.From
+		operations[i] = uri(client.Config.Bucket, storageKey(pair.From), client.Config.Bucket, storageKey(pair.To), force)
+	}
+	return client.batchPaths(paths, operations)
+}
+
+func (client Client) batchPaths(paths []string, operations []string) (map[string]error, error) {
+	rets, err := client.batch(operations)
+
+	errs := map[string]error{}
+	for i, ret := range rets {
+		if err := batchError(ret); err != nil {
+			errs[paths[i]] = err
+		}
+	}
+	return errs, err
+}
+
+// Fetch let Qiniu download the resource URL and save it into given path
+func (client Client) Fetch(resURL string, path string) (*model.Object, error) {
+	key := storageKey(path)
+	ret, err := client.bucketManager.Fetch(resURL, client.Config.Bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &model.Object{
+		Path:             "/" + key,
+		Name:             filepath.Base(key),
+		LastModified:     &now,
+		Size:             ret.Fsize,
+		ETag:             ret.Hash,
+		ContentType:      ret.MimeType,
+		StorageInterface: client,
+	}, nil
 }
 
 // GetEndpoint get endpoint, FileSystem's endpoint is /
@@ -211,6 +424,17 @@ func (client Client) GetEndpoint() string {
 	return client.Config.Endpoint
 }
 
+// downloadDomain download domain of the bucket with scheme
+func (client Client) downloadDomain() string {
+	if strings.Contains(client.Config.Endpoint, "://") {
+		return client.Config.Endpoint
+	}
+	if client.Config.UseHTTPS {
+		return "https://" + client.Config.Endpoint
+	}
+	return "http://" + client.Config.Endpoint
+}
+
 var urlRegexp = regexp.MustCompile(`(https?:)?//((\w+).)+(\w+)/`)
 
 func storageKey(urlPath string) string {
@@ -231,11 +455,117 @@ func (client Client) GetURL(path string) (url string, err error) {
 
 	if client.Config.PrivateURL {
 		deadline := time.Now().Add(time.Second * 3600).Unix()
-		url = storage.MakePrivateURL(client.mac, client.Config.Endpoint, key, deadline)
+		url = storage.MakePrivateURLv2(client.mac, client.downloadDomain(), key, deadline)
 		return
 	}
 
-	url = storage.MakePublicURL(client.GetEndpoint(), key)
+	url = storage.MakePublicURLv2(client.downloadDomain(), key)
 
 	return
 }
+
+var gravities = map[model.ImageGravity]string{
+	model.GravityNorthWest: "NorthWest",
+	model.GravityNorth:     "North",
+	model.GravityNorthEast: "NorthEast",
+	model.GravityWest:      "West",
+	model.GravityCenter:    "Center",
+	model.GravityEast:      "East",
+	model.GravitySouthWest: "SouthWest",
+	model.GravitySouth:     "South",
+	model.GravitySouthEast: "SouthEast",
+}
+
+// imageFop render the image transform as imageView2, imageMogr2 and watermark operations, joined with pipes to run in order
+func (client Client) imageFop(transform model.ImageTransform) string {
+	var steps []string
+
+	if crop := transform.Crop; crop != nil {
+		steps = append(steps, fmt.Sprintf("imageMogr2/crop/!%dx%da%da%d", crop.Width, crop.Height, crop.X, crop.Y))
+	}
+
+	if transform.Width > 0 || transform.Height > 0 {
+		switch transform.Mode {
+		case model.ImageStretch:
+			steps = append(steps, fmt.Sprintf("imageMogr2/thumbnail/%dx%d!", transform.Width, transform.Height))
+		default:
+			step := "imageView2/2"
+			if transform.Mode == model.ImageFill {
+				step = "imageView2/1"
+			}
+			if transform.Width > 0 {
+				step += fmt.Sprintf("/w/%d", transform.Width)
+			}
+			if transform.Height > 0 {
+				step += fmt.Sprintf("/h/%d", transform.Height)
+			}
+			steps = append(steps, step)
+		}
+	}
+
+	if watermark := transform.Watermark; watermark != nil {
+		step := "watermark/2/text/" + base64.URLEncoding.EncodeToString([]byte(watermark.Text))
+		if watermark.Image != "" {
+			imageURL := storage.MakePublicURLv2(client.downloadDomain(), storageKey(watermark.Image))
+			step = "watermark/1/image/" + base64.URLEncoding.EncodeToString([]byte(imageURL))
+		}
+
+		gravity := gravities[watermark.Gravity]
+		if gravity == "" {
+			gravity = gravities[model.GravitySouthEast]
+		}
+		step += fmt.Sprintf("/gravity/%s/dx/%d/dy/%d", gravity, watermark.X, watermark.Y)
+		if watermark.Opacity > 0 {
+			step += fmt.Sprintf("/dissolve/%d", watermark.Opacity)
+		}
+		steps = append(steps, step)
+	}
+
+	if transform.Format != "" || transform.Quality > 0 {
+		// format and quality are merged into the last image operation if possible
+		last := len(steps) - 1
+		if last < 0 || strings.HasPrefix(steps[last], "watermark/") {
+			steps = append(steps, "imageMogr2")
+			last++
+		}
+
+		format := strings.ToLower(transform.Format)
+		if format == "jpeg" {
+			format = "jpg"
+		}
+
+		isView := strings.HasPrefix(steps[last], "imageView2")
+		if format != "" {
+			steps[last] += "/format/" + format
+		}
+		if transform.Quality > 0 {
+			if isView {
+				steps[last] += fmt.Sprintf("/q/%d", transform.Quality)
+			} else {
+				steps[last] += fmt.Sprintf("/quality/%d", transform.Quality)
+			}
+		}
+	}
+
+	return strings.Join(steps, "|")
+}
+
+// GetImageURL get URL of the image processed by Qiniu, it is signed if PrivateURL is enabled
+func (client Client) GetImageURL(path string, transform model.ImageTransform) (string, error) {
+	if err := transform.Validate(); err != nil {
+		return "", err
+	}
+
+	if transform.IsZero() {
+		return client.GetURL(path)
+	}
+
+	key := storageKey(path)
+	fop := client.imageFop(transform)
+	if client.Config.PrivateURL {
+		deadline := time.Now().Add(time.Second * 3600).Unix()
+		return storage.MakePrivateURLv2WithQueryString(client.mac, client.downloadDomain(), key, fop, deadline), nil
+	}
+
+	return storage.MakePublicURLv2(client.downloadDomain(), key) + "?" + fop, nil
+}
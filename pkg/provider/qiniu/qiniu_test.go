@@ -21,11 +21,24 @@ package qiniu_test
 // THE SOFTWARE.
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/model"
 	qiniu "github.com/bhojpur/drive/pkg/provider/qiniu"
 	"github.com/bhojpur/drive/tests"
+	"github.com/qiniu/go-sdk/v7/auth/qbox"
+	"github.com/qiniu/go-sdk/v7/storage"
 )
 
 type Config struct {
@@ -80,3 +93,455 @@ func TestAll(t *testing.T) {
 		tests.TestAll(cli, t)
 	}
 }
+
+const (
+	accessID  = "drive-test-ak"
+	accessKey = "drive-test-sk"
+	bucket    = "drive"
+)
+
+// fakeQiniu in-process fake of Qiniu up, rs, rsf, io and download hosts
+type fakeQiniu struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	mac      *qbox.Mac
+	private  bool
+	objects  map[string]*fakeObject
+	pageSize int
+	batches  int
+	policy   storage.PutPolicy
+}
+
+type fakeObject struct {
+	data     []byte
+	mimeType string
+	putTime  int64
+}
+
+func startFake(t *testing.T, private bool) *fakeQiniu {
+	fake := &fakeQiniu{mac: qbox.NewMac(accessID, accessKey), private: private, objects: map[string]*fakeObject{}, pageSize: 2}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func newClient(fake *fakeQiniu) *qiniu.Client {
+	host := strings.TrimPrefix(fake.URL, "http://")
+	return qiniu.New(&qiniu.Config{
+		AccessID:   accessID,
+		AccessKey:  accessKey,
+		Bucket:     bucket,
+		Endpoint:   host,
+		PrivateURL: fake.private,
+		Zone: &storage.Zone{
+			SrcUpHosts: []string{host},
+			CdnUpHosts: []string{host},
+			RsHost:     host,
+			RsfHost:    host,
+			ApiHost:    host,
+			IovipHost:  host,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+func decodeEntry(entry string) string {
+	data, _ := base64.URLEncoding.DecodeString(entry)
+	return strings.TrimPrefix(string(data), bucket+":")
+}
+
+func (fake *fakeQiniu) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fake.download(w, r)
+		return
+	}
+
+	if r.URL.Path == "/" {
+		fake.upload(w, r)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Qiniu "+accessID+":") && !strings.HasPrefix(auth, "QBox "+accessID+":") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "bad token"})
+		return
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	switch segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/"); segments[0] {
+	case "list":
+		fake.list(w, r)
+	case "batch":
+		r.ParseForm()
+		fake.batches++
+		code, rets := http.StatusOK, []map[string]interface{}{}
+		for _, op := range r.PostForm["op"] {
+			opCode, data := fake.operate(strings.Split(strings.TrimPrefix(op, "/"), "/"))
+			if opCode != http.StatusOK {
+				code = 298
+			}
+			rets = append(rets, map[string]interface{}{"code": opCode, "data": data})
+		}
+		writeJSON(w, code, rets)
+	case "fetch":
+		source, _ := base64.URLEncoding.DecodeString(segments[1])
+		resp, err := http.Get(string(source))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			writeJSON(w, 478, map[string]string{"error": "fetch failed"})
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		key := decodeEntry(segments[3])
+		fake.objects[key] = &fakeObject{data: data, mimeType: resp.Header.Get("Content-Type"), putTime: time.Now().UnixNano() / 100}
+		writeJSON(w, http.StatusOK, storage.FetchRet{Key: key, Fsize: int64(len(data)), Hash: "hash-" + key, MimeType: resp.Header.Get("Content-Type")})
+	default:
+		code, data := fake.operate(segments)
+		writeJSON(w, code, data)
+	}
+}
+
+// operate run stat, delete, copy and move operations
+func (fake *fakeQiniu) operate(segments []string) (int, interface{}) {
+	notFound := map[string]string{"error": "no such file or directory"}
+
+	key := decodeEntry(segments[1])
+	object, ok := fake.objects[key]
+	if !ok {
+		return 612, notFound
+	}
+
+	switch segments[0] {
+	case "stat":
+		return http.StatusOK, storage.FileInfo{Hash: "hash-" + key, Fsize: int64(len(object.data)), PutTime: object.putTime, MimeType: object.mimeType}
+	case "delete":
+		delete(fake.objects, key)
+	case "copy", "move":
+		dest := decodeEntry(segments[2])
+		if _, exists := fake.objects[dest]; exists && segments[4] != "true" {
+			return 614, map[string]string{"error": "file exists"}
+		}
+		fake.objects[dest] = object
+		if segments[0] == "move" {
+			delete(fake.objects, key)
+		}
+	}
+	return http.StatusOK, map[string]string{}
+}
+
+func (fake *fakeQiniu) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var keys []string
+	for key := range fake.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("marker") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var marker string
+	if len(keys) > fake.pageSize {
+		keys = keys[:fake.pageSize]
+		marker = keys[len(keys)-1]
+	}
+
+	items := []storage.ListItem{}
+	for _, key := range keys {
+		object := fake.objects[key]
+		items = append(items, storage.ListItem{Key: key, Hash: "hash-" + key, Fsize: int64(len(object.data)), PutTime: object.putTime, MimeType: object.mimeType})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items, "marker": marker})
+}
+
+func (fake *fakeQiniu) upload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// token is access key, signature of encoded policy and encoded policy
+	parts := strings.Split(r.FormValue("token"), ":")
+	if len(parts) != 3 || fake.mac.Sign([]byte(parts[2])) != parts[0]+":"+parts[1] {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "bad token"})
+		return
+	}
+	var policy storage.PutPolicy
+	data, _ := base64.URLEncoding.DecodeString(parts[2])
+	json.Unmarshal(data, &policy)
+
+	key := r.FormValue("key")
+	if policy.Scope != bucket && policy.Scope != bucket+":"+key {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "key doesn't match scope"})
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	content, _ := ioutil.ReadAll(file)
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.policy = policy
+	fake.objects[key] = &fakeObject{data: content, mimeType: header.Header.Get("Content-Type"), putTime: time.Now().UnixNano() / 100}
+	writeJSON(w, http.StatusOK, storage.PutRet{Key: key, Hash: "hash-" + key})
+}
+
+func (fake *fakeQiniu) download(w http.ResponseWriter, r *http.Request) {
+	if fake.private {
+		// private URLs are signed without the token parameter
+		token := r.URL.Query().Get("token")
+		signed := "http://" + r.Host + strings.TrimSuffix(r.RequestURI, "&token="+token)
+		if token == "" || fake.mac.Sign([]byte(signed)) != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "bad token")
+			return
+		}
+	}
+
+	fake.mutex.Lock()
+	object, ok := fake.objects[strings.TrimPrefix(r.URL.Path, "/")]
+	fake.mutex.Unlock()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"Document not found"}`)
+		return
+	}
+	w.Header().Set("Content-Type", object.mimeType)
+	w.Write(object.data)
+}
+
+func TestFake(t *testing.T) {
+	for _, private := range []bool{false, true} {
+		tests.TestAll(newClient(startFake(t, private)), t)
+	}
+}
+
+func TestPrivateRead(t *testing.T) {
+	fake := startFake(t, true)
+	cli := newClient(fake)
+	cli.Put("/private file.txt", strings.NewReader("secret"))
+
+	stream, err := cli.GetStream("/private file.txt")
+	if err != nil {
+		t.Fatalf("No error should happen when get private object, but got %v", err)
+	}
+	if data, _ := ioutil.ReadAll(stream); string(data) != "secret" {
+		t.Errorf("Private object should be downloaded, but got %q", data)
+	}
+
+	// downloads are signed even if URLs are not
+	public := newClient(fake)
+	public.Config.PrivateURL = false
+	if stream, err := public.GetStream("/private file.txt"); err != nil {
+		t.Errorf("Private object should be downloaded without PrivateURL, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "secret" {
+		t.Errorf("Private object should be downloaded without PrivateURL, but got %q", data)
+	}
+	if url, _ := public.GetURL("/private file.txt"); strings.Contains(url, "token=") {
+		t.Errorf("URL should not be signed without PrivateURL, but got %v", url)
+	}
+
+	if _, err := cli.GetStream("/missing.txt"); !qiniu.IsNotExist(err) {
+		t.Errorf("Missing object should be reported as not exist, but got %v", err)
+	}
+	if _, err := cli.Stat("/missing.txt"); !qiniu.IsNotExist(err) {
+		t.Errorf("Missing object should be reported as not exist when stat, but got %v", err)
+	}
+}
+
+func TestPutPolicy(t *testing.T) {
+	fake := startFake(t, false)
+	cli := newClient(fake)
+	cli.SetPutPolicy(&storage.PutPolicy{DeleteAfterDays: 7})
+
+	if _, err := cli.Put("/policy.txt", strings.NewReader("policy")); err != nil {
+		t.Fatalf("No error should happen when put with policy, but got %v", err)
+	}
+	if fake.policy.DeleteAfterDays != 7 || fake.policy.Scope != bucket+":policy.txt" {
+		t.Errorf("Put policy should be used with scope of the key, but got %+v", fake.policy)
+	}
+
+	object, err := cli.Stat("/policy.txt")
+	if err != nil || object.Size != 6 || object.ContentType != "text/plain; charset=utf-8" || object.LastModified == nil {
+		t.Errorf("Stat should report uploaded object, but got %+v, %v", object, err)
+	} else if time.Since(*object.LastModified) > time.Minute {
+		t.Errorf("Stat should report put time, but got %v", object.LastModified)
+	}
+}
+
+func TestList(t *testing.T) {
+	fake := startFake(t, false)
+	cli := newClient(fake)
+	for i := 0; i < 5; i++ {
+		cli.Put(fmt.Sprintf("/list/%d.txt", i), strings.NewReader("list"))
+	}
+	cli.Put("/other.txt", strings.NewReader("other"))
+
+	objects, err := cli.List("/list")
+	if err != nil {
+		t.Fatalf("No error should happen when list objects, but got %v", err)
+	}
+	if len(objects) != 5 {
+		t.Errorf("List should page through all objects, but got %v", len(objects))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	fake := startFake(t, false)
+	cli := newClient(fake)
+
+	var paths []string
+	for i := 0; i < 1005; i++ {
+		paths = append(paths, fmt.Sprintf("/batch/%04d.txt", i))
+		fake.objects[strings.TrimPrefix(paths[i], "/")] = &fakeObject{data: []byte("batch"), mimeType: "text/plain"}
+	}
+
+	objects, errs, err := cli.BatchStat(append(paths, "/batch/missing.txt")...)
+	if err != nil {
+		t.Fatalf("No error should happen when batch stat, but got %v", err)
+	}
+	if len(objects) != 1005 || objects["/batch/0000.txt"].Size != 5 || objects["/batch/1004.txt"].Path != "/batch/1004.txt" {
+		t.Errorf("Batch stat should return all objects, but got %v", len(objects))
+	}
+	if len(errs) != 1 || !qiniu.IsNotExist(errs["/batch/missing.txt"]) {
+		t.Errorf("Batch stat should report missing object, but got %v", errs)
+	}
+	if fake.batches != 2 {
+		t.Errorf("Batch operations should be split by 1000, but sent %v requests", fake.batches)
+	}
+
+	errs, err = cli.BatchCopy([]qiniu.Pair{{From: "/batch/0000.txt", To: "/copy.txt"}, {From: "/batch/0001.txt", To: "/copy.txt"}}, false)
+	if err != nil || len(errs) != 1 || errs["/batch/0001.txt"] == nil {
+		t.Errorf("Batch copy shouldn't overwrite without force, but got %v, %v", errs, err)
+	}
+	if errs, err = cli.BatchCopy([]qiniu.Pair{{From: "/batch/0001.txt", To: "/copy.txt"}}, true); err != nil || len(errs) != 0 {
+		t.Errorf("No error should happen when batch copy with force, but got %v, %v", errs, err)
+	}
+
+	if errs, err = cli.BatchMove([]qiniu.Pair{{From: "/batch/0002.txt", To: "/moved.txt"}}, false); err != nil || len(errs) != 0 {
+		t.Errorf("No error should happen when batch move, but got %v, %v", errs, err)
+	}
+	if _, err := cli.Stat("/batch/0002.txt"); !qiniu.IsNotExist(err) {
+		t.Errorf("Moved object should be removed from source, but got %v", err)
+	}
+	if _, err := cli.Stat("/moved.txt"); err != nil {
+		t.Errorf("Moved object should exist at destination, but got %v", err)
+	}
+
+	if errs, err = cli.BatchDelete(paths...); err != nil || len(errs) != 1 {
+		t.Errorf("Batch delete should only fail for the moved object, but got %v, %v", errs, err)
+	}
+	if objects, _ := cli.List("/batch"); len(objects) != 0 {
+		t.Errorf("All objects should be deleted, but got %v", len(objects))
+	}
+}
+
+func TestFetch(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "remote image")
+	}))
+	defer source.Close()
+
+	cli := newClient(startFake(t, true))
+	object, err := cli.Fetch(source.URL+"/logo.png", "/imported/logo.png")
+	if err != nil {
+		t.Fatalf("No error should happen when fetch, but got %v", err)
+	}
+	if object.Path != "/imported/logo.png" || object.Size != 12 || object.ContentType != "image/png" {
+		t.Errorf("Fetched object should be returned, but got %+v", object)
+	}
+
+	if stream, err := cli.GetStream("/imported/logo.png"); err != nil {
+		t.Errorf("No error should happen when get fetched object, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "remote image" {
+		t.Errorf("Fetched object should contain remote content, but got %q", data)
+	}
+
+	if _, err := cli.Fetch(source.URL+"/logo.png", "/imported/logo.png"); err != nil {
+		t.Errorf("No error should happen when fetch again, but got %v", err)
+	}
+	if _, err := cli.Fetch("http://127.0.0.1:1/missing.png", "/imported/missing.png"); err == nil {
+		t.Errorf("Fetch of unreachable URL should fail")
+	}
+}
+
+func TestRegion(t *testing.T) {
+	for _, region := range []string{"", "auto", "huadong", "Huabei", "xinjiapo", "huadong-zhejiang", "z2", "na0", "cn-east-2"} {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("Region %q should be supported, but got %v", region, r)
+				}
+			}()
+			qiniu.New(&qiniu.Config{AccessID: accessID, AccessKey: accessKey, Bucket: bucket, Region: region, Endpoint: "cdn.example.com"})
+		}()
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Unknown region should panic")
+		}
+	}()
+	qiniu.New(&qiniu.Config{AccessID: accessID, AccessKey: accessKey, Bucket: bucket, Region: "mars", Endpoint: "cdn.example.com"})
+}
+
+func TestImageURL(t *testing.T) {
+	fake := startFake(t, false)
+	cli := newClient(fake)
+
+	cases := []struct {
+		transform model.ImageTransform
+		fop       string
+	}{
+		{model.ImageTransform{Width: 200, Height: 100, Format: "webp", Quality: 80}, "imageView2/2/w/200/h/100/format/webp/q/80"},
+		{model.ImageTransform{Width: 200, Height: 100, Mode: model.ImageFill}, "imageView2/1/w/200/h/100"},
+		{model.ImageTransform{Width: 200, Height: 100, Mode: model.ImageStretch, Crop: &model.ImageCrop{X: 10, Y: 20, Width: 300, Height: 400}}, "imageMogr2/crop/!300x400a10a20|imageMogr2/thumbnail/200x100!"},
+		{model.ImageTransform{Format: "JPEG", Watermark: &model.ImageWatermark{Text: "drive", Gravity: model.GravityNorth, X: 5, Y: 6, Opacity: 50}}, "watermark/2/text/ZHJpdmU=/gravity/North/dx/5/dy/6/dissolve/50|imageMogr2/format/jpg"},
+	}
+	for _, c := range cases {
+		imageURL, err := cli.GetImageURL("/photo.png", c.transform)
+		if err != nil {
+			t.Errorf("No error should happen when get image URL, but got %v", err)
+		} else if expected := fake.URL + "/photo.png?" + c.fop; imageURL != expected {
+			t.Errorf("Image URL should be %v, but got %v", expected, imageURL)
+		}
+	}
+
+	if imageURL, _ := cli.GetImageURL("/photo.png", model.ImageTransform{}); imageURL != fake.URL+"/photo.png" {
+		t.Errorf("Empty transform should return the plain URL, but got %v", imageURL)
+	}
+	if _, err := cli.GetImageURL("/photo.png", model.ImageTransform{Width: 100, Mode: model.ImageFill}); err == nil {
+		t.Errorf("Invalid transform should fail")
+	}
+}
+
+func TestPrivateImageURL(t *testing.T) {
+	cli := newClient(startFake(t, true))
+	cli.Put("/photo.png", strings.NewReader("image"))
+
+	imageURL, err := model.GetImageURL(cli, "/photo.png", model.ImageTransform{Width: 100, Watermark: &model.ImageWatermark{Image: "/logo.png"}})
+	if err != nil {
+		t.Fatalf("No error should happen when get image URL, but got %v", err)
+	}
+	if !strings.Contains(imageURL, "imageView2/2/w/100|watermark/1/image/") || !strings.Contains(imageURL, "&token=") {
+		t.Errorf("Private image URL should be signed with operations, but got %v", imageURL)
+	}
+
+	resp, err := http.Get(imageURL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Signed image URL should be accepted, but got %v, %v", resp, err)
+	}
+}
@@ -22,6 +22,9 @@ package s3
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -36,6 +39,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
@@ -64,6 +68,11 @@ type Config struct {
 	S3ForcePathStyle bool
 	CacheControl     string
 
+	// Encryption server-side encryption of uploaded objects, customer keys are sent when reading objects too
+	Encryption *model.Encryption
+	// ObjectEncryption encryption of the object at given path, overwrites Encryption if it returns non-nil
+	ObjectEncryption func(path string) *model.Encryption
+
 	Session *session.Session
 
 	RoleARN string
@@ -88,12 +97,30 @@ func EC2RoleAwsConfig(config *Config) *aws.Config {
 	}
 }
 
+// IsNotExist report whether the error means the object doesn't exist
+func IsNotExist(err error) bool {
+	if requestErr, ok := err.(awserr.RequestFailure); ok && requestErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	awsErr, ok := err.(awserr.Error)
+	return ok && (awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound")
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
+}
+
 // New initialize S3 storage
 func New(config *Config) *Client {
 	if config.ACL == "" {
 		config.ACL = s3.BucketCannedACLPublicRead
 	}
 
+	if err := validateEncryption(config.Encryption, config.ACL); err != nil {
+		panic(err)
+	}
+
 	client := &Client{Config: config}
 
 	if config.RoleARN != "" {
@@ -135,6 +162,56 @@ func New(config *Config) *Client {
 	return client
 }
 
+// validateEncryption check S3 supports the encryption, and objects encrypted with customer keys won't be public
+func validateEncryption(encryption *model.Encryption, acl string) error {
+	if err := encryption.Validate(); err != nil {
+		return err
+	}
+	if encryption == nil {
+		return nil
+	}
+
+	if encryption.Mode == model.EncryptionStorageManaged && encryption.Algorithm != "" && strings.ToUpper(encryption.Algorithm) != s3.ServerSideEncryptionAes256 {
+		return fmt.Errorf("s3: encryption algorithm %v is not supported", encryption.Algorithm)
+	}
+	if encryption.Mode == model.EncryptionCustomerKey && acl != s3.BucketCannedACLPrivate && acl != s3.BucketCannedACLAuthenticatedRead {
+		return fmt.Errorf("s3: objects encrypted with customer keys can't be read with ACL %v", acl)
+	}
+	return nil
+}
+
+// encryption get encryption of the object with given relative path
+func (client Client) encryption(relativePath string) (*model.Encryption, error) {
+	encryption := client.Config.Encryption
+	if client.Config.ObjectEncryption != nil {
+		if objectEncryption := client.Config.ObjectEncryption("/" + strings.TrimLeft(relativePath, "/")); objectEncryption != nil {
+			encryption = objectEncryption
+		}
+	}
+	return encryption, validateEncryption(encryption, client.Config.ACL)
+}
+
+// customerKey get algorithm and key of the object at given path if it is encrypted with a customer key
+func (client Client) customerKey(path string) (algorithm *string, key *string, err error) {
+	encryption, err := client.encryption(client.ToRelativePath(path))
+	if err != nil || encryption == nil || encryption.Mode != model.EncryptionCustomerKey {
+		return nil, nil, err
+	}
+	return aws.String(s3.ServerSideEncryptionAes256), aws.String(string(encryption.CustomerKey)), nil
+}
+
+func headEncryption(headResponse *s3.HeadObjectOutput) *model.Encryption {
+	switch {
+	case headResponse.SSECustomerAlgorithm != nil:
+		return &model.Encryption{Mode: model.EncryptionCustomerKey, CustomerKeyMD5: aws.StringValue(headResponse.SSECustomerKeyMD5)}
+	case aws.StringValue(headResponse.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms:
+		return &model.Encryption{Mode: model.EncryptionKMS, KMSKeyID: aws.StringValue(headResponse.SSEKMSKeyId)}
+	case headResponse.ServerSideEncryption != nil:
+		return &model.Encryption{Mode: model.EncryptionStorageManaged, Algorithm: aws.StringValue(headResponse.ServerSideEncryption)}
+	}
+	return nil
+}
+
 // Get receive file with given path
 func (client Client) Get(path string) (file *os.File, err error) {
 	readCloser, err := client.GetStream(path)
@@ -155,16 +232,195 @@ func (client Client) Get(path string) (file *os.File, err error) {
 
 // GetStream get file as stream
 func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	algorithm, key, err := client.customerKey(path)
+	if err != nil {
+		return nil, err
+	}
+
 	getResponse, err := client.S3.GetObject(&s3.GetObjectInput{
+		Bucket:               aws.String(client.Config.Bucket),
+		Key:                  aws.String(client.ToRelativePath(path)),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return getResponse.Body, err
+}
+
+func (client Client) headObject(path string) (*s3.HeadObjectOutput, error) {
+	algorithm, key, err := client.customerKey(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket:               aws.String(client.Config.Bucket),
+		Key:                  aws.String(client.ToRelativePath(path)),
+		SSECustomerAlgorithm: algorithm,
+		SSECustomerKey:       key,
+	})
+}
+
+// Stat get object's information, including its object lock retention and server-side encryption
+func (client Client) Stat(path string) (*model.Object, error) {
+	headResponse, err := client.headObject(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := client.ToRelativePath(path)
+	return &model.Object{
+		Path:             objectPath(key),
+		Name:             filepath.Base(key),
+		LastModified:     headResponse.LastModified,
+		Size:             aws.Int64Value(headResponse.ContentLength),
+		ETag:             strings.Trim(aws.StringValue(headResponse.ETag), `"`),
+		ContentType:      aws.StringValue(headResponse.ContentType),
+		Retention:        headRetention(headResponse),
+		Encryption:       headEncryption(headResponse),
+		StorageInterface: client,
+	}, nil
+}
+
+func headRetention(headResponse *s3.HeadObjectOutput) *model.Retention {
+	if headResponse.ObjectLockMode == nil && headResponse.ObjectLockRetainUntilDate == nil && headResponse.ObjectLockLegalHoldStatus == nil {
+		return nil
+	}
+
+	return &model.Retention{
+		Mode:        model.RetentionMode(aws.StringValue(headResponse.ObjectLockMode)),
+		RetainUntil: headResponse.ObjectLockRetainUntilDate,
+		LegalHold:   aws.StringValue(headResponse.ObjectLockLegalHoldStatus) == s3.ObjectLockLegalHoldStatusOn,
+	}
+}
+
+// GetRetention get object lock retention and legal hold of the object, return nil if the object doesn't exist
+func (client Client) GetRetention(path string) (*model.Retention, error) {
+	headResponse, err := client.headObject(path)
+	if err != nil {
+		if requestErr, ok := err.(awserr.RequestFailure); ok && requestErr.StatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return headRetention(headResponse), nil
+}
+
+// SetRetention set object lock retention of the object, the bucket should be created with object lock enabled
+func (client Client) SetRetention(path string, mode model.RetentionMode, retainUntil time.Time, bypassGovernance bool) error {
+	_, err := client.S3.PutObjectRetention(&s3.PutObjectRetentionInput{
 		Bucket: aws.String(client.Config.Bucket),
 		Key:    aws.String(client.ToRelativePath(path)),
+		Retention: &s3.ObjectLockRetention{
+			Mode:            aws.String(string(mode)),
+			RetainUntilDate: aws.Time(retainUntil),
+		},
+		BypassGovernanceRetention: aws.Bool(bypassGovernance),
 	})
+	return err
+}
 
-	return getResponse.Body, err
+// SetLegalHold place or release object lock legal hold of the object
+func (client Client) SetLegalHold(path string, hold bool) error {
+	status := s3.ObjectLockLegalHoldStatusOff
+	if hold {
+		status = s3.ObjectLockLegalHoldStatusOn
+	}
+
+	_, err := client.S3.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(client.Config.Bucket),
+		Key:       aws.String(client.ToRelativePath(path)),
+		LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+	})
+	return err
+}
+
+// GetLifecycleRules get lifecycle rules of the bucket
+func (client Client) GetLifecycleRules() ([]model.LifecycleRule, error) {
+	output, err := client.S3.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(client.Config.Bucket),
+	})
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var rules []model.LifecycleRule
+	for _, s3Rule := range output.Rules {
+		rule := model.LifecycleRule{
+			ID:       aws.StringValue(s3Rule.ID),
+			Prefix:   "/" + aws.StringValue(s3Rule.Prefix),
+			Disabled: aws.StringValue(s3Rule.Status) != s3.ExpirationStatusEnabled,
+		}
+		if s3Rule.Filter != nil && s3Rule.Filter.Prefix != nil {
+			rule.Prefix = "/" + aws.StringValue(s3Rule.Filter.Prefix)
+		}
+		if s3Rule.Expiration != nil {
+			rule.ExpirationDays = int(aws.Int64Value(s3Rule.Expiration.Days))
+		}
+		if len(s3Rule.Transitions) > 0 {
+			rule.TransitionDays = int(aws.Int64Value(s3Rule.Transitions[0].Days))
+			rule.TransitionStorageClass = aws.StringValue(s3Rule.Transitions[0].StorageClass)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetLifecycleRules replace lifecycle rules of the bucket, S3 only supports prefix filters, expiration and transition to storage classes
+func (client Client) SetLifecycleRules(rules []model.LifecycleRule) error {
+	if len(rules) == 0 {
+		_, err := client.S3.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{Bucket: aws.String(client.Config.Bucket)})
+		return err
+	}
+
+	var s3Rules []*s3.LifecycleRule
+	for _, rule := range rules {
+		if rule.Suffix != "" || rule.MaxVersions > 0 || rule.TransitionTarget != "" {
+			return fmt.Errorf("lifecycle rule %v is not supported by S3", rule.ID)
+		}
+
+		s3Rule := &s3.LifecycleRule{
+			ID:     aws.String(rule.ID),
+			Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(strings.TrimPrefix(rule.Prefix, "/"))},
+			Status: aws.String(s3.ExpirationStatusEnabled),
+		}
+		if rule.Disabled {
+			s3Rule.Status = aws.String(s3.ExpirationStatusDisabled)
+		}
+		if rule.ExpirationDays > 0 {
+			s3Rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(int64(rule.ExpirationDays))}
+		}
+		if rule.TransitionDays > 0 {
+			s3Rule.Transitions = []*s3.Transition{{Days: aws.Int64(int64(rule.TransitionDays)), StorageClass: aws.String(rule.TransitionStorageClass)}}
+		}
+		s3Rules = append(s3Rules, s3Rule)
+	}
+
+	_, err := client.S3.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(client.Config.Bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{Rules: s3Rules},
+	})
+	return err
 }
 
 // Put store a reader into given path
 func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	return client.put(urlPath, reader, nil)
+}
+
+// PutWithRetention store a reader into given path with object lock retention, it fails without saving the object
+// if the bucket doesn't have object lock enabled
+func (client Client) PutWithRetention(urlPath string, reader io.Reader, mode model.RetentionMode, retainUntil time.Time) (*model.Object, error) {
+	return client.put(urlPath, reader, &model.Retention{Mode: mode, RetainUntil: &retainUntil})
+}
+
+func (client Client) put(urlPath string, reader io.Reader, retention *model.Retention) (*model.Object, error) {
 	if seeker, ok := reader.(io.ReadSeeker); ok {
 		seeker.Seek(0, 0)
 	}
@@ -188,6 +444,32 @@ func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error
 	if client.Config.CacheControl != "" {
 		params.CacheControl = aws.String(client.Config.CacheControl)
 	}
+	if retention != nil {
+		// object lock parameters require the Content-MD5 header
+		checksum := md5.Sum(buffer)
+		params.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(checksum[:]))
+		params.ObjectLockMode = aws.String(string(retention.Mode))
+		params.ObjectLockRetainUntilDate = retention.RetainUntil
+	}
+
+	encryption, err := client.encryption(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	if encryption != nil {
+		switch encryption.Mode {
+		case model.EncryptionStorageManaged:
+			params.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAes256)
+		case model.EncryptionKMS:
+			params.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+			if encryption.KMSKeyID != "" {
+				params.SSEKMSKeyId = aws.String(encryption.KMSKeyID)
+			}
+		case model.EncryptionCustomerKey:
+			params.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+			params.SSECustomerKey = aws.String(string(encryption.CustomerKey))
+		}
+	}
 
 	_, err = client.S3.PutObject(params)
 
@@ -240,21 +522,23 @@ func (client Client) List(path string) ([]*model.Object, error) {
 		prefix = strings.Trim(path, "/") + "/"
 	}
 
-	listObjectsResponse, err := client.S3.ListObjectsV2(&s3.ListObjectsV2Input{
+	// each response returns at most 1000 keys, follow continuation tokens until all objects are listed
+	err := client.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(client.Config.Bucket),
 		Prefix: aws.String(prefix),
-	})
-
-	if err == nil {
-		for _, content := range listObjectsResponse.Contents {
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, content := range page.Contents {
 			objects = append(objects, &model.Object{
-				Path:             client.ToRelativePath(*content.Key),
+				Path:             objectPath(*content.Key),
 				Name:             filepath.Base(*content.Key),
 				LastModified:     content.LastModified,
+				Size:             aws.Int64Value(content.Size),
+				ETag:             strings.Trim(aws.StringValue(content.ETag), `"`),
 				StorageInterface: client,
 			})
 		}
-	}
+		return true
+	})
 
 	return objects, err
 }
@@ -275,6 +559,11 @@ func (client Client) GetEndpoint() string {
 
 var urlRegexp = regexp.MustCompile(`(https?:)?//((\w+).)+(\w+)/`)
 
+// objectPath path of objects returned by Stat and List, keys are used as they are, with a leading slash like other storages
+func objectPath(key string) string {
+	return "/" + strings.TrimPrefix(key, "/")
+}
+
 // ToRelativePath process path to relative path
 func (client Client) ToRelativePath(urlPath string) string {
 	if urlRegexp.MatchString(urlPath) {
@@ -292,8 +581,17 @@ func (client Client) ToRelativePath(urlPath string) string {
 	return "/" + strings.TrimPrefix(urlPath, "/")
 }
 
+// ErrCustomerKeyURL returned by GetURL for objects encrypted with customer keys, which have to be read with the key in headers
+var ErrCustomerKeyURL = errors.New("s3: objects encrypted with customer keys can't be read with URLs")
+
 // GetURL get public accessible URL
 func (client Client) GetURL(path string) (url string, err error) {
+	if _, key, err := client.customerKey(path); err != nil {
+		return "", err
+	} else if key != nil {
+		return "", ErrCustomerKeyURL
+	}
+
 	if client.Endpoint == "" {
 		if client.Config.ACL == s3.BucketCannedACLPrivate || client.Config.ACL == s3.BucketCannedACLAuthenticatedRead {
 			getResponse, _ := client.S3.GetObjectRequest(&s3.GetObjectInput{
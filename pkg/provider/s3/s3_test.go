@@ -21,11 +21,23 @@ package s3_test
 // THE SOFTWARE.
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	awss3 "github.com/aws/aws-sdk-go/service/s3"
 	cfgsvr "github.com/bhojpur/configure/pkg/markup"
+	"github.com/bhojpur/drive/pkg/model"
 	"github.com/bhojpur/drive/pkg/provider/s3"
 	"github.com/bhojpur/drive/tests"
 )
@@ -96,3 +108,171 @@ func TestToRelativePathWithS3ForcePathStyle(t *testing.T) {
 		}
 	}
 }
+
+// encryptedS3 in-process fake of S3 over TLS, which stores encryption headers of objects and
+// requires the customer key to read objects encrypted with it
+type encryptedS3 struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	objects map[string]*encryptedObject
+}
+
+type encryptedObject struct {
+	data   []byte
+	header http.Header
+}
+
+var encryptionHeaders = []string{
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+	"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+	"X-Amz-Server-Side-Encryption-Customer-Key-Md5",
+}
+
+func startEncryptedS3(t *testing.T) *encryptedS3 {
+	fake := &encryptedS3{objects: map[string]*encryptedObject{}}
+	fake.Server = httptest.NewTLSServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (fake *encryptedS3) serve(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if key := r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key"); key != "" {
+		decoded, _ := base64.StdEncoding.DecodeString(key)
+		sum := md5.Sum(decoded)
+		if base64.StdEncoding.EncodeToString(sum[:]) != r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		object := &encryptedObject{data: data, header: http.Header{}}
+		for _, name := range encryptionHeaders {
+			if value := r.Header.Get(name); value != "" {
+				object.header.Set(name, value)
+			}
+		}
+		fake.objects[r.URL.Path] = object
+	case http.MethodGet, http.MethodHead:
+		object, ok := fake.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if md5 := object.header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"); md5 != "" && md5 != r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for name, values := range object.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(object.data)))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	}
+}
+
+func newEncryptedClient(fake *encryptedS3, config s3.Config) *s3.Client {
+	config.Bucket = "drive"
+	config.Region = "us-east-1"
+	config.S3Endpoint = fake.URL
+	config.S3ForcePathStyle = true
+	config.Session = session.Must(session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "key", ""),
+	}))
+	// set the client after the session is created, which would load AWS_CA_BUNDLE into it
+	config.Session.Config.HTTPClient = fake.Client()
+	return s3.New(&config)
+}
+
+func TestEncryption(t *testing.T) {
+	fake := startEncryptedS3(t)
+	customerKey := bytes.Repeat([]byte("k"), 32)
+	config := s3.Config{
+		ACL:        awss3.BucketCannedACLPrivate,
+		Encryption: &model.Encryption{Mode: model.EncryptionKMS, KMSKeyID: "drive-key"},
+		ObjectEncryption: func(path string) *model.Encryption {
+			if strings.HasPrefix(path, "/secret/") {
+				return &model.Encryption{Mode: model.EncryptionCustomerKey, CustomerKey: customerKey}
+			}
+			return nil
+		},
+	}
+	client := newEncryptedClient(fake, config)
+
+	if _, err := client.Put("/report.txt", strings.NewReader("report")); err != nil {
+		t.Fatalf("No error should happen when put with KMS encryption, but got %v", err)
+	}
+	if object, err := client.Stat("/report.txt"); err != nil {
+		t.Errorf("No error should happen when stat, but got %v", err)
+	} else if object.Encryption == nil || object.Encryption.Mode != model.EncryptionKMS || object.Encryption.KMSKeyID != "drive-key" {
+		t.Errorf("Stat should report KMS encryption, but got %+v", object.Encryption)
+	}
+
+	if _, err := client.Put("/secret/key.txt", strings.NewReader("secret")); err != nil {
+		t.Fatalf("No error should happen when put with customer key, but got %v", err)
+	}
+	if stream, err := client.GetStream("/secret/key.txt"); err != nil {
+		t.Errorf("No error should happen when get object with customer key, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "secret" {
+		t.Errorf("Object encrypted with customer key should be read, but got %q", data)
+	}
+
+	sum := md5.Sum(customerKey)
+	if object, err := client.Stat("/secret/key.txt"); err != nil {
+		t.Errorf("No error should happen when stat object with customer key, but got %v", err)
+	} else if object.Encryption == nil || object.Encryption.Mode != model.EncryptionCustomerKey || object.Encryption.CustomerKeyMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Errorf("Stat should report customer key encryption, but got %+v", object.Encryption)
+	}
+
+	if _, err := client.GetURL("/secret/key.txt"); err != s3.ErrCustomerKeyURL {
+		t.Errorf("Objects encrypted with customer keys shouldn't have URLs, but got %v", err)
+	}
+
+	plain := newEncryptedClient(fake, s3.Config{ACL: awss3.BucketCannedACLPrivate})
+	if _, err := plain.GetStream("/secret/key.txt"); err == nil {
+		t.Errorf("Object encrypted with customer key shouldn't be read without the key")
+	}
+
+	sse := newEncryptedClient(fake, s3.Config{Encryption: &model.Encryption{Mode: model.EncryptionStorageManaged}})
+	sse.Put("/public.txt", strings.NewReader("public"))
+	if object, err := sse.Stat("/public.txt"); err != nil || object.Encryption == nil || object.Encryption.Algorithm != "AES256" {
+		t.Errorf("Stat should report storage managed encryption, but got %+v, %v", object, err)
+	}
+}
+
+func TestEncryptionValidation(t *testing.T) {
+	invalid := []s3.Config{
+		{Encryption: &model.Encryption{Mode: model.EncryptionStorageManaged, KMSKeyID: "key"}},
+		{Encryption: &model.Encryption{Mode: model.EncryptionStorageManaged, Algorithm: "SM4"}},
+		{Encryption: &model.Encryption{Mode: "unknown"}},
+		{ACL: awss3.BucketCannedACLPrivate, Encryption: &model.Encryption{Mode: model.EncryptionCustomerKey, CustomerKey: []byte("short")}},
+		{ACL: awss3.BucketCannedACLPublicRead, Encryption: &model.Encryption{Mode: model.EncryptionCustomerKey, CustomerKey: bytes.Repeat([]byte("k"), 32)}},
+	}
+
+	for _, config := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Invalid encryption %+v should panic", config.Encryption)
+				}
+			}()
+			config := config
+			s3.New(&config)
+		}()
+	}
+
+	client := newEncryptedClient(startEncryptedS3(t), s3.Config{ObjectEncryption: func(string) *model.Encryption {
+		return &model.Encryption{Mode: model.EncryptionKMS, CustomerKey: []byte("key")}
+	}})
+	if _, err := client.Put("/invalid.txt", strings.NewReader("invalid")); err == nil {
+		t.Errorf("Invalid object encryption should fail")
+	}
+}
@@ -0,0 +1,176 @@
+package scope
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var _ model.StorageInterface = (*Client)(nil)
+
+// ErrOutOfScope returned when a path tries to escape the storage scope
+var ErrOutOfScope = errors.New("path is outside of the storage scope")
+
+// Client scoped storage, roots all operations of the underlying storage under a prefix
+type Client struct {
+	Storage model.StorageInterface
+	Prefix  string
+}
+
+// New initialize scoped storage, panics if prefix can't be used as a scope
+func New(storage model.StorageInterface, prefix string) *Client {
+	cleaned, err := CleanPath(prefix)
+	if err != nil || cleaned == "" {
+		panic(fmt.Sprintf("scope prefix %q is invalid", prefix))
+	}
+
+	return &Client{Storage: storage, Prefix: cleaned}
+}
+
+// CleanPath normalise a storage key, e.g. "a//b/./c/" => "a/b/c", and reject traversal attempts like "a/../b"
+func CleanPath(urlPath string) (string, error) {
+	if strings.ContainsRune(urlPath, 0) {
+		return "", ErrOutOfScope
+	}
+
+	var segments []string
+	for _, segment := range strings.FieldsFunc(urlPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		switch segment {
+		case ".":
+		case "..":
+			return "", ErrOutOfScope
+		default:
+			segments = append(segments, segment)
+		}
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// ToStoragePath convert a path inside of the scope to the path of the underlying storage
+func (client Client) ToStoragePath(urlPath string) (string, error) {
+	cleaned, err := CleanPath(urlPath)
+	if err != nil {
+		return "", err
+	}
+	return "/" + path.Join(client.Prefix, cleaned), nil
+}
+
+// FromStoragePath convert a path of the underlying storage to the path inside of the scope
+func (client Client) FromStoragePath(storagePath string) (string, error) {
+	cleaned, err := CleanPath(storagePath)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(cleaned, client.Prefix+"/") {
+		return "", ErrOutOfScope
+	}
+	return "/" + strings.TrimPrefix(cleaned, client.Prefix+"/"), nil
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (*os.File, error) {
+	storagePath, err := client.ToStoragePath(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.Storage.Get(storagePath)
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(urlPath string) (io.ReadCloser, error) {
+	storagePath, err := client.ToStoragePath(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.Storage.GetStream(storagePath)
+}
+
+// Put store a reader into given path
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	storagePath, err := client.ToStoragePath(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := client.Storage.Put(storagePath, reader)
+	if object != nil {
+		object = client.toScopedObject(object, strings.TrimPrefix(storagePath, "/"+client.Prefix))
+	}
+	return object, err
+}
+
+// Delete delete file
+func (client Client) Delete(urlPath string) error {
+	storagePath, err := client.ToStoragePath(urlPath)
+	if err != nil {
+		return err
+	}
+	return client.Storage.Delete(storagePath)
+}
+
+// List list all objects under current path, objects outside of the scope are skipped
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	storagePath, err := client.ToStoragePath(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := client.Storage.List(storagePath)
+
+	var objects []*model.Object
+	for _, object := range results {
+		if scopedPath, err := client.FromStoragePath(object.Path); err == nil {
+			objects = append(objects, client.toScopedObject(object, scopedPath))
+		}
+	}
+
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage, joined with the scope prefix
+func (client Client) GetEndpoint() string {
+	return path.Join(client.Storage.GetEndpoint(), client.Prefix)
+}
+
+// GetURL get public accessible URL
+func (client Client) GetURL(urlPath string) (string, error) {
+	storagePath, err := client.ToStoragePath(urlPath)
+	if err != nil {
+		return "", err
+	}
+	return client.Storage.GetURL(storagePath)
+}
+
+func (client Client) toScopedObject(object *model.Object, scopedPath string) *model.Object {
+	scoped := *object
+	scoped.Path = scopedPath
+	scoped.StorageInterface = client
+	return &scoped
+}
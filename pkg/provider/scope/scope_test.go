@@ -0,0 +1,89 @@
+package scope_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/scope"
+	"github.com/bhojpur/drive/tests"
+)
+
+func TestAll(t *testing.T) {
+	tests.TestAll(scope.New(filesystem.New(t.TempDir()), "tenant"), t)
+}
+
+func TestCleanPath(t *testing.T) {
+	pathMap := map[string]string{
+		"/a/b/c.txt":   "a/b/c.txt",
+		"a//b/./c.txt": "a/b/c.txt",
+		`a\b\c.txt`:    "a/b/c.txt",
+		"/":            "",
+	}
+
+	for urlPath, cleaned := range pathMap {
+		if result, err := scope.CleanPath(urlPath); err != nil || result != cleaned {
+			t.Errorf("%v should be cleaned to %v, but got %v, %v", urlPath, cleaned, result, err)
+		}
+	}
+
+	for _, urlPath := range []string{"../other/a.txt", "/a/../../other/a.txt", `a\..\..\b`, "a/\x00/b"} {
+		if _, err := scope.CleanPath(urlPath); err != scope.ErrOutOfScope {
+			t.Errorf("%v should be rejected, but got %v", urlPath, err)
+		}
+	}
+}
+
+func TestTraversal(t *testing.T) {
+	base := filesystem.New(t.TempDir())
+	tenant := scope.New(base, "tenant")
+	other := scope.New(base, "other")
+
+	if _, err := other.Put("/secret.txt", strings.NewReader("secret")); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+
+	for _, urlPath := range []string{"../other/secret.txt", "/a/../../other/secret.txt"} {
+		if _, err := tenant.GetStream(urlPath); err != scope.ErrOutOfScope {
+			t.Errorf("GetStream %v should be rejected, but got %v", urlPath, err)
+		}
+		if _, err := tenant.GetURL(urlPath); err != scope.ErrOutOfScope {
+			t.Errorf("GetURL %v should be rejected, but got %v", urlPath, err)
+		}
+		if err := tenant.Delete(urlPath); err != scope.ErrOutOfScope {
+			t.Errorf("Delete %v should be rejected, but got %v", urlPath, err)
+		}
+	}
+
+	if object, err := tenant.Put("/a/./b.txt", strings.NewReader("b")); err != nil {
+		t.Errorf("No error should happen when save file, but got %v", err)
+	} else if object.Path != "/a/b.txt" {
+		t.Errorf("returned object path should be /a/b.txt, but got %v", object.Path)
+	}
+
+	if objects, err := tenant.List("/"); err != nil {
+		t.Errorf("No error should happen when list objects, but got %v", err)
+	} else if len(objects) != 1 || objects[0].Path != "/a/b.txt" {
+		t.Errorf("Should only found /a/b.txt, but got %v", objects)
+	}
+}
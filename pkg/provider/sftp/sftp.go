@@ -0,0 +1,296 @@
+package sftp
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// Config SFTP client config
+type Config struct {
+	// Host address of the server, e.g. "sftp.example.com:22"
+	Host string
+	User string
+
+	// Password and/or PrivateKey (PEM) used to authenticate, the key could be encrypted with Passphrase
+	Password   string
+	PrivateKey []byte
+	Passphrase string
+
+	// KnownHostsFile verify the server's host key with an OpenSSH known_hosts file
+	KnownHostsFile string
+	// HostKeyCallback verify the server's host key, overrides KnownHostsFile
+	HostKeyCallback ssh.HostKeyCallback
+	// InsecureIgnoreHostKey accept any host key, only for testing
+	InsecureIgnoreHostKey bool
+
+	// Root directory on the server where objects are saved
+	Root string
+	// Endpoint public URL of Root, if files are also served over HTTP
+	Endpoint string
+
+	// Timeout of establishing the connection, default to 30s
+	Timeout time.Duration
+}
+
+// Client SFTP storage
+type Client struct {
+	*sftp.Client
+	SSH    *ssh.Client
+	Config *Config
+}
+
+// New connect to the SFTP server
+func New(config *Config) (*Client, error) {
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	var auths []ssh.AuthMethod
+	if len(config.PrivateKey) > 0 {
+		var (
+			signer ssh.Signer
+			err    error
+		)
+		if config.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(config.PrivateKey, []byte(config.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(config.PrivateKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if config.Password != "" {
+		auths = append(auths, ssh.Password(config.Password))
+	}
+
+	hostKeyCallback := config.HostKeyCallback
+	if hostKeyCallback == nil {
+		switch {
+		case config.KnownHostsFile != "":
+			callback, err := knownhosts.New(config.KnownHostsFile)
+			if err != nil {
+				return nil, err
+			}
+			hostKeyCallback = callback
+		case config.InsecureIgnoreHostKey:
+			hostKeyCallback = ssh.InsecureIgnoreHostKey()
+		default:
+			return nil, errors.New("sftp host key verification requires KnownHostsFile or HostKeyCallback")
+		}
+	}
+
+	sshClient, err := ssh.Dial("tcp", config.Host, &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         config.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+
+	return &Client{Client: sftpClient, SSH: sshClient, Config: config}, nil
+}
+
+// Close close the SFTP session and the SSH connection
+func (client Client) Close() error {
+	client.Client.Close()
+	return client.SSH.Close()
+}
+
+// ToRemotePath get the path on the server
+func (client Client) ToRemotePath(urlPath string) string {
+	return path.Join("/", client.Config.Root, urlPath)
+}
+
+func (client Client) toPath(remotePath string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(remotePath, path.Join("/", client.Config.Root)), "/")
+}
+
+// Get receive file with given path
+func (client Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "sftp"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(path string) (io.ReadCloser, error) {
+	return client.Client.Open(client.ToRemotePath(path))
+}
+
+func (client Client) toObject(remotePath string, info os.FileInfo) *model.Object {
+	modTime := info.ModTime()
+	return &model.Object{
+		Path:             client.toPath(remotePath),
+		Name:             info.Name(),
+		LastModified:     &modTime,
+		Size:             info.Size(),
+		ContentType:      mime.TypeByExtension(path.Ext(remotePath)),
+		StorageInterface: client,
+	}
+}
+
+// Stat get object's information
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	remotePath := client.ToRemotePath(urlPath)
+	info, err := client.Client.Stat(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &os.PathError{Op: "stat", Path: urlPath, Err: errors.New("is a directory")}
+	}
+	return client.toObject(remotePath, info), nil
+}
+
+// Put store a reader into given path, parent directories are created if they don't exist. The content is uploaded to a
+// temporary file which is renamed to the path when completed, so a failed upload never leaves a partial file
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	remotePath := client.ToRemotePath(urlPath)
+	if err := client.Client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	tempPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".part-"+hex.EncodeToString(nonce))
+
+	file, err := client.Client.Create(tempPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.ReadFrom(reader); err != nil {
+		file.Close()
+		client.Client.Remove(tempPath)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		client.Client.Remove(tempPath)
+		return nil, err
+	}
+
+	if err := client.rename(tempPath, remotePath); err != nil {
+		client.Client.Remove(tempPath)
+		return nil, err
+	}
+
+	info, err := client.Client.Stat(remotePath)
+	if err != nil {
+		return nil, err
+	}
+	return client.toObject(remotePath, info), nil
+}
+
+// rename replace the target atomically with the posix-rename extension, falls back to remove and rename on servers without it
+func (client Client) rename(from, to string) error {
+	if _, ok := client.Client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.Client.PosixRename(from, to)
+	}
+
+	if err := client.Client.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return client.Client.Rename(from, to)
+}
+
+// Delete delete file
+func (client Client) Delete(path string) error {
+	return client.Client.Remove(client.ToRemotePath(path))
+}
+
+// List list all objects under current path recursively
+func (client Client) List(path string) ([]*model.Object, error) {
+	var objects []*model.Object
+
+	walker := client.Client.Walk(client.ToRemotePath(path))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return objects, err
+		}
+
+		info := walker.Stat()
+		if info.IsDir() || (strings.HasPrefix(info.Name(), ".") && strings.Contains(info.Name(), ".part-")) {
+			continue
+		}
+		objects = append(objects, client.toObject(walker.Path(), info))
+	}
+	return objects, nil
+}
+
+// GetEndpoint get endpoint, default to the server's host
+func (client Client) GetEndpoint() string {
+	if client.Config.Endpoint != "" {
+		return client.Config.Endpoint
+	}
+	return client.Config.Host
+}
+
+// GetURL get public accessible URL if Endpoint is set, otherwise return the path like FileSystem does
+func (client Client) GetURL(path string) (string, error) {
+	if client.Config.Endpoint != "" {
+		return strings.TrimSuffix(client.Config.Endpoint, "/") + "/" + strings.TrimPrefix(path, "/"), nil
+	}
+	return path, nil
+}
@@ -0,0 +1,197 @@
+package sftp_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/provider/sftp"
+	"github.com/bhojpur/drive/tests"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type server struct {
+	addr       string
+	root       string
+	knownHosts string
+	clientKey  []byte
+}
+
+// startServer start an in-process SFTP server serving the local file system, which accepts password "secret" and the generated client key
+func startServer(t *testing.T) *server {
+	_, hostPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, _ := ssh.NewSignerFromKey(hostPrivate)
+
+	clientPublic, clientPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	authorized, _ := ssh.NewPublicKey(clientPublic)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "partner" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "partner" && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, config)
+		}
+	}()
+
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{listener.Addr().String()}, hostSigner.PublicKey())+"\n"), 0600)
+
+	der, _ := x509.MarshalPKCS8PrivateKey(clientPrivate)
+	clientKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return &server{addr: listener.Addr().String(), root: t.TempDir(), knownHosts: knownHosts, clientKey: clientKey}
+}
+
+func serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if server, err := pkgsftp.NewServer(channel); err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func newClient(t *testing.T, s *server, config *sftp.Config) *sftp.Client {
+	config.Host = s.addr
+	config.User = "partner"
+	config.Root = s.root
+	client, err := sftp.New(config)
+	if err != nil {
+		t.Fatalf("No error should happen when connect, but got %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestAll(t *testing.T) {
+	s := startServer(t)
+	tests.TestAll(newClient(t, s, &sftp.Config{Password: "secret", KnownHostsFile: s.knownHosts}), t)
+}
+
+func TestAuth(t *testing.T) {
+	s := startServer(t)
+	newClient(t, s, &sftp.Config{PrivateKey: s.clientKey, KnownHostsFile: s.knownHosts})
+
+	if _, err := sftp.New(&sftp.Config{Host: s.addr, User: "partner", Password: "wrong", KnownHostsFile: s.knownHosts}); err == nil {
+		t.Errorf("Wrong password should be rejected")
+	}
+
+	other := startServer(t)
+	if _, err := sftp.New(&sftp.Config{Host: s.addr, User: "partner", Password: "secret", KnownHostsFile: other.knownHosts}); err == nil {
+		t.Errorf("Unknown host key should be rejected")
+	}
+
+	if _, err := sftp.New(&sftp.Config{Host: s.addr, User: "partner", Password: "secret"}); err == nil {
+		t.Errorf("Host key verification should be required")
+	}
+}
+
+func TestPutAndList(t *testing.T) {
+	s := startServer(t)
+	client := newClient(t, s, &sftp.Config{Password: "secret", KnownHostsFile: s.knownHosts})
+
+	for _, path := range []string{"/inbox/2022/a.csv", "/inbox/b.csv", "/outbox/c.csv"} {
+		if _, err := client.Put(path, strings.NewReader(path)); err != nil {
+			t.Fatalf("No error should happen when save file, but got %v", err)
+		}
+	}
+	client.Put("/inbox/b.csv", strings.NewReader("updated"))
+
+	objects, err := client.List("/inbox")
+	if err != nil || len(objects) != 2 {
+		t.Fatalf("List should return files under the path recursively, but got %v, %v", objects, err)
+	}
+	for _, object := range objects {
+		if object.Path != "/inbox/2022/a.csv" && object.Path != "/inbox/b.csv" {
+			t.Errorf("Unexpected object %v", object.Path)
+		}
+	}
+
+	if object, err := client.Stat("/inbox/b.csv"); err != nil || object.Size != int64(len("updated")) || object.LastModified == nil {
+		t.Errorf("Stat should report size and modified time, but got %+v, %v", object, err)
+	}
+	if _, err := client.Stat("/inbox"); err == nil {
+		t.Errorf("Stat of a directory should fail")
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(s.root, "inbox", "b.csv")); err != nil || string(data) != "updated" {
+		t.Errorf("File should be written under root, but got %q, %v", data, err)
+	}
+}
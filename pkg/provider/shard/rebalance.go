@@ -0,0 +1,97 @@
+package shard
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sort"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+// Move a move of an object from the backend it is stored in to the backend it belongs to
+type Move struct {
+	Path string
+	From string
+	To   string
+}
+
+// Plan return moves of objects under the path, which are not stored in the backend they belong to with the current config
+func (client Client) Plan(urlPath string) ([]Move, error) {
+	results, err := client.listAll(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var moves []Move
+	for name, objects := range results {
+		for _, object := range objects {
+			objectPath := "/" + cleanKey(object.Path)
+			if to := client.Locate(objectPath); to != name {
+				moves = append(moves, Move{Path: objectPath, From: name, To: to})
+			}
+		}
+	}
+
+	sort.Slice(moves, func(i, j int) bool {
+		if moves[i].Path != moves[j].Path {
+			return moves[i].Path < moves[j].Path
+		}
+		return moves[i].From < moves[j].From
+	})
+	return moves, nil
+}
+
+// Rebalance move objects under the path to the backend they belong to after the config changed. An object is only copied
+// if the destination doesn't have it yet, as it might have been written after the change, then it is deleted from the
+// source. Set Config.Previous to the old config while rebalancing, so lookups of objects not moved yet succeed.
+// Objects failed to be moved are reported with their errors
+func (client Client) Rebalance(urlPath string) ([]Move, map[string]error, error) {
+	plan, err := client.Plan(urlPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		moves []Move
+		errs  = map[string]error{}
+	)
+	for _, move := range plan {
+		if err := client.move(move); err != nil {
+			errs[move.Path] = err
+		} else {
+			moves = append(moves, move)
+		}
+	}
+	return moves, errs, nil
+}
+
+func (client Client) move(move Move) error {
+	from, to := client.Backends[move.From], client.Backends[move.To]
+
+	if ok, err := model.Exists(to, move.Path); err != nil {
+		return err
+	} else if !ok {
+		if err := model.Copy(from, to, move.Path); err != nil {
+			return err
+		}
+	}
+	return from.Delete(move.Path)
+}
@@ -0,0 +1,340 @@
+package shard
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// Rule route keys under Prefix to Backend, e.g. {Prefix: "images/", Backend: "images"}
+type Rule struct {
+	Prefix  string
+	Backend string
+}
+
+// Config placement of keys on backends
+type Config struct {
+	// Rules prefix rules, the rule with the longest matching prefix wins
+	Rules []Rule
+
+	// Ring names of backends on the consistent hashing ring, keys which don't match any rule are placed on the ring
+	Ring []string
+	// VirtualNodes number of points of each backend on the ring, default to 128
+	VirtualNodes int
+
+	// Previous placement before the current one is changed. During the migration, lookups which miss on the current
+	// backend fall back to the previous backend, until objects are moved by Rebalance
+	Previous *Config
+}
+
+type point struct {
+	hash    uint64
+	backend string
+}
+
+// placement locate keys on backends with rules and the ring of a config
+type placement struct {
+	rules []Rule
+	ring  []point
+}
+
+func hashKey(key string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	// fnv has poor avalanche on short similar keys, finalize it like splitmix64 to spread virtual nodes evenly
+	h := hasher.Sum64()
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
+
+// Validate check rules and the ring only refer to given backends, and all keys are placed, including the previous config
+func (config *Config) Validate(backends map[string]model.StorageInterface) error {
+	coversAll := len(config.Ring) > 0
+	for _, rule := range config.Rules {
+		if _, ok := backends[rule.Backend]; !ok {
+			return fmt.Errorf("shard rule %q routes to unknown backend %q", rule.Prefix, rule.Backend)
+		}
+		if strings.TrimPrefix(rule.Prefix, "/") == "" {
+			coversAll = true
+		}
+	}
+
+	for _, name := range config.Ring {
+		if _, ok := backends[name]; !ok {
+			return fmt.Errorf("shard ring contains unknown backend %q", name)
+		}
+	}
+
+	if !coversAll {
+		return fmt.Errorf("shard ring is empty, and rules don't cover all keys")
+	}
+
+	if config.Previous != nil {
+		if err := config.Previous.Validate(backends); err != nil {
+			return fmt.Errorf("previous config: %w", err)
+		}
+	}
+	return nil
+}
+
+// newPlacement build placement of a validated config
+func newPlacement(config *Config) *placement {
+	if config.VirtualNodes == 0 {
+		config.VirtualNodes = 128
+	}
+
+	p := &placement{rules: append([]Rule{}, config.Rules...)}
+	for i, rule := range p.rules {
+		p.rules[i].Prefix = strings.TrimPrefix(rule.Prefix, "/")
+	}
+	// longest prefix first
+	sort.SliceStable(p.rules, func(i, j int) bool { return len(p.rules[i].Prefix) > len(p.rules[j].Prefix) })
+
+	for _, name := range config.Ring {
+		for i := 0; i < config.VirtualNodes; i++ {
+			p.ring = append(p.ring, point{hash: hashKey(fmt.Sprintf("%s#%d", name, i)), backend: name})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p
+}
+
+func (p *placement) locate(key string) string {
+	for _, rule := range p.rules {
+		if strings.HasPrefix(key, rule.Prefix) {
+			return rule.Backend
+		}
+	}
+
+	h := hashKey(key)
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	if i == len(p.ring) {
+		i = 0
+	}
+	return p.ring[i].backend
+}
+
+// Client sharding router, maps each key to one of the named backends by prefix rules or consistent hashing
+type Client struct {
+	Backends map[string]model.StorageInterface
+	Config   *Config
+	current  *placement
+	previous *placement
+}
+
+// New initialize sharding router, panics if the config is invalid, call Config.Validate first to handle the error
+func New(backends map[string]model.StorageInterface, config *Config) *Client {
+	if err := config.Validate(backends); err != nil {
+		panic(err)
+	}
+
+	client := &Client{Backends: backends, Config: config, current: newPlacement(config)}
+	if config.Previous != nil {
+		client.previous = newPlacement(config.Previous)
+	}
+	return client
+}
+
+func cleanKey(urlPath string) string {
+	return strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+}
+
+// Locate return the name of the backend the key belongs to
+func (client Client) Locate(urlPath string) string {
+	return client.current.locate(cleanKey(urlPath))
+}
+
+// locations return backends of the key, the current one first and the previous one if it is different
+func (client Client) locations(urlPath string) []string {
+	key := cleanKey(urlPath)
+	locations := []string{client.current.locate(key)}
+	if client.previous != nil {
+		if previous := client.previous.locate(key); previous != locations[0] {
+			locations = append(locations, previous)
+		}
+	}
+	return locations
+}
+
+// lookup call fn with backends of the key until it succeeds
+func (client Client) lookup(urlPath string, fn func(backend model.StorageInterface) error) (err error) {
+	for _, name := range client.locations(urlPath) {
+		if err = fn(client.Backends[name]); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (file *os.File, err error) {
+	err = client.lookup(urlPath, func(backend model.StorageInterface) (err error) {
+		file, err = backend.Get(urlPath)
+		return err
+	})
+	return file, err
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(urlPath string) (stream io.ReadCloser, err error) {
+	err = client.lookup(urlPath, func(backend model.StorageInterface) (err error) {
+		stream, err = backend.GetStream(urlPath)
+		return err
+	})
+	return stream, err
+}
+
+// Stat get object's information
+func (client Client) Stat(urlPath string) (object *model.Object, err error) {
+	err = client.lookup(urlPath, func(backend model.StorageInterface) (err error) {
+		object, err = model.Stat(backend, urlPath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Put store a reader into the current backend of the key
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	object, err := client.Backends[client.Locate(urlPath)].Put(urlPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Delete delete the object from the current backend, and the previous one during a migration, so it doesn't show up again.
+// Missing objects are skipped, any other error is returned, as the object might still be visible
+func (client Client) Delete(urlPath string) error {
+	var (
+		deleted bool
+		missing error
+	)
+	for _, name := range client.locations(urlPath) {
+		backend := client.Backends[name]
+		if err := backend.Delete(urlPath); err == nil {
+			deleted = true
+		} else if model.IsNotExist(backend, err) {
+			missing = err
+		} else {
+			return err
+		}
+	}
+
+	if deleted {
+		return nil
+	}
+	return missing
+}
+
+// listAll list all backends concurrently, objects are grouped by backend name
+func (client Client) listAll(urlPath string) (map[string][]*model.Object, error) {
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		results = map[string][]*model.Object{}
+		lastErr error
+	)
+
+	for name, backend := range client.Backends {
+		wg.Add(1)
+		go func(name string, backend model.StorageInterface) {
+			defer wg.Done()
+			objects, err := backend.List(urlPath)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				lastErr = fmt.Errorf("shard backend %q: %w", name, err)
+			}
+			results[name] = objects
+		}(name, backend)
+	}
+	wg.Wait()
+
+	return results, lastErr
+}
+
+// List list all objects under current path from all backends. Objects are only returned from the backends they belong
+// to, the current backend wins if an object exists in both the current and the previous one during a migration
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	results, err := client.listAll(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]*model.Object{}
+	for name, objects := range results {
+		for _, object := range objects {
+			objectPath := "/" + cleanKey(object.Path)
+			locations := client.locations(objectPath)
+			if name == locations[0] || (len(locations) > 1 && name == locations[1] && found[objectPath] == nil) {
+				object.StorageInterface = client
+				found[objectPath] = object
+			}
+		}
+	}
+
+	objects := make([]*model.Object, 0, len(found))
+	for _, object := range found {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool { return cleanKey(objects[i].Path) < cleanKey(objects[j].Path) })
+	return objects, nil
+}
+
+// GetEndpoint sharded objects have no single endpoint, return the endpoint of the backend of root path
+func (client Client) GetEndpoint() string {
+	return client.Backends[client.Locate("/")].GetEndpoint()
+}
+
+// GetURL get public accessible URL from the backend which has the object
+func (client Client) GetURL(urlPath string) (url string, err error) {
+	locations := client.locations(urlPath)
+	backend := client.Backends[locations[0]]
+	if len(locations) > 1 {
+		if ok, err := model.Exists(backend, urlPath); err != nil {
+			return "", err
+		} else if !ok {
+			backend = client.Backends[locations[1]]
+		}
+	}
+	return backend.GetURL(urlPath)
+}
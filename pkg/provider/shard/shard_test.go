@@ -0,0 +1,225 @@
+package shard_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/pkg/provider/shard"
+	"github.com/bhojpur/drive/tests"
+)
+
+func newBackends(names ...string) map[string]model.StorageInterface {
+	backends := map[string]model.StorageInterface{}
+	for _, name := range names {
+		backends[name] = memory.New(&memory.Config{})
+	}
+	return backends
+}
+
+func count(storage model.StorageInterface) int {
+	objects, _ := storage.List("/")
+	return len(objects)
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(shard.New(newBackends("a", "b", "c"), &shard.Config{Ring: []string{"a", "b", "c"}}), t)
+}
+
+func TestRules(t *testing.T) {
+	backends := newBackends("default", "images", "raw")
+	client := shard.New(backends, &shard.Config{
+		Rules: []shard.Rule{{Prefix: "/images/", Backend: "images"}, {Prefix: "images/raw/", Backend: "raw"}},
+		Ring:  []string{"default"},
+	})
+
+	client.Put("/images/logo.png", strings.NewReader("logo"))
+	client.Put("/images/raw/logo.tiff", strings.NewReader("raw"))
+	client.Put("/docs/README.md", strings.NewReader("readme"))
+
+	if count(backends["images"]) != 1 || count(backends["raw"]) != 1 || count(backends["default"]) != 1 {
+		t.Errorf("Keys should be routed by the longest matching prefix")
+	}
+	if name := client.Locate("images/raw/a.tiff"); name != "raw" {
+		t.Errorf("Key should be located on raw backend, but got %v", name)
+	}
+
+	objects, err := client.List("/")
+	if err != nil || len(objects) != 3 || objects[0].Path != "/docs/README.md" {
+		t.Errorf("List should merge objects of all backends, but got %v, %v", objects, err)
+	}
+	if objects, _ := client.List("/images"); len(objects) != 2 {
+		t.Errorf("List should only return objects under the path, but got %v", len(objects))
+	}
+
+	for _, config := range []*shard.Config{
+		{Rules: []shard.Rule{{Prefix: "videos/", Backend: "videos"}}, Ring: []string{"default"}},
+		{Ring: []string{"default", "videos"}},
+		{Rules: []shard.Rule{{Prefix: "images/", Backend: "images"}}},
+		{Ring: []string{"default"}, Previous: &shard.Config{Ring: []string{"videos"}}},
+	} {
+		if err := config.Validate(backends); err == nil {
+			t.Errorf("Config %+v should be rejected", config)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Unknown backend should be rejected")
+		}
+	}()
+	shard.New(backends, &shard.Config{Rules: []shard.Rule{{Prefix: "videos/", Backend: "videos"}}, Ring: []string{"default"}})
+}
+
+func TestRing(t *testing.T) {
+	backends := newBackends("a", "b", "c", "d")
+	before := shard.New(backends, &shard.Config{Ring: []string{"a", "b", "c"}})
+	after := shard.New(backends, &shard.Config{Ring: []string{"a", "b", "c", "d"}})
+
+	var (
+		total   = 3000
+		located = map[string]int{}
+		moved   int
+	)
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("/objects/%d.bin", i)
+		located[before.Locate(key)]++
+
+		if from, to := before.Locate(key), after.Locate(key); from != to {
+			moved++
+			if to != "d" {
+				t.Errorf("Keys should only move to the new backend, but %v moved from %v to %v", key, from, to)
+			}
+		}
+	}
+
+	for name, n := range located {
+		if n < total/4 || n > total*5/12 {
+			t.Errorf("Keys should be spread evenly, but %v got %v of %v", name, n, total)
+		}
+	}
+	if moved < total/6 || moved > total/3 {
+		t.Errorf("About a quarter of keys should move to the new backend, but got %v of %v", moved, total)
+	}
+}
+
+func TestMigration(t *testing.T) {
+	var (
+		backends = newBackends("a", "b", "c")
+		old      = &shard.Config{Ring: []string{"a", "b"}}
+		client   = shard.New(backends, old)
+		total    = 200
+	)
+	for i := 0; i < total; i++ {
+		client.Put(fmt.Sprintf("/objects/%d.txt", i), strings.NewReader(fmt.Sprint(i)))
+	}
+
+	// dual-read while objects are moved to the new backend
+	client = shard.New(backends, &shard.Config{Ring: []string{"a", "b", "c"}, Previous: old})
+	for i := 0; i < total; i++ {
+		stream, err := client.GetStream(fmt.Sprintf("/objects/%d.txt", i))
+		if err != nil {
+			t.Fatalf("Lookups should fall back to the previous backend, but got %v", err)
+		}
+		if data, _ := ioutil.ReadAll(stream); string(data) != fmt.Sprint(i) {
+			t.Errorf("Object should match content, but got %v", string(data))
+		}
+		stream.Close()
+	}
+
+	plan, err := client.Plan("/")
+	if err != nil || len(plan) == 0 {
+		t.Fatalf("Objects should be planned to move, but got %v, %v", plan, err)
+	}
+	for _, move := range plan {
+		if move.To != "c" {
+			t.Errorf("Objects should only move to the new backend, but got %+v", move)
+		}
+	}
+
+	// written after the change, before rebalanced
+	updated := plan[0].Path
+	client.Put(updated, strings.NewReader("updated"))
+	deleted := plan[1].Path
+	if err := client.Delete(deleted); err != nil {
+		t.Errorf("No error should happen when delete during migration, but got %v", err)
+	}
+	if _, err := client.GetStream(deleted); err == nil {
+		t.Errorf("Deleted object should not show up from the previous backend")
+	}
+
+	if objects, err := client.List("/objects"); err != nil || len(objects) != total-1 {
+		t.Errorf("List should return each object once during migration, but got %v, %v", len(objects), err)
+	}
+
+	moves, errs, err := client.Rebalance("/")
+	if err != nil || len(errs) != 0 || len(moves) != len(plan)-1 {
+		t.Errorf("No error should happen when rebalance, but got %v moves, %v, %v", len(moves), errs, err)
+	}
+	if plan, _ := client.Plan("/"); len(plan) != 0 {
+		t.Errorf("All objects should be on their backends after rebalance, but got %v", plan)
+	}
+
+	client = shard.New(backends, &shard.Config{Ring: []string{"a", "b", "c"}})
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("/objects/%d.txt", i)
+		if _, err := client.Stat(key); (err == nil) == (key == deleted) {
+			t.Errorf("Object %v should be found without the previous config, but got %v", key, err)
+		}
+	}
+	if stream, err := client.GetStream(updated); err != nil {
+		t.Errorf("No error should happen when get updated object, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "updated" {
+		t.Errorf("Rebalance should not overwrite objects written after the change, but got %v", string(data))
+	}
+}
+
+func TestDeleteFailure(t *testing.T) {
+	var (
+		backends = newBackends("a", "b")
+		old      = &shard.Config{Ring: []string{"a"}}
+		client   = shard.New(backends, &shard.Config{Ring: []string{"b"}, Previous: old})
+	)
+	shard.New(backends, old).Put("/a.txt", strings.NewReader("a"))
+	client.Put("/b.txt", strings.NewReader("b"))
+
+	// missing on the current backend is not an error during a migration
+	if err := client.Delete("/a.txt"); err != nil {
+		t.Errorf("No error should happen when delete object of the previous backend, but got %v", err)
+	}
+	if err := client.Delete("/a.txt"); !os.IsNotExist(err) {
+		t.Errorf("Deleting a deleted object should return not exist error, but got %v", err)
+	}
+
+	backends["b"].(*memory.Client).SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationDelete: 1}})
+	if err := client.Delete("/b.txt"); err != memory.ErrInjected {
+		t.Errorf("Errors of the current backend should be returned, but got %v", err)
+	}
+	if _, err := client.Stat("/b.txt"); err != nil {
+		t.Errorf("Object failed to be deleted should still exist, but got %v", err)
+	}
+}
@@ -22,10 +22,13 @@ package tencent
 
 import (
 	"bytes"
-	"errors"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -37,7 +40,11 @@ import (
 	model "github.com/bhojpur/drive/pkg/model"
 )
 
-var _ model.StorageInterface = (*Client)(nil)
+var (
+	_ model.StorageInterface  = (*Client)(nil)
+	_ model.StatInterface     = (*Client)(nil)
+	_ model.NotExistInterface = (*Client)(nil)
+)
 
 type Config struct {
 	AppID     string
@@ -45,9 +52,13 @@ type Config struct {
 	AccessKey string
 	Region    string
 	Bucket    string
-	ACL       string
-	CORS      string
-	Endpoint  string
+	// ACL of uploaded objects, e.g. private, public-read. GetURL returns signed URLs unless it is public-read or public-read-write
+	ACL string
+	// CORS allowed origins separated by commas, e.g. "*" or "https://a.com,https://b.com", which is applied by PutBucketCORS
+	CORS string
+	// Endpoint domain of the bucket, e.g. a custom domain, default to <bucket>.cos.<region>.myqcloud.com.
+	// Requests are sent with HTTPS, unless the scheme is included, e.g. http://127.0.0.1:8080
+	Endpoint string
 }
 
 type Client struct {
@@ -59,8 +70,76 @@ func New(conf *Config) *Client {
 	return &Client{conf, &http.Client{}}
 }
 
+// Error error response of COS
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestID  string `xml:"RequestId"`
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("cos: %d %s", err.StatusCode, err.Code)
+	}
+	return fmt.Sprintf("cos: %d %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+// IsNotExist check if the error is returned because the object doesn't exist
+func IsNotExist(err error) bool {
+	cosErr, ok := err.(*Error)
+	return ok && cosErr.StatusCode == http.StatusNotFound
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
+}
+
 func (client Client) getUrl() string {
-	return fmt.Sprintf("http://%s.cos.%s.myqcloud.com/", client.Config.Bucket, client.Config.Region)
+	endpoint := client.Config.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("%s.cos.%s.myqcloud.com", client.Config.Bucket, client.Config.Region)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/"
+}
+
+// objectUrl return URL of the object with escaped key
+func (client Client) objectUrl(path string) string {
+	return client.getUrl() + strings.TrimPrefix((&url.URL{Path: "/" + client.ToRelativePath(path)}).EscapedPath(), "/")
+}
+
+// do send a signed request, responses with error status are returned as Error
+func (client Client) do(method string, rawUrl string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("Authorization", client.authorization(req, 30*time.Minute))
+
+	resp, err := client.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		cosErr := &Error{StatusCode: resp.StatusCode}
+		if data, _ := ioutil.ReadAll(resp.Body); len(data) > 0 {
+			xml.Unmarshal(data, cosErr)
+		}
+		if cosErr.Code == "" {
+			cosErr.Code = http.StatusText(resp.StatusCode)
+		}
+		return nil, cosErr
+	}
+	return resp, nil
 }
 
 func (client Client) Get(path string) (file *os.File, err error) {
@@ -89,16 +168,35 @@ func (client Client) ToRelativePath(urlPath string) string {
 }
 
 func (client Client) GetStream(path string) (io.ReadCloser, error) {
-	resp, err := http.Get(fmt.Sprintf("%s%s", client.getUrl(), client.ToRelativePath(path)))
+	resp, err := client.do(http.MethodGet, client.objectUrl(path), nil, nil)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("get file fail")
-	}
 	return resp.Body, nil
 }
 
+// Stat get object's information with HEAD Object
+func (client Client) Stat(path string) (*model.Object, error) {
+	resp, err := client.do(http.MethodHead, client.objectUrl(path), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	object := &model.Object{
+		Path:             "/" + client.ToRelativePath(path),
+		Name:             filepath.Base(path),
+		Size:             resp.ContentLength,
+		ETag:             resp.Header.Get("ETag"),
+		ContentType:      resp.Header.Get("Content-Type"),
+		StorageInterface: client,
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		object.LastModified = &modified
+	}
+	return object, nil
+}
+
 func (client Client) Put(path string, body io.Reader) (*model.Object, error) {
 	if seeker, ok := body.(io.ReadSeeker); ok {
 		seeker.Seek(0, 0)
@@ -115,87 +213,196 @@ func (client Client) Put(path string, body io.Reader) (*model.Object, error) {
 		}
 	}
 
-	req, err := http.NewRequest("PUT", fmt.Sprintf("%s%s", client.getUrl(), client.ToRelativePath(path)), body)
-	if err != nil {
-		return nil, err
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		header.Set("Content-Type", contentType)
 	}
-	req.Header.Set("Host", client.GetEndpoint())
-	req.Header.Set("Authorization", client.authorization(req))
-	result, err := client.Client.Do(req)
+	if client.Config.ACL != "" {
+		header.Set("x-cos-acl", client.Config.ACL)
+	}
+
+	resp, err := client.do(http.MethodPut, client.objectUrl(path), header, body)
 	if err != nil {
 		return nil, err
 	}
-	if result.StatusCode != http.StatusOK {
-		d, err := ioutil.ReadAll(ioutil.NopCloser(result.Body))
-		if err != nil {
-			return nil, err
-		}
-		return nil, errors.New(string(d))
-	}
+	resp.Body.Close()
+
 	now := time.Now()
 	return &model.Object{
 		Path:             path,
 		Name:             filepath.Base(path),
 		LastModified:     &now,
+		ETag:             resp.Header.Get("ETag"),
 		StorageInterface: client,
 	}, nil
 }
 
 func (client Client) Delete(path string) error {
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s%s", client.getUrl(), client.ToRelativePath(path)), nil)
+	resp, err := client.do(http.MethodDelete, client.objectUrl(path), nil, nil)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Host", client.GetEndpoint())
-	req.Header.Set("Authorization", client.authorization(req))
-	result, err := client.Client.Do(req)
-	if err != nil {
-		return err
+	return resp.Body.Close()
+}
+
+type listBucketResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+// List list all objects under current path with GET Bucket, following markers of truncated results
+func (client Client) List(path string) ([]*model.Object, error) {
+	var (
+		objects []*model.Object
+		query   = url.Values{"max-keys": {"1000"}}
+	)
+	if prefix := strings.Trim(client.ToRelativePath(path), "/"); prefix != "" {
+		query.Set("prefix", prefix+"/")
 	}
-	if result.StatusCode != http.StatusOK && result.StatusCode != http.StatusNoContent {
-		d, err := ioutil.ReadAll(ioutil.NopCloser(result.Body))
+
+	for {
+		resp, err := client.do(http.MethodGet, client.getUrl()+"?"+query.Encode(), nil, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return errors.New(string(d))
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			if strings.HasSuffix(content.Key, "/") {
+				continue
+			}
+			lastModified := content.LastModified
+			objects = append(objects, &model.Object{
+				Path:             "/" + content.Key,
+				Name:             filepath.Base(content.Key),
+				LastModified:     &lastModified,
+				Size:             content.Size,
+				ETag:             content.ETag,
+				StorageInterface: client,
+			})
+		}
+
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return objects, nil
+		}
+		marker := result.NextMarker
+		if marker == "" {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+		query.Set("marker", marker)
 	}
-	return nil
 }
 
-//todo not found api
-func (client Client) List(path string) ([]*model.Object, error) {
-	var objects []*model.Object
+type corsRule struct {
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  int      `xml:"MaxAgeSeconds"`
+}
 
-	results, err := client.Get(path)
+type corsConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Rules   []corsRule `xml:"CORSRule"`
+}
 
-	if err == nil {
-		objects = append(objects, &model.Object{
-			Path: client.ToRelativePath(path),
-			Name: results.Name(),
-			//LastModified:     &obj.LastModified,
-			StorageInterface: client,
-		})
+// PutBucketCORS allow origins of Config.CORS to access the bucket from browsers
+func (client Client) PutBucketCORS() error {
+	var origins []string
+	for _, origin := range strings.Split(client.Config.CORS, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		return fmt.Errorf("cos: no CORS origin is configured")
+	}
 
+	data, err := xml.Marshal(corsConfiguration{Rules: []corsRule{{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "HEAD", "PUT", "POST", "DELETE"},
+		AllowedHeaders: []string{"*"},
+		ExposeHeaders:  []string{"ETag", "Content-Length"},
+		MaxAgeSeconds:  600,
+	}}})
+	if err != nil {
+		return err
+	}
+	sum := md5.Sum(data)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+
+	resp, err := client.do(http.MethodPut, client.getUrl()+"?cors", header, bytes.NewReader(data))
+	if err != nil {
+		return err
 	}
-	return objects, err
+	return resp.Body.Close()
 }
 
 func (client Client) GetEndpoint() string {
-	if client.Config.Endpoint != "" {
-		return client.Config.Endpoint
+	endpoint := strings.TrimSuffix(client.getUrl(), "/")
+	for _, prefix := range []string{"https://", "http://"} {
+		endpoint = strings.TrimPrefix(endpoint, prefix)
 	}
-	return fmt.Sprintf("%s.cos.%s.myqcloud.com", client.Config.Bucket, client.Config.Region)
+	return endpoint
 }
 
+// GetURL get public accessible URL, it is signed for an hour unless objects are uploaded with public ACL
 func (client Client) GetURL(path string) (string, error) {
-	return fmt.Sprintf("%s%s", client.getUrl(), client.ToRelativePath(path)), nil
+	if client.Config.ACL == "public-read" || client.Config.ACL == "public-read-write" {
+		return client.objectUrl(path), nil
+	}
+	return client.SignURL(path, time.Hour)
 }
 
-func (client Client) authorization(req *http.Request) string {
-	signTime := getSignTime()
+// SignURL return a pre-signed URL to get the object, which is valid for given duration
+func (client Client) SignURL(path string, expiry time.Duration) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, client.objectUrl(path), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Host", req.URL.Host)
+
+	query := url.Values{}
+	for _, param := range client.sign(req, expiry) {
+		query.Set(param[0], param[1])
+	}
+	return req.URL.String() + "?" + query.Encode(), nil
+}
+
+// sign return parameters of the signature of the request
+func (client Client) sign(req *http.Request, expiry time.Duration) [][2]string {
+	signTime := getSignTime(expiry)
 	signature := getSignature(client.Config.AccessKey, req, signTime)
-	authStr := fmt.Sprintf("q-sign-algorithm=sha1&q-ak=%s&q-sign-time=%s&q-key-time=%s&q-header-list=%s&q-url-param-list=%s&q-signature=%s",
-		client.Config.AccessID, signTime, signTime, getHeadKeys(req.Header), getParamsKeys(req.URL.RawQuery), signature)
+	return [][2]string{
+		{"q-sign-algorithm", "sha1"},
+		{"q-ak", client.Config.AccessID},
+		{"q-sign-time", signTime},
+		{"q-key-time", signTime},
+		{"q-header-list", getHeadKeys(req.Header)},
+		{"q-url-param-list", getParamsKeys(req.URL.RawQuery)},
+		{"q-signature", signature},
+	}
+}
 
-	return authStr
+func (client Client) authorization(req *http.Request, expiry time.Duration) string {
+	var params []string
+	for _, param := range client.sign(req, expiry) {
+		params = append(params, param[0]+"="+param[1])
+	}
+	return strings.Join(params, "&")
 }
@@ -21,47 +21,336 @@ package tencent_test
 // THE SOFTWARE.
 
 import (
-	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	tencent "github.com/bhojpur/drive/pkg/provider/tencent"
 	"github.com/bhojpur/drive/tests"
 )
 
-func TestClient_Get(t *testing.T) {
+const (
+	accessID  = "AKIDdrivetest"
+	accessKey = "drivetestsecretkey"
+)
 
+// fakeCOS in-process fake of COS, which verifies signatures of requests
+type fakeCOS struct {
+	*httptest.Server
+	mutex        sync.Mutex
+	objects      map[string]*fakeObject
+	cors         string
+	pageSize     int
+	listRequests int
 }
 
-var client *tencent.Client
+type fakeObject struct {
+	data        []byte
+	contentType string
+	acl         string
+	modified    time.Time
+}
 
-func init() {
-	client = tencent.New(&tencent.Config{
-		AppID:     "1252882253",
-		AccessID:  "AKIDToxukQWBG8nGXcBN8i662nOo12sc5Wjl",
-		AccessKey: "40jNrBf5mLiuuiU8HH7lDTXP5at00sbA",
-		Bucket:    "tets-1252882253",
+func startFake(t *testing.T) *fakeCOS {
+	fake := &fakeCOS{objects: map[string]*fakeObject{}, pageSize: 2}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func newClient(fake *fakeCOS, acl string) *tencent.Client {
+	return tencent.New(&tencent.Config{
+		AccessID:  accessID,
+		AccessKey: accessKey,
+		Bucket:    "drive-1250000000",
 		Region:    "ap-shanghai",
-		ACL:       "public-read", // private，public-read-write，public-read；默认值：private
-		//Endpoint:  config.Public.Endpoint,
+		ACL:       acl,
+		Endpoint:  fake.URL,
 	})
 }
 
-func TestClient_Put(t *testing.T) {
-	f, err := ioutil.ReadFile("/home/shashi.rai/Downloads/2.png")
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func hmacSha1(key, s string) string {
+	h := hmac.New(sha1.New, []byte(key))
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verify check the signature of the request with the algorithm of COS, it returns false for anonymous requests
+func verify(req *http.Request) (bool, error) {
+	query := req.URL.Query()
+	params := map[string]string{}
+	if query.Get("q-signature") != "" {
+		for key := range query {
+			if strings.HasPrefix(key, "q-") {
+				params[key] = query.Get(key)
+				query.Del(key)
+			}
+		}
+	} else if authorization := req.Header.Get("Authorization"); authorization != "" {
+		for _, param := range strings.Split(authorization, "&") {
+			if pair := strings.SplitN(param, "=", 2); len(pair) == 2 {
+				params[pair[0]] = pair[1]
+			}
+		}
+	} else {
+		return false, nil
+	}
+
+	if params["q-ak"] != accessID || params["q-sign-algorithm"] != "sha1" {
+		return false, fmt.Errorf("invalid access id %v", params["q-ak"])
+	}
+	times := strings.Split(params["q-sign-time"], ";")
+	if len(times) != 2 {
+		return false, fmt.Errorf("invalid sign time %v", params["q-sign-time"])
+	}
+	start, _ := strconv.ParseInt(times[0], 10, 64)
+	end, _ := strconv.ParseInt(times[1], 10, 64)
+	if now := time.Now().Unix(); now < start-60 || now > end {
+		return false, fmt.Errorf("signature expired")
+	}
+
+	format := func(list string, value func(key string) string) string {
+		var pairs []string
+		for _, key := range strings.Split(list, ";") {
+			if key != "" {
+				pairs = append(pairs, key+"="+escape(value(key)))
+			}
+		}
+		return strings.Join(pairs, "&")
+	}
+
+	// all parameters and headers should be signed
+	var queryKeys []string
+	for key := range query {
+		queryKeys = append(queryKeys, strings.ToLower(key))
+	}
+	sort.Strings(queryKeys)
+	if strings.Join(queryKeys, ";") != params["q-url-param-list"] {
+		return false, fmt.Errorf("parameters %v are not signed", queryKeys)
+	}
+	if !strings.Contains(params["q-header-list"], "host") {
+		return false, fmt.Errorf("host is not signed")
+	}
+
+	httpString := strings.ToLower(req.Method) + "\n" + req.URL.Path + "\n" +
+		format(params["q-url-param-list"], func(key string) string { return query.Get(key) }) + "\n" +
+		format(params["q-header-list"], func(key string) string {
+			if key == "host" {
+				return req.Host
+			}
+			return req.Header.Get(key)
+		}) + "\n"
+	sum := sha1.Sum([]byte(httpString))
+	stringToSign := "sha1\n" + params["q-sign-time"] + "\n" + hex.EncodeToString(sum[:]) + "\n"
+
+	if hmacSha1(hmacSha1(accessKey, params["q-key-time"]), stringToSign) != params["q-signature"] {
+		return false, fmt.Errorf("signature doesn't match")
+	}
+	return true, nil
+}
+
+func (fake *fakeCOS) fail(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message><RequestId>fake</RequestId></Error>", code, message)
+}
+
+func (fake *fakeCOS) serve(w http.ResponseWriter, req *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	signed, err := verify(req)
 	if err != nil {
-		t.Error(err)
+		fake.fail(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	key := strings.TrimPrefix(req.URL.Path, "/")
+	object := fake.objects[key]
+	if !signed && !(req.Method == http.MethodGet && object != nil && object.acl == "public-read") {
+		fake.fail(w, http.StatusForbidden, "AccessDenied", "Access Denied")
 		return
 	}
 
-	client.Put("test.png", bytes.NewReader(f))
+	switch {
+	case key == "" && req.Method == http.MethodPut && req.URL.Query().Has("cors"):
+		data, _ := ioutil.ReadAll(req.Body)
+		if req.Header.Get("Content-MD5") == "" {
+			fake.fail(w, http.StatusBadRequest, "MissingContentMD5", "Content-MD5 is required")
+			return
+		}
+		fake.cors = string(data)
+	case key == "" && req.Method == http.MethodGet:
+		fake.list(w, req.URL.Query())
+	case req.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(req.Body)
+		fake.objects[key] = &fakeObject{data: data, contentType: req.Header.Get("Content-Type"), acl: req.Header.Get("x-cos-acl"), modified: time.Now().UTC()}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha1.Sum(data)))
+	case object == nil:
+		fake.fail(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	case req.Method == http.MethodGet, req.Method == http.MethodHead:
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha1.Sum(object.data)))
+		if req.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case req.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fake.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed", req.Method)
+	}
 }
 
-func TestClient_Put2(t *testing.T) {
-	tests.TestAll(client, t)
+func (fake *fakeCOS) list(w http.ResponseWriter, query url.Values) {
+	fake.listRequests++
+
+	var keys []string
+	for key := range fake.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("marker") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		IsTruncated bool
+		NextMarker  string `xml:",omitempty"`
+		Contents    []content
+	}{}
+	if len(keys) > fake.pageSize {
+		keys = keys[:fake.pageSize]
+		result.IsTruncated = true
+		// COS returns NextMarker for truncated results, the last key is used when it is missing
+		if fake.listRequests%2 == 1 {
+			result.NextMarker = keys[len(keys)-1]
+		}
+	}
+	for _, key := range keys {
+		object := fake.objects[key]
+		result.Contents = append(result.Contents, content{Key: key, LastModified: object.modified.Format(time.RFC3339), Size: len(object.data)})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestAll(t *testing.T) {
+	fake := startFake(t)
+	tests.TestAll(newClient(fake, "private"), t)
+	tests.TestAll(newClient(fake, "public-read"), t)
+}
+
+func TestList(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(fake, "")
+
+	for i := 0; i < 5; i++ {
+		client.Put(fmt.Sprintf("/logs/%d.txt", i), strings.NewReader("log"))
+	}
+	client.Put("/logs.txt", strings.NewReader("not under logs/"))
+
+	objects, err := client.List("/logs")
+	if err != nil || len(objects) != 5 {
+		t.Fatalf("List should follow markers, but got %v objects, %v", len(objects), err)
+	}
+	if fake.listRequests != 3 {
+		t.Errorf("List should request 3 pages, but got %v", fake.listRequests)
+	}
+	if objects[0].Path != "/logs/0.txt" || objects[0].Size != 3 || objects[0].LastModified == nil {
+		t.Errorf("Listed objects should have properties, but got %+v", objects[0])
+	}
+
+	if objects, _ := client.List("/"); len(objects) != 6 {
+		t.Errorf("List of root should return all objects, but got %v", len(objects))
+	}
+}
+
+func TestPrivateRead(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(fake, "private")
+	client.Put("/docs/a report.pdf", strings.NewReader("report"))
+
+	object, err := client.Stat("/docs/a report.pdf")
+	if err != nil || object.Size != 6 || object.ContentType != "application/pdf" || object.LastModified == nil || object.ETag == "" {
+		t.Errorf("Stat should return object's information, but got %+v, %v", object, err)
+	}
+	if _, err := client.Stat("/docs/missing.pdf"); !tencent.IsNotExist(err) {
+		t.Errorf("Missing object should return not exist error, but got %v", err)
+	}
+
+	publicURL := fake.URL + "/docs/a%20report.pdf"
+	if resp, err := http.Get(publicURL); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Private object should not be readable anonymously, but got %v", resp.StatusCode)
+	}
+
+	signed, err := client.GetURL("/docs/a report.pdf")
+	if err != nil || !strings.HasPrefix(signed, publicURL+"?") {
+		t.Fatalf("URL of private object should be signed, but got %v, %v", signed, err)
+	}
+	if resp, err := http.Get(signed); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Signed URL should be readable, but got %v, %v", resp.StatusCode, err)
+	}
+
+	client.Config.AccessKey = "wrong key"
+	if _, err := client.GetStream("/docs/a report.pdf"); err == nil || err.(*tencent.Error).Code != "SignatureDoesNotMatch" {
+		t.Errorf("Request with wrong key should fail, but got %v", err)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	client := tencent.New(&tencent.Config{Bucket: "drive-1250000000", Region: "ap-shanghai", ACL: "public-read"})
+	if endpoint := client.GetEndpoint(); endpoint != "drive-1250000000.cos.ap-shanghai.myqcloud.com" {
+		t.Errorf("Endpoint should be the bucket domain, but got %v", endpoint)
+	}
+	if url, _ := client.GetURL("/a.txt"); url != "https://drive-1250000000.cos.ap-shanghai.myqcloud.com/a.txt" {
+		t.Errorf("URL should use HTTPS, but got %v", url)
+	}
+
+	client.Config.Endpoint = "cdn.bhojpur.net"
+	if url, _ := client.GetURL("/a.txt"); url != "https://cdn.bhojpur.net/a.txt" || client.GetEndpoint() != "cdn.bhojpur.net" {
+		t.Errorf("URL should use custom endpoint, but got %v", url)
+	}
 }
 
-func TestClient_Delete(t *testing.T) {
-	fmt.Println(client.Delete("test.png"))
+func TestCORS(t *testing.T) {
+	fake := startFake(t)
+	client := newClient(fake, "")
+
+	if err := client.PutBucketCORS(); err == nil {
+		t.Errorf("CORS without origins should fail")
+	}
+
+	client.Config.CORS = "https://a.bhojpur.net, https://b.bhojpur.net"
+	if err := client.PutBucketCORS(); err != nil {
+		t.Fatalf("No error should happen when put bucket CORS, but got %v", err)
+	}
+	if !strings.Contains(fake.cors, "<AllowedOrigin>https://a.bhojpur.net</AllowedOrigin><AllowedOrigin>https://b.bhojpur.net</AllowedOrigin>") {
+		t.Errorf("CORS origins should be applied, but got %v", fake.cors)
+	}
 }
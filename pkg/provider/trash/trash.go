@@ -0,0 +1,424 @@
+package trash
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+var (
+	// ErrItemNotFound returned when the trash item doesn't exist
+	ErrItemNotFound = errors.New("trash item not found")
+	// ErrRestoreConflict returned when restoring an item to a path that already has an object
+	ErrRestoreConflict = errors.New("an object already exists at the restore path")
+	// ErrReservedPath returned when writing or deleting objects in the trash namespace directly
+	ErrReservedPath = errors.New("path is reserved for the trash")
+)
+
+// Config trash config
+type Config struct {
+	// Prefix where deleted objects are moved to, default to ".trash"
+	Prefix string
+
+	// Retention how long deleted objects are kept before purged, default to 30 days
+	Retention time.Duration
+
+	// Now current time, default to time.Now
+	Now func() time.Time
+}
+
+// Item a deleted object in the trash
+type Item struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	LastModified time.Time `json:"last_modified,omitempty"`
+}
+
+// Client trash storage, Delete moves objects into the trash namespace of the underlying storage, where they could be listed,
+// restored or purged. It only relies on the common storage interface, so works the same with all providers.
+//
+// Layout in the underlying storage:
+//
+//	<prefix>/items/<id>.json  JSON item with original path and deletion time
+//	<prefix>/objects/<id>     content of the deleted object
+type Client struct {
+	Storage model.StorageInterface
+	Config  *Config
+}
+
+// New initialize trash storage
+func New(storage model.StorageInterface, config *Config) *Client {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.Prefix == "" {
+		config.Prefix = ".trash"
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	if config.Retention == 0 {
+		config.Retention = 30 * 24 * time.Hour
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Client{Storage: storage, Config: config}
+}
+
+func (client Client) itemPath(id string) string {
+	return "/" + path.Join(client.Config.Prefix, "items", id) + ".json"
+}
+
+func (client Client) objectPath(id string) string {
+	return "/" + path.Join(client.Config.Prefix, "objects", id)
+}
+
+// isTrashPath report whether the path is in the trash, paths are cleaned first so "//" or "/./" can't reach it
+func (client Client) isTrashPath(urlPath string) bool {
+	urlPath = strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	return urlPath == client.Config.Prefix || strings.HasPrefix(urlPath, client.Config.Prefix+"/")
+}
+
+func newID(now time.Time) string {
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	return fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(nonce))
+}
+
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.count += int64(n)
+	return n, err
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (*os.File, error) {
+	if client.isTrashPath(urlPath) {
+		return nil, os.ErrNotExist
+	}
+	return client.Storage.Get(urlPath)
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(urlPath string) (io.ReadCloser, error) {
+	if client.isTrashPath(urlPath) {
+		return nil, os.ErrNotExist
+	}
+	return client.Storage.GetStream(urlPath)
+}
+
+// Stat get object's information
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	if client.isTrashPath(urlPath) {
+		return nil, os.ErrNotExist
+	}
+
+	object, err := model.Stat(client.Storage, urlPath)
+	if err != nil {
+		return nil, err
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Put store a reader into given path
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if client.isTrashPath(urlPath) {
+		return nil, ErrReservedPath
+	}
+
+	object, err := client.Storage.Put(urlPath, reader)
+	if err == nil {
+		object.StorageInterface = client
+	}
+	return object, err
+}
+
+// Delete move the object into the trash
+func (client Client) Delete(urlPath string) error {
+	if client.isTrashPath(urlPath) {
+		return ErrReservedPath
+	}
+
+	stream, err := client.Storage.GetStream(urlPath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	now := client.Config.Now()
+	item := &Item{
+		ID:          newID(now),
+		Path:        "/" + strings.TrimPrefix(urlPath, "/"),
+		DeletedAt:   now,
+		ContentType: mime.TypeByExtension(path.Ext(urlPath)),
+	}
+
+	// only ask storages which could stat objects cheaply, others would download the object once more
+	if stater, ok := client.Storage.(model.StatInterface); ok {
+		if object, err := stater.Stat(urlPath); err == nil {
+			if object.ContentType != "" {
+				item.ContentType = object.ContentType
+			}
+			if object.LastModified != nil {
+				item.LastModified = *object.LastModified
+			}
+		}
+	}
+
+	counter := &countingReader{Reader: stream}
+	if _, err := client.Storage.Put(client.objectPath(item.ID), counter); err != nil {
+		return err
+	}
+	item.Size = counter.count
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Storage.Put(client.itemPath(item.ID), bytes.NewReader(data)); err != nil {
+		client.Storage.Delete(client.objectPath(item.ID))
+		return err
+	}
+
+	return client.Storage.Delete(urlPath)
+}
+
+// List list all objects under current path, the trash is excluded
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	results, err := client.Storage.List(urlPath)
+
+	var objects []*model.Object
+	for _, object := range results {
+		if !client.isTrashPath(object.Path) {
+			object.StorageInterface = client
+			objects = append(objects, object)
+		}
+	}
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL get public accessible URL
+func (client Client) GetURL(urlPath string) (string, error) {
+	if client.isTrashPath(urlPath) {
+		return "", os.ErrNotExist
+	}
+	return client.Storage.GetURL(urlPath)
+}
+
+// GetItem get trash item with its id
+func (client Client) GetItem(id string) (*Item, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") {
+		return nil, ErrItemNotFound
+	}
+
+	stream, err := client.Storage.GetStream(client.itemPath(id))
+	if err != nil {
+		return nil, ErrItemNotFound
+	}
+	defer stream.Close()
+
+	item := &Item{}
+	if err := json.NewDecoder(stream).Decode(item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ListTrash list items in the trash, oldest first
+func (client Client) ListTrash() ([]*Item, error) {
+	results, err := client.Storage.List("/" + path.Join(client.Config.Prefix, "items"))
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*Item
+	for _, result := range results {
+		if !strings.HasSuffix(result.Path, ".json") {
+			continue
+		}
+
+		item, err := client.GetItem(strings.TrimSuffix(path.Base(result.Path), ".json"))
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// Restore move the item back to its original path, return ErrRestoreConflict if an object exists there
+func (client Client) Restore(id string) (*model.Object, error) {
+	item, err := client.GetItem(id)
+	if err != nil {
+		return nil, err
+	}
+	return client.RestoreTo(id, item.Path)
+}
+
+// RestoreTo move the item to the given path, return ErrRestoreConflict if an object exists there
+func (client Client) RestoreTo(id string, urlPath string) (*model.Object, error) {
+	if client.isTrashPath(urlPath) {
+		return nil, ErrReservedPath
+	}
+
+	if _, err := client.GetItem(id); err != nil {
+		return nil, err
+	}
+
+	if _, err := model.Stat(client.Storage, urlPath); err == nil {
+		return nil, ErrRestoreConflict
+	}
+
+	stream, err := client.Storage.GetStream(client.objectPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	object, err := client.Storage.Put(urlPath, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.remove(id); err != nil {
+		return nil, err
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+// remove delete the item and its content from the trash permanently
+func (client Client) remove(id string) error {
+	if err := client.Storage.Delete(client.objectPath(id)); err != nil {
+		return err
+	}
+	return client.Storage.Delete(client.itemPath(id))
+}
+
+// DeletePermanently delete the item from the trash permanently
+func (client Client) DeletePermanently(id string) error {
+	if _, err := client.GetItem(id); err != nil {
+		return err
+	}
+	return client.remove(id)
+}
+
+// EmptyTrash delete all items from the trash permanently, return number of deleted items
+func (client Client) EmptyTrash() (int, error) {
+	return client.purge(func(*Item) bool { return true })
+}
+
+// Purge delete items which have been in the trash longer than Config.Retention, return number of deleted items
+func (client Client) Purge() (int, error) {
+	expiry := client.Config.Now().Add(-client.Config.Retention)
+	return client.purge(func(item *Item) bool { return item.DeletedAt.Before(expiry) })
+}
+
+func (client Client) purge(match func(*Item) bool) (int, error) {
+	items, err := client.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		deleted int
+		lastErr error
+	)
+	for _, item := range items {
+		if !match(item) {
+			continue
+		}
+		if err := client.remove(item.ID); err != nil {
+			lastErr = err
+		} else {
+			deleted++
+		}
+	}
+	return deleted, lastErr
+}
+
+// StartPurge purge expired items in the background every interval, errors are reported to onError if it is not nil.
+// Call the returned function to stop it
+func (client Client) StartPurge(interval time.Duration, onError func(error)) (stop func()) {
+	var (
+		done = make(chan struct{})
+		once sync.Once
+	)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := client.Purge(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,137 @@
+package trash_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/trash"
+	"github.com/bhojpur/drive/tests"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(trash.New(filesystem.New(t.TempDir()), nil), t)
+}
+
+func TestRestore(t *testing.T) {
+	client := trash.New(filesystem.New(t.TempDir()), nil)
+	client.Put("/docs/a.txt", strings.NewReader("first"))
+
+	if err := client.Delete("/docs/a.txt"); err != nil {
+		t.Fatalf("No error should happen when delete file, but got %v", err)
+	}
+	if _, err := client.GetStream("/docs/a.txt"); err == nil {
+		t.Errorf("Deleted file should not be accessible")
+	}
+	if objects, err := client.List("/"); err != nil || len(objects) != 0 {
+		t.Errorf("Trash should be excluded from List, but got %v, %v", objects, err)
+	}
+
+	items, err := client.ListTrash()
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Deleted file should be in the trash, but got %v, %v", items, err)
+	}
+	if items[0].Path != "/docs/a.txt" || items[0].Size != 5 || items[0].DeletedAt.IsZero() {
+		t.Errorf("Trash item should record original path and size, but got %+v", items[0])
+	}
+
+	client.Put("/docs/a.txt", strings.NewReader("second"))
+	if _, err := client.Restore(items[0].ID); err != trash.ErrRestoreConflict {
+		t.Errorf("Restore over an existing file should be rejected, but got %v", err)
+	}
+
+	if _, err := client.RestoreTo(items[0].ID, "/docs/a.restored.txt"); err != nil {
+		t.Fatalf("No error should happen when restore file, but got %v", err)
+	}
+	if stream, err := client.GetStream("/docs/a.restored.txt"); err != nil {
+		t.Errorf("No error should happen when get restored file, but got %v", err)
+	} else if data, _ := ioutil.ReadAll(stream); string(data) != "first" {
+		t.Errorf("Restored file should have original content, but got %v", string(data))
+	}
+
+	if items, _ := client.ListTrash(); len(items) != 0 {
+		t.Errorf("Restored item should be removed from the trash, but got %v", items)
+	}
+	if _, err := client.Restore(items[0].ID); err != trash.ErrItemNotFound {
+		t.Errorf("Restore a removed item should fail, but got %v", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	c := &clock{now: time.Now()}
+	client := trash.New(filesystem.New(t.TempDir()), &trash.Config{Retention: time.Hour, Now: c.Now})
+
+	client.Put("/a.txt", strings.NewReader("a"))
+	client.Delete("/a.txt")
+	c.now = c.now.Add(30 * time.Minute)
+	client.Put("/a.txt", strings.NewReader("b"))
+	client.Delete("/a.txt")
+
+	if items, _ := client.ListTrash(); len(items) != 2 {
+		t.Fatalf("Both versions should be in the trash, but got %v", items)
+	}
+
+	c.now = c.now.Add(45 * time.Minute)
+	if deleted, err := client.Purge(); err != nil || deleted != 1 {
+		t.Errorf("Expired item should be purged, but got %v, %v", deleted, err)
+	}
+	if deleted, err := client.EmptyTrash(); err != nil || deleted != 1 {
+		t.Errorf("Remaining item should be deleted, but got %v, %v", deleted, err)
+	}
+}
+
+func TestReservedPath(t *testing.T) {
+	client := trash.New(filesystem.New(t.TempDir()), nil)
+	client.Put("/a.txt", strings.NewReader("a"))
+	client.Delete("/a.txt")
+
+	items, _ := client.ListTrash()
+	if err := client.Delete("/.trash/items/" + items[0].ID + ".json"); err != trash.ErrReservedPath {
+		t.Errorf("Delete in the trash should be rejected, but got %v", err)
+	}
+	if _, err := client.GetStream("/.trash/objects/" + items[0].ID); err == nil {
+		t.Errorf("Trash content should not be accessible")
+	}
+
+	for _, trashPath := range []string{"//.trash/items/", "/./.trash/items/", "/a/../.trash/items/"} {
+		if err := client.Delete(trashPath + items[0].ID + ".json"); err != trash.ErrReservedPath {
+			t.Errorf("Delete in the trash with %v should be rejected, but got %v", trashPath, err)
+		}
+		if _, err := client.Put(trashPath+items[0].ID+".json", strings.NewReader("{}")); err != trash.ErrReservedPath {
+			t.Errorf("Put in the trash with %v should be rejected, but got %v", trashPath, err)
+		}
+	}
+	if _, err := client.Restore(items[0].ID); err != nil {
+		t.Errorf("Trash item should be intact, but got %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package union
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface = (*Client)(nil)
+	_ model.StatInterface    = (*Client)(nil)
+)
+
+// ErrReservedPath returned when writing or deleting whiteout markers directly
+var ErrReservedPath = errors.New("path is reserved for whiteout markers")
+
+// Config union storage config
+type Config struct {
+	// WhiteoutPrefix name prefix of whiteout markers in the upper layer, default to ".wh."
+	WhiteoutPrefix string
+}
+
+// Client union storage, overlays a writable upper storage on top of read-only lower storages.
+//
+// Lookups fall through the layers, from the upper one to the lower ones in order. Put writes into the upper layer,
+// and Delete hides objects of lower layers with a whiteout marker in the upper layer, e.g. deleting "/dir/a.txt" puts
+// an empty "/dir/.wh.a.txt". Lower storages are never modified.
+type Client struct {
+	Upper  model.StorageInterface
+	Lower  []model.StorageInterface
+	Config *Config
+}
+
+// New initialize union storage, lower storages are ordered from the highest priority to the lowest
+func New(upper model.StorageInterface, lower []model.StorageInterface, config *Config) *Client {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.WhiteoutPrefix == "" {
+		config.WhiteoutPrefix = ".wh."
+	}
+
+	return &Client{Upper: upper, Lower: lower, Config: config}
+}
+
+func cleanPath(urlPath string) string {
+	return path.Clean("/" + urlPath)
+}
+
+func (client Client) isWhiteoutPath(urlPath string) bool {
+	return strings.HasPrefix(path.Base(urlPath), client.Config.WhiteoutPrefix)
+}
+
+func (client Client) whiteoutPath(urlPath string) string {
+	dir, name := path.Split(cleanPath(urlPath))
+	return dir + client.Config.WhiteoutPrefix + name
+}
+
+func (client Client) isWhiteout(urlPath string) (bool, error) {
+	return model.Exists(client.Upper, client.whiteoutPath(urlPath))
+}
+
+// lookup call fn with the layers in order until it succeeds, layers below a whiteout marker are skipped.
+// Only misses fall through to the next layer, other errors are returned, so stale objects of lower layers never show through
+func (client Client) lookup(op string, urlPath string, fn func(storage model.StorageInterface) error) error {
+	notExist := &os.PathError{Op: op, Path: urlPath, Err: os.ErrNotExist}
+	if client.isWhiteoutPath(urlPath) {
+		return notExist
+	}
+
+	err := fn(client.Upper)
+	if err == nil || !model.IsNotExist(client.Upper, err) {
+		return err
+	}
+	if whiteout, err := client.isWhiteout(urlPath); err != nil {
+		return err
+	} else if whiteout {
+		return notExist
+	}
+
+	for _, storage := range client.Lower {
+		if err = fn(storage); err == nil || !model.IsNotExist(storage, err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (file *os.File, err error) {
+	err = client.lookup("open", urlPath, func(storage model.StorageInterface) (err error) {
+		file, err = storage.Get(urlPath)
+		return err
+	})
+	return file, err
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(urlPath string) (stream io.ReadCloser, err error) {
+	err = client.lookup("open", urlPath, func(storage model.StorageInterface) (err error) {
+		stream, err = storage.GetStream(urlPath)
+		return err
+	})
+	return stream, err
+}
+
+// Stat get object's information from the layer it is found in
+func (client Client) Stat(urlPath string) (object *model.Object, err error) {
+	err = client.lookup("stat", urlPath, func(storage model.StorageInterface) (err error) {
+		object, err = model.Stat(storage, urlPath)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Put store a reader into the upper layer, and remove the whiteout marker of the path if any
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if client.isWhiteoutPath(urlPath) {
+		return nil, ErrReservedPath
+	}
+
+	object, err := client.Upper.Put(urlPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	whiteout := client.whiteoutPath(urlPath)
+	if hasWhiteout, err := model.Exists(client.Upper, whiteout); err != nil {
+		return nil, err
+	} else if hasWhiteout {
+		if err := client.Upper.Delete(whiteout); err != nil {
+			return nil, err
+		}
+	}
+
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Delete delete the object from the upper layer, and hide the object of lower layers with a whiteout marker
+func (client Client) Delete(urlPath string) error {
+	if client.isWhiteoutPath(urlPath) {
+		return ErrReservedPath
+	}
+
+	// errors other than misses fail the delete, so the whiteout marker is never skipped by a transient error
+	inUpper, err := model.Exists(client.Upper, urlPath)
+	if err != nil {
+		return err
+	}
+
+	whiteout, err := client.isWhiteout(urlPath)
+	if err != nil {
+		return err
+	}
+
+	var inLower bool
+	if !whiteout {
+		for _, storage := range client.Lower {
+			if inLower, err = model.Exists(storage, urlPath); err != nil {
+				return err
+			} else if inLower {
+				break
+			}
+		}
+	}
+
+	if !inUpper && !inLower {
+		return &os.PathError{Op: "delete", Path: urlPath, Err: os.ErrNotExist}
+	}
+
+	// write the marker first, so the lower object never shows through
+	if inLower {
+		if _, err := client.Upper.Put(client.whiteoutPath(urlPath), strings.NewReader("")); err != nil {
+			return err
+		}
+	}
+	if inUpper {
+		return client.Upper.Delete(urlPath)
+	}
+	return nil
+}
+
+// listUpper list objects and whiteout markers of the upper layer
+func (client Client) listUpper(urlPath string) (objects []*model.Object, whiteouts map[string]bool, err error) {
+	whiteouts = map[string]bool{}
+
+	all, err := client.Upper.List(urlPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, object := range all {
+		objectPath := cleanPath(object.Path)
+		if dir, name := path.Split(objectPath); strings.HasPrefix(name, client.Config.WhiteoutPrefix) {
+			whiteouts[dir+strings.TrimPrefix(name, client.Config.WhiteoutPrefix)] = true
+		} else {
+			objects = append(objects, object)
+		}
+	}
+	return objects, whiteouts, nil
+}
+
+// List list all objects under current path, objects of upper layers hide the ones with the same path of lower layers
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	objects, whiteouts, err := client.listUpper(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, object := range objects {
+		seen[cleanPath(object.Path)] = true
+	}
+
+	for _, storage := range client.Lower {
+		lower, err := storage.List(urlPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range lower {
+			objectPath := cleanPath(object.Path)
+			if seen[objectPath] || whiteouts[objectPath] || client.isWhiteoutPath(objectPath) {
+				continue
+			}
+			seen[objectPath] = true
+			objects = append(objects, object)
+		}
+	}
+
+	for _, object := range objects {
+		object.StorageInterface = client
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return cleanPath(objects[i].Path) < cleanPath(objects[j].Path)
+	})
+	return objects, nil
+}
+
+// GetEndpoint get endpoint of the upper layer
+func (client Client) GetEndpoint() string {
+	return client.Upper.GetEndpoint()
+}
+
+// GetURL get public accessible URL from the layer the object is found in
+func (client Client) GetURL(urlPath string) (url string, err error) {
+	err = client.lookup("url", urlPath, func(storage model.StorageInterface) (err error) {
+		if ok, err := model.Exists(storage, urlPath); err != nil {
+			return err
+		} else if !ok {
+			return &os.PathError{Op: "url", Path: urlPath, Err: os.ErrNotExist}
+		}
+		url, err = storage.GetURL(urlPath)
+		return err
+	})
+	return url, err
+}
+
+// Change a change of the upper layer
+type Change struct {
+	Path    string
+	Deleted bool
+}
+
+// Changes return changes of the upper layer, i.e. written objects and whiteout markers, sorted by path
+func (client Client) Changes() ([]Change, error) {
+	objects, whiteouts, err := client.listUpper("/")
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, object := range objects {
+		changes = append(changes, Change{Path: cleanPath(object.Path)})
+	}
+	for whiteout := range whiteouts {
+		changes = append(changes, Change{Path: whiteout, Deleted: true})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// Commit flatten changes of the upper layer into target, e.g. the storage of the lowest layer. Written objects are
+// copied into target, and deleted objects are deleted from target. It returns changes applied before an error happens
+func (client Client) Commit(target model.StorageInterface) ([]Change, error) {
+	changes, err := client.Changes()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, change := range changes {
+		if change.Deleted {
+			var ok bool
+			if ok, err = model.Exists(target, change.Path); ok {
+				err = target.Delete(change.Path)
+			}
+		} else {
+			err = model.Copy(client.Upper, target, change.Path)
+		}
+
+		if err != nil {
+			return changes[:i], err
+		}
+	}
+	return changes, nil
+}
+
+// Reset discard all changes of the upper layer, e.g. after they are committed
+func (client Client) Reset() error {
+	changes, err := client.Changes()
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		changePath := change.Path
+		if change.Deleted {
+			changePath = client.whiteoutPath(change.Path)
+		}
+		if err := client.Upper.Delete(changePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package union_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/pkg/provider/union"
+	"github.com/bhojpur/drive/tests"
+)
+
+func read(storage model.StorageInterface, path string) string {
+	stream, err := storage.GetStream(path)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	defer stream.Close()
+
+	data, _ := ioutil.ReadAll(stream)
+	return string(data)
+}
+
+func paths(objects []*model.Object) string {
+	var paths []string
+	for _, object := range objects {
+		paths = append(paths, object.Path)
+	}
+	return strings.Join(paths, ",")
+}
+
+// setup production layer with an older base layer below it
+func setup(t *testing.T) (*union.Client, model.StorageInterface, model.StorageInterface, model.StorageInterface) {
+	upper := memory.New(&memory.Config{})
+	production := filesystem.New(t.TempDir())
+	base := filesystem.New(t.TempDir())
+
+	production.Put("/config/app.yml", strings.NewReader("production"))
+	production.Put("/assets/logo.png", strings.NewReader("logo"))
+	base.Put("/config/app.yml", strings.NewReader("base"))
+	base.Put("/config/defaults.yml", strings.NewReader("defaults"))
+
+	return union.New(upper, []model.StorageInterface{production, base}, nil), upper, production, base
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(union.New(memory.New(&memory.Config{}), []model.StorageInterface{filesystem.New(t.TempDir())}, nil), t)
+}
+
+func TestLookup(t *testing.T) {
+	client, upper, production, _ := setup(t)
+
+	if result := read(client, "/config/app.yml"); result != "production" {
+		t.Errorf("Lookup should return object of the highest layer, but got %v", result)
+	}
+	if result := read(client, "/config/defaults.yml"); result != "defaults" {
+		t.Errorf("Lookup should fall through to the lowest layer, but got %v", result)
+	}
+	if _, err := client.GetStream("/missing.txt"); err == nil {
+		t.Errorf("Missing object should return error")
+	}
+
+	if _, err := client.Put("/config/app.yml", strings.NewReader("preview")); err != nil {
+		t.Fatalf("No error should happen when put, but got %v", err)
+	}
+	if result := read(client, "/config/app.yml"); result != "preview" {
+		t.Errorf("Object of the upper layer should hide lower ones, but got %v", result)
+	}
+	if result := read(production, "/config/app.yml"); result != "production" {
+		t.Errorf("Lower layers should not be modified, but got %v", result)
+	}
+	if result := read(upper, "/config/app.yml"); result != "preview" {
+		t.Errorf("Put should write into the upper layer, but got %v", result)
+	}
+
+	if object, err := client.Stat("/config/defaults.yml"); err != nil || object.Size != 8 {
+		t.Errorf("Stat should return object's information from the lower layer, but got %+v, %v", object, err)
+	}
+}
+
+func TestUpperFailure(t *testing.T) {
+	client, upper, _, _ := setup(t)
+	client.Put("/config/app.yml", strings.NewReader("preview"))
+
+	// a failing upper layer must not serve the stale object of lower layers
+	upper.(*memory.Client).SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationGetStream: 1}})
+	if _, err := client.GetStream("/config/app.yml"); err != memory.ErrInjected {
+		t.Errorf("Errors of the upper layer should be returned, but got %v", err)
+	}
+
+	// a failing check must not skip the whiteout marker
+	upper.(*memory.Client).SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationStat: 1}})
+	if err := client.Delete("/assets/logo.png"); err != memory.ErrInjected {
+		t.Errorf("Delete should fail when the upper layer fails, but got %v", err)
+	}
+	upper.(*memory.Client).SetFaults(nil)
+	if err := client.Delete("/assets/logo.png"); err != nil {
+		t.Errorf("No error should happen when delete, but got %v", err)
+	}
+	if _, err := client.GetStream("/assets/logo.png"); !os.IsNotExist(err) {
+		t.Errorf("Deleted object should not show through, but got %v", err)
+	}
+}
+
+func TestWhiteout(t *testing.T) {
+	client, upper, production, _ := setup(t)
+
+	if err := client.Delete("/assets/logo.png"); err != nil {
+		t.Fatalf("No error should happen when delete, but got %v", err)
+	}
+	if _, err := client.GetStream("/assets/logo.png"); !os.IsNotExist(err) {
+		t.Errorf("Deleted object should be hidden, but got %v", err)
+	}
+	if _, err := client.Stat("/assets/logo.png"); !os.IsNotExist(err) {
+		t.Errorf("Deleted object should be hidden, but got %v", err)
+	}
+	if result := read(upper, "/assets/.wh.logo.png"); result != "" {
+		t.Errorf("Whiteout marker should be written into the upper layer, but got %v", result)
+	}
+	if result := read(production, "/assets/logo.png"); result != "logo" {
+		t.Errorf("Lower layers should not be modified, but got %v", result)
+	}
+	if err := client.Delete("/assets/logo.png"); !os.IsNotExist(err) {
+		t.Errorf("Deleting a deleted object should return not exist error, but got %v", err)
+	}
+
+	// deleting an object of both upper and lower layers hides both
+	client.Put("/config/app.yml", strings.NewReader("preview"))
+	client.Delete("/config/app.yml")
+	if _, err := client.GetStream("/config/app.yml"); !os.IsNotExist(err) {
+		t.Errorf("Deleted object should hide all layers, but got %v", err)
+	}
+
+	if _, err := client.Put("/assets/logo.png", strings.NewReader("new logo")); err != nil {
+		t.Errorf("No error should happen when put deleted object, but got %v", err)
+	}
+	if result := read(client, "/assets/logo.png"); result != "new logo" {
+		t.Errorf("Put should revive deleted object, but got %v", result)
+	}
+	if _, err := upper.GetStream("/assets/.wh.logo.png"); err == nil {
+		t.Errorf("Whiteout marker should be removed by put")
+	}
+
+	// objects only in the upper layer don't need a marker
+	client.Put("/tmp.txt", strings.NewReader("tmp"))
+	client.Delete("/tmp.txt")
+	if _, err := upper.GetStream("/.wh.tmp.txt"); err == nil {
+		t.Errorf("Whiteout marker should not be written for objects only in the upper layer")
+	}
+
+	if _, err := client.Put("/assets/.wh.logo.png", strings.NewReader("")); err != union.ErrReservedPath {
+		t.Errorf("Writing whiteout markers should be rejected, but got %v", err)
+	}
+	if err := client.Delete("/assets/.wh.logo.png"); err != union.ErrReservedPath {
+		t.Errorf("Deleting whiteout markers should be rejected, but got %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	client, _, _, _ := setup(t)
+	client.Put("/config/app.yml", strings.NewReader("preview"))
+	client.Put("/config/preview.yml", strings.NewReader("preview"))
+	client.Delete("/config/defaults.yml")
+
+	objects, err := client.List("/config")
+	if err != nil {
+		t.Fatalf("No error should happen when list, but got %v", err)
+	}
+	if result := paths(objects); result != "/config/app.yml,/config/preview.yml" {
+		t.Errorf("List should merge layers, but got %v", result)
+	}
+	if objects[0].Size != 7 {
+		t.Errorf("Listed object should come from the upper layer, but got %+v", objects[0])
+	}
+
+	if objects, _ := client.List("/"); paths(objects) != "/assets/logo.png,/config/app.yml,/config/preview.yml" {
+		t.Errorf("List should return visible objects of all layers, but got %v", paths(objects))
+	}
+}
+
+func TestCommit(t *testing.T) {
+	client, upper, production, _ := setup(t)
+	client.Put("/config/app.yml", strings.NewReader("preview"))
+	client.Put("/assets/banner.png", strings.NewReader("banner"))
+	client.Delete("/assets/logo.png")
+	client.Delete("/config/defaults.yml")
+
+	changes, err := client.Commit(production)
+	if err != nil {
+		t.Fatalf("No error should happen when commit, but got %v", err)
+	}
+	if len(changes) != 4 || changes[0].Path != "/assets/banner.png" || changes[1].Path != "/assets/logo.png" || !changes[1].Deleted {
+		t.Errorf("Commit should return applied changes, but got %+v", changes)
+	}
+
+	if result := read(production, "/config/app.yml"); result != "preview" {
+		t.Errorf("Written objects should be copied into target, but got %v", result)
+	}
+	if result := read(production, "/assets/banner.png"); result != "banner" {
+		t.Errorf("Written objects should be copied into target, but got %v", result)
+	}
+	if _, err := production.GetStream("/assets/logo.png"); err == nil {
+		t.Errorf("Deleted objects should be deleted from target")
+	}
+
+	if err := client.Reset(); err != nil {
+		t.Fatalf("No error should happen when reset, but got %v", err)
+	}
+	if objects, _ := upper.List("/"); len(objects) != 0 {
+		t.Errorf("Reset should discard changes of the upper layer, but got %v", paths(objects))
+	}
+	// defaults.yml only exists in the base layer, which is not the commit target
+	if objects, _ := client.List("/"); paths(objects) != "/assets/banner.png,/config/app.yml,/config/defaults.yml" {
+		t.Errorf("Union should show committed objects, but got %v", paths(objects))
+	}
+}
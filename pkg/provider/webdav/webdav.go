@@ -0,0 +1,473 @@
+package webdav
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface  = (*Client)(nil)
+	_ model.StatInterface     = (*Client)(nil)
+	_ model.NotExistInterface = (*Client)(nil)
+)
+
+// Config WebDAV client config
+type Config struct {
+	// URL of the collection where objects are saved, e.g. "https://cloud.example.com/remote.php/dav/files/drive"
+	URL string
+	// User and Password used for basic authentication
+	User     string
+	Password string
+	// Header extra headers sent with every request, e.g. a bearer token
+	Header http.Header
+
+	// DisableInfiniteDepth list collections with one "Depth: 1" PROPFIND per collection. Otherwise "Depth: infinity"
+	// is tried first, and the client falls back when the server refuses it
+	DisableInfiniteDepth bool
+
+	// PublicEndpoint public URL of the collection, if files are also served without authentication
+	PublicEndpoint string
+
+	// HTTPClient used to send requests, default to a client with 5 minutes timeout
+	HTTPClient *http.Client
+}
+
+// Client WebDAV storage
+type Client struct {
+	Config *Config
+	base   *url.URL
+
+	mutex sync.Mutex
+	// finiteDepth set when the server refused a "Depth: infinity" PROPFIND
+	finiteDepth bool
+}
+
+// StatusError returned when the server responds an unexpected status
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("webdav: %v %v: %v", err.Method, err.Path, err.Status)
+}
+
+// IsNotExist report whether the error shows that the path doesn't exist
+func IsNotExist(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+// IsNotExist implements model.NotExistInterface
+func (client *Client) IsNotExist(err error) bool {
+	return IsNotExist(err)
+}
+
+// New initialize WebDAV storage
+func New(config *Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("webdav: unsupported URL %q", config.URL)
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+	return &Client{Config: config, base: base}, nil
+}
+
+// ToURL get the URL of the path on the server
+func (client *Client) ToURL(urlPath string) string {
+	var segments []string
+	for _, segment := range strings.Split(path.Clean("/"+urlPath), "/") {
+		if segment != "" {
+			segments = append(segments, url.PathEscape(segment))
+		}
+	}
+	return client.base.String() + "/" + strings.Join(segments, "/")
+}
+
+// toPath get the object's path from a href of PROPFIND response, which is either a path or an absolute URL
+func (client *Client) toPath(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	return "/" + strings.Trim(strings.TrimPrefix(u.Path, client.base.Path), "/"), nil
+}
+
+func (client *Client) do(method, urlPath string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, client.ToURL(urlPath), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range client.Config.Header {
+		req.Header[key] = values
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if client.Config.User != "" || client.Config.Password != "" {
+		req.SetBasicAuth(client.Config.User, client.Config.Password)
+	}
+	if body != nil {
+		req.ContentLength = contentLength(body)
+	}
+	return client.Config.HTTPClient.Do(req)
+}
+
+// contentLength get the size of the body if it is known, so the request is not sent chunked, -1 otherwise
+func contentLength(body io.Reader) int64 {
+	switch reader := body.(type) {
+	case interface{ Len() int }:
+		return int64(reader.Len())
+	case io.Seeker:
+		current, err := reader.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := reader.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err := reader.Seek(current, io.SeekStart); err != nil {
+			return -1
+		}
+		return end - current
+	}
+	return -1
+}
+
+func statusError(resp *http.Response, method, urlPath string) error {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return &StatusError{Method: method, Path: urlPath, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// Get receive file with given path
+func (client *Client) Get(path string) (file *os.File, err error) {
+	readCloser, err := client.GetStream(path)
+
+	if err == nil {
+		if file, err = ioutil.TempFile("/tmp", "webdav"); err == nil {
+			defer readCloser.Close()
+			_, err = io.Copy(file, readCloser)
+			file.Seek(0, 0)
+		}
+	}
+
+	return file, err
+}
+
+// GetStream get file as stream
+func (client *Client) GetStream(path string) (io.ReadCloser, error) {
+	resp, err := client.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, http.MethodGet, path)
+	}
+	return resp.Body, nil
+}
+
+// Put store a reader into given path, parent collections are created with MKCOL if they don't exist
+func (client *Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		seeker.Seek(0, 0)
+	}
+
+	if err := client.makeCollections(path.Dir(path.Clean("/" + urlPath))); err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	if contentType := mime.TypeByExtension(path.Ext(urlPath)); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.do(http.MethodPut, urlPath, reader, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, http.MethodPut, urlPath)
+	}
+	resp.Body.Close()
+
+	now := time.Now()
+	return &model.Object{
+		Path:             path.Clean("/" + urlPath),
+		Name:             path.Base(urlPath),
+		LastModified:     &now,
+		ETag:             resp.Header.Get("ETag"),
+		ContentType:      header.Get("Content-Type"),
+		StorageInterface: client,
+	}, nil
+}
+
+// makeCollections create the collection and its parents, from the deepest existing one
+func (client *Client) makeCollections(dir string) error {
+	if dir == "/" {
+		return nil
+	}
+
+	resp, err := client.do("MKCOL", dir, nil, nil)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		// 405 is returned when the collection already exists
+		return nil
+	case http.StatusConflict:
+		// the parent doesn't exist
+		if err := client.makeCollections(path.Dir(dir)); err != nil {
+			return err
+		}
+		resp, err := client.do("MKCOL", dir, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return statusError(resp, "MKCOL", dir)
+		}
+		resp.Body.Close()
+		return nil
+	}
+	return &StatusError{Method: "MKCOL", Path: dir, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// Delete delete file
+func (client *Client) Delete(path string) error {
+	resp, err := client.do(http.MethodDelete, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return statusError(resp, http.MethodDelete, path)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/><d:getcontenttype/></d:prop></d:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ETag          string `xml:"DAV: getetag"`
+				ContentType   string `xml:"DAV: getcontenttype"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+type resource struct {
+	path       string
+	collection bool
+	object     *model.Object
+}
+
+// propfind get properties of the path, and its members to given depth: "0", "1" or "infinity"
+func (client *Client) propfind(urlPath string, depth string) ([]*resource, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := client.do("PROPFIND", urlPath, strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError(resp, "PROPFIND", urlPath)
+	}
+	defer resp.Body.Close()
+
+	result := multistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var resources []*resource
+	for _, response := range result.Responses {
+		p, err := client.toPath(response.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		res := &resource{path: p}
+		for _, propstat := range response.Propstats {
+			// properties missing on the resource are reported in another propstat with 404 status
+			if fields := strings.Fields(propstat.Status); len(fields) < 2 || fields[1] != "200" {
+				continue
+			}
+
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				res.collection = true
+				continue
+			}
+
+			object := &model.Object{
+				Path:             p,
+				Name:             path.Base(p),
+				ETag:             prop.ETag,
+				ContentType:      prop.ContentType,
+				StorageInterface: client,
+			}
+			object.Size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			if modTime, err := http.ParseTime(prop.LastModified); err == nil {
+				object.LastModified = &modTime
+			}
+			if object.ContentType == "" {
+				object.ContentType = mime.TypeByExtension(path.Ext(p))
+			}
+			res.object = object
+		}
+		if res.collection {
+			res.object = nil
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// Stat get object's information from DAV properties
+func (client *Client) Stat(urlPath string) (*model.Object, error) {
+	resources, err := client.propfind(urlPath, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 || resources[0].object == nil {
+		return nil, &os.PathError{Op: "stat", Path: urlPath, Err: fmt.Errorf("not a file")}
+	}
+	return resources[0].object, nil
+}
+
+// List list all objects under current path recursively. It sends a single "Depth: infinity" PROPFIND, unless the
+// server refuses it, like most servers do by default, then collections are listed one by one with "Depth: 1"
+func (client *Client) List(urlPath string) ([]*model.Object, error) {
+	urlPath = path.Clean("/" + urlPath)
+
+	client.mutex.Lock()
+	finiteDepth := client.Config.DisableInfiniteDepth || client.finiteDepth
+	client.mutex.Unlock()
+
+	if !finiteDepth {
+		resources, err := client.propfind(urlPath, "infinity")
+		if err == nil {
+			return objectsOf(resources), nil
+		}
+
+		statusErr, ok := err.(*StatusError)
+		if !ok || (statusErr.StatusCode != http.StatusForbidden && statusErr.StatusCode != http.StatusBadRequest && statusErr.StatusCode != http.StatusNotImplemented) {
+			if IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		client.mutex.Lock()
+		client.finiteDepth = true
+		client.mutex.Unlock()
+	}
+
+	var objects []*model.Object
+	collections := []string{urlPath}
+	for len(collections) > 0 {
+		collection := collections[0]
+		collections = collections[1:]
+
+		resources, err := client.propfind(collection, "1")
+		if err != nil {
+			if IsNotExist(err) && collection == urlPath {
+				return nil, nil
+			}
+			return objects, err
+		}
+
+		for _, res := range resources {
+			if res.collection && res.path != collection {
+				collections = append(collections, res.path)
+			}
+		}
+		objects = append(objects, objectsOf(resources)...)
+	}
+	return objects, nil
+}
+
+func objectsOf(resources []*resource) []*model.Object {
+	var objects []*model.Object
+	for _, res := range resources {
+		if res.object != nil {
+			objects = append(objects, res.object)
+		}
+	}
+	return objects
+}
+
+// GetEndpoint get endpoint, default to the WebDAV URL
+func (client *Client) GetEndpoint() string {
+	if client.Config.PublicEndpoint != "" {
+		return client.Config.PublicEndpoint
+	}
+	return client.Config.URL
+}
+
+// GetURL get public accessible URL if PublicEndpoint is set, otherwise return the path like FileSystem does
+func (client *Client) GetURL(path string) (string, error) {
+	if client.Config.PublicEndpoint != "" {
+		return strings.TrimSuffix(client.Config.PublicEndpoint, "/") + "/" + strings.TrimPrefix(path, "/"), nil
+	}
+	return path, nil
+}
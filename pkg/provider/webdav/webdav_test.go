@@ -0,0 +1,150 @@
+package webdav_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bhojpur/drive/pkg/provider/webdav"
+	"github.com/bhojpur/drive/tests"
+	xwebdav "golang.org/x/net/webdav"
+)
+
+type server struct {
+	*httptest.Server
+	root string
+	// refuse "Depth: infinity" PROPFIND like most servers do by default
+	finiteDepth bool
+	propfinds   int32
+}
+
+// startServer start a WebDAV server serving a local directory under /dav/files/partner, which accepts user "partner"
+// with password "secret"
+func startServer(t *testing.T) *server {
+	s := &server{root: t.TempDir()}
+	handler := &xwebdav.Handler{
+		Prefix:     "/dav/files/partner",
+		FileSystem: xwebdav.Dir(s.root),
+		LockSystem: xwebdav.NewMemLS(),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, ok := req.BasicAuth(); !ok || user != "partner" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Method == "PROPFIND" {
+			atomic.AddInt32(&s.propfinds, 1)
+			if s.finiteDepth && req.Header.Get("Depth") == "infinity" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newClient(t *testing.T, s *server) *webdav.Client {
+	client, err := webdav.New(&webdav.Config{URL: s.URL + "/dav/files/partner", User: "partner", Password: "secret"})
+	if err != nil {
+		t.Fatalf("No error should happen when initialize client, but got %v", err)
+	}
+	return client
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(newClient(t, startServer(t)), t)
+}
+
+func TestFiniteDepth(t *testing.T) {
+	s := startServer(t)
+	s.finiteDepth = true
+	client := newClient(t, s)
+	tests.TestAll(client, t)
+
+	for _, path := range []string{"/docs/a.txt", "/docs/2022/b.txt", "/docs/2022/03/c.txt", "/other.txt"} {
+		client.Put(path, strings.NewReader(path))
+	}
+
+	atomic.StoreInt32(&s.propfinds, 0)
+	objects, err := client.List("/docs")
+	if err != nil || len(objects) != 3 {
+		t.Fatalf("List should return files under the path recursively, but got %v, %v", objects, err)
+	}
+	if propfinds := atomic.LoadInt32(&s.propfinds); propfinds != 3 {
+		t.Errorf("List should send one PROPFIND per collection after the server refused infinite depth, but sent %v", propfinds)
+	}
+
+	if objects, err := client.List("/missing"); err != nil || len(objects) != 0 {
+		t.Errorf("List of a missing path should be empty, but got %v, %v", objects, err)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	s := startServer(t)
+	client := newClient(t, s)
+
+	path := "/reports/Q1 2022/summary #1.csv"
+	if _, err := client.Put(path, strings.NewReader("a,b,c")); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(s.root, "reports", "Q1 2022", "summary #1.csv")); err != nil || string(data) != "a,b,c" {
+		t.Errorf("File should be saved with escaped path, but got %q, %v", data, err)
+	}
+
+	object, err := client.Stat(path)
+	if err != nil {
+		t.Fatalf("No error should happen when stat file, but got %v", err)
+	}
+	if object.Path != path || object.Size != 5 || object.ETag == "" || object.LastModified == nil || object.ContentType != "text/csv; charset=utf-8" {
+		t.Errorf("Stat should read DAV properties, but got %+v", object)
+	}
+
+	objects, err := client.List("/reports")
+	if err != nil || len(objects) != 1 || objects[0].Path != path || objects[0].ETag != object.ETag {
+		t.Errorf("List should return the same properties, but got %v, %v", objects, err)
+	}
+
+	if _, err := client.Stat("/reports"); err == nil {
+		t.Errorf("Stat of a collection should fail")
+	}
+	if _, err := client.GetStream("/missing.txt"); !webdav.IsNotExist(err) {
+		t.Errorf("Missing file should return not exist error, but got %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := startServer(t)
+	client, _ := webdav.New(&webdav.Config{URL: s.URL + "/dav/files/partner", User: "partner", Password: "wrong"})
+
+	_, err := client.Put("/a.txt", strings.NewReader("a"))
+	if statusErr, ok := err.(*webdav.StatusError); !ok || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wrong password should be rejected, but got %v", err)
+	}
+}
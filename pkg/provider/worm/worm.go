@@ -0,0 +1,353 @@
+package worm
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+)
+
+var (
+	_ model.StorageInterface   = (*Client)(nil)
+	_ model.StatInterface      = (*Client)(nil)
+	_ model.RetentionInterface = (*Client)(nil)
+)
+
+var (
+	// ErrObjectLocked returned when deleting or overwriting an object under retention or legal hold
+	ErrObjectLocked = errors.New("object is locked by retention or legal hold")
+	// ErrRetentionLocked returned when shortening or removing a retention that is not allowed to be changed
+	ErrRetentionLocked = errors.New("retention can't be shortened or removed")
+)
+
+// Config WORM storage config
+type Config struct {
+	// Mode and Period of the default retention applied to new objects, zero Period disables it
+	Mode   model.RetentionMode
+	Period time.Duration
+
+	// BypassGovernance allow to shorten governance retention, and delete or overwrite objects under governance retention
+	BypassGovernance bool
+
+	// MetaPrefix where retention records are saved if the storage doesn't support retention natively, default to ".retention"
+	MetaPrefix string
+
+	// IsNotExist report whether an error of the storage means the retention record doesn't exist, default to errors.Is(err, os.ErrNotExist).
+	// Other errors fail closed, e.g. set the provider's IsNotExist like qiniu.IsNotExist for cloud storages
+	IsNotExist func(error) bool
+
+	// Now current time, default to time.Now
+	Now func() time.Time
+}
+
+// Client WORM storage, enforce retention and legal hold on top of the underlying storage.
+// If the underlying storage implements model.RetentionInterface (e.g. S3 with object lock), retention is delegated to it,
+// otherwise retention records are saved into the storage under Config.MetaPrefix
+type Client struct {
+	Storage model.StorageInterface
+	Config  *Config
+}
+
+// New initialize WORM storage
+func New(storage model.StorageInterface, config *Config) *Client {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.Mode == "" {
+		config.Mode = model.RetentionGovernance
+	}
+
+	if config.MetaPrefix == "" {
+		config.MetaPrefix = ".retention"
+	}
+	config.MetaPrefix = strings.Trim(config.MetaPrefix, "/")
+
+	if config.IsNotExist == nil {
+		config.IsNotExist = func(err error) bool { return errors.Is(err, os.ErrNotExist) }
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Client{Storage: storage, Config: config}
+}
+
+func (client Client) native() (model.RetentionInterface, bool) {
+	native, ok := client.Storage.(model.RetentionInterface)
+	return native, ok
+}
+
+func (client Client) metaPath(urlPath string) string {
+	return "/" + path.Join(client.Config.MetaPrefix, strings.TrimPrefix(urlPath, "/")) + ".json"
+}
+
+// isMetaPath report whether the path refers to retention records, paths are cleaned first so "//" or "/./" can't reach them
+func (client Client) isMetaPath(urlPath string) bool {
+	urlPath = strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	return urlPath == client.Config.MetaPrefix || strings.HasPrefix(urlPath, client.Config.MetaPrefix+"/")
+}
+
+// GetRetention get retention and legal hold of the object, return nil if the object has no retention
+func (client Client) GetRetention(urlPath string) (*model.Retention, error) {
+	if native, ok := client.native(); ok {
+		return native.GetRetention(urlPath)
+	}
+
+	stream, err := client.Storage.GetStream(client.metaPath(urlPath))
+	if err != nil {
+		if client.Config.IsNotExist(err) {
+			// no retention record, the object is not retained
+			return nil, nil
+		}
+		// fail closed, so that retained objects are never deleted or overwritten when the record can't be read
+		return nil, err
+	}
+	defer stream.Close()
+
+	retention := &model.Retention{}
+	if err := json.NewDecoder(stream).Decode(retention); err != nil {
+		return nil, err
+	}
+	return retention, nil
+}
+
+func (client Client) saveRetention(urlPath string, retention *model.Retention) error {
+	data, err := json.Marshal(retention)
+	if err != nil {
+		return err
+	}
+	_, err = client.Storage.Put(client.metaPath(urlPath), bytes.NewReader(data))
+	return err
+}
+
+// SetRetention set retention of the object. Compliance retention can't be shortened or changed to governance before it expires,
+// governance retention can only be shortened with bypassGovernance, which also requires Config.BypassGovernance
+func (client Client) SetRetention(urlPath string, mode model.RetentionMode, retainUntil time.Time, bypassGovernance bool) error {
+	bypassGovernance = bypassGovernance && client.Config.BypassGovernance
+
+	if native, ok := client.native(); ok {
+		return native.SetRetention(urlPath, mode, retainUntil, bypassGovernance)
+	}
+
+	if _, err := model.Stat(client.Storage, urlPath); err != nil {
+		return err
+	}
+
+	retention, err := client.GetRetention(urlPath)
+	if err != nil {
+		return err
+	}
+
+	if retention == nil {
+		retention = &model.Retention{}
+	} else if retention.Retained(client.Config.Now()) {
+		shortened := retainUntil.Before(*retention.RetainUntil)
+		switch retention.Mode {
+		case model.RetentionCompliance:
+			if shortened || mode != model.RetentionCompliance {
+				return ErrRetentionLocked
+			}
+		default:
+			if shortened && !bypassGovernance {
+				return ErrRetentionLocked
+			}
+		}
+	}
+
+	retention.Mode = mode
+	retention.RetainUntil = &retainUntil
+	return client.saveRetention(urlPath, retention)
+}
+
+// SetLegalHold place or release legal hold of the object, objects under legal hold can't be deleted or overwritten regardless of retention
+func (client Client) SetLegalHold(urlPath string, hold bool) error {
+	if native, ok := client.native(); ok {
+		return native.SetLegalHold(urlPath, hold)
+	}
+
+	if _, err := model.Stat(client.Storage, urlPath); err != nil {
+		return err
+	}
+
+	retention, err := client.GetRetention(urlPath)
+	if err != nil {
+		return err
+	}
+	if retention == nil {
+		retention = &model.Retention{}
+	}
+
+	retention.LegalHold = hold
+	return client.saveRetention(urlPath, retention)
+}
+
+// checkWritable return ErrObjectLocked if the object can't be deleted or overwritten
+func (client Client) checkWritable(urlPath string) (*model.Retention, error) {
+	retention, err := client.GetRetention(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	now := client.Config.Now()
+	if retention.Locked(now) {
+		bypassable := !retention.LegalHold && retention.Mode == model.RetentionGovernance && client.Config.BypassGovernance
+		if !bypassable {
+			return retention, ErrObjectLocked
+		}
+	}
+	return retention, nil
+}
+
+// Get receive file with given path
+func (client Client) Get(urlPath string) (*os.File, error) {
+	if client.isMetaPath(urlPath) {
+		return nil, os.ErrNotExist
+	}
+	return client.Storage.Get(urlPath)
+}
+
+// GetStream get file as stream
+func (client Client) GetStream(urlPath string) (io.ReadCloser, error) {
+	if client.isMetaPath(urlPath) {
+		return nil, os.ErrNotExist
+	}
+	return client.Storage.GetStream(urlPath)
+}
+
+// Stat get object's information, including its retention
+func (client Client) Stat(urlPath string) (*model.Object, error) {
+	if client.isMetaPath(urlPath) {
+		return nil, os.ErrNotExist
+	}
+
+	object, err := model.Stat(client.Storage, urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := client.native(); !ok {
+		if object.Retention, err = client.GetRetention(urlPath); err != nil {
+			return nil, err
+		}
+	}
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Put store a reader into given path, return ErrObjectLocked if the existing object is locked, default retention is applied to the new object
+func (client Client) Put(urlPath string, reader io.Reader) (*model.Object, error) {
+	if client.isMetaPath(urlPath) {
+		return nil, ErrObjectLocked
+	}
+
+	retention, err := client.checkWritable(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if putter, ok := client.Storage.(model.RetentionPutInterface); ok && client.Config.Period > 0 {
+		// saved with its retention in one request, so the object is never left unretained
+		object, err := putter.PutWithRetention(urlPath, reader, client.Config.Mode, client.Config.Now().Add(client.Config.Period))
+		if err != nil {
+			return object, err
+		}
+		object.StorageInterface = client
+		return object, nil
+	}
+
+	object, err := client.Storage.Put(urlPath, reader)
+	if err != nil {
+		return object, err
+	}
+
+	if client.Config.Period > 0 {
+		retainUntil := client.Config.Now().Add(client.Config.Period)
+		if err := client.SetRetention(urlPath, client.Config.Mode, retainUntil, true); err != nil {
+			return object, err
+		}
+	} else if retention != nil {
+		// overwritten with governance bypassed, the new object starts without retention
+		if _, ok := client.native(); !ok {
+			client.Storage.Delete(client.metaPath(urlPath))
+		}
+	}
+
+	object.StorageInterface = client
+	return object, nil
+}
+
+// Delete delete file, return ErrObjectLocked if the object is locked
+func (client Client) Delete(urlPath string) error {
+	if client.isMetaPath(urlPath) {
+		return ErrObjectLocked
+	}
+
+	retention, err := client.checkWritable(urlPath)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Storage.Delete(urlPath); err != nil {
+		return err
+	}
+
+	if _, ok := client.native(); !ok && retention != nil {
+		return client.Storage.Delete(client.metaPath(urlPath))
+	}
+	return nil
+}
+
+// List list all objects under current path, retention records are excluded
+func (client Client) List(urlPath string) ([]*model.Object, error) {
+	results, err := client.Storage.List(urlPath)
+
+	var objects []*model.Object
+	for _, object := range results {
+		if !client.isMetaPath(object.Path) {
+			object.StorageInterface = client
+			objects = append(objects, object)
+		}
+	}
+	return objects, err
+}
+
+// GetEndpoint get endpoint of the underlying storage
+func (client Client) GetEndpoint() string {
+	return client.Storage.GetEndpoint()
+}
+
+// GetURL get public accessible URL
+func (client Client) GetURL(urlPath string) (string, error) {
+	if client.isMetaPath(urlPath) {
+		return "", os.ErrNotExist
+	}
+	return client.Storage.GetURL(urlPath)
+}
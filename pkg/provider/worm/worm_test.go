@@ -0,0 +1,211 @@
+package worm_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/drive/pkg/model"
+	"github.com/bhojpur/drive/pkg/provider/filesystem"
+	"github.com/bhojpur/drive/pkg/provider/memory"
+	"github.com/bhojpur/drive/pkg/provider/worm"
+	"github.com/bhojpur/drive/tests"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestAll(t *testing.T) {
+	tests.TestAll(worm.New(filesystem.New(t.TempDir()), nil), t)
+}
+
+func TestDefaultRetention(t *testing.T) {
+	c := &clock{now: time.Now()}
+	client := worm.New(filesystem.New(t.TempDir()), &worm.Config{Mode: model.RetentionCompliance, Period: time.Hour, Now: c.Now})
+
+	if _, err := client.Put("/records/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+
+	if object, err := client.Stat("/records/a.txt"); err != nil {
+		t.Errorf("No error should happen when stat file, but got %v", err)
+	} else if object.Retention == nil || object.Retention.Mode != model.RetentionCompliance || !object.Retention.RetainUntil.Equal(c.now.Add(time.Hour)) {
+		t.Errorf("Stat should report retention, but got %+v", object.Retention)
+	}
+
+	if err := client.Delete("/records/a.txt"); err != worm.ErrObjectLocked {
+		t.Errorf("Delete retained file should be rejected, but got %v", err)
+	}
+	if _, err := client.Put("/records/a.txt", strings.NewReader("b")); err != worm.ErrObjectLocked {
+		t.Errorf("Overwrite retained file should be rejected, but got %v", err)
+	}
+	if err := client.SetRetention("/records/a.txt", model.RetentionCompliance, c.now, true); err != worm.ErrRetentionLocked {
+		t.Errorf("Shorten compliance retention should be rejected, but got %v", err)
+	}
+	if err := client.SetRetention("/records/a.txt", model.RetentionGovernance, c.now.Add(2*time.Hour), false); err != worm.ErrRetentionLocked {
+		t.Errorf("Change compliance retention to governance should be rejected, but got %v", err)
+	}
+
+	if objects, err := client.List("/"); err != nil || len(objects) != 1 {
+		t.Errorf("Retention records should be excluded from List, but got %v, %v", objects, err)
+	}
+
+	c.now = c.now.Add(2 * time.Hour)
+	if err := client.Delete("/records/a.txt"); err != nil {
+		t.Errorf("Delete expired file should succeed, but got %v", err)
+	}
+}
+
+func TestGovernanceRetention(t *testing.T) {
+	storage := filesystem.New(t.TempDir())
+	client := worm.New(storage, nil)
+	admin := worm.New(storage, &worm.Config{BypassGovernance: true})
+
+	client.Put("/a.txt", strings.NewReader("a"))
+	until := time.Now().Add(time.Hour)
+	if err := client.SetRetention("/a.txt", model.RetentionGovernance, until, false); err != nil {
+		t.Fatalf("No error should happen when set retention, but got %v", err)
+	}
+
+	if err := client.SetRetention("/a.txt", model.RetentionGovernance, time.Now(), true); err != worm.ErrRetentionLocked {
+		t.Errorf("Shorten governance retention without bypass permission should be rejected, but got %v", err)
+	}
+	if err := client.Delete("/a.txt"); err != worm.ErrObjectLocked {
+		t.Errorf("Delete retained file should be rejected, but got %v", err)
+	}
+
+	if err := admin.SetLegalHold("/a.txt", true); err != nil {
+		t.Fatalf("No error should happen when set legal hold, but got %v", err)
+	}
+	if err := admin.Delete("/a.txt"); err != worm.ErrObjectLocked {
+		t.Errorf("Delete file under legal hold should be rejected, but got %v", err)
+	}
+
+	admin.SetLegalHold("/a.txt", false)
+	if err := admin.Delete("/a.txt"); err != nil {
+		t.Errorf("Delete file under governance retention with bypass should succeed, but got %v", err)
+	}
+}
+
+func TestLegalHoldWithoutRetention(t *testing.T) {
+	client := worm.New(filesystem.New(t.TempDir()), nil)
+
+	if err := client.SetLegalHold("/missing.txt", true); err == nil {
+		t.Errorf("Set legal hold on missing file should fail")
+	}
+
+	client.Put("/a.txt", strings.NewReader("a"))
+	client.SetLegalHold("/a.txt", true)
+	if _, err := client.Put("/a.txt", strings.NewReader("b")); err != worm.ErrObjectLocked {
+		t.Errorf("Overwrite file under legal hold should be rejected, but got %v", err)
+	}
+	if _, err := client.GetStream("/.retention/a.txt.json"); err == nil {
+		t.Errorf("Retention records should not be accessible")
+	}
+}
+
+func TestRetentionReadFailure(t *testing.T) {
+	storage := memory.New(nil)
+	client := worm.New(storage, &worm.Config{Mode: model.RetentionCompliance, Period: time.Hour})
+	if _, err := client.Put("/records/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+
+	// failing to read the retention record must not unlock the object
+	storage.SetFaults(&memory.Faults{FailOn: map[memory.Operation]int{memory.OperationGetStream: 1}})
+	if err := client.Delete("/records/a.txt"); err != memory.ErrInjected {
+		t.Errorf("Delete should fail when retention can't be read, but got %v", err)
+	}
+	if _, err := storage.Stat("/records/a.txt"); err != nil {
+		t.Errorf("Retained file should be kept, but got %v", err)
+	}
+
+	if retention, err := client.GetRetention("/records/b.txt"); err != nil || retention != nil {
+		t.Errorf("Missing retention record should mean no retention, but got %v, %v", retention, err)
+	}
+}
+
+func TestMetaPath(t *testing.T) {
+	storage := memory.New(nil)
+	client := worm.New(storage, &worm.Config{Mode: model.RetentionCompliance, Period: time.Hour})
+	if _, err := client.Put("/a.txt", strings.NewReader("a")); err != nil {
+		t.Fatalf("No error should happen when save file, but got %v", err)
+	}
+
+	for _, metaPath := range []string{"/.retention/a.txt.json", "//.retention/a.txt.json", "/./.retention/a.txt.json", "/records/../.retention/a.txt.json"} {
+		if _, err := client.Put(metaPath, strings.NewReader("{}")); err != worm.ErrObjectLocked {
+			t.Errorf("Overwrite retention record %v should be rejected, but got %v", metaPath, err)
+		}
+		if err := client.Delete(metaPath); err != worm.ErrObjectLocked {
+			t.Errorf("Delete retention record %v should be rejected, but got %v", metaPath, err)
+		}
+		if _, err := client.GetStream(metaPath); err == nil {
+			t.Errorf("Retention record %v should be hidden", metaPath)
+		}
+	}
+
+	if err := client.Delete("/a.txt"); err != worm.ErrObjectLocked {
+		t.Errorf("Retention should be kept, but got %v", err)
+	}
+	if objects, err := client.List("/"); err != nil || len(objects) != 1 {
+		t.Errorf("Retention records should be excluded from List, but got %v, %v", objects, err)
+	}
+}
+
+var errObjectLockDisabled = errors.New("object lock is not enabled for the bucket")
+
+// bucket supporting retention natively but without object lock enabled, like an S3 bucket created without it
+type unlockedBucket struct {
+	*memory.Client
+}
+
+func (unlockedBucket) GetRetention(string) (*model.Retention, error) { return nil, nil }
+
+func (unlockedBucket) SetRetention(string, model.RetentionMode, time.Time, bool) error {
+	return errObjectLockDisabled
+}
+
+func (unlockedBucket) SetLegalHold(string, bool) error { return errObjectLockDisabled }
+
+func (unlockedBucket) PutWithRetention(string, io.Reader, model.RetentionMode, time.Time) (*model.Object, error) {
+	return nil, errObjectLockDisabled
+}
+
+func TestNativeRetentionFailure(t *testing.T) {
+	storage := memory.New(nil)
+	client := worm.New(unlockedBucket{storage}, &worm.Config{Mode: model.RetentionCompliance, Period: time.Hour})
+
+	if _, err := client.Put("/records/a.txt", strings.NewReader("a")); err != errObjectLockDisabled {
+		t.Errorf("Put should fail when the storage can't retain the object, but got %v", err)
+	}
+	if _, err := storage.Stat("/records/a.txt"); err == nil {
+		t.Errorf("Object should not be saved without retention")
+	}
+}
@@ -21,20 +21,36 @@ package storage
 // THE SOFTWARE.
 
 import (
+	"fmt"
+
 	"github.com/bhojpur/drive/pkg/model"
 )
 
+// GetStorageProvider return the storage provider, or nil if the provider type is unknown or its config is invalid
 func GetStorageProvider(providerType string, clientId string, clientSecret string, region string, bucket string, endpoint string) model.StorageInterface {
+	sp, err := OpenStorageProvider(providerType, clientId, clientSecret, region, bucket, endpoint)
+	if err != nil {
+		return nil
+	}
+	return sp
+}
+
+// OpenStorageProvider return the storage provider, or the error why it can't be initialized
+func OpenStorageProvider(providerType string, clientId string, clientSecret string, region string, bucket string, endpoint string) (model.StorageInterface, error) {
 	switch providerType {
 	case "Local File System":
-		return NewLocalFileSystemStorageProvider(clientId, clientSecret, region, bucket, endpoint)
+		return NewLocalFileSystemStorageProvider(clientId, clientSecret, region, bucket, endpoint), nil
 	case "AWS S3":
-		return NewAwsS3StorageProvider(clientId, clientSecret, region, bucket, endpoint)
+		return NewAwsS3StorageProvider(clientId, clientSecret, region, bucket, endpoint), nil
 	case "Aliyun OSS":
-		return NewAliyunOssStorageProvider(clientId, clientSecret, region, bucket, endpoint)
+		return NewAliyunOssStorageProvider(clientId, clientSecret, region, bucket, endpoint), nil
 	case "Tencent Cloud COS":
-		return NewTencentCloudCosStorageProvider(clientId, clientSecret, region, bucket, endpoint)
+		return NewTencentCloudCosStorageProvider(clientId, clientSecret, region, bucket, endpoint), nil
+	case "Azure Blob Storage":
+		return NewAzureBlobStorageProvider(clientId, clientSecret, region, bucket, endpoint)
+	case "Google Cloud Storage":
+		return NewGoogleCloudStorageProvider(clientId, clientSecret, region, bucket, endpoint)
 	}
 
-	return nil
+	return nil, fmt.Errorf("unknown storage provider %q", providerType)
 }
@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -33,6 +34,13 @@ import (
 	model "github.com/bhojpur/drive/pkg/model"
 )
 
+// SampleFile returns the absolute path of the sample file shipped with the
+// tests package, regardless of the working directory of the running test
+func SampleFile() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "sample.txt")
+}
+
 func TestAll(storage model.StorageInterface, t *testing.T) {
 	randomPath := strings.Replace(time.Now().Format("20060102150506.000"), ".", "", -1)
 	fmt.Printf("testing file in %v\n", filepath.Join(storage.GetEndpoint(), randomPath))
@@ -40,7 +48,7 @@ func TestAll(storage model.StorageInterface, t *testing.T) {
 	fileName := "/" + filepath.Join(randomPath, "sample.txt")
 	fileName2 := "/" + filepath.Join(randomPath, "sample2", "sample.txt")
 	exceptObjects := 2
-	sampleFile, _ := filepath.Abs("../tests/sample.txt")
+	sampleFile := SampleFile()
 
 	// Put file
 	if file, err := os.Open(sampleFile); err == nil {
@@ -69,7 +77,7 @@ func TestAll(storage model.StorageInterface, t *testing.T) {
 	} else {
 		if buffer, err := ioutil.ReadAll(file); err != nil {
 			t.Errorf("No error should happen when read downloaded file, but got %v", err)
-		} else if string(buffer) == "sample" {
+		} else if string(buffer) != "sample" {
 			t.Errorf("Downloaded file should contain correct content, but got %v", string(buffer))
 		}
 	}
@@ -85,7 +93,7 @@ func TestAll(storage model.StorageInterface, t *testing.T) {
 		} else {
 			if buffer, err := ioutil.ReadAll(resp.Body); err != nil {
 				t.Errorf("No error should happen when read downloaded file, but got %v", err)
-			} else if string(buffer) == "sample" {
+			} else if string(buffer) != "sample" {
 				t.Errorf("Downloaded file should contain correct content, but got %v", string(buffer))
 			}
 		}
@@ -97,7 +105,7 @@ func TestAll(storage model.StorageInterface, t *testing.T) {
 	} else {
 		if buffer, err := ioutil.ReadAll(stream); err != nil {
 			t.Errorf("No error should happen when read downloaded file, but got %v", err)
-		} else if string(buffer) == "sample" {
+		} else if string(buffer) != "sample" {
 			t.Errorf("Downloaded file should contain correct content, but got %v", string(buffer))
 		}
 	}